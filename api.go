@@ -0,0 +1,316 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/vkhangstack/Custos/internal/control"
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/proxy"
+	"github.com/vkhangstack/Custos/internal/store"
+	"github.com/vkhangstack/Custos/internal/system"
+)
+
+// Status summarizes a running instance
+type Status struct {
+	Version     string             `json:"version"`
+	Protection  bool               `json:"protection"`
+	Adblock     bool               `json:"adblock"`
+	ProxyPort   int                `json:"proxy_port"`
+	SystemProxy system.ProxyStatus `json:"system_proxy"`
+	Transparent TransparentStatus  `json:"transparent"`
+	Stats       core.Stats         `json:"stats"`
+}
+
+// GetStatus returns protection, proxy and traffic at a glance
+func (a *App) GetStatus() Status {
+	if a.remote != nil {
+		return call[Status](a, "GetStatus")
+	}
+	return Status{
+		Version:     a.GetAppInfo().Version,
+		Protection:  a.GetProtectionStatus(),
+		Adblock:     a.GetAdblockStatus(),
+		ProxyPort:   a.proxyServer.GetPort(),
+		SystemProxy: a.GetSystemProxyStatus(),
+		Transparent: a.GetTransparentStatus(),
+		Stats:       a.GetStats(),
+	}
+}
+
+// ExplainURL tells whether the proxy would let a connection to the host of
+// rawURL from proc through, and which filter, rule or list decides. A bare
+// host name works too, and an empty proc stands for an unknown process.
+func (a *App) ExplainURL(rawURL string, proc core.Process) (proxy.Verdict, error) {
+	if a.remote != nil {
+		return callErr[proxy.Verdict](a, "ExplainURL", rawURL, proc)
+	}
+	target := strings.TrimSpace(rawURL)
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Hostname() == "" {
+		return proxy.Verdict{}, fmt.Errorf("no host in %q", rawURL)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	return a.proxyServer.Explain(host, &proc), nil
+}
+
+// subscribeLogs streams new and updated logs filtered like GetLogsPaginated
+// until ctx is done
+func (a *App) subscribeLogs(ctx context.Context, in logStreamQuery) (<-chan core.LogEntry, error) {
+	q, err := store.ParseLogQuery(in.Search)
+	if err != nil {
+		return nil, err
+	}
+	sub := a.store.SubscribeLogs(ctx, store.SubscribeOptions{Query: q.WithFilters(in.Status, in.Type)})
+	return sub.C, nil
+}
+
+// GetAPIToken returns the token clients of the control API need over TCP
+func (a *App) GetAPIToken() (string, error) {
+	path, err := custosPath("api_token")
+	if err != nil {
+		return "", err
+	}
+	return control.LoadToken(path)
+}
+
+// apiPort returns the loopback TCP port of the control API, 0 if it's off
+func (a *App) apiPort() int {
+	if a.remote != nil {
+		return 0
+	}
+	val, err := a.store.GetSetting("api_port")
+	if err != nil {
+		return 0
+	}
+	port, _ := strconv.Atoi(val)
+	return port
+}
+
+// serveAPI moves the control API to a loopback TCP port, or only closes
+// it if port is 0
+func (a *App) serveAPI(port int) error {
+	a.apiMu.Lock()
+	defer a.apiMu.Unlock()
+
+	if a.apiListener != nil {
+		a.apiListener.Close()
+		a.apiListener = nil
+	}
+	if port == 0 || a.controlServer == nil {
+		return nil
+	}
+
+	token, err := a.GetAPIToken()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	a.apiListener = l
+	go func() {
+		if err := a.controlServer.ServeTCP(l, token); err != nil {
+			log.Printf("Control API error: %v", err)
+		}
+	}()
+	log.Printf("Control API listening on %s", l.Addr())
+	return nil
+}
+
+// Request types of the control API
+
+type enabledRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type ruleListQuery struct {
+	Page     int    `query:"page"`      // From 1
+	PageSize int    `query:"page_size"` // 0 = 50
+	Search   string `query:"search"`
+}
+
+type ruleRequest struct {
+	Pattern       string `json:"pattern"` // Exact domain, or "*.example.com" for it and its subdomains
+	Type          string `json:"type"`    // "BLOCK" or "ALLOW"
+	ProcessPath   string `json:"process_path,omitempty"`
+	ProcessSHA256 string `json:"process_sha256,omitempty"`
+	ProcessUser   string `json:"process_user,omitempty"`
+}
+
+// rule is the rule the request asks for
+func (r ruleRequest) rule() core.Rule {
+	return core.Rule{
+		Pattern:       r.Pattern,
+		Type:          core.RuleType(r.Type),
+		ProcessPath:   r.ProcessPath,
+		ProcessSHA256: r.ProcessSHA256,
+		ProcessUser:   r.ProcessUser,
+	}
+}
+
+type ruleUpdate struct {
+	ID      string `path:"id" json:"-"`
+	Enabled bool   `json:"enabled"`
+}
+
+type idParam struct {
+	ID string `path:"id"`
+}
+
+type logListQuery struct {
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit"`
+	Search string `query:"search"` // store.ParseLogQuery syntax
+	Status string `query:"status"`
+	Type   string `query:"type"`
+}
+
+type logStreamQuery struct {
+	Search string `query:"search"`
+	Status string `query:"status"`
+	Type   string `query:"type"`
+}
+
+type explainQuery struct {
+	URL     string `query:"url"`
+	Process string `query:"process"` // Executable path of the connecting process
+	SHA256  string `query:"sha256"`
+	User    string `query:"user"`
+}
+
+type trafficQuery struct {
+	Duration string `query:"duration"` // e.g. "90m" or "7d"
+}
+
+type processParam struct {
+	Key string `path:"key"`
+}
+
+type filterRequest struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type filterUpdate struct {
+	ID             string `path:"id" json:"-"`
+	Enabled        *bool  `json:"enabled,omitempty"`
+	UpdateInterval *int64 `json:"update_interval,omitempty"` // Seconds, 0 = the list's own
+}
+
+type dnsUpstreams struct {
+	Upstreams []string `json:"upstreams"`
+}
+
+// done answers an operation without a result
+func done(err error) (struct{}, error) {
+	return struct{}{}, err
+}
+
+// filterNotFound turns a missing filter into a 404
+func filterNotFound(err error) error {
+	if errors.Is(err, errFilterNotFound) {
+		return control.NotFound("%v", err)
+	}
+	return err
+}
+
+// apiRoutes maps the control API onto the bindings
+func (a *App) apiRoutes() []control.Route {
+	return []control.Route{
+		control.Handle("POST", "/v1/activate", "activate", "Show the window with the arguments of another launch",
+			func(in activateRequest) (struct{}, error) {
+				a.activate(in.Args)
+				return done(nil)
+			}),
+		control.Handle("GET", "/v1/status", "getStatus", "Protection, proxy and traffic at a glance",
+			func(struct{}) (Status, error) { return a.GetStatus(), nil }),
+		control.Handle("PUT", "/v1/protection", "setProtection", "Turn protection and the system proxy on or off",
+			func(in enabledRequest) (struct{}, error) {
+				a.EnableProtection(in.Enabled)
+				return done(nil)
+			}),
+		control.Handle("PUT", "/v1/adblock", "setAdblock", "Turn the adblock engine on or off",
+			func(in enabledRequest) (struct{}, error) {
+				a.EnableAdblock(in.Enabled)
+				return done(nil)
+			}),
+		control.Handle("PUT", "/v1/transparent", "setTransparent", "Turn the transparent proxy on or off (Linux)",
+			func(in enabledRequest) (struct{}, error) { return done(a.EnableTransparentProxy(in.Enabled)) }),
+
+		control.Handle("GET", "/v1/rules", "listRules", "List rules a page at a time",
+			func(in ruleListQuery) (core.PaginatedRulesResponse, error) {
+				return a.GetRulesPaginated(max(in.Page, 1), cmp.Or(in.PageSize, 50), in.Search), nil
+			}),
+		control.Handle("POST", "/v1/rules", "addRule", "Add a custom rule",
+			func(in ruleRequest) (core.Rule, error) { return a.addRule(in.rule()) }),
+		control.Handle("PATCH", "/v1/rules/{id}", "updateRule", "Enable or disable a rule",
+			func(in ruleUpdate) (struct{}, error) { return done(a.ToggleRule(in.ID, in.Enabled)) }),
+		control.Handle("DELETE", "/v1/rules/{id}", "deleteRule", "Delete a rule",
+			func(in idParam) (struct{}, error) { return done(a.DeleteRule(in.ID)) }),
+
+		control.Handle("GET", "/v1/logs", "listLogs", "Search logs, newest first, a page at a time",
+			func(in logListQuery) (core.PaginatedLogs, error) {
+				return a.GetLogsPaginated(in.Cursor, in.Limit, in.Search, in.Status, in.Type), nil
+			}),
+		control.Stream("GET", "/v1/logs/stream", "streamLogs", "Stream new and updated logs as they happen; updates repeat the ID",
+			a.subscribeLogs),
+		control.Handle("GET", "/v1/explain", "explain", "Dry run the proxy's decision for a URL or host",
+			func(in explainQuery) (proxy.Verdict, error) {
+				return a.ExplainURL(in.URL, core.Process{ExePath: in.Process, SHA256: in.SHA256, User: in.User})
+			}),
+		control.Handle("GET", "/v1/processes/{key}/lineage", "getProcessLineage", "A logged process and its ancestors",
+			func(in processParam) ([]core.Process, error) { return a.GetProcessLineage(in.Key), nil }),
+		control.Handle("GET", "/v1/stats", "getStats", "Traffic totals",
+			func(struct{}) (core.Stats, error) { return a.GetStats(), nil }),
+		control.Handle("GET", "/v1/traffic", "getTraffic", "Traffic over time",
+			func(in trafficQuery) ([]core.TrafficDataPoint, error) { return a.GetChartData(in.Duration), nil }),
+		control.Handle("GET", "/v1/usage", "getUsage", "Top domains, processes, ports, IPs or countries",
+			func(in UsageRequest) ([]core.UsageStat, error) { return a.GetTopUsage(in) }),
+
+		control.Handle("GET", "/v1/filters", "listFilters", "List adblock filter lists",
+			func(struct{}) ([]core.AdblockFilter, error) { return a.GetAdblockFilters(), nil }),
+		control.Handle("POST", "/v1/filters", "addFilter", "Add a filter list by URL",
+			func(in filterRequest) (core.AdblockFilter, error) { return a.addAdblockFilter(in.Name, in.URL) }),
+		control.Handle("PATCH", "/v1/filters/{id}", "updateFilter", "Enable or disable a filter list or change its refresh interval",
+			func(in filterUpdate) (struct{}, error) {
+				if in.Enabled != nil {
+					if err := a.ToggleAdblockFilter(in.ID, *in.Enabled); err != nil {
+						return done(filterNotFound(err))
+					}
+				}
+				if in.UpdateInterval != nil {
+					return done(filterNotFound(a.SetAdblockFilterInterval(in.ID, *in.UpdateInterval)))
+				}
+				return done(nil)
+			}),
+		control.Handle("DELETE", "/v1/filters/{id}", "deleteFilter", "Delete a filter list",
+			func(in idParam) (struct{}, error) { return done(a.DeleteAdblockFilter(in.ID)) }),
+		control.Handle("POST", "/v1/filters/refresh", "refreshFilters", "Download filter lists and rebuild the engine",
+			func(struct{}) (struct{}, error) { return done(a.RefreshAdblockFilters()) }),
+
+		control.Handle("GET", "/v1/settings", "getSettings", "Application settings",
+			func(struct{}) (AppSettings, error) { return a.GetAppSettings(), nil }),
+		control.Handle("PUT", "/v1/settings", "saveSettings", "Save and apply application settings",
+			func(in AppSettings) (struct{}, error) { return done(a.SaveAppSettings(in)) }),
+		control.Handle("GET", "/v1/retention", "getRetention", "How long logs are kept",
+			func(struct{}) (core.RetentionPolicy, error) { return a.GetRetentionPolicy(), nil }),
+		control.Handle("PUT", "/v1/retention", "setRetention", "Change how long logs are kept",
+			func(in core.RetentionPolicy) (struct{}, error) { return done(a.SetRetentionPolicy(in)) }),
+		control.Handle("GET", "/v1/dns/upstreams", "getDNSUpstreams", "DNS resolvers queries are forwarded to",
+			func(struct{}) (dnsUpstreams, error) { return dnsUpstreams{a.GetDNSUpstreams()}, nil }),
+		control.Handle("PUT", "/v1/dns/upstreams", "setDNSUpstreams", "Change the DNS resolvers, tried in order",
+			func(in dnsUpstreams) (struct{}, error) { return done(a.SetDNSUpstreams(in.Upstreams)) }),
+	}
+}
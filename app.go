@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -15,6 +17,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/vkhangstack/Custos/internal/adblock"
+	"github.com/vkhangstack/Custos/internal/control"
 	"github.com/vkhangstack/Custos/internal/system"
 
 	"github.com/vkhangstack/Custos/internal/store"
@@ -39,8 +43,30 @@ type App struct {
 	proxyServer   *proxy.Server
 	dnsServer     *dns.Server
 	systemTracker *system.Tracker
+	systemProxy   *system.ProxyController
+	watchdog      *system.Watchdog
+	transparent   transparentState
 	blocklist     *core.BlocklistManager
 	refreshMu     sync.Mutex
+
+	// Filter refresh scheduling
+	adblockHash      string // Hash of the lists the running engine was built from
+	adblockRuleCount int
+	schedulerMu      sync.Mutex
+	filterJitter     map[string]time.Duration
+
+	// Live log stream
+	streamMu     sync.Mutex
+	streamCancel context.CancelFunc
+
+	// Control socket, and the daemon a window is attached to
+	gui             bool // Running in a window, a.ctx is from Wails
+	events          *control.Hub
+	controlListener net.Listener
+	controlServer   *control.Server
+	remote          *control.Client
+	apiMu           sync.Mutex
+	apiListener     net.Listener // Control API over loopback TCP
 }
 
 // NewApp creates a new App application struct
@@ -86,7 +112,10 @@ func NewApp() *App {
 		proxyServer:   proxy.NewServer(s, bm, systemTracker, port),
 		dnsServer:     dns.NewServer(s, bm, 5353),
 		systemTracker: systemTracker,
+		systemProxy:   system.NewProxyController(filepath.Join(dataPath, "proxy_snapshot.json")),
 		blocklist:     bm,
+		filterJitter:  make(map[string]time.Duration),
+		events:        control.NewHub(),
 	}
 }
 
@@ -96,9 +125,43 @@ func NewApp() *App {
 // so we can call the runtime methods
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
+	a.gui = true
+
+	// The daemon runs the services, the window only shows them
+	if a.remote != nil {
+		go a.forwardEvents(ctx)
+		return
+	}
+	a.startServices(ctx)
+}
+
+// startServices starts the proxy and everything around it, with or
+// without a window
+func (a *App) startServices(ctx context.Context) {
+	// Repair a system proxy left pointing at a previous run that crashed,
+	// before this run starts listening on the same port
+	if recovered, err := a.systemProxy.RecoverStale(); err != nil {
+		log.Printf("Failed to check the system proxy: %v", err)
+	} else if recovered {
+		log.Println("Restored the system proxy left behind by a previous run")
+	}
+	if w, err := a.systemProxy.StartWatchdog(); err != nil {
+		log.Printf("Running without a proxy watchdog: %v", err)
+	} else {
+		a.watchdog = w
+	}
+
 	a.proxyServer.Start()
 	// a.dnsServer.Start()
 
+	// Rules left by a crashed run would send every connection to a dead port
+	if err := system.RemoveRedirect(); err != nil {
+		log.Printf("Failed to remove stale redirect rules: %v", err)
+	}
+	if a.getTransparentEnabled() {
+		a.setTransparent(true)
+	}
+
 	// Auto-enable system proxy
 	// if err := a.SetSystemProxy(true); err != nil {
 	// 	fmt.Printf("Failed to set system proxy on startup: %v\n", err)
@@ -121,60 +184,180 @@ func (a *App) startup(ctx context.Context) {
 
 	// Seed and Refresh Filters
 	go func() {
+		// Protect with the last known engine while lists are refreshed
+		a.loadAdblockSnapshot()
 		a.seedFilters()
 		a.RefreshAdblockFilters()
 	}()
 
-	// Start a ticker to emit logs to frontend
-	go a.broadcastLogs()
+	// Keep filter lists fresh in the background
+	go a.runFilterScheduler(ctx)
+
+	// Stream logs to the frontend as they happen
+	a.broadcastLogs()
+
+	a.serveControl()
 }
 
 // shutdown is called at application termination
 func (a *App) shutdown(ctx context.Context) {
+	// Closing an attached window leaves the daemon running
+	if a.remote != nil {
+		return
+	}
+	if a.controlListener != nil {
+		a.controlListener.Close()
+	}
+	a.serveAPI(0)
 	// Auto-disable system proxy
 	if err := a.SetSystemProxy(false); err != nil {
 		fmt.Printf("Failed to disable system proxy on shutdown: %v\n", err)
 	}
+	if a.proxyServer.TransparentPort() != 0 {
+		a.setTransparent(false)
+	}
 	a.proxyServer.Stop()
+	if a.watchdog != nil {
+		a.watchdog.Stop()
+	}
+	if c, ok := a.store.(io.Closer); ok {
+		c.Close()
+	}
 	ctx.Done()
 }
 
-// broadcastLogs sends new logs to frontend events
+// Live log stream batching; bursts are sent as one event
+const (
+	logStreamInterval = 200 * time.Millisecond
+	logStreamBatch    = 500
+)
+
+// broadcastLogs streams every new and updated log to the frontend
 func (a *App) broadcastLogs() {
-	a.store.Subscribe(func(entry core.LogEntry) {
-		// Emit event to Wails runtime
-		// runtime.EventsEmit(a.ctx, "log:new", entry)
-		// Since we don't have runtime imported here broadly, we'd typically use it
-		// For now let's rely on polling or add runtime via import
-	})
+	if err := a.StreamLogs("", "", ""); err != nil {
+		log.Printf("Failed to start log stream: %v", err)
+	}
+}
+
+// StreamLogs replaces the live log stream with one filtered like
+// GetLogsPaginated. Batches of complete entries are emitted as "logs:new";
+// entries already shown should be replaced by ID.
+func (a *App) StreamLogs(search, status, logType string) error {
+	if a.remote != nil {
+		return a.remote.Call("StreamLogs", nil, search, status, logType)
+	}
+	q, err := store.ParseLogQuery(search)
+	if err != nil {
+		return err
+	}
+
+	a.streamMu.Lock()
+	defer a.streamMu.Unlock()
+	if a.streamCancel != nil {
+		a.streamCancel()
+	}
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.streamCancel = cancel
+
+	sub := a.store.SubscribeLogs(ctx, store.SubscribeOptions{Query: q.WithFilters(status, logType), Buffer: 4 * logStreamBatch})
+	go a.emitLogs(sub)
+	return nil
+}
+
+// emitLogs forwards a subscription to the frontend in batches until it closes
+func (a *App) emitLogs(sub *store.Subscription) {
+	ticker := time.NewTicker(logStreamInterval)
+	defer ticker.Stop()
+
+	var batch []core.LogEntry
+	var reportedDrops int64
+	flush := func() {
+		if len(batch) > 0 {
+			a.emit("logs:new", batch)
+			batch = nil
+		}
+		if dropped := sub.Dropped(); dropped != reportedDrops {
+			a.emit("logs:dropped", dropped-reportedDrops)
+			reportedDrops = dropped
+		}
+	}
+
+	for {
+		select {
+		case entry, ok := <-sub.C:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= logStreamBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
 }
 
 // GetLogs returns recent logs for the frontend
 func (a *App) GetLogs() []core.LogEntry {
+	if a.remote != nil {
+		return call[[]core.LogEntry](a, "GetLogs")
+	}
 	return a.store.GetRecentLogs(50)
 }
 
-// GetLogsPaginated returns paginated logs for the frontend
+// GetLogsPaginated returns paginated logs for the frontend. search uses the
+// query syntax of store.ParseLogQuery, e.g. "domain:*.google.com bytes>1MB".
 func (a *App) GetLogsPaginated(cursor string, limit int, search, status, logType string) core.PaginatedLogs {
-	logs, nextCursor, hasMore, total, err := a.store.GetLogsPaginated(cursor, limit, search, status, logType)
+	if a.remote != nil {
+		return call[core.PaginatedLogs](a, "GetLogsPaginated", cursor, limit, search, status, logType)
+	}
+	empty := core.PaginatedLogs{Logs: []core.LogEntry{}, Total: 0}
+	q, err := store.ParseLogQuery(search)
 	if err != nil {
-		return core.PaginatedLogs{Logs: []core.LogEntry{}, Total: 0}
+		log.Printf("Invalid log search %q: %v", search, err)
+		return empty
 	}
-	return core.PaginatedLogs{
-		Logs:       logs,
-		NextCursor: nextCursor,
-		HasMore:    hasMore,
-		Total:      total,
+	page, err := a.store.SearchLogs(cursor, limit, q.WithFilters(status, logType))
+	if err != nil {
+		log.Printf("Failed to search logs: %v", err)
+		return empty
 	}
+	return page
+}
+
+// ResetData clears logs, stats and filter hit counters; rules, filter lists and settings are kept
+func (a *App) ResetData() error {
+	if a.remote != nil {
+		return a.remote.Call("ResetData", nil)
+	}
+	a.store.ResetData()
+	return nil
 }
 
 // GetStats returns current stats
 func (a *App) GetStats() core.Stats {
+	if a.remote != nil {
+		return call[core.Stats](a, "GetStats")
+	}
 	return a.store.GetStats()
 }
 
+// GetProcessLineage returns a logged process followed by its ancestors,
+// nearest first, for the process_key of a log entry
+func (a *App) GetProcessLineage(key string) []core.Process {
+	if a.remote != nil {
+		return call[[]core.Process](a, "GetProcessLineage", key)
+	}
+	return store.ProcessLineage(a.store, key)
+}
+
 // GetSystemConnections returns active system connections
 func (a *App) GetSystemConnections() []system.ConnectionInfo {
+	if a.remote != nil {
+		return call[[]system.ConnectionInfo](a, "GetSystemConnections")
+	}
 	conns, _ := a.systemTracker.GetActiveConnections()
 	return conns
 }
@@ -184,13 +367,34 @@ func (a *App) Greet(name string) string {
 	return fmt.Sprintf("Hello %s, It's show time!", name)
 }
 
-// SetSystemProxy enables or disables the system proxy
+// SetSystemProxy points the system proxy at Custos, or restores the
+// configuration the user had before
 func (a *App) SetSystemProxy(enabled bool) error {
-	return system.SetSystemProxy(enabled, a.proxyServer.GetPort())
+	if a.remote != nil {
+		return a.remote.Call("SetSystemProxy", nil, enabled)
+	}
+	err := a.systemProxy.SetSystemProxy(enabled, a.proxyServer.GetPort())
+	if err != nil {
+		log.Printf("Failed to configure the system proxy: %v", err)
+	}
+	return err
+}
+
+// GetSystemProxyStatus reports which proxy backends the last change was
+// applied to and which failed
+func (a *App) GetSystemProxyStatus() system.ProxyStatus {
+	if a.remote != nil {
+		return call[system.ProxyStatus](a, "GetSystemProxyStatus")
+	}
+	return a.systemProxy.Status()
 }
 
 // EnableProtection toggles HTTP blocking
 func (a *App) EnableProtection(enabled bool) {
+	if a.remote != nil {
+		call[any](a, "EnableProtection", enabled)
+		return
+	}
 	a.SetSystemProxy(enabled)
 	// Persist
 	val := "false"
@@ -202,6 +406,9 @@ func (a *App) EnableProtection(enabled bool) {
 
 // GetProtectionStatus returns the current status
 func (a *App) GetProtectionStatus() bool {
+	if a.remote != nil {
+		return call[bool](a, "GetProtectionStatus")
+	}
 	val, err := a.store.GetSetting("protection_enabled")
 	if err != nil {
 		return false
@@ -211,6 +418,10 @@ func (a *App) GetProtectionStatus() bool {
 
 // EnableAdblock toggles the adblock engine
 func (a *App) EnableAdblock(enabled bool) {
+	if a.remote != nil {
+		call[any](a, "EnableAdblock", enabled)
+		return
+	}
 	a.proxyServer.SetAdblockEnabled(enabled)
 	// Persist
 	val := "false"
@@ -222,6 +433,9 @@ func (a *App) EnableAdblock(enabled bool) {
 
 // GetAdblockStatus returns the current status
 func (a *App) GetAdblockStatus() bool {
+	if a.remote != nil {
+		return call[bool](a, "GetAdblockStatus")
+	}
 	val, err := a.store.GetSetting("adblock_enabled")
 	if err != nil || val == "" {
 		// Default to enabled if not set
@@ -230,45 +444,96 @@ func (a *App) GetAdblockStatus() bool {
 	return val == "true"
 }
 
-// GetChartData returns historical traffic data for the chart
+// GetChartData returns historical traffic data for the chart.
+// durationStr is a Go duration ("90m", "3h") or a number of days ("7d", "30d").
 func (a *App) GetChartData(durationStr string) []core.TrafficDataPoint {
-	// Parse duration
-	var duration time.Duration
-	switch durationStr {
-	case "1h":
-		duration = 1 * time.Hour
-	case "3h":
-		duration = 3 * time.Hour
-	case "24h":
-		duration = 24 * time.Hour
-	default:
+	if a.remote != nil {
+		return call[[]core.TrafficDataPoint](a, "GetChartData", durationStr)
+	}
+	duration, err := parseChartDuration(durationStr)
+	if err != nil || duration <= 0 {
 		// Default to 1h if invalid or empty
 		duration = 1 * time.Hour
 	}
 	return a.store.GetTrafficHistory(duration)
 }
 
+// ChartQuery selects chart data over an arbitrary range
+type ChartQuery struct {
+	From    int64  `json:"from"`   // Unix milliseconds
+	To      int64  `json:"to"`     // Unix milliseconds, 0 = now
+	Bucket  int64  `json:"bucket"` // Seconds, 0 = pick from the range
+	Domain  string `json:"domain"`
+	Process string `json:"process"`
+}
+
+// GetChartRange returns traffic over an arbitrary range and bucket size
+func (a *App) GetChartRange(query ChartQuery) []core.TrafficDataPoint {
+	if a.remote != nil {
+		return call[[]core.TrafficDataPoint](a, "GetChartRange", query)
+	}
+	q := core.TrafficQuery{
+		Bucket:  time.Duration(query.Bucket) * time.Second,
+		Domain:  query.Domain,
+		Process: query.Process,
+	}
+	if query.From > 0 {
+		q.From = time.UnixMilli(query.From)
+	}
+	if query.To > 0 {
+		q.To = time.UnixMilli(query.To)
+	}
+	return a.store.GetTrafficRange(q)
+}
+
+// parseChartDuration parses a Go duration, also accepting whole days ("7d")
+func parseChartDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
 // Rule Management
 
 // AddRule adds a new rule
 func (a *App) AddRule(pattern string, ruleType string) error {
-	rule := core.Rule{
-		ID:      utils.GenerateIDString(),
-		Pattern: pattern,
-		Type:    core.RuleType(ruleType),
-		Enabled: true,
-		Source:  core.RuleSourceCustom,
+	if a.remote != nil {
+		return a.remote.Call("AddRule", nil, pattern, ruleType)
 	}
-	return a.store.AddRule(rule)
+	_, err := a.addRule(core.Rule{Pattern: pattern, Type: core.RuleType(ruleType)})
+	return err
+}
+
+// addRule adds rule as an enabled custom rule and returns it
+func (a *App) addRule(rule core.Rule) (core.Rule, error) {
+	rule.ID = utils.GenerateIDString()
+	rule.Enabled = true
+	rule.Source = core.RuleSourceCustom
+	rule.HitCount = 0
+	if rule.Type != core.RuleBlock && rule.Type != core.RuleAllow {
+		return core.Rule{}, fmt.Errorf("rule type must be %s or %s", core.RuleBlock, core.RuleAllow)
+	}
+	return rule, a.store.AddRule(rule)
 }
 
 // GetRules returns all rules (legacy/internal use)
 func (a *App) GetRules() []core.Rule {
+	if a.remote != nil {
+		return call[[]core.Rule](a, "GetRules")
+	}
 	return a.store.GetRules()
 }
 
 // GetRulesPaginated returns rules with pagination
 func (a *App) GetRulesPaginated(page, pageSize int, search string) core.PaginatedRulesResponse {
+	if a.remote != nil {
+		return call[core.PaginatedRulesResponse](a, "GetRulesPaginated", page, pageSize, search)
+	}
 	rules, total, err := a.store.GetRulesPaginated(page, pageSize, search)
 	if err != nil {
 		return core.PaginatedRulesResponse{Rules: []core.Rule{}, Total: 0}
@@ -278,6 +543,9 @@ func (a *App) GetRulesPaginated(page, pageSize int, search string) core.Paginate
 
 // DeleteRule deletes a rule by ID
 func (a *App) DeleteRule(id string) error {
+	if a.remote != nil {
+		return a.remote.Call("DeleteRule", nil, id)
+	}
 	return a.store.DeleteRule(id)
 }
 
@@ -287,6 +555,9 @@ func (a *App) DeleteRule(id string) error {
 // But frontend might just call Delete/Add. Or we need explicit Toggle.
 // Let's stick to Add/Get/Delete for MVP as per request "add rules and magement".
 func (a *App) ToggleRule(id string, enabled bool) error {
+	if a.remote != nil {
+		return a.remote.Call("ToggleRule", nil, id, enabled)
+	}
 	// We need to fetch it first? Or just blindly update?
 	// SQLiteStore UpdateRule updates fields present in struct.
 	// We just pass ID and Enabled.
@@ -339,10 +610,14 @@ type AppSettings struct {
 	Notifications  bool `json:"notifications"`
 	AutoStart      bool `json:"auto_start"`
 	AdblockEnabled bool `json:"adblock_enabled"`
+	APIPort        int  `json:"api_port"` // Loopback TCP port of the control API, 0 = off
 }
 
 // GetAppSettings returns current settings
 func (a *App) GetAppSettings() AppSettings {
+	if a.remote != nil {
+		return call[AppSettings](a, "GetAppSettings")
+	}
 	// Port
 	port := a.proxyServer.GetPort()
 
@@ -364,11 +639,15 @@ func (a *App) GetAppSettings() AppSettings {
 		Notifications:  notifications,
 		AutoStart:      autoStart,
 		AdblockEnabled: adblockEnabled,
+		APIPort:        a.apiPort(),
 	}
 }
 
 // SaveAppSettings saves settings and applies changes
 func (a *App) SaveAppSettings(settings AppSettings) error {
+	if a.remote != nil {
+		return a.remote.Call("SaveAppSettings", nil, settings)
+	}
 	// AutoStart
 	if err := a.SetRunOnStartup(settings.AutoStart); err != nil {
 		log.Printf("Failed to set startup: %v", err)
@@ -401,16 +680,64 @@ func (a *App) SaveAppSettings(settings AppSettings) error {
 	// Adblock
 	a.EnableAdblock(settings.AdblockEnabled)
 
+	// Control API over TCP
+	if settings.APIPort != a.apiPort() {
+		a.store.SetSetting("api_port", strconv.Itoa(settings.APIPort))
+		if err := a.serveAPI(settings.APIPort); err != nil {
+			return fmt.Errorf("failed to start the control API: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// GetRetentionPolicy returns how long logs are kept
+func (a *App) GetRetentionPolicy() core.RetentionPolicy {
+	if a.remote != nil {
+		return call[core.RetentionPolicy](a, "GetRetentionPolicy")
+	}
+	return store.GetRetentionPolicy(a.store)
+}
+
+// SetRetentionPolicy saves the log retention policy and applies it right away
+func (a *App) SetRetentionPolicy(policy core.RetentionPolicy) error {
+	if a.remote != nil {
+		return a.remote.Call("SetRetentionPolicy", nil, policy)
+	}
+	if err := store.SetRetentionPolicy(a.store, policy); err != nil {
+		return err
+	}
+	if p, ok := a.store.(store.Pruner); ok {
+		go func() {
+			if _, err := p.Prune(); err != nil {
+				log.Printf("Failed to prune logs: %v", err)
+			}
+		}()
+	}
 	return nil
 }
 
 // Adblock Filter Management
 
+var errFilterNotFound = errors.New("filter not found")
+
 func (a *App) GetAdblockFilters() []core.AdblockFilter {
+	if a.remote != nil {
+		return call[[]core.AdblockFilter](a, "GetAdblockFilters")
+	}
 	return a.store.GetAdblockFilters()
 }
 
 func (a *App) AddAdblockFilter(name, url string) error {
+	if a.remote != nil {
+		return a.remote.Call("AddAdblockFilter", nil, name, url)
+	}
+	_, err := a.addAdblockFilter(name, url)
+	return err
+}
+
+// addAdblockFilter adds a filter list, starts downloading it and returns it
+func (a *App) addAdblockFilter(name, url string) (core.AdblockFilter, error) {
 	filter := core.AdblockFilter{
 		ID:      utils.GenerateIDString(),
 		Name:    name,
@@ -421,10 +748,13 @@ func (a *App) AddAdblockFilter(name, url string) error {
 	if err == nil {
 		go a.RefreshAdblockFilters()
 	}
-	return err
+	return filter, err
 }
 
 func (a *App) DeleteAdblockFilter(id string) error {
+	if a.remote != nil {
+		return a.remote.Call("DeleteAdblockFilter", nil, id)
+	}
 	err := a.store.DeleteAdblockFilter(id)
 	if err == nil {
 		go a.RefreshAdblockFilters()
@@ -433,6 +763,9 @@ func (a *App) DeleteAdblockFilter(id string) error {
 }
 
 func (a *App) ToggleAdblockFilter(id string, enabled bool) error {
+	if a.remote != nil {
+		return a.remote.Call("ToggleAdblockFilter", nil, id, enabled)
+	}
 	filters := a.store.GetAdblockFilters()
 	for _, f := range filters {
 		if f.ID == id {
@@ -444,28 +777,54 @@ func (a *App) ToggleAdblockFilter(id string, enabled bool) error {
 			return err
 		}
 	}
-	return fmt.Errorf("filter not found")
+	return errFilterNotFound
+}
+
+// SetAdblockFilterInterval overrides how often a filter list is refreshed.
+// An interval of 0 restores the list's own Expires header or the default.
+func (a *App) SetAdblockFilterInterval(id string, seconds int64) error {
+	if a.remote != nil {
+		return a.remote.Call("SetAdblockFilterInterval", nil, id, seconds)
+	}
+	if seconds < 0 {
+		return fmt.Errorf("invalid interval")
+	}
+	filters := a.store.GetAdblockFilters()
+	for _, f := range filters {
+		if f.ID == id {
+			f.UpdateInterval = seconds
+			err := a.store.UpdateAdblockFilter(f)
+			if err == nil {
+				a.clearFilterJitter(id)
+			}
+			return err
+		}
+	}
+	return errFilterNotFound
 }
 
 func (a *App) RefreshAdblockFilters() error {
+	if a.remote != nil {
+		return a.remote.Call("RefreshAdblockFilters", nil)
+	}
 	a.refreshMu.Lock()
 	defer a.refreshMu.Unlock()
 
 	filters := a.store.GetAdblockFilters()
-	var allRules strings.Builder
+	var lists []adblock.FilterList
 	var blocklistSources []string
 	// Always include the default hosts list as a base for the blocklist
 	blocklistSources = append(blocklistSources, "https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts")
 
 	// Default hardcoded rules for adblock engine
-	allRules.WriteString(`||ads.google.com^
+	lists = append(lists, adblock.FilterList{Rules: `||ads.google.com^
 ||doubleclick.net^
 ||adnxs.com^
 ||googleadservices.com^
 ||pagead2.googlesyndication.com^
 ||analytics.google.com^
 ||facebook.com/tr/^
-`)
+`})
 
 	for _, f := range filters {
 		if !f.Enabled {
@@ -473,9 +832,7 @@ func (a *App) RefreshAdblockFilters() error {
 		}
 
 		// Add to blocklist sources
-		homeDir, _ := os.UserHomeDir()
-		filterDir := filepath.Join(homeDir, ".custos", "filters")
-		filePath := filepath.Join(filterDir, f.ID+".txt")
+		filePath := a.filterPath(f)
 
 		if _, err := os.Stat(filePath); err == nil {
 			blocklistSources = append(blocklistSources, filePath)
@@ -485,13 +842,39 @@ func (a *App) RefreshAdblockFilters() error {
 
 		content, err := a.getFilterContent(f)
 		if err == nil {
-			allRules.WriteString("\n")
-			allRules.WriteString(a.normalizeFilterRules(content))
+			// Tag rules with their list so hits can be attributed
+			lists = append(lists, adblock.FilterList{ID: f.ID, Rules: a.normalizeFilterRules(content)})
 		}
 	}
 
-	// Update and reload adblock engine
-	a.proxyServer.ReloadAdblockEngine(allRules.String())
+	// Rebuild the adblock engine unless the loaded snapshot already matches
+	listsHash := adblock.ListsHash(lists)
+	if listsHash != a.adblockHash {
+		size := 0
+		for _, l := range lists {
+			size += len(l.Rules)
+		}
+		engine := adblock.NewEngineFromLists(lists)
+		log.Printf("Adblock engine parsed with %d bytes of rules from %d lists", size, len(lists))
+		a.proxyServer.SetAdblockEngine(engine)
+		a.adblockHash = listsHash
+
+		if err := adblock.SaveSnapshot(a.adblockSnapshotPath(), engine, listsHash); err != nil {
+			log.Printf("Failed to save adblock engine snapshot: %v", err)
+		}
+	} else {
+		log.Println("Adblock engine snapshot is up to date, skipping rebuild")
+	}
+
+	// Let the frontend know when the effective rule set changed
+	count := 0
+	for _, l := range lists {
+		count += countFilterRules(l.Rules)
+	}
+	if count != a.adblockRuleCount {
+		a.adblockRuleCount = count
+		a.emit("adblock:rules-updated", count)
+	}
 
 	// Update and reload blocklist
 	if a.blocklist != nil {
@@ -503,89 +886,106 @@ func (a *App) RefreshAdblockFilters() error {
 }
 
 func (a *App) getFilterContent(f core.AdblockFilter) (string, error) {
-	homeDir, _ := os.UserHomeDir()
-	filterDir := filepath.Join(homeDir, ".custos", "filters")
-	os.MkdirAll(filterDir, 0755)
-	filePath := filepath.Join(filterDir, f.ID+".txt")
-
 	if f.URL == "" {
 		return "", nil
 	}
+	filePath := a.filterPath(f)
 
-	// Check if file exists and is less than 24h old
+	// Use the cached copy while it is still within the list's refresh interval
 	info, err := os.Stat(filePath)
-	if err == nil && time.Since(info.ModTime()) < 24*time.Hour {
+	if err == nil && time.Since(info.ModTime()) < f.RefreshInterval() {
 		content, err := os.ReadFile(filePath)
 		if err == nil {
 			return string(content), nil
 		}
 	}
 
-	// Download
-	log.Printf("Downloading adblock filter: %s from %s", f.Name, f.URL)
-	resp, err := http.Get(f.URL)
+	content, err := a.downloadFilter(f)
 	if err != nil {
+		// Keep protecting with the stale copy rather than dropping the list
+		if cached, readErr := os.ReadFile(filePath); readErr == nil {
+			log.Printf("Using stale copy of adblock filter %s: %v", f.Name, err)
+			return string(cached), nil
+		}
 		return "", err
 	}
-	defer resp.Body.Close()
+	return content, nil
+}
+
+// downloadFilter fetches a filter list, caches it on disk and records the
+// outcome on the filter so the scheduler can back off on failures
+func (a *App) downloadFilter(f core.AdblockFilter) (string, error) {
+	log.Printf("Downloading adblock filter: %s from %s", f.Name, f.URL)
+	f.LastAttempt = time.Now()
 
-	content, err := io.ReadAll(resp.Body)
+	content, err := fetchFilterList(f.URL)
 	if err != nil {
+		f.FailureCount++
+		a.store.UpdateAdblockFilter(f)
 		return "", err
 	}
 
-	os.WriteFile(filePath, content, 0644)
+	os.WriteFile(a.filterPath(f), content, 0644)
 
-	f.LastUpdated = time.Now()
+	f.LastUpdated = f.LastAttempt
+	f.FailureCount = 0
+	f.Expires = int64(core.ParseFilterExpires(string(content)) / time.Second)
 	a.store.UpdateAdblockFilter(f)
 
 	return string(content), nil
 }
 
-func (a *App) seedFilters() {
-	// Truncate before seeding as requested
-	a.store.ClearAdblockFilters()
-
-	defaults := []struct {
-		Name string
-		URL  string
-	}{
-		{"AdGuard DNS", "https://justdomains.github.io/blocklists/lists/adguarddns-justdomains.txt"},
-		{"Easy List", "https://justdomains.github.io/blocklists/lists/easylist-justdomains.txt"},
-		{"Easy Privacy", "https://justdomains.github.io/blocklists/lists/easyprivacy-justdomains.txt"},
-		{"NoCoin", "https://justdomains.github.io/blocklists/lists/nocoin-justdomains.txt"},
-		{"Pi-hole", "https://raw.githubusercontent.com/xxcriticxx/.pl-host-file/master/hosts.txt"},
-		{"Ramnit", "https://1275.ru/DGA/ramnit.txt"},
-		{"SharkBot", "https://1275.ru/DGA/sharkbot.txt"},
-		{"QSnatch", "https://1275.ru/DGA/qsnatch.txt"},
-		{"CryptoLocker", "https://1275.ru/DGA/cryptolocker.txt"},
-		{"1024 Hosts", "https://raw.githubusercontent.com/Goooler/1024_hosts/master/hosts"},
-	}
-
-	existingFilters := a.store.GetAdblockFilters()
-	existingURLs := make(map[string]bool)
-	for _, f := range existingFilters {
-		existingURLs[f.URL] = true
-	}
-
-	added := false
-	for _, d := range defaults {
-		if !existingURLs[d.URL] {
-			filter := core.AdblockFilter{
-				ID:      utils.GenerateIDString(),
-				Name:    d.Name,
-				URL:     d.URL,
-				Enabled: true,
-			}
-			if err := a.store.AddAdblockFilter(filter); err == nil {
-				added = true
-			}
-		}
+func fetchFilterList(url string) ([]byte, error) {
+	// Bypass the system proxy, which may be pointing at ourselves
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: nil,
+		},
+		Timeout: 60 * time.Second,
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	if added {
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// filterPath returns the on-disk cache location of a filter list
+func (a *App) filterPath(f core.AdblockFilter) string {
+	homeDir, _ := os.UserHomeDir()
+	filterDir := filepath.Join(homeDir, ".custos", "filters")
+	os.MkdirAll(filterDir, 0755)
+	return filepath.Join(filterDir, f.ID+".txt")
+}
+
+// seedFilters subscribes to default lists that were never offered before
+// and removes cached downloads of lists that are gone
+func (a *App) seedFilters() {
+	if added := store.SeedAdblockFilters(a.store, store.DefaultAdblockFilters); added > 0 {
 		go a.RefreshAdblockFilters()
 	}
+	a.removeOrphanedFilterFiles()
+}
+
+// removeOrphanedFilterFiles deletes cache files no list refers to, like
+// those of lists seeded with new IDs on every start by older versions
+func (a *App) removeOrphanedFilterFiles() {
+	known := make(map[string]bool)
+	for _, f := range a.store.GetAdblockFilters() {
+		known[a.filterPath(f)] = true
+	}
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(a.filterPath(core.AdblockFilter{})), "*.txt"))
+	for _, file := range files {
+		if !known[file] {
+			os.Remove(file)
+		}
+	}
 }
 
 func (a *App) normalizeFilterRules(content string) string {
@@ -618,3 +1018,43 @@ func (a *App) normalizeFilterRules(content string) string {
 	}
 	return normalized.String()
 }
+
+// adblockSnapshotPath returns where the serialized adblock engine is cached
+func (a *App) adblockSnapshotPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".custos", "cache", "adblock.snapshot")
+}
+
+// loadAdblockSnapshot installs the cached adblock engine, if a compatible one exists
+func (a *App) loadAdblockSnapshot() {
+	a.refreshMu.Lock()
+	defer a.refreshMu.Unlock()
+
+	path := a.adblockSnapshotPath()
+	engine, hash, err := adblock.LoadSnapshot(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Discarding adblock engine snapshot: %v", err)
+			os.Remove(path)
+		}
+		return
+	}
+
+	a.proxyServer.SetAdblockEngine(engine)
+	a.adblockHash = hash
+	log.Println("Loaded adblock engine from snapshot")
+}
+
+// countFilterRules counts the non-comment lines of a normalized rule set
+func countFilterRules(rules string) int {
+	count := 0
+	scanner := bufio.NewScanner(strings.NewReader(rules))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		count++
+	}
+	return count
+}
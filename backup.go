@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/backup"
+	"github.com/vkhangstack/Custos/internal/store"
+	rt "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+var backupFilter = rt.FileFilter{DisplayName: "Custos configuration (*.json)", Pattern: "*.json"}
+
+// BackupConfig saves settings, custom rules, filter lists and DNS upstreams
+// to a file chosen by the user. It returns the path, or "" if cancelled.
+func (a *App) BackupConfig() (string, error) {
+	path, err := rt.SaveFileDialog(a.ctx, rt.SaveDialogOptions{
+		Title:           "Back Up Configuration",
+		DefaultFilename: fmt.Sprintf("custos-config-%s.json", time.Now().Format("20060102")),
+		Filters:         []rt.FileFilter{backupFilter},
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+	if err := a.SaveConfigBackup(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// SaveConfigBackup writes a configuration backup to path
+func (a *App) SaveConfigBackup(path string) error {
+	if a.remote != nil {
+		return a.remote.Call("SaveConfigBackup", nil, path)
+	}
+	return writeFile(path, func(f *os.File) error {
+		return backup.Write(f, backup.Create(a.store, a.GetAppInfo().Version))
+	})
+}
+
+// RestoreConfig applies a configuration backup chosen by the user. mode is
+// "merge" to keep items missing from the backup, or "replace" to remove them.
+// It returns nil if the user cancelled.
+func (a *App) RestoreConfig(mode string) (*backup.Result, error) {
+	path, err := rt.OpenFileDialog(a.ctx, rt.OpenDialogOptions{
+		Title:   "Restore Configuration",
+		Filters: []rt.FileFilter{backupFilter},
+	})
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return a.RestoreConfigFile(path, mode)
+}
+
+// RestoreConfigFile applies the configuration backup at path, see RestoreConfig
+func (a *App) RestoreConfigFile(path, mode string) (*backup.Result, error) {
+	if a.remote != nil {
+		return callErr[*backup.Result](a, "RestoreConfigFile", path, mode)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	archive, err := backup.Read(f)
+	if err != nil {
+		return nil, err
+	}
+	res, err := backup.Restore(a.store, archive, backup.Mode(mode))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Restored configuration from %s: %+v", path, res)
+
+	a.applyConfig()
+	return &res, nil
+}
+
+// applyConfig brings the running services in line with the stored configuration
+func (a *App) applyConfig() {
+	if val, err := a.store.GetSetting("proxy_port"); err == nil {
+		if port, err := strconv.Atoi(val); err == nil && port != a.proxyServer.GetPort() {
+			if err := a.proxyServer.Restart(port); err != nil {
+				log.Printf("Failed to restart proxy on port %d: %v", port, err)
+			}
+		}
+	}
+
+	a.EnableProtection(a.GetProtectionStatus())
+	a.EnableAdblock(a.GetAdblockStatus())
+	a.dnsServer.SetUpstreams(store.GetDNSUpstreams(a.store))
+
+	if p, ok := a.store.(store.Pruner); ok {
+		go p.Prune()
+	}
+	go a.RefreshAdblockFilters()
+}
+
+// GetDNSUpstreams returns the DNS resolvers queries are forwarded to
+func (a *App) GetDNSUpstreams() []string {
+	if a.remote != nil {
+		return call[[]string](a, "GetDNSUpstreams")
+	}
+	return store.GetDNSUpstreams(a.store)
+}
+
+// SetDNSUpstreams saves the DNS resolvers, tried in order, and applies them
+func (a *App) SetDNSUpstreams(upstreams []string) error {
+	if a.remote != nil {
+		return a.remote.Call("SetDNSUpstreams", nil, upstreams)
+	}
+	if err := store.SetDNSUpstreams(a.store, upstreams); err != nil {
+		return err
+	}
+	a.dnsServer.SetUpstreams(upstreams)
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/vkhangstack/Custos/internal/cli"
+	"github.com/vkhangstack/Custos/internal/control"
+	"github.com/vkhangstack/Custos/internal/system"
+
+	rt "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// windowBindings need a window and can't be called through the control socket
+var windowBindings = []string{"BackupConfig", "RestoreConfig", "ExportLogs", "ExportReport"}
+
+// custosPath returns the path of a file in ~/.custos
+func custosPath(name string) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".custos", name), nil
+}
+
+// controlSocketPath is where a running instance listens for the window and scripts
+func controlSocketPath() (string, error) {
+	return custosPath("custos.sock")
+}
+
+// runDaemon runs the proxy, DNS and filter refresh without a window until
+// it's interrupted or stopped, e.g. by systemd
+func runDaemon() error {
+	// Fail before touching the database another instance has open
+	l, err := lock(servicesLockName)
+	if errors.Is(err, system.ErrLocked) {
+		return control.ErrRunning
+	}
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+
+	app := NewApp()
+	if app == nil {
+		return errors.New("failed to initialize")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app.ctx = ctx
+	app.startServices(ctx)
+	log.Println("Custos daemon running")
+
+	<-ctx.Done()
+	log.Println("Custos daemon stopping")
+	app.shutdown(context.Background())
+	return nil
+}
+
+// runCLI runs a command line subcommand against the running instance and
+// returns the exit status
+func runCLI(args []string) int {
+	path, err := controlSocketPath()
+	if err != nil {
+		println("Custos:", err.Error())
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return cli.Run(ctx, control.NewClient(path), args, os.Stdout, os.Stderr)
+}
+
+// newAttachedApp creates an App for a window whose bindings run in the
+// instance behind client
+func newAttachedApp(client *control.Client) *App {
+	return &App{remote: client}
+}
+
+// serveControl lets windows and scripts control this instance
+func (a *App) serveControl() {
+	path, err := controlSocketPath()
+	if err != nil {
+		log.Printf("Control socket unavailable: %v", err)
+		return
+	}
+	l, err := control.Listen(path)
+	if err != nil {
+		log.Printf("Control socket unavailable: %v", err)
+		return
+	}
+	a.controlListener = l
+
+	a.controlServer = control.NewServer(a, a.events, windowBindings...)
+	a.controlServer.Version = a.GetAppInfo().Version
+	a.controlServer.Route(a.apiRoutes()...)
+	go func() {
+		if err := a.controlServer.Serve(l); err != nil {
+			log.Printf("Control socket error: %v", err)
+		}
+	}()
+
+	if port := a.apiPort(); port != 0 {
+		if err := a.serveAPI(port); err != nil {
+			log.Printf("Control API unavailable on port %d: %v", port, err)
+		}
+	}
+}
+
+// emit sends an event to this instance's window and to attached windows
+func (a *App) emit(name string, data ...any) {
+	if a.gui {
+		rt.EventsEmit(a.ctx, name, data...)
+	}
+	if a.events != nil {
+		a.events.Publish(name, data...)
+	}
+}
+
+// forwardEvents passes the events of the daemon on to the window
+func (a *App) forwardEvents(ctx context.Context) {
+	events, err := a.remote.Events(ctx)
+	if err != nil {
+		log.Printf("Failed to receive events from the daemon: %v", err)
+		return
+	}
+	for ev := range events {
+		if ev.Name == activateEvent {
+			a.handleActivate(ev)
+			continue
+		}
+		data := make([]any, len(ev.Data))
+		for i, d := range ev.Data {
+			data[i] = d
+		}
+		rt.EventsEmit(ctx, ev.Name, data...)
+	}
+	if ctx.Err() == nil {
+		log.Println("Lost the connection to the Custos daemon")
+	}
+}
+
+// call runs a binding in the daemon the window is attached to. Bindings
+// without an error result log failures.
+func call[T any](a *App, method string, args ...any) T {
+	var res T
+	if err := a.remote.Call(method, &res, args...); err != nil {
+		log.Printf("Daemon call %s failed: %v", method, err)
+	}
+	return res
+}
+
+// callErr runs a binding in the daemon that reports errors itself
+func callErr[T any](a *App, method string, args ...any) (T, error) {
+	var res T
+	err := a.remote.Call(method, &res, args...)
+	return res, err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/report"
+	"github.com/vkhangstack/Custos/internal/store"
+	rt "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+var exportFilters = map[string]rt.FileFilter{
+	report.FormatCSV:      {DisplayName: "CSV (*.csv)", Pattern: "*.csv"},
+	report.FormatJSONL:    {DisplayName: "JSON Lines (*.jsonl)", Pattern: "*.jsonl"},
+	report.FormatHTML:     {DisplayName: "HTML (*.html)", Pattern: "*.html"},
+	report.FormatMarkdown: {DisplayName: "Markdown (*.md)", Pattern: "*.md"},
+}
+
+// saveExport asks where to save an export and writes it there with save.
+// It returns the chosen path, or "" if the user cancelled.
+func (a *App) saveExport(title, name, format string, save func(path string) error) (string, error) {
+	filter, ok := exportFilters[format]
+	if !ok {
+		return "", fmt.Errorf("unsupported export format %q", format)
+	}
+
+	path, err := rt.SaveFileDialog(a.ctx, rt.SaveDialogOptions{
+		Title:           title,
+		DefaultFilename: fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102-150405"), format),
+		Filters:         []rt.FileFilter{filter},
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+	if err := save(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeFile creates path with write, and removes it again if write fails
+func writeFile(path string, write func(f *os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// ExportLogs saves the logs matching a search to a CSV or JSONL file chosen by the user
+func (a *App) ExportLogs(format, search, status, logType string) (string, error) {
+	// Reject bad input before asking for a file
+	if _, err := logExportQuery(format, search, status, logType); err != nil {
+		return "", err
+	}
+	return a.saveExport("Export Logs", "custos-logs", format, func(path string) error {
+		return a.SaveLogExport(path, format, search, status, logType)
+	})
+}
+
+// SaveLogExport writes the logs matching a search to path, see ExportLogs
+func (a *App) SaveLogExport(path, format, search, status, logType string) error {
+	if a.remote != nil {
+		return a.remote.Call("SaveLogExport", nil, path, format, search, status, logType)
+	}
+	q, err := logExportQuery(format, search, status, logType)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, func(f *os.File) error {
+		n, err := report.ExportLogs(f, a.store, q, format)
+		if err == nil {
+			log.Printf("Exported %d logs to %s", n, f.Name())
+		}
+		return err
+	})
+}
+
+func logExportQuery(format, search, status, logType string) (store.LogQuery, error) {
+	if format != report.FormatCSV && format != report.FormatJSONL {
+		return store.LogQuery{}, fmt.Errorf("unsupported log export format %q", format)
+	}
+	q, err := store.ParseLogQuery(search)
+	if err != nil {
+		return store.LogQuery{}, err
+	}
+	return q.WithFilters(status, logType), nil
+}
+
+// ExportReport saves an HTML or Markdown traffic report for a date range.
+// from and to are Unix milliseconds; to = 0 means now.
+func (a *App) ExportReport(format string, from, to int64) (string, error) {
+	if _, _, err := reportRange(format, from, to); err != nil {
+		return "", err
+	}
+	return a.saveExport("Export Report", "custos-report", format, func(path string) error {
+		return a.SaveReport(path, format, from, to)
+	})
+}
+
+// SaveReport writes a traffic report to path, see ExportReport
+func (a *App) SaveReport(path, format string, from, to int64) error {
+	if a.remote != nil {
+		return a.remote.Call("SaveReport", nil, path, format, from, to)
+	}
+	start, end, err := reportRange(format, from, to)
+	if err != nil {
+		return err
+	}
+	r, err := report.Build(a.store, start, end, report.DefaultTopN)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, func(f *os.File) error {
+		return report.Write(f, r, format)
+	})
+}
+
+func reportRange(format string, from, to int64) (time.Time, time.Time, error) {
+	end := time.Now()
+	if to > 0 {
+		end = time.UnixMilli(to)
+	}
+	start := time.UnixMilli(from)
+	if format != report.FormatHTML && format != report.FormatMarkdown {
+		return start, end, fmt.Errorf("unsupported report format %q", format)
+	}
+	if from <= 0 || !start.Before(end) {
+		return start, end, fmt.Errorf("invalid report range")
+	}
+	return start, end, nil
+}
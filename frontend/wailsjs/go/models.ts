@@ -143,6 +143,9 @@ export namespace core {
 	    enabled: boolean;
 	    source: string;
 	    hit_count: number;
+	    process_path?: string;
+	    process_sha256?: string;
+	    process_user?: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new Rule(source);
@@ -156,6 +159,9 @@ export namespace core {
 	        this.enabled = source["enabled"];
 	        this.source = source["source"];
 	        this.hit_count = source["hit_count"];
+	        this.process_path = source["process_path"];
+	        this.process_sha256 = source["process_sha256"];
+	        this.process_user = source["process_user"];
 	    }
 	}
 	export class PaginatedRulesResponse {
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/control"
+	"github.com/vkhangstack/Custos/internal/system"
+
+	rt "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Two locks keep instances apart: the services lock is held by the process
+// running the proxy and the database, the daemon or a window on its own;
+// the window lock by the process showing the window, on its own or
+// attached to the daemon
+const (
+	servicesLockName = "app.lock"
+	windowLockName   = "window.lock"
+)
+
+// activateEvent asks windows attached to the daemon to come to the front;
+// its argument is the arguments of the launch
+const activateEvent = "window:activate"
+
+// deepLinkScheme opens a page of the window, e.g. "custos://rules"
+const deepLinkScheme = "custos"
+
+// startupTimeout bounds how long a launch waits for a running instance
+// that holds its lock but isn't answering yet
+const startupTimeout = 5 * time.Second
+
+// lock takes one of the instance locks in ~/.custos
+func lock(name string) (*system.FileLock, error) {
+	path, err := custosPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return system.Lock(path)
+}
+
+// lockWindow makes this launch the only window. If there already is one,
+// it's handed args instead and the returned lock is nil.
+func lockWindow(args []string) (*system.FileLock, error) {
+	l, err := lock(windowLockName)
+	if !errors.Is(err, system.ErrLocked) {
+		return l, err
+	}
+	return nil, activateRunning(args)
+}
+
+// activateRunning passes the arguments of this launch to the running window
+func activateRunning(args []string) error {
+	client, err := runningInstance()
+	if err != nil {
+		return err
+	}
+	if args == nil {
+		args = []string{}
+	}
+	return client.Do(context.Background(), "POST", "/v1/activate", activateRequest{Args: args}, nil)
+}
+
+// runningInstance connects to the instance that holds a lock, waiting a
+// little for one that is still starting up
+func runningInstance() (*control.Client, error) {
+	path, err := controlSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	client := control.NewClient(path)
+	deadline := time.Now().Add(startupTimeout)
+	for {
+		err := client.Ping()
+		if err == nil {
+			return client, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("another Custos instance is running but not answering")
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+type activateRequest struct {
+	Args []string `json:"args"` // e.g. "--show" or a custos:// link
+}
+
+// activate shows the window for another launch. The daemon passes the
+// arguments on to its attached window.
+func (a *App) activate(args []string) {
+	if a.gui {
+		a.showWindow(args)
+		return
+	}
+	a.events.Publish(activateEvent, args)
+}
+
+// handleActivate shows the window for an activation forwarded by the daemon
+func (a *App) handleActivate(ev control.Event) {
+	var args []string
+	if len(ev.Data) > 0 {
+		json.Unmarshal(ev.Data[0], &args)
+	}
+	a.showWindow(args)
+}
+
+// showWindow brings the window to the front and follows a deep link in args
+func (a *App) showWindow(args []string) {
+	rt.WindowUnminimise(a.ctx)
+	rt.WindowShow(a.ctx)
+	a.openLink(args)
+}
+
+// openLink navigates the window to the page of the first custos:// link
+// in args
+func (a *App) openLink(args []string) {
+	for _, arg := range args {
+		u, err := url.Parse(arg)
+		if err != nil || u.Scheme != deepLinkScheme {
+			continue
+		}
+		// custos://rules and custos:///rules are the same page
+		route := path.Clean("/" + u.Host + "/" + strings.TrimPrefix(u.Path, "/"))
+		if u.RawQuery != "" {
+			route += "?" + u.RawQuery
+		}
+		rt.EventsEmit(a.ctx, "navigate-to", route)
+		return
+	}
+}
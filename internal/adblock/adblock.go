@@ -0,0 +1,25 @@
+package adblock
+
+// FilterList is a set of filter rules tagged with the list they came from
+type FilterList struct {
+	ID    string // Empty for built-in rules
+	Rules string
+}
+
+// MatchResult describes how the engine decided on a request
+type MatchResult struct {
+	Matched   bool   // The request should be blocked
+	Filter    string // Blocking filter that matched, if any
+	Exception string // Exception filter that overrode Filter, if any
+	List      string // ID of the list Filter came from
+}
+
+// NewEngine creates an engine from a single, untagged set of rules
+func NewEngine(rules string) *Engine {
+	return NewEngineFromLists([]FilterList{{Rules: rules}})
+}
+
+// Check reports whether the request should be blocked
+func (e *Engine) Check(url, sourceURL, resourceType string) bool {
+	return e.Match(url, sourceURL, resourceType).Matched
+}
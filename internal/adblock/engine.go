@@ -1,4 +1,4 @@
-//go:build cgo
+//go:build cgo && !purego
 
 package adblock
 
@@ -10,23 +10,55 @@ package adblock
 typedef void* adblock_engine_t;
 
 adblock_engine_t adblock_engine_create(const char* rules);
+adblock_engine_t adblock_engine_create_lists(const char** ids, const char** rules, size_t count);
 bool adblock_engine_check(adblock_engine_t engine, const char* url, const char* source_url, const char* resource_type);
+bool adblock_engine_match(adblock_engine_t engine, const char* url, const char* source_url, const char* resource_type, char** filter, char** exception, char** list);
+void adblock_string_free(char* s);
+unsigned char* adblock_engine_serialize(adblock_engine_t engine, size_t* len);
+adblock_engine_t adblock_engine_deserialize(const unsigned char* data, size_t len);
+void adblock_bytes_free(unsigned char* data, size_t len);
 void adblock_engine_destroy(adblock_engine_t engine);
 */
 import "C"
 import (
+	"errors"
 	"unsafe"
 )
 
+// engineBackend tags snapshots written by this engine, bump it together
+// with the adblock crate version since its serialization format may change
+const engineBackend = "adblock-rust/0.9"
+
 type Engine struct {
 	ptr C.adblock_engine_t
 }
 
-func NewEngine(rules string) *Engine {
-	cRules := C.CString(rules)
-	defer C.free(unsafe.Pointer(cRules))
+func NewEngineFromLists(lists []FilterList) *Engine {
+	count := len(lists)
+	if count == 0 {
+		lists = []FilterList{{}}
+		count = 1
+	}
+
+	// C arrays must live in C memory since they hold C pointers
+	size := C.size_t(count) * C.size_t(unsafe.Sizeof(uintptr(0)))
+	cIDs := unsafe.Slice((**C.char)(C.malloc(size)), count)
+	cRules := unsafe.Slice((**C.char)(C.malloc(size)), count)
+	defer C.free(unsafe.Pointer(&cIDs[0]))
+	defer C.free(unsafe.Pointer(&cRules[0]))
 
-	ptr := C.adblock_engine_create(cRules)
+	for i, l := range lists {
+		cIDs[i] = C.CString(l.ID)
+		cRules[i] = C.CString(l.Rules)
+	}
+	defer func() {
+		for i := range lists {
+			C.free(unsafe.Pointer(cIDs[i]))
+			C.free(unsafe.Pointer(cRules[i]))
+		}
+	}()
+
+	ptr := C.adblock_engine_create_lists(&cIDs[0], &cRules[0], C.size_t(count))
 	if ptr == nil {
 		return nil
 	}
@@ -34,9 +66,9 @@ func NewEngine(rules string) *Engine {
 	return &Engine{ptr: ptr}
 }
 
-func (e *Engine) Check(url, sourceURL, resourceType string) bool {
+func (e *Engine) Match(url, sourceURL, resourceType string) MatchResult {
 	if e.ptr == nil {
-		return false
+		return MatchResult{}
 	}
 
 	cUrl := C.CString(url)
@@ -48,7 +80,51 @@ func (e *Engine) Check(url, sourceURL, resourceType string) bool {
 	cResourceType := C.CString(resourceType)
 	defer C.free(unsafe.Pointer(cResourceType))
 
-	return bool(C.adblock_engine_check(e.ptr, cUrl, cSourceURL, cResourceType))
+	var cFilter, cException, cList *C.char
+	matched := C.adblock_engine_match(e.ptr, cUrl, cSourceURL, cResourceType, &cFilter, &cException, &cList)
+
+	return MatchResult{
+		Matched:   bool(matched),
+		Filter:    takeString(cFilter),
+		Exception: takeString(cException),
+		List:      takeString(cList),
+	}
+}
+
+// takeString copies and frees a string allocated by the Rust library
+func takeString(s *C.char) string {
+	if s == nil {
+		return ""
+	}
+	defer C.adblock_string_free(s)
+	return C.GoString(s)
+}
+
+func (e *Engine) serialize() ([]byte, error) {
+	if e.ptr == nil {
+		return nil, errors.New("adblock: engine is closed")
+	}
+
+	var n C.size_t
+	data := C.adblock_engine_serialize(e.ptr, &n)
+	if data == nil {
+		return nil, errors.New("adblock: failed to serialize engine")
+	}
+	defer C.adblock_bytes_free(data, n)
+
+	return C.GoBytes(unsafe.Pointer(data), C.int(n)), nil
+}
+
+func deserializeEngine(data []byte) (*Engine, error) {
+	if len(data) == 0 {
+		return nil, errors.New("adblock: empty engine snapshot")
+	}
+
+	ptr := C.adblock_engine_deserialize((*C.uchar)(unsafe.Pointer(&data[0])), C.size_t(len(data)))
+	if ptr == nil {
+		return nil, errors.New("adblock: failed to deserialize engine")
+	}
+	return &Engine{ptr: ptr}, nil
 }
 
 func (e *Engine) Close() {
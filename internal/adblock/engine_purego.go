@@ -0,0 +1,625 @@
+//go:build !cgo || purego
+
+package adblock
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Engine is a pure-Go implementation of the network-filter subset of the
+// adblock syntax, used when the Rust library can't be linked (no cgo, or
+// the purego build tag). Cosmetic filters and regex filters are ignored.
+type Engine struct {
+	blocks     filterIndex
+	exceptions filterIndex
+}
+
+// engineBackend tags snapshots written by this engine
+const engineBackend = "purego/1"
+
+// Resource types, mirroring the adblock request types
+const (
+	typeDocument uint16 = 1 << iota
+	typeSubdocument
+	typeScript
+	typeImage
+	typeStylesheet
+	typeObject
+	typeXHR
+	typePing
+	typeMedia
+	typeFont
+	typeWebsocket
+	typeOther
+
+	// typeAny is what a filter without type options applies to.
+	// Like other adblock engines, documents must be opted into explicitly.
+	typeAny = typeSubdocument | typeScript | typeImage | typeStylesheet | typeObject |
+		typeXHR | typePing | typeMedia | typeFont | typeWebsocket | typeOther
+)
+
+var resourceTypes = map[string]uint16{
+	"document":       typeDocument,
+	"main_frame":     typeDocument,
+	"subdocument":    typeSubdocument,
+	"sub_frame":      typeSubdocument,
+	"script":         typeScript,
+	"image":          typeImage,
+	"stylesheet":     typeStylesheet,
+	"css":            typeStylesheet,
+	"object":         typeObject,
+	"xmlhttprequest": typeXHR,
+	"xhr":            typeXHR,
+	"ping":           typePing,
+	"beacon":         typePing,
+	"media":          typeMedia,
+	"font":           typeFont,
+	"websocket":      typeWebsocket,
+	"other":          typeOther,
+}
+
+// networkFilter is a single parsed filter line
+type networkFilter struct {
+	raw         string // Filter text as written in the list
+	list        string // ID of the originating list
+	pattern     string // Body without anchors, lowercase unless matchCase
+	hostAnchor  bool   // ||
+	startAnchor bool   // |
+	endAnchor   bool   // trailing |
+	exception   bool   // @@
+	important   bool
+	matchCase   bool
+	types       uint16
+	thirdParty  int8 // 0 = any, 1 = third-party only, -1 = first-party only
+	domains     []string
+	notDomains  []string
+}
+
+// filterIndex buckets filters so a request only checks plausible candidates
+type filterIndex struct {
+	hosts   map[string][]*networkFilter // ||host^ style filters, keyed by host
+	tokens  map[string][]*networkFilter // Keyed by a complete alphanumeric token of the pattern
+	generic []*networkFilter
+}
+
+// request holds the pre-processed parts of a request being checked
+type request struct {
+	url        string // Lowercased
+	rawURL     string
+	hostStart  int
+	hostEnd    int
+	sourceHost string
+	types      uint16
+	thirdParty bool
+}
+
+func NewEngineFromLists(lists []FilterList) *Engine {
+	e := &Engine{
+		blocks:     newFilterIndex(),
+		exceptions: newFilterIndex(),
+	}
+
+	for _, l := range lists {
+		scanner := bufio.NewScanner(strings.NewReader(l.Rules))
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			f := parseFilter(scanner.Text())
+			if f == nil {
+				continue
+			}
+			f.list = l.ID
+			if f.exception {
+				e.exceptions.add(f)
+			} else {
+				e.blocks.add(f)
+			}
+		}
+	}
+
+	return e
+}
+
+func (e *Engine) Match(url, sourceURL, resourceType string) MatchResult {
+	req := newRequest(url, sourceURL, resourceType)
+	if req == nil {
+		return MatchResult{}
+	}
+
+	var blocked *networkFilter
+	e.blocks.match(req, func(f *networkFilter) bool {
+		if blocked == nil || f.important {
+			blocked = f
+		}
+		// Keep looking only while an $important filter could still win
+		return f.important
+	})
+	if blocked == nil {
+		return MatchResult{}
+	}
+
+	result := MatchResult{
+		Matched: true,
+		Filter:  blocked.raw,
+		List:    blocked.list,
+	}
+	if blocked.important {
+		return result
+	}
+
+	e.exceptions.match(req, func(f *networkFilter) bool {
+		result.Matched = false
+		result.Exception = f.raw
+		return true
+	})
+	return result
+}
+
+func (e *Engine) Close() {
+}
+
+// serialize writes every filter as "listID\tfilter" lines, grouped by list.
+// Parsing is cheap in this backend, the snapshot mainly saves downloading and
+// normalizing the lists again.
+func (e *Engine) serialize() ([]byte, error) {
+	byList := make(map[string][]string)
+	for _, ix := range []*filterIndex{&e.blocks, &e.exceptions} {
+		ix.each(func(f *networkFilter) {
+			byList[f.list] = append(byList[f.list], f.raw)
+		})
+	}
+
+	ids := make([]string, 0, len(byList))
+	for id := range byList {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		for _, raw := range byList[id] {
+			buf.WriteString(id)
+			buf.WriteByte('\t')
+			buf.WriteString(raw)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func deserializeEngine(data []byte) (*Engine, error) {
+	var lists []FilterList
+	var rules strings.Builder
+	flush := func() {
+		if len(lists) > 0 {
+			lists[len(lists)-1].Rules = rules.String()
+			rules.Reset()
+		}
+	}
+
+	for len(data) > 0 {
+		line := data
+		if idx := bytes.IndexByte(data, '\n'); idx >= 0 {
+			line, data = data[:idx], data[idx+1:]
+		} else {
+			data = nil
+		}
+
+		id, raw, ok := bytes.Cut(line, []byte{'\t'})
+		if !ok {
+			return nil, fmt.Errorf("adblock: corrupt engine snapshot")
+		}
+		if len(lists) == 0 || lists[len(lists)-1].ID != string(id) {
+			flush()
+			lists = append(lists, FilterList{ID: string(id)})
+		}
+		rules.Write(raw)
+		rules.WriteByte('\n')
+	}
+	flush()
+
+	return NewEngineFromLists(lists), nil
+}
+
+// parseFilter parses a single line, returning nil for anything outside the
+// supported network-filter subset
+func parseFilter(line string) *networkFilter {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '!' || line[0] == '[' {
+		return nil
+	}
+	// Cosmetic filters and hosts-style lines
+	if strings.Contains(line, "##") || strings.Contains(line, "#@#") || strings.Contains(line, "#?#") ||
+		strings.Contains(line, "#$#") || strings.ContainsAny(line, " \t") {
+		return nil
+	}
+
+	f := &networkFilter{raw: line}
+	if strings.HasPrefix(line, "@@") {
+		f.exception = true
+		line = line[2:]
+	}
+
+	// Regex filters are not supported
+	if len(line) > 1 && line[0] == '/' && line[len(line)-1] == '/' {
+		return nil
+	}
+
+	if idx := strings.LastIndexByte(line, '$'); idx >= 0 {
+		if !f.parseOptions(line[idx+1:]) {
+			return nil
+		}
+		line = line[:idx]
+	}
+
+	switch {
+	case strings.HasPrefix(line, "||"):
+		f.hostAnchor = true
+		line = line[2:]
+	case strings.HasPrefix(line, "|"):
+		f.startAnchor = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "|") {
+		f.endAnchor = true
+		line = line[:len(line)-1]
+	}
+
+	// Collapse runs of wildcards and drop a leading one, it is implied
+	for strings.Contains(line, "**") {
+		line = strings.ReplaceAll(line, "**", "*")
+	}
+	if !f.hostAnchor && !f.startAnchor {
+		line = strings.TrimPrefix(line, "*")
+	}
+	if line == "" && !f.hostAnchor && !f.startAnchor && len(f.domains) == 0 {
+		// Would match every request
+		return nil
+	}
+
+	if !f.matchCase {
+		line = strings.ToLower(line)
+	}
+	f.pattern = line
+	if f.types == 0 {
+		f.types = typeAny
+	}
+	return f
+}
+
+// parseOptions applies the $options of a filter, reporting false if any
+// option is unsupported so the filter gets skipped rather than misapplied
+func (f *networkFilter) parseOptions(options string) bool {
+	var types, notTypes uint16
+	for _, opt := range strings.Split(options, ",") {
+		opt = strings.TrimSpace(opt)
+		negated := strings.HasPrefix(opt, "~")
+		name := strings.TrimPrefix(opt, "~")
+
+		switch {
+		case strings.HasPrefix(name, "domain="):
+			if negated {
+				return false
+			}
+			for _, d := range strings.Split(strings.TrimPrefix(name, "domain="), "|") {
+				d = strings.ToLower(strings.TrimSpace(d))
+				if strings.HasPrefix(d, "~") {
+					f.notDomains = append(f.notDomains, d[1:])
+				} else if d != "" {
+					f.domains = append(f.domains, d)
+				}
+			}
+		case name == "third-party" || name == "3p":
+			f.thirdParty = 1
+			if negated {
+				f.thirdParty = -1
+			}
+		case name == "first-party" || name == "1p":
+			f.thirdParty = -1
+			if negated {
+				f.thirdParty = 1
+			}
+		case name == "important":
+			f.important = true
+		case name == "match-case":
+			f.matchCase = true
+		default:
+			t, ok := resourceTypes[name]
+			if !ok {
+				return false
+			}
+			if negated {
+				notTypes |= t
+			} else {
+				types |= t
+			}
+		}
+	}
+
+	switch {
+	case types != 0:
+		f.types = types &^ notTypes
+	case notTypes != 0:
+		f.types = typeAny &^ notTypes
+	}
+	return true
+}
+
+// matches reports whether the filter applies to the request
+func (f *networkFilter) matches(req *request) bool {
+	if f.types&req.types == 0 {
+		return false
+	}
+	if f.thirdParty == 1 && !req.thirdParty || f.thirdParty == -1 && req.thirdParty {
+		return false
+	}
+	if len(f.domains) > 0 || len(f.notDomains) > 0 {
+		for _, d := range f.notDomains {
+			if isSubdomain(req.sourceHost, d) {
+				return false
+			}
+		}
+		if len(f.domains) > 0 {
+			found := false
+			for _, d := range f.domains {
+				if isSubdomain(req.sourceHost, d) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+
+	url := req.url
+	if f.matchCase {
+		url = req.rawURL
+	}
+
+	switch {
+	case f.hostAnchor:
+		// Try every label boundary of the hostname
+		for i := req.hostStart; i < req.hostEnd; i++ {
+			if i == req.hostStart || url[i-1] == '.' {
+				if globMatch(f.pattern, url[i:], f.endAnchor) {
+					return true
+				}
+			}
+		}
+		return false
+	case f.startAnchor:
+		return globMatch(f.pattern, url, f.endAnchor)
+	default:
+		for i := 0; i <= len(url); i++ {
+			if globMatch(f.pattern, url[i:], f.endAnchor) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// globMatch matches a pattern with '*' and '^' against the start of s
+func globMatch(pattern, s string, endAnchor bool) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = pattern[1:]
+			if pattern == "" && !endAnchor {
+				return true
+			}
+			for k := 0; k <= len(s); k++ {
+				if globMatch(pattern, s[k:], endAnchor) {
+					return true
+				}
+			}
+			return false
+		case '^':
+			// A separator also matches the end of the address
+			if len(s) > 0 {
+				if !isSeparator(s[0]) {
+					return false
+				}
+				s = s[1:]
+			}
+			pattern = pattern[1:]
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return !endAnchor || len(s) == 0
+}
+
+// isSeparator implements '^': anything but a letter, digit, or one of _-.%
+func isSeparator(c byte) bool {
+	return !isTokenChar(c) && c != '_' && c != '-' && c != '.'
+}
+
+func isTokenChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '%'
+}
+
+func isSubdomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func newRequest(url, sourceURL, resourceType string) *request {
+	req := &request{
+		url:    strings.ToLower(url),
+		rawURL: url,
+	}
+
+	var ok bool
+	req.hostStart, req.hostEnd, ok = hostBounds(req.url)
+	if !ok {
+		return nil
+	}
+
+	if t, ok := resourceTypes[strings.ToLower(resourceType)]; ok {
+		req.types = t
+	} else {
+		req.types = typeOther
+	}
+
+	lowerSource := strings.ToLower(sourceURL)
+	if start, end, ok := hostBounds(lowerSource); ok {
+		req.sourceHost = lowerSource[start:end]
+		req.thirdParty = registrableDomain(req.sourceHost) != registrableDomain(req.url[req.hostStart:req.hostEnd])
+	}
+	return req
+}
+
+// hostBounds returns the hostname span of an absolute URL
+func hostBounds(url string) (int, int, bool) {
+	scheme := strings.Index(url, "://")
+	if scheme <= 0 {
+		return 0, 0, false
+	}
+	start := scheme + 3
+	end := start
+	for end < len(url) && !strings.ContainsRune("/?#:", rune(url[end])) {
+		end++
+	}
+	// Strip credentials
+	if at := strings.LastIndexByte(url[start:end], '@'); at >= 0 {
+		start += at + 1
+	}
+	if start == end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func registrableDomain(host string) string {
+	if d, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return d
+	}
+	return host
+}
+
+func newFilterIndex() filterIndex {
+	return filterIndex{
+		hosts:  make(map[string][]*networkFilter),
+		tokens: make(map[string][]*networkFilter),
+	}
+}
+
+func (ix *filterIndex) add(f *networkFilter) {
+	if host, ok := f.indexHost(); ok {
+		ix.hosts[host] = append(ix.hosts[host], f)
+		return
+	}
+	if token := f.indexToken(); token != "" {
+		ix.tokens[token] = append(ix.tokens[token], f)
+		return
+	}
+	ix.generic = append(ix.generic, f)
+}
+
+// each calls fn for every filter in the index
+func (ix *filterIndex) each(fn func(*networkFilter)) {
+	for _, bucket := range []map[string][]*networkFilter{ix.hosts, ix.tokens} {
+		for _, filters := range bucket {
+			for _, f := range filters {
+				fn(f)
+			}
+		}
+	}
+	for _, f := range ix.generic {
+		fn(f)
+	}
+}
+
+// match calls fn for every filter matching the request until fn returns true
+func (ix *filterIndex) match(req *request, fn func(*networkFilter) bool) {
+	check := func(filters []*networkFilter) bool {
+		for _, f := range filters {
+			if f.matches(req) && fn(f) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Every label suffix of the hostname
+	host := req.url[req.hostStart:req.hostEnd]
+	for {
+		if check(ix.hosts[host]) {
+			return
+		}
+		dot := strings.IndexByte(host, '.')
+		if dot < 0 {
+			break
+		}
+		host = host[dot+1:]
+	}
+
+	// Every token of the URL
+	url := req.url
+	for i := 0; i < len(url); {
+		if !isTokenChar(url[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(url) && isTokenChar(url[j]) {
+			j++
+		}
+		if check(ix.tokens[url[i:j]]) {
+			return
+		}
+		i = j
+	}
+
+	check(ix.generic)
+}
+
+// indexHost returns the hostname of a "||host^" or "||host/..." filter
+func (f *networkFilter) indexHost() (string, bool) {
+	if !f.hostAnchor || f.matchCase {
+		return "", false
+	}
+	end := strings.IndexAny(f.pattern, "^/*|")
+	if end <= 0 || f.pattern[end] == '*' || f.pattern[end] == '|' {
+		return "", false
+	}
+	return f.pattern[:end], true
+}
+
+// indexToken returns the longest alphanumeric run of the pattern that must
+// appear as a whole token in any matching URL
+func (f *networkFilter) indexToken() string {
+	if f.matchCase {
+		return ""
+	}
+	best := ""
+	p := f.pattern
+	for i := 0; i < len(p); {
+		if !isTokenChar(p[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(p) && isTokenChar(p[j]) {
+			j++
+		}
+		// Both ends must be bounded by a literal separator or an anchor
+		leftOK := i > 0 && p[i-1] != '*' || i == 0 && (f.hostAnchor || f.startAnchor)
+		rightOK := j < len(p) && p[j] != '*' || j == len(p) && f.endAnchor
+		if leftOK && rightOK && j-i > len(best) {
+			best = p[i:j]
+		}
+		i = j
+	}
+	return best
+}
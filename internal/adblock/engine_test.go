@@ -34,3 +34,97 @@ func TestAdblockEngine(t *testing.T) {
 		}
 	}
 }
+
+// TestAdblockEngineSyntax covers the network-filter subset both backends support
+func TestAdblockEngineSyntax(t *testing.T) {
+	rules := `! Comment lines and cosmetic filters are ignored
+example.com##.banner
+||ads.example.com^
+@@||ads.example.com/allowed/
+||tracker.com^$third-party
+||cdn.example.org/ads/*
+|http://banner.
+/pixel.gif|
+||important.com^$important
+@@||important.com^
+||scripts.net^$script
+||media.net^$~image
+||sub.example.net^$domain=~safe.com
+`
+	engine := NewEngine(rules)
+	if engine == nil {
+		t.Fatal("Failed to create adblock engine")
+	}
+	defer engine.Close()
+
+	tests := []struct {
+		url          string
+		sourceURL    string
+		resourceType string
+		wantBlocked  bool
+	}{
+		// || hostname anchor and ^ separator
+		{"http://ads.example.com/", "http://news.com", "image", true},
+		{"http://x.ads.example.com/a.gif", "http://news.com", "image", true},
+		{"http://notads.example.com/", "http://news.com", "image", false},
+		{"http://ads.example.community/", "http://news.com", "image", false},
+		// @@ exceptions
+		{"http://ads.example.com/allowed/a.gif", "http://news.com", "image", false},
+		// $third-party
+		{"http://tracker.com/t.js", "http://news.com", "script", true},
+		{"http://tracker.com/t.js", "http://www.tracker.com", "script", false},
+		// * wildcard
+		{"http://cdn.example.org/ads/1.js", "http://news.com", "script", true},
+		{"http://cdn.example.org/img/1.js", "http://news.com", "script", false},
+		// | start and end anchors
+		{"http://banner.foo.com/x", "http://news.com", "image", true},
+		{"https://banner.foo.com/x", "http://news.com", "image", false},
+		{"http://site.com/pixel.gif", "http://news.com", "image", true},
+		{"http://site.com/pixel.gif?x=1", "http://news.com", "image", false},
+		// $important beats exceptions
+		{"http://important.com/x", "http://news.com", "image", true},
+		// Resource types
+		{"http://scripts.net/a.js", "http://news.com", "script", true},
+		{"http://scripts.net/a.png", "http://news.com", "image", false},
+		{"http://media.net/a.png", "http://news.com", "image", false},
+		{"http://media.net/a.mp4", "http://news.com", "media", true},
+		// Negated $domain=
+		{"http://sub.example.net/", "http://safe.com", "image", false},
+		{"http://sub.example.net/", "http://other.com", "image", true},
+	}
+
+	for _, tt := range tests {
+		got := engine.Check(tt.url, tt.sourceURL, tt.resourceType)
+		if got != tt.wantBlocked {
+			t.Errorf("Check(%q, %q, %q) = %v; want %v", tt.url, tt.sourceURL, tt.resourceType, got, tt.wantBlocked)
+		}
+	}
+}
+
+func TestAdblockEngineMatch(t *testing.T) {
+	engine := NewEngineFromLists([]FilterList{
+		{ID: "builtin", Rules: "||ads.example.com^\n"},
+		{ID: "easylist", Rules: "||tracker.com^\n@@||tracker.com/ok/\n"},
+	})
+	if engine == nil {
+		t.Fatal("Failed to create adblock engine")
+	}
+	defer engine.Close()
+
+	tests := []struct {
+		url  string
+		want MatchResult
+	}{
+		{"http://ads.example.com/a.gif", MatchResult{Matched: true, Filter: "||ads.example.com^", List: "builtin"}},
+		{"http://tracker.com/t.js", MatchResult{Matched: true, Filter: "||tracker.com^", List: "easylist"}},
+		{"http://tracker.com/ok/t.js", MatchResult{Matched: false, Filter: "||tracker.com^", Exception: "@@||tracker.com/ok/", List: "easylist"}},
+		{"http://example.com/", MatchResult{}},
+	}
+
+	for _, tt := range tests {
+		got := engine.Match(tt.url, "http://news.com", "script")
+		if got != tt.want {
+			t.Errorf("Match(%q) = %+v; want %+v", tt.url, got, tt.want)
+		}
+	}
+}
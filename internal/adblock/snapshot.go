@@ -0,0 +1,114 @@
+package adblock
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Snapshot file layout:
+//
+//	magic | format version (uint16) | backend (uint16 len + bytes) | lists hash (32 bytes) | engine payload
+//
+// Snapshots written by another format version or engine backend are rejected,
+// since the payload is only meaningful to the backend that produced it.
+const (
+	snapshotMagic   = "CUSTOSAB"
+	snapshotVersion = 1
+)
+
+// ErrStaleSnapshot is returned when a snapshot was written by an incompatible build
+var ErrStaleSnapshot = errors.New("adblock: stale engine snapshot")
+
+// ListsHash identifies a set of filter lists, so a snapshot can be matched
+// against the lists it was built from. The IDs are part of it since the
+// engine reports them, which is why they must be stable across starts; the
+// order isn't, as stores don't guarantee one.
+func ListsHash(lists []FilterList) string {
+	sorted := slices.Clone(lists)
+	slices.SortStableFunc(sorted, func(a, b FilterList) int { return strings.Compare(a.ID, b.ID) })
+
+	h := sha256.New()
+	for _, l := range sorted {
+		fmt.Fprintf(h, "%d:%s\n%d:", len(l.ID), l.ID, len(l.Rules))
+		io.WriteString(h, l.Rules)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SaveSnapshot serializes the engine to path, tagged with the hash of the
+// lists it was built from. The file is replaced atomically.
+func SaveSnapshot(path string, e *Engine, listsHash string) error {
+	hash, err := hex.DecodeString(listsHash)
+	if err != nil || len(hash) != sha256.Size {
+		return fmt.Errorf("adblock: invalid lists hash %q", listsHash)
+	}
+
+	payload, err := e.serialize()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(snapshotMagic)
+	binary.Write(&buf, binary.LittleEndian, uint16(snapshotVersion))
+	binary.Write(&buf, binary.LittleEndian, uint16(len(engineBackend)))
+	buf.WriteString(engineBackend)
+	buf.Write(hash)
+	buf.Write(payload)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadSnapshot restores an engine saved by SaveSnapshot and returns the hash
+// of the lists it was built from. Incompatible snapshots yield ErrStaleSnapshot.
+func LoadSnapshot(path string) (*Engine, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	r := bytes.NewReader(data)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != snapshotMagic {
+		return nil, "", ErrStaleSnapshot
+	}
+
+	var version, backendLen uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil || version != snapshotVersion {
+		return nil, "", ErrStaleSnapshot
+	}
+	if err := binary.Read(r, binary.LittleEndian, &backendLen); err != nil {
+		return nil, "", ErrStaleSnapshot
+	}
+	backend := make([]byte, backendLen)
+	if _, err := io.ReadFull(r, backend); err != nil || string(backend) != engineBackend {
+		return nil, "", ErrStaleSnapshot
+	}
+
+	hash := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, hash); err != nil {
+		return nil, "", ErrStaleSnapshot
+	}
+
+	e, err := deserializeEngine(data[len(data)-r.Len():])
+	if err != nil {
+		return nil, "", err
+	}
+	return e, hex.EncodeToString(hash), nil
+}
@@ -0,0 +1,100 @@
+package adblock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/store"
+)
+
+func TestEngineSnapshot(t *testing.T) {
+	lists := []FilterList{
+		{ID: "easylist", Rules: "||ads.example.com^\n@@||ads.example.com/ok/\n"},
+	}
+	engine := NewEngineFromLists(lists)
+	if engine == nil {
+		t.Fatal("Failed to create adblock engine")
+	}
+	defer engine.Close()
+
+	path := filepath.Join(t.TempDir(), "adblock.snapshot")
+	hash := ListsHash(lists)
+	if err := SaveSnapshot(path, engine, hash); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+
+	restored, gotHash, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	defer restored.Close()
+
+	if gotHash != hash {
+		t.Errorf("LoadSnapshot hash = %s; want %s", gotHash, hash)
+	}
+	if !restored.Check("http://ads.example.com/a.gif", "http://news.com", "image") {
+		t.Errorf("restored engine should block ads.example.com")
+	}
+	if restored.Check("http://ads.example.com/ok/a.gif", "http://news.com", "image") {
+		t.Errorf("restored engine should honour exceptions")
+	}
+
+	if ListsHash([]FilterList{{ID: "other", Rules: lists[0].Rules}}) == hash {
+		t.Errorf("ListsHash should change with the list IDs")
+	}
+
+	// Snapshots from another format version are discarded
+	data, _ := os.ReadFile(path)
+	data[len(snapshotMagic)]++
+	os.WriteFile(path, data, 0644)
+	if _, _, err := LoadSnapshot(path); err != ErrStaleSnapshot {
+		t.Errorf("LoadSnapshot of other version = %v; want ErrStaleSnapshot", err)
+	}
+}
+
+// seededLists builds the engine's lists from the store like the app does
+func seededLists(s store.Store, content map[string]string) []FilterList {
+	var lists []FilterList
+	for _, f := range s.GetAdblockFilters() {
+		lists = append(lists, FilterList{ID: f.ID, Rules: content[f.URL]})
+	}
+	return lists
+}
+
+func TestSnapshotSurvivesReseeding(t *testing.T) {
+	defaults := []core.AdblockFilter{
+		{Name: "EasyList", URL: "https://example.com/easylist.txt"},
+		{Name: "EasyPrivacy", URL: "https://example.com/easyprivacy.txt"},
+	}
+	content := map[string]string{
+		defaults[0].URL: "||ads.example.com^\n",
+		defaults[1].URL: "||tracker.example.com^\n",
+	}
+	s := store.NewMemoryStore()
+	store.SeedAdblockFilters(s, defaults)
+
+	lists := seededLists(s, content)
+	engine := NewEngineFromLists(lists)
+	defer engine.Close()
+	path := filepath.Join(t.TempDir(), "adblock.snapshot")
+	if err := SaveSnapshot(path, engine, ListsHash(lists)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next start seeds again and finds the snapshot up to date
+	store.SeedAdblockFilters(s, defaults)
+	restored, hash, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	lists = seededLists(s, content)
+	if hash != ListsHash(lists) {
+		t.Errorf("snapshot hash doesn't match the reseeded lists")
+	}
+	if hash != ListsHash([]FilterList{lists[1], lists[0]}) {
+		t.Errorf("ListsHash should not depend on the order of the lists")
+	}
+}
@@ -0,0 +1,398 @@
+// Package backup exports and restores the configuration of Custos: settings,
+// custom rules, adblock filter lists and DNS upstreams. Logs, statistics and
+// hit counters are not part of a backup.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/store"
+	"github.com/vkhangstack/Custos/internal/utils"
+)
+
+// Format identifies Custos configuration archives
+const Format = "custos-config"
+
+// Version is the archive version written by this build, archives from
+// newer versions are rejected
+const Version = 1
+
+// ErrUnsupportedVersion is returned for archives written by a newer build
+var ErrUnsupportedVersion = errors.New("configuration archive is from a newer version of Custos")
+
+// Mode decides what happens to configuration missing from an archive
+type Mode string
+
+const (
+	// ModeMerge adds and updates items from the archive and keeps everything else
+	ModeMerge Mode = "merge"
+	// ModeReplace also removes custom rules and filter lists the archive doesn't have
+	ModeReplace Mode = "replace"
+)
+
+// Archive is a versioned snapshot of the configuration
+type Archive struct {
+	Format       string            `json:"format"`
+	Version      int               `json:"version"`
+	CreatedAt    time.Time         `json:"created_at"`
+	AppVersion   string            `json:"app_version,omitempty"`
+	Settings     map[string]string `json:"settings"`
+	Rules        []Rule            `json:"rules"`
+	Filters      []Filter          `json:"adblock_filters"`
+	DNSUpstreams []string          `json:"dns_upstreams"`
+}
+
+// Rule is a custom rule; IDs and hit counts are local to a database
+type Rule struct {
+	Type          core.RuleType `json:"type"`
+	Pattern       string        `json:"pattern"`
+	Enabled       bool          `json:"enabled"`
+	ProcessPath   string        `json:"process_path,omitempty"`
+	ProcessSHA256 string        `json:"process_sha256,omitempty"`
+	ProcessUser   string        `json:"process_user,omitempty"`
+}
+
+// fromRule keeps the parts of a rule that belong in an archive
+func fromRule(r core.Rule) Rule {
+	return Rule{
+		Type:          r.Type,
+		Pattern:       r.Pattern,
+		Enabled:       r.Enabled,
+		ProcessPath:   r.ProcessPath,
+		ProcessSHA256: r.ProcessSHA256,
+		ProcessUser:   r.ProcessUser,
+	}
+}
+
+// key identifies a rule across databases: the same pattern may be ruled
+// differently for different processes
+func (r Rule) key() string {
+	return strings.Join([]string{r.Pattern, r.ProcessPath, strings.ToLower(r.ProcessSHA256), r.ProcessUser}, "\x00")
+}
+
+// Filter is an adblock filter list subscription, its content is downloaded again
+type Filter struct {
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	Enabled        bool   `json:"enabled"`
+	UpdateInterval int64  `json:"update_interval,omitempty"` // Seconds, 0 = list default
+}
+
+// settingValidators lists the settings that are configuration, as opposed to
+// runtime state, and checks their values
+var settingValidators = map[string]func(string) error{
+	"proxy_port":            validatePort,
+	"protection_enabled":    validateBool,
+	"adblock_enabled":       validateBool,
+	"notifications_enabled": validateBool,
+	"retention_policy": func(v string) error {
+		var p core.RetentionPolicy
+		if err := json.Unmarshal([]byte(v), &p); err != nil {
+			return err
+		}
+		return store.ValidateRetentionPolicy(p)
+	},
+}
+
+func validatePort(v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", v)
+	}
+	return nil
+}
+
+func validateBool(v string) error {
+	if v != "true" && v != "false" {
+		return fmt.Errorf("invalid boolean %q", v)
+	}
+	return nil
+}
+
+// Create snapshots the configuration in a store
+func Create(s store.Store, appVersion string) *Archive {
+	a := &Archive{
+		Format:       Format,
+		Version:      Version,
+		CreatedAt:    time.Now(),
+		AppVersion:   appVersion,
+		Settings:     make(map[string]string),
+		Rules:        []Rule{},
+		Filters:      []Filter{},
+		DNSUpstreams: store.GetDNSUpstreams(s),
+	}
+
+	for key := range settingValidators {
+		if val, err := s.GetSetting(key); err == nil && val != "" {
+			a.Settings[key] = val
+		}
+	}
+	for _, r := range s.GetRules() {
+		if r.Source == core.RuleSourceCustom {
+			a.Rules = append(a.Rules, fromRule(r))
+		}
+	}
+	for _, f := range s.GetAdblockFilters() {
+		a.Filters = append(a.Filters, Filter{
+			Name:           f.Name,
+			URL:            f.URL,
+			Enabled:        f.Enabled,
+			UpdateInterval: f.UpdateInterval,
+		})
+	}
+	return a
+}
+
+// Write encodes an archive as indented JSON
+func Write(w io.Writer, a *Archive) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a)
+}
+
+// Read decodes and validates an archive
+func Read(r io.Reader) (*Archive, error) {
+	var a Archive
+	if err := json.NewDecoder(io.LimitReader(r, 32<<20)).Decode(&a); err != nil {
+		return nil, fmt.Errorf("invalid configuration archive: %w", err)
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Validate checks every item of an archive, reporting all problems at once
+func (a *Archive) Validate() error {
+	if a.Format != Format {
+		return fmt.Errorf("not a Custos configuration archive")
+	}
+	if a.Version < 1 {
+		return fmt.Errorf("invalid archive version %d", a.Version)
+	}
+	if a.Version > Version {
+		return fmt.Errorf("%w (v%d > v%d)", ErrUnsupportedVersion, a.Version, Version)
+	}
+
+	var errs []error
+	for key, val := range a.Settings {
+		validate, ok := settingValidators[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("setting %q: unknown setting", key))
+			continue
+		}
+		if err := validate(val); err != nil {
+			errs = append(errs, fmt.Errorf("setting %q: %w", key, err))
+		}
+	}
+
+	keys := make(map[string]bool)
+	for i, r := range a.Rules {
+		if err := validateRule(r); err != nil {
+			errs = append(errs, fmt.Errorf("rule %d: %w", i+1, err))
+		} else if keys[r.key()] {
+			errs = append(errs, fmt.Errorf("rule %d: duplicate pattern %q for the same processes", i+1, r.Pattern))
+		}
+		keys[r.key()] = true
+	}
+
+	urls := make(map[string]bool)
+	for i, f := range a.Filters {
+		if err := validateFilter(f); err != nil {
+			errs = append(errs, fmt.Errorf("filter list %d: %w", i+1, err))
+		} else if urls[f.URL] {
+			errs = append(errs, fmt.Errorf("filter list %d: duplicate URL %s", i+1, f.URL))
+		}
+		urls[f.URL] = true
+	}
+
+	for _, u := range a.DNSUpstreams {
+		if err := store.ValidateDNSUpstream(u); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateRule(r Rule) error {
+	if r.Type != core.RuleBlock && r.Type != core.RuleAllow {
+		return fmt.Errorf("invalid type %q", r.Type)
+	}
+	pattern := strings.TrimPrefix(r.Pattern, "*.")
+	if pattern == "" || strings.ContainsAny(pattern, " \t\r\n/*") {
+		return fmt.Errorf("invalid pattern %q", r.Pattern)
+	}
+	if r.ProcessSHA256 != "" {
+		if b, err := hex.DecodeString(r.ProcessSHA256); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid SHA-256 %q", r.ProcessSHA256)
+		}
+	}
+	return nil
+}
+
+func validateFilter(f Filter) error {
+	if strings.TrimSpace(f.Name) == "" {
+		return fmt.Errorf("missing name")
+	}
+	u, err := url.Parse(f.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", f.URL)
+	}
+	if f.UpdateInterval < 0 {
+		return fmt.Errorf("invalid update interval %d", f.UpdateInterval)
+	}
+	return nil
+}
+
+// Result counts what a restore changed
+type Result struct {
+	Settings       int  `json:"settings"`
+	RulesAdded     int  `json:"rules_added"`
+	RulesUpdated   int  `json:"rules_updated"`
+	RulesRemoved   int  `json:"rules_removed"`
+	FiltersAdded   int  `json:"filters_added"`
+	FiltersUpdated int  `json:"filters_updated"`
+	FiltersRemoved int  `json:"filters_removed"`
+	DNSUpstreams   bool `json:"dns_upstreams"`
+}
+
+// Restore applies a validated archive to a store. Rules are matched by
+// pattern and filter lists by URL, so restoring the same archive twice
+// changes nothing. Default rules are never touched.
+func Restore(s store.Store, a *Archive, mode Mode) (Result, error) {
+	var res Result
+	if mode != ModeMerge && mode != ModeReplace {
+		return res, fmt.Errorf("invalid restore mode %q", mode)
+	}
+	if err := a.Validate(); err != nil {
+		return res, err
+	}
+
+	for key, val := range a.Settings {
+		if err := s.SetSetting(key, val); err != nil {
+			return res, err
+		}
+		res.Settings++
+	}
+
+	if len(a.DNSUpstreams) > 0 {
+		if err := store.SetDNSUpstreams(s, a.DNSUpstreams); err != nil {
+			return res, err
+		}
+		res.DNSUpstreams = true
+	}
+
+	if err := restoreRules(s, a.Rules, mode, &res); err != nil {
+		return res, err
+	}
+	err := restoreFilters(s, a.Filters, mode, &res)
+	return res, err
+}
+
+func restoreRules(s store.Store, rules []Rule, mode Mode, res *Result) error {
+	existing := make(map[string]core.Rule)
+	for _, r := range s.GetRules() {
+		if r.Source == core.RuleSourceCustom {
+			existing[fromRule(r).key()] = r
+		}
+	}
+
+	wanted := make(map[string]bool)
+	for _, r := range rules {
+		wanted[r.key()] = true
+		cur, ok := existing[r.key()]
+		if !ok {
+			err := s.AddRule(core.Rule{
+				ID:            utils.GenerateIDString(),
+				Type:          r.Type,
+				Pattern:       r.Pattern,
+				Enabled:       r.Enabled,
+				Source:        core.RuleSourceCustom,
+				ProcessPath:   r.ProcessPath,
+				ProcessSHA256: r.ProcessSHA256,
+				ProcessUser:   r.ProcessUser,
+			})
+			if err != nil {
+				return err
+			}
+			res.RulesAdded++
+			continue
+		}
+		if cur.Type != r.Type || cur.Enabled != r.Enabled {
+			cur.Type, cur.Enabled = r.Type, r.Enabled
+			if err := s.UpdateRule(cur); err != nil {
+				return err
+			}
+			res.RulesUpdated++
+		}
+	}
+
+	if mode == ModeReplace {
+		for key, r := range existing {
+			if wanted[key] {
+				continue
+			}
+			if err := s.DeleteRule(r.ID); err != nil {
+				return err
+			}
+			res.RulesRemoved++
+		}
+	}
+	return nil
+}
+
+func restoreFilters(s store.Store, filters []Filter, mode Mode, res *Result) error {
+	existing := make(map[string]core.AdblockFilter)
+	for _, f := range s.GetAdblockFilters() {
+		existing[f.URL] = f
+	}
+
+	wanted := make(map[string]bool)
+	for _, f := range filters {
+		wanted[f.URL] = true
+		cur, ok := existing[f.URL]
+		if !ok {
+			err := s.AddAdblockFilter(core.AdblockFilter{
+				ID:             utils.GenerateIDString(),
+				Name:           f.Name,
+				URL:            f.URL,
+				Enabled:        f.Enabled,
+				UpdateInterval: f.UpdateInterval,
+			})
+			if err != nil {
+				return err
+			}
+			res.FiltersAdded++
+			continue
+		}
+		if cur.Name != f.Name || cur.Enabled != f.Enabled || cur.UpdateInterval != f.UpdateInterval {
+			cur.Name, cur.Enabled, cur.UpdateInterval = f.Name, f.Enabled, f.UpdateInterval
+			if err := s.UpdateAdblockFilter(cur); err != nil {
+				return err
+			}
+			res.FiltersUpdated++
+		}
+	}
+
+	if mode == ModeReplace {
+		for u, f := range existing {
+			if wanted[u] {
+				continue
+			}
+			if err := s.DeleteAdblockFilter(f.ID); err != nil {
+				return err
+			}
+			res.FiltersRemoved++
+		}
+	}
+	return nil
+}
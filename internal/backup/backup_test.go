@@ -0,0 +1,246 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/store"
+	"github.com/vkhangstack/Custos/internal/utils"
+)
+
+func configuredStore(t *testing.T) store.Store {
+	t.Helper()
+	s := store.NewMemoryStore()
+	s.SetSetting("proxy_port", "1081")
+	s.SetSetting("adblock_enabled", "true")
+	s.SetSetting("adblock_hits", "42")
+	store.SetRetentionPolicy(s, core.RetentionPolicy{AllowedMaxAge: 3600})
+	store.SetDNSUpstreams(s, []string{"1.1.1.1:53", "9.9.9.9:53"})
+	s.AddRule(core.Rule{ID: utils.GenerateIDString(), Type: core.RuleBlock, Pattern: "*.ads.com", Enabled: true, Source: core.RuleSourceCustom, HitCount: 9})
+	s.AddRule(core.Rule{ID: utils.GenerateIDString(), Type: core.RuleAllow, Pattern: "cdn.example.com", Enabled: false, Source: core.RuleSourceCustom})
+	s.AddRule(core.Rule{ID: utils.GenerateIDString(), Type: core.RuleBlock, Pattern: "tracker.net", Enabled: true, Source: core.RuleSourceDefault})
+	s.AddAdblockFilter(core.AdblockFilter{ID: utils.GenerateIDString(), Name: "EasyList", URL: "https://example.com/easylist.txt", Enabled: true, Hits: 5, UpdateInterval: 3600})
+	return s
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := configuredStore(t)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, Create(src, "1.2.3")); err != nil {
+		t.Fatal(err)
+	}
+	a, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if _, ok := a.Settings["adblock_hits"]; ok {
+		t.Errorf("runtime counters should not be backed up")
+	}
+	if len(a.Rules) != 2 {
+		t.Errorf("archive has %d rules; want only the 2 custom rules", len(a.Rules))
+	}
+	if len(a.Filters) != 1 || a.Filters[0].UpdateInterval != 3600 {
+		t.Errorf("filters = %+v; want the list with its interval", a.Filters)
+	}
+
+	dst := store.NewMemoryStore()
+	res, err := Restore(dst, a, ModeMerge)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if res.Settings != 3 || res.RulesAdded != 2 || res.FiltersAdded != 1 || !res.DNSUpstreams {
+		t.Errorf("result = %+v", res)
+	}
+	if port, _ := dst.GetSetting("proxy_port"); port != "1081" {
+		t.Errorf("proxy_port = %q; want 1081", port)
+	}
+	if p := store.GetRetentionPolicy(dst); p.AllowedMaxAge != 3600 {
+		t.Errorf("retention policy = %+v; want it restored", p)
+	}
+	if u := store.GetDNSUpstreams(dst); strings.Join(u, ",") != "1.1.1.1:53,9.9.9.9:53" {
+		t.Errorf("upstreams = %v", u)
+	}
+	rules := make(map[string]core.Rule)
+	for _, r := range dst.GetRules() {
+		rules[r.Pattern] = r
+	}
+	if r := rules["cdn.example.com"]; r.Type != core.RuleAllow || r.Enabled || r.Source != core.RuleSourceCustom || r.HitCount != 0 {
+		t.Errorf("restored rule = %+v", r)
+	}
+
+	// Restoring again changes nothing
+	if res, _ := Restore(dst, a, ModeMerge); res.RulesAdded+res.RulesUpdated+res.FiltersAdded+res.FiltersUpdated != 0 {
+		t.Errorf("second restore = %+v; want no rule or filter changes", res)
+	}
+}
+
+func TestRoundTripProcessRules(t *testing.T) {
+	curl := core.Rule{ID: utils.GenerateIDString(), Type: core.RuleBlock, Pattern: "x.com", Enabled: true, Source: core.RuleSourceCustom,
+		ProcessPath: "/usr/bin/curl"}
+	python := core.Rule{ID: utils.GenerateIDString(), Type: core.RuleAllow, Pattern: "x.com", Enabled: true, Source: core.RuleSourceCustom,
+		ProcessPath: "/usr/bin/python3", ProcessSHA256: strings.Repeat("ab", 32), ProcessUser: "alice"}
+	src := store.NewMemoryStore()
+	src.AddRule(curl)
+	src.AddRule(python)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, Create(src, "1.2.3")); err != nil {
+		t.Fatal(err)
+	}
+	// The same pattern for different processes isn't a duplicate
+	a, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	dst := store.NewMemoryStore()
+	if res, err := Restore(dst, a, ModeReplace); err != nil || res.RulesAdded != 2 {
+		t.Fatalf("Restore = %+v, %v; want both rules added", res, err)
+	}
+	byPath := make(map[string]core.Rule)
+	for _, r := range dst.GetRules() {
+		byPath[r.ProcessPath] = r
+	}
+	if r := byPath[curl.ProcessPath]; r.Type != core.RuleBlock || r.ProcessSHA256 != "" || r.ProcessUser != "" {
+		t.Errorf("restored curl rule = %+v", r)
+	}
+	if r := byPath[python.ProcessPath]; r.Type != core.RuleAllow || r.ProcessSHA256 != python.ProcessSHA256 || r.ProcessUser != "alice" {
+		t.Errorf("restored python rule = %+v", r)
+	}
+	if _, global := byPath[""]; global {
+		t.Errorf("a process rule was restored for every process")
+	}
+
+	// Restoring again matches each rule to its own
+	if res, _ := Restore(dst, a, ModeReplace); res.RulesAdded+res.RulesUpdated+res.RulesRemoved != 0 {
+		t.Errorf("second restore = %+v; want no rule changes", res)
+	}
+}
+
+func TestRestoreModes(t *testing.T) {
+	a := &Archive{
+		Format:  Format,
+		Version: Version,
+		Rules: []Rule{
+			{Type: core.RuleAllow, Pattern: "*.ads.com", Enabled: true},
+			{Type: core.RuleBlock, Pattern: "new.com", Enabled: true},
+		},
+		Filters: []Filter{{Name: "Privacy", URL: "https://example.com/privacy.txt", Enabled: true}},
+	}
+
+	merged := configuredStore(t)
+	res, err := Restore(merged, a, ModeMerge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RulesAdded != 1 || res.RulesUpdated != 1 || res.RulesRemoved != 0 || res.FiltersAdded != 1 || res.FiltersRemoved != 0 {
+		t.Errorf("merge result = %+v", res)
+	}
+	if n := len(merged.GetRules()); n != 4 {
+		t.Errorf("merge kept %d rules; want 4", n)
+	}
+
+	replaced := configuredStore(t)
+	res, err = Restore(replaced, a, ModeReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RulesRemoved != 1 || res.FiltersRemoved != 1 {
+		t.Errorf("replace result = %+v", res)
+	}
+	var patterns []string
+	for _, r := range replaced.GetRules() {
+		patterns = append(patterns, r.Pattern)
+	}
+	if len(patterns) != 3 || !strings.Contains(strings.Join(patterns, ","), "tracker.net") {
+		t.Errorf("replace left rules %v; want the archive rules and the default rule", patterns)
+	}
+	if f := replaced.GetAdblockFilters(); len(f) != 1 || f[0].Name != "Privacy" {
+		t.Errorf("replace left filters %+v", f)
+	}
+	if u := store.GetDNSUpstreams(replaced); len(u) != 2 {
+		t.Errorf("an archive without upstreams should keep the configured ones, got %v", u)
+	}
+
+	if _, err := Restore(replaced, a, "overwrite"); err == nil {
+		t.Errorf("unknown modes should be rejected")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bad := `{
+		"format": "custos-config",
+		"version": 1,
+		"settings": {"proxy_port": "99999", "theme": "dark", "adblock_enabled": "yes"},
+		"rules": [{"type": "BLOCK", "pattern": "ok.com"}, {"type": "DENY", "pattern": "x.com"}, {"type": "BLOCK", "pattern": "ok.com"}, {"type": "ALLOW", "pattern": "*."}, {"type": "BLOCK", "pattern": "ok.com", "process_path": "curl"}, {"type": "BLOCK", "pattern": "y.com", "process_sha256": "abc"}],
+		"adblock_filters": [{"name": "", "url": "https://a.com/x.txt"}, {"name": "Local", "url": "file:///etc/passwd"}],
+		"dns_upstreams": ["1.1.1.1"]
+	}`
+	_, err := Read(strings.NewReader(bad))
+	if err == nil {
+		t.Fatal("Read should reject the invalid archive")
+	}
+	for _, want := range []string{`"proxy_port"`, `"theme"`, `"adblock_enabled"`, "rule 2", "rule 3: duplicate", "rule 4", "rule 6: invalid SHA-256", "filter list 1", "filter list 2", "1.1.1.1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error should mention %s:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "rule 5") {
+		t.Errorf("a pattern scoped to a process isn't a duplicate:\n%v", err)
+	}
+
+	// Nothing is applied from an invalid archive
+	s := store.NewMemoryStore()
+	if _, err := Restore(s, &Archive{Format: Format, Version: Version, Settings: map[string]string{"proxy_port": "0"}}, ModeMerge); err == nil {
+		t.Errorf("Restore should validate the archive")
+	}
+	if _, err := s.GetSetting("proxy_port"); err == nil {
+		t.Errorf("an invalid archive should not touch the store")
+	}
+
+	_, err = Read(strings.NewReader(`{"format": "custos-config", "version": 99}`))
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Read of a newer archive = %v; want ErrUnsupportedVersion", err)
+	}
+	if _, err := Read(strings.NewReader(`{"format": "something-else", "version": 1}`)); err == nil {
+		t.Errorf("Read should reject other formats")
+	}
+}
+
+func TestRestoredFiltersSurviveSeeding(t *testing.T) {
+	defaults := []core.AdblockFilter{
+		{Name: "EasyList", URL: "https://example.com/easylist.txt"},
+		{Name: "EasyPrivacy", URL: "https://example.com/easyprivacy.txt"},
+	}
+	s := store.NewMemoryStore()
+	store.SeedAdblockFilters(s, defaults)
+
+	// The archive drops one default, changes the other and adds a list
+	a := &Archive{Format: Format, Version: Version, Filters: []Filter{
+		{Name: "EasyList", URL: defaults[0].URL, Enabled: false, UpdateInterval: 7200},
+		{Name: "Privacy", URL: "https://example.com/privacy.txt", Enabled: true, UpdateInterval: 3600},
+	}}
+	if _, err := Restore(s, a, ModeReplace); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next start seeds again
+	store.SeedAdblockFilters(s, defaults)
+	filters := make(map[string]core.AdblockFilter)
+	for _, f := range s.GetAdblockFilters() {
+		filters[f.URL] = f
+	}
+	if len(filters) != 2 {
+		t.Errorf("seeding after a restore left %d lists; want the archive's 2", len(filters))
+	}
+	if f := filters[defaults[0].URL]; f.Enabled || f.UpdateInterval != 7200 {
+		t.Errorf("restored default = %+v; want it disabled with interval 7200", f)
+	}
+	if f := filters["https://example.com/privacy.txt"]; f.UpdateInterval != 3600 {
+		t.Errorf("restored list = %+v; want interval 3600", f)
+	}
+}
@@ -0,0 +1,202 @@
+// Package cli is the custos command line. It drives the running instance
+// through the control API, printing tables or, with --json, the API's JSON.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/vkhangstack/Custos/internal/control"
+)
+
+// command is a subcommand, run with the arguments after its name
+type command struct {
+	usage string
+	run   func(e *env, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"status":  {"status", runStatus},
+		"enable":  {"enable", func(e *env, args []string) error { return setProtection(e, args, true) }},
+		"disable": {"disable", func(e *env, args []string) error { return setProtection(e, args, false) }},
+		"rules":   {"rules list [--search text] [--page n] | rules add [--process path] [--sha256 hash] [--user name] allow|block <pattern> | rules rm <id>...", runRules},
+		"logs":    {"logs tail [--blocked] [--search query] [-n lines]", runLogs},
+		"filters": {"filters list | filters refresh", runFilters},
+		"explain": {"explain [--process path] [--sha256 hash] [--user name] <url>", runExplain},
+		"help":    {"help", runHelp},
+	}
+}
+
+// IsCommand reports whether arg names a subcommand; other arguments are
+// for the window
+func IsCommand(arg string) bool {
+	_, ok := commands[arg]
+	return ok
+}
+
+// errUsage makes Run print the usage of the command
+var errUsage = errors.New("usage")
+
+// env is what a command runs with
+type env struct {
+	ctx    context.Context
+	client *control.Client
+	out    io.Writer
+	errOut io.Writer
+	json   bool
+}
+
+// Run runs the subcommand in args[0] against the instance behind client
+// and returns the exit status
+func Run(ctx context.Context, client *control.Client, args []string, stdout, stderr io.Writer) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "custos: unknown command %q\n", args[0])
+		return 2
+	}
+	e := &env{ctx: ctx, client: client, out: stdout, errOut: stderr}
+	if args[0] != "help" && client.Ping() != nil {
+		fmt.Fprintln(stderr, "custos: Custos isn't running; open it or start `custos daemon`")
+		return 1
+	}
+
+	err := cmd.run(e, args[1:])
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "usage: custos %s\n", cmd.usage)
+		return 2
+	default:
+		fmt.Fprintf(stderr, "custos %s: %v\n", args[0], err)
+		return 1
+	}
+}
+
+// flags starts parsing the arguments of a command, with the --json flag
+// every command takes
+func (e *env) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("custos "+name, flag.ContinueOnError)
+	fs.SetOutput(e.errOut)
+	fs.BoolVar(&e.json, "json", false, "print JSON instead of a table")
+	return fs
+}
+
+// parse parses args, allowing flags after positional arguments, e.g.
+// "rules rm abc --json", and checks that there are min to max positional
+// arguments; max -1 means any number
+func parse(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, errUsage
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(pos) < min || (max >= 0 && len(pos) > max) {
+		return nil, errUsage
+	}
+	return pos, nil
+}
+
+// show prints raw as indented JSON with --json, otherwise decodes it into
+// a T and prints it with table
+func show[T any](e *env, raw json.RawMessage, table func(w io.Writer, v T)) error {
+	if e.json {
+		return e.printJSON(raw)
+	}
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(e.out, 0, 4, 2, ' ', 0)
+	table(tw, v)
+	return tw.Flush()
+}
+
+// get requests path and shows the answer like show
+func get[T any](e *env, path string, table func(w io.Writer, v T)) error {
+	var raw json.RawMessage
+	if err := e.client.Do(e.ctx, "GET", path, nil, &raw); err != nil {
+		return err
+	}
+	return show(e, raw, table)
+}
+
+func (e *env) printJSON(v any) error {
+	enc := json.NewEncoder(e.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// done confirms an operation without a result; JSON output stays empty so
+// scripts only look at the exit status
+func (e *env) done(format string, args ...any) {
+	if !e.json {
+		fmt.Fprintf(e.out, format+"\n", args...)
+	}
+}
+
+// query encodes URL parameters, leaving out empty ones
+func query(path string, params map[string]string) string {
+	v := url.Values{}
+	for key, val := range params {
+		if val != "" {
+			v.Set(key, val)
+		}
+	}
+	if len(v) == 0 {
+		return path
+	}
+	return path + "?" + v.Encode()
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func runHelp(e *env, args []string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(e.out, "Controls the running Custos.\n\nUsage:")
+	for _, name := range names {
+		fmt.Fprintf(e.out, "  custos %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(e.out, "\nEvery command takes --json to print the API's JSON instead of a table.")
+	return nil
+}
+
+// subcommand splits off the subcommand of a command group like "rules"
+func subcommand(args []string, names ...string) (string, []string, error) {
+	if len(args) == 0 || !slices.Contains(names, args[0]) {
+		return "", nil, errUsage
+	}
+	return args[0], args[1:], nil
+}
@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vkhangstack/Custos/internal/control"
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/proxy"
+)
+
+// fakeInstance answers the control API like a running Custos
+type fakeInstance struct {
+	protection bool
+	rules      []core.Rule
+	logs       chan core.LogEntry
+}
+
+func (f *fakeInstance) routes() []control.Route {
+	type enabled struct {
+		Enabled bool `json:"enabled"`
+	}
+	type idParam struct {
+		ID string `path:"id"`
+	}
+	type ruleRequest struct {
+		Pattern string `json:"pattern"`
+		Type    string `json:"type"`
+	}
+	type logQuery struct {
+		Status string `query:"status"`
+	}
+	type explainQuery struct {
+		URL string `query:"url"`
+	}
+	return []control.Route{
+		control.Handle("GET", "/v1/status", "getStatus", "",
+			func(struct{}) (map[string]any, error) {
+				return map[string]any{"version": "1.0.0", "protection": f.protection, "proxy_port": 1080}, nil
+			}),
+		control.Handle("PUT", "/v1/protection", "setProtection", "",
+			func(in enabled) (struct{}, error) {
+				f.protection = in.Enabled
+				return struct{}{}, nil
+			}),
+		control.Handle("GET", "/v1/rules", "listRules", "",
+			func(struct{}) (core.PaginatedRulesResponse, error) {
+				return core.PaginatedRulesResponse{Rules: f.rules, Total: int64(len(f.rules))}, nil
+			}),
+		control.Handle("POST", "/v1/rules", "addRule", "",
+			func(in ruleRequest) (core.Rule, error) {
+				rule := core.Rule{ID: "r1", Type: core.RuleType(in.Type), Pattern: in.Pattern, Enabled: true}
+				f.rules = append(f.rules, rule)
+				return rule, nil
+			}),
+		control.Handle("DELETE", "/v1/rules/{id}", "deleteRule", "",
+			func(in idParam) (struct{}, error) {
+				return struct{}{}, control.NotFound("rule %s not found", in.ID)
+			}),
+		control.Handle("GET", "/v1/logs", "listLogs", "",
+			func(in logQuery) (core.PaginatedLogs, error) {
+				// Newest first
+				return core.PaginatedLogs{Logs: []core.LogEntry{
+					{ID: "2", Domain: "second.example.com", Status: in.Status},
+					{ID: "1", Domain: "first.example.com", Status: in.Status},
+				}}, nil
+			}),
+		control.Stream("GET", "/v1/logs/stream", "streamLogs", "",
+			func(ctx context.Context, _ logQuery) (<-chan core.LogEntry, error) { return f.logs, nil }),
+		control.Handle("GET", "/v1/explain", "explain", "",
+			func(in explainQuery) (proxy.Verdict, error) {
+				return proxy.Verdict{Host: "ads.example.com", Source: proxy.VerdictAdblock, Filter: "||ads.example.com^", List: "easylist"}, nil
+			}),
+	}
+}
+
+func start(t *testing.T, f *fakeInstance) *control.Client {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "custos.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := control.NewServer(f, control.NewHub())
+	s.Route(f.routes()...)
+	go s.Serve(l)
+	return control.NewClient(path)
+}
+
+func run(client *control.Client, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = Run(context.Background(), client, args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestCommands(t *testing.T) {
+	f := &fakeInstance{}
+	client := start(t, f)
+
+	if code, out, _ := run(client, "enable"); code != 0 || out != "Protection on\n" || !f.protection {
+		t.Errorf("enable = %d %q, protection %v", code, out, f.protection)
+	}
+	if code, out, _ := run(client, "status"); code != 0 || !strings.Contains(out, "Protection         on") {
+		t.Errorf("status = %d\n%s", code, out)
+	}
+	code, out, _ := run(client, "status", "--json")
+	var status map[string]any
+	if err := json.Unmarshal([]byte(out), &status); code != 0 || err != nil || status["version"] != "1.0.0" {
+		t.Errorf("status --json = %d %q", code, out)
+	}
+
+	if code, out, _ := run(client, "rules", "add", "allow", "*.example.com"); code != 0 || out != "Added ALLOW rule r1 for *.example.com\n" {
+		t.Errorf("rules add = %d %q", code, out)
+	}
+	if code, out, _ := run(client, "rules", "list"); code != 0 || !strings.Contains(out, "r1  ALLOW  *.example.com") {
+		t.Errorf("rules list = %d\n%s", code, out)
+	}
+	if code, _, errOut := run(client, "rules", "rm", "nope"); code != 1 || errOut != "custos rules: nope: rule nope not found\n" {
+		t.Errorf("rules rm = %d %q", code, errOut)
+	}
+
+	if code, out, _ := run(client, "explain", "https://ads.example.com/banner.js"); code != 0 ||
+		!strings.Contains(out, "blocked") || !strings.Contains(out, "adblock filter ||ads.example.com^ from list easylist") {
+		t.Errorf("explain = %d\n%s", code, out)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	client := start(t, &fakeInstance{})
+	for _, args := range [][]string{
+		{"rules"},
+		{"rules", "add", "allow"},
+		{"rules", "add", "maybe", "example.com"},
+		{"explain"},
+		{"status", "extra"},
+		{"logs", "tail", "--bogus"},
+	} {
+		if code, _, errOut := run(client, args...); code != 2 || !strings.Contains(errOut, "usage: custos "+args[0]) {
+			t.Errorf("%v = %d %q, want usage", args, code, errOut)
+		}
+	}
+}
+
+func TestNotRunning(t *testing.T) {
+	client := control.NewClient(filepath.Join(t.TempDir(), "custos.sock"))
+	if code, _, errOut := run(client, "status"); code != 1 || !strings.Contains(errOut, "isn't running") {
+		t.Errorf("status = %d %q", code, errOut)
+	}
+}
+
+func TestLogsTail(t *testing.T) {
+	f := &fakeInstance{logs: make(chan core.LogEntry, 4)}
+	client := start(t, f)
+
+	// An update of a printed log is skipped, the stream ends with the channel
+	f.logs <- core.LogEntry{ID: "2", Domain: "second.example.com", BytesRecv: 10}
+	f.logs <- core.LogEntry{ID: "3", Domain: "third.example.com", Status: core.LogStatusBlocked}
+	close(f.logs)
+
+	var out, errOut bytes.Buffer
+	e := &env{ctx: context.Background(), client: client, out: &out, errOut: &errOut}
+	err := runLogs(e, []string{"tail", "--blocked", "--json"})
+	if err == nil || !strings.Contains(err.Error(), "lost the connection") {
+		t.Errorf("runLogs = %v, want a lost connection", err)
+	}
+
+	var domains []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var l core.LogEntry
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		domains = append(domains, l.Domain)
+	}
+	want := "first.example.com second.example.com third.example.com"
+	if got := strings.Join(domains, " "); got != want {
+		t.Errorf("tailed %s, want %s", got, want)
+	}
+}
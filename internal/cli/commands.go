@@ -0,0 +1,323 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/control"
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/proxy"
+	"github.com/vkhangstack/Custos/internal/report"
+	"github.com/vkhangstack/Custos/internal/system"
+)
+
+// status is the part of GET /v1/status the table shows
+type status struct {
+	Version     string             `json:"version"`
+	Protection  bool               `json:"protection"`
+	Adblock     bool               `json:"adblock"`
+	ProxyPort   int                `json:"proxy_port"`
+	SystemProxy system.ProxyStatus `json:"system_proxy"`
+	Transparent struct {
+		Enabled bool   `json:"enabled"`
+		Port    int    `json:"port"`
+		Error   string `json:"error"`
+	} `json:"transparent"`
+	Stats core.Stats `json:"stats"`
+}
+
+func runStatus(e *env, args []string) error {
+	if _, err := parse(e.flags("status"), args, 0, 0); err != nil {
+		return err
+	}
+	return get(e, "/v1/status", func(w io.Writer, s status) {
+		fmt.Fprintf(w, "Version\t%s\n", s.Version)
+		fmt.Fprintf(w, "Protection\t%s\n", onOff(s.Protection))
+		fmt.Fprintf(w, "Adblock\t%s\n", onOff(s.Adblock))
+		fmt.Fprintf(w, "Proxy port\t%d\n", s.ProxyPort)
+		fmt.Fprintf(w, "System proxy\t%s\n", systemProxy(s.SystemProxy))
+		transparent := onOff(s.Transparent.Enabled)
+		if s.Transparent.Enabled {
+			transparent += fmt.Sprintf(" (port %d)", s.Transparent.Port)
+		}
+		if s.Transparent.Error != "" {
+			transparent += ": " + s.Transparent.Error
+		}
+		fmt.Fprintf(w, "Transparent proxy\t%s\n", transparent)
+		fmt.Fprintf(w, "Traffic\t%s up, %s down\n", report.FormatBytes(s.Stats.TotalUpload), report.FormatBytes(s.Stats.TotalDownload))
+		fmt.Fprintf(w, "Connections\t%d active\n", s.Stats.ActiveConns)
+		fmt.Fprintf(w, "Blocked\t%d\n", s.Stats.AdblockHits)
+	})
+}
+
+// systemProxy summarizes where the system proxy is applied
+func systemProxy(p system.ProxyStatus) string {
+	if !p.Enabled {
+		return "off"
+	}
+	var applied []string
+	for _, b := range p.Backends {
+		if b.Applied {
+			applied = append(applied, b.Name)
+		}
+	}
+	if len(applied) == 0 {
+		return "on"
+	}
+	return "on (" + strings.Join(applied, ", ") + ")"
+}
+
+func setProtection(e *env, args []string, enabled bool) error {
+	name := "disable"
+	if enabled {
+		name = "enable"
+	}
+	if _, err := parse(e.flags(name), args, 0, 0); err != nil {
+		return err
+	}
+	if err := e.client.Do(e.ctx, "PUT", "/v1/protection", map[string]bool{"enabled": enabled}, nil); err != nil {
+		return err
+	}
+	e.done("Protection %s", onOff(enabled))
+	return nil
+}
+
+func runRules(e *env, args []string) error {
+	sub, args, err := subcommand(args, "list", "add", "rm")
+	if err != nil {
+		return err
+	}
+	fs := e.flags("rules " + sub)
+	switch sub {
+	case "list":
+		search := fs.String("search", "", "only rules whose pattern contains text")
+		page := fs.Int("page", 1, "page, from 1")
+		if _, err := parse(fs, args, 0, 0); err != nil {
+			return err
+		}
+		path := query("/v1/rules", map[string]string{"search": *search, "page": strconv.Itoa(*page)})
+		return get(e, path, func(w io.Writer, res core.PaginatedRulesResponse) {
+			fmt.Fprintln(w, "ID\tTYPE\tPATTERN\tENABLED\tHITS\tSOURCE")
+			for _, r := range res.Rules {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", r.ID, r.Type, r.Pattern, onOff(r.Enabled), r.HitCount, r.Source)
+			}
+			if int64(len(res.Rules)) < res.Total {
+				fmt.Fprintf(w, "\nPage %d, %d rules in total\n", *page, res.Total)
+			}
+		})
+
+	case "add":
+		proc := processFlags(fs)
+		pos, err := parse(fs, args, 2, 2)
+		if err != nil {
+			return err
+		}
+		ruleType := strings.ToUpper(pos[0])
+		if ruleType != string(core.RuleAllow) && ruleType != string(core.RuleBlock) {
+			return errUsage
+		}
+		req := map[string]string{"pattern": pos[1], "type": ruleType,
+			"process_path": proc.ExePath, "process_sha256": proc.SHA256, "process_user": proc.User}
+		var rule core.Rule
+		if err := e.client.Do(e.ctx, "POST", "/v1/rules", req, &rule); err != nil {
+			return err
+		}
+		if e.json {
+			return e.printJSON(rule)
+		}
+		fmt.Fprintf(e.out, "Added %s rule %s for %s\n", rule.Type, rule.ID, rule.Pattern)
+		return nil
+
+	default:
+		ids, err := parse(fs, args, 1, -1)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := e.client.Do(e.ctx, "DELETE", "/v1/rules/"+url.PathEscape(id), nil, nil); err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+			e.done("Deleted rule %s", id)
+		}
+		return nil
+	}
+}
+
+// maxSeenLogs bounds the IDs logs tail remembers to skip updates of logs
+// it already printed
+const maxSeenLogs = 10000
+
+func runLogs(e *env, args []string) error {
+	_, args, err := subcommand(args, "tail")
+	if err != nil {
+		return err
+	}
+	fs := e.flags("logs tail")
+	blocked := fs.Bool("blocked", false, "only blocked requests")
+	search := fs.String("search", "", "only logs matching the query, e.g. \"domain:example.com\"")
+	lines := fs.Int("n", 10, "recent logs to print before following")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	params := map[string]string{"search": *search}
+	if *blocked {
+		params["status"] = core.LogStatusBlocked
+	}
+
+	// Follow before reading the backlog so nothing falls in between
+	stream, err := control.Watch[core.LogEntry](e.ctx, e.client, query("/v1/logs/stream", params))
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	showLog := func(l core.LogEntry) error {
+		if seen[l.ID] {
+			return nil
+		}
+		if len(seen) >= maxSeenLogs {
+			clear(seen)
+		}
+		seen[l.ID] = true
+		return e.printLog(l)
+	}
+
+	if *lines > 0 {
+		params["limit"] = strconv.Itoa(*lines)
+		var recent core.PaginatedLogs
+		if err := e.client.Do(e.ctx, "GET", query("/v1/logs", params), nil, &recent); err != nil {
+			return err
+		}
+		// Newest first
+		for i := len(recent.Logs) - 1; i >= 0; i-- {
+			if err := showLog(recent.Logs[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for l := range stream {
+		if err := showLog(l); err != nil {
+			return err
+		}
+	}
+	if e.ctx.Err() == nil {
+		return errors.New("lost the connection to Custos")
+	}
+	return nil
+}
+
+// printLog prints one line per log, as JSON lines with --json
+func (e *env) printLog(l core.LogEntry) error {
+	if e.json {
+		data, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(e.out, "%s\n", data)
+		return err
+	}
+
+	target := l.Domain
+	if target == "" {
+		target = l.DstIP
+	}
+	if l.DstPort != 0 {
+		target = fmt.Sprintf("%s:%d", target, l.DstPort)
+	}
+	process := l.ProcessName
+	if process == "" {
+		process = "-"
+	}
+	line := fmt.Sprintf("%s  %-7s  %-5s  %-20s  %s", l.Timestamp.Local().Format(time.TimeOnly), l.Status, l.Type, process, target)
+	if l.Reason != nil && *l.Reason != "" {
+		line += "  (" + *l.Reason + ")"
+	}
+	_, err := fmt.Fprintln(e.out, line)
+	return err
+}
+
+func runFilters(e *env, args []string) error {
+	sub, args, err := subcommand(args, "list", "refresh")
+	if err != nil {
+		return err
+	}
+	if _, err := parse(e.flags("filters "+sub), args, 0, 0); err != nil {
+		return err
+	}
+
+	if sub == "refresh" {
+		if err := e.client.Do(e.ctx, "POST", "/v1/filters/refresh", nil, nil); err != nil {
+			return err
+		}
+		e.done("Filter lists refreshed")
+		return nil
+	}
+	return get(e, "/v1/filters", func(w io.Writer, filters []core.AdblockFilter) {
+		fmt.Fprintln(w, "ID\tNAME\tENABLED\tHITS\tUPDATED")
+		for _, f := range filters {
+			updated := "never"
+			if !f.LastUpdated.IsZero() {
+				updated = f.LastUpdated.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", f.ID, f.Name, onOff(f.Enabled), f.Hits, updated)
+		}
+	})
+}
+
+// processFlags adds the flags that name a process, for rules limited to
+// some processes
+func processFlags(fs *flag.FlagSet) *core.Process {
+	p := &core.Process{}
+	fs.StringVar(&p.ExePath, "process", "", "executable path, or just its file name")
+	fs.StringVar(&p.SHA256, "sha256", "", "SHA-256 of the executable")
+	fs.StringVar(&p.User, "user", "", "user running the process")
+	return p
+}
+
+func runExplain(e *env, args []string) error {
+	fs := e.flags("explain")
+	proc := processFlags(fs)
+	pos, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	params := map[string]string{"url": pos[0], "process": proc.ExePath, "sha256": proc.SHA256, "user": proc.User}
+	return get(e, query("/v1/explain", params), func(w io.Writer, v proxy.Verdict) {
+		decision := "blocked"
+		if v.Allowed {
+			decision = "allowed"
+		}
+		fmt.Fprintf(w, "Host\t%s\n", v.Host)
+		fmt.Fprintf(w, "Decision\t%s\n", decision)
+		fmt.Fprintf(w, "Because\t%s\n", reason(v))
+		if v.Exception != "" {
+			fmt.Fprintf(w, "Exception\t%s\n", v.Exception)
+		}
+	})
+}
+
+// reason says in words what decided a verdict
+func reason(v proxy.Verdict) string {
+	switch v.Source {
+	case proxy.VerdictLocal:
+		return "local traffic is always allowed"
+	case proxy.VerdictAdblock:
+		if v.List != "" {
+			return fmt.Sprintf("adblock filter %s from list %s", v.Filter, v.List)
+		}
+		return "adblock filter " + v.Filter
+	case proxy.VerdictRule:
+		return fmt.Sprintf("rule %s (%s)", v.RuleID, v.Filter)
+	case proxy.VerdictBlocklist:
+		return "the domain is on a blocklist"
+	default:
+		return "no filter, rule or blocklist matched"
+	}
+}
@@ -0,0 +1,218 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+// Route is an operation of the control API. The OpenAPI description is
+// built from its request and response types.
+type Route struct {
+	Method  string // "GET", "POST", "PUT", "PATCH" or "DELETE"
+	Path    string // With {name} placeholders, e.g. "/v1/rules/{id}"
+	Name    string // operationId
+	Summary string
+
+	in      reflect.Type
+	out     reflect.Type
+	stream  bool // The response is a stream of JSON lines
+	handler http.HandlerFunc
+}
+
+// Error is returned by a handler to answer with a specific status
+type Error struct {
+	Status int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NotFound reports that the requested item doesn't exist
+func NotFound(format string, args ...any) error {
+	return &Error{Status: http.StatusNotFound, Err: fmt.Errorf(format, args...)}
+}
+
+// errorResponse is the body of every failed request
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+var noContent = reflect.TypeFor[struct{}]()
+
+// Handle makes a route calling fn. The request is decoded into In: fields
+// tagged `path:"name"` or `query:"name"` come from the URL and the others
+// from the JSON body. Out is encoded as the response; struct{} answers 204
+// No Content. Errors from fn are answered with 400 unless they are an
+// *Error.
+func Handle[In, Out any](method, path, name, summary string, fn func(in In) (Out, error)) Route {
+	r := Route{
+		Method:  method,
+		Path:    path,
+		Name:    name,
+		Summary: summary,
+		in:      reflect.TypeFor[In](),
+		out:     reflect.TypeFor[Out](),
+	}
+	r.handler = func(w http.ResponseWriter, req *http.Request) {
+		var in In
+		if err := decodeRequest(req, &in); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		out, err := fn(in)
+		if err != nil {
+			status := http.StatusBadRequest
+			var e *Error
+			if errors.As(err, &e) {
+				status = e.Status
+			}
+			writeJSON(w, status, errorResponse{err.Error()})
+			return
+		}
+		if r.out == noContent {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+	return r
+}
+
+// Stream makes a route that answers with the values fn sends, as JSON
+// lines, until the channel closes or the client goes away. ctx ends with
+// the request; fn's sender must not block once it's done.
+func Stream[In, Out any](method, path, name, summary string, fn func(ctx context.Context, in In) (<-chan Out, error)) Route {
+	r := Route{
+		Method:  method,
+		Path:    path,
+		Name:    name,
+		Summary: summary,
+		in:      reflect.TypeFor[In](),
+		out:     reflect.TypeFor[Out](),
+		stream:  true,
+	}
+	r.handler = func(w http.ResponseWriter, req *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{"streaming unsupported"})
+			return
+		}
+		var in In
+		if err := decodeRequest(req, &in); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		ch, err := fn(req.Context(), in)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		enc := json.NewEncoder(w)
+		for v := range ch {
+			if err := enc.Encode(v); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+	return r
+}
+
+// decodeRequest fills the struct v points to from the body, then from the
+// path and query, so the body can't override the URL
+func decodeRequest(r *http.Request, v any) error {
+	rv := reflect.ValueOf(v).Elem()
+	if rv.Kind() != reflect.Struct {
+		return decodeBody(r, v)
+	}
+
+	if hasBodyFields(rv.Type()) {
+		if err := decodeBody(r, v); err != nil {
+			return err
+		}
+	}
+	t := rv.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if name := f.Tag.Get("path"); name != "" {
+			if err := setParam(rv.Field(i), r.PathValue(name)); err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+		} else if name := f.Tag.Get("query"); name != "" {
+			if val := r.URL.Query().Get(name); val != "" {
+				if err := setParam(rv.Field(i), val); err != nil {
+					return fmt.Errorf("invalid %s: %w", name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func decodeBody(r *http.Request, v any) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+// isParam tells URL parameters from body fields
+func isParam(f reflect.StructField) bool {
+	return f.Tag.Get("path") != "" || f.Tag.Get("query") != ""
+}
+
+func hasBodyFields(t reflect.Type) bool {
+	for i := range t.NumField() {
+		if f := t.Field(i); f.IsExported() && !isParam(f) && f.Tag.Get("json") != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// setParam parses a URL parameter into a field
+func setParam(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported parameter type %s", v.Type())
+	}
+	return nil
+}
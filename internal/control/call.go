@@ -0,0 +1,192 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// callResponse carries what a method returned. Error is set if it
+// returned a non-nil error; the call itself still succeeded.
+type callResponse struct {
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+var (
+	errorType   = reflect.TypeFor[error]()
+	rawArgsType = reflect.TypeFor[[]json.RawMessage]()
+)
+
+// handleCall runs a method with the JSON array in the body as arguments
+func (s *Server) handleCall(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("method")
+	m := s.target.MethodByName(name)
+	if !m.IsValid() || s.exclude[name] {
+		writeJSON(w, http.StatusNotFound, callResponse{Error: fmt.Sprintf("unknown method %q", name)})
+		return
+	}
+
+	var raw []json.RawMessage
+	body, err := io.ReadAll(r.Body)
+	if err == nil && len(bytes.TrimSpace(body)) > 0 {
+		err = json.Unmarshal(body, &raw)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, callResponse{Error: "arguments must be a JSON array"})
+		return
+	}
+	t := m.Type()
+	if len(raw) != t.NumIn() {
+		writeJSON(w, http.StatusBadRequest, callResponse{Error: fmt.Sprintf("%s takes %d arguments, got %d", name, t.NumIn(), len(raw))})
+		return
+	}
+	args := make([]reflect.Value, len(raw))
+	for i, arg := range raw {
+		v := reflect.New(t.In(i))
+		if err := json.Unmarshal(arg, v.Interface()); err != nil {
+			writeJSON(w, http.StatusBadRequest, callResponse{Error: fmt.Sprintf("argument %d: %v", i+1, err)})
+			return
+		}
+		args[i] = v.Elem()
+	}
+
+	var resp callResponse
+	for _, out := range m.Call(args) {
+		if out.Type() == errorType {
+			if !out.IsNil() {
+				resp.Error = out.Interface().(error).Error()
+			}
+			continue
+		}
+		resp.Result = out.Interface()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Call runs a method of the instance and decodes its result into result,
+// which may be nil. An error returned by the method is returned as is.
+func (c *Client) Call(method string, result any, args ...any) error {
+	if args == nil {
+		args = []any{}
+	}
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Post("http://custos/v1/call/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Result json.RawMessage `json:"result"`
+		Error  string          `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+	if out.Error != "" {
+		return errors.New(out.Error)
+	}
+	if result != nil && len(out.Result) > 0 {
+		return json.Unmarshal(out.Result, result)
+	}
+	return nil
+}
+
+// Events streams the events of the instance until ctx is done or the
+// connection is lost, when the channel is closed
+func (c *Client) Events(ctx context.Context) (<-chan Event, error) {
+	return Watch[Event](ctx, c, "/v1/events")
+}
+
+// Do sends a request to the API and decodes the answer into out, which may
+// be nil. in is sent as the JSON body unless it's nil. Failed requests
+// return an *Error with the status.
+func (c *Client) Do(ctx context.Context, method, path string, in, out any) error {
+	resp, err := c.send(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Watch streams the JSON lines of a Stream route until ctx is done or the
+// connection is lost, when the channel is closed
+func Watch[T any](ctx context.Context, c *Client, path string) (<-chan T, error) {
+	resp, err := c.send(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan T, eventBuffer)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		sc := bufio.NewScanner(resp.Body)
+		sc.Buffer(nil, 64<<20)
+		for sc.Scan() {
+			var v T
+			if json.Unmarshal(sc.Bytes(), &v) != nil {
+				continue
+			}
+			select {
+			case ch <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// send makes a request and turns an unsuccessful answer into an *Error
+func (c *Client) send(ctx context.Context, method, path string, in any) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://custos"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	var e errorResponse
+	if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+		e.Error = resp.Status
+	}
+	return nil, &Error{Status: resp.StatusCode, Err: errors.New(e.Error)}
+}
@@ -0,0 +1,153 @@
+// Package control lets other processes drive a running Custos over a
+// local socket: the window attaching to the daemon, and scripts.
+package control
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrRunning is returned by Listen when another instance serves the socket
+var ErrRunning = errors.New("another Custos instance is running")
+
+// Listen opens the control socket at path, replacing one left behind by a
+// process that died. Only the current user may connect.
+func Listen(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if NewClient(path).Ping() == nil {
+			return nil, ErrRunning
+		}
+		os.Remove(path)
+	}
+	return listenPrivate(path)
+}
+
+// LoadToken returns the API token stored at path, creating it on first use
+func LoadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil && len(bytes.TrimSpace(data)) > 0 {
+		return string(bytes.TrimSpace(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Event is a notification for the frontend, like "logs:new", with its
+// arguments encoded as JSON
+type Event struct {
+	Name string            `json:"name"`
+	Data []json.RawMessage `json:"data,omitempty"`
+}
+
+// eventBuffer is how many events a slow subscriber may fall behind before
+// it misses some
+const eventBuffer = 64
+
+// Hub fans events out to the attached clients
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewHub creates an event hub without subscribers
+func NewHub() *Hub {
+	return &Hub{subs: make(map[chan Event]struct{})}
+}
+
+// Publish sends an event to every subscriber that keeps up
+func (h *Hub) Publish(name string, data ...any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subs) == 0 {
+		return
+	}
+
+	ev := Event{Name: name}
+	for _, d := range data {
+		raw, err := json.Marshal(d)
+		if err != nil {
+			return
+		}
+		ev.Data = append(ev.Data, raw)
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the events published until ctx is done
+func (h *Hub) Subscribe(ctx context.Context) <-chan Event {
+	ch := make(chan Event, eventBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+		close(ch)
+	}()
+	return ch
+}
+
+// Client calls a Custos instance through its control socket
+type Client struct {
+	http *http.Client
+}
+
+// NewClient creates a client for the socket at path; nothing is dialed yet
+func NewClient(path string) *Client {
+	var d net.Dialer
+	return &Client{http: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}}
+}
+
+// pingTimeout bounds how long a live instance may take to answer
+const pingTimeout = time.Second
+
+// Ping checks that an instance is serving the socket
+func (c *Client) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://custos/v1/ping", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
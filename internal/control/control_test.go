@@ -0,0 +1,313 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeTarget struct {
+	enabled bool
+}
+
+type point struct {
+	X, Y int
+}
+
+func (f *fakeTarget) SetEnabled(enabled bool) { f.enabled = enabled }
+
+func (f *fakeTarget) Enabled() bool { return f.enabled }
+
+func (f *fakeTarget) Move(p point, dx int) (point, error) {
+	if dx < 0 {
+		return point{}, errors.New("can't move back")
+	}
+	return point{p.X + dx, p.Y}, nil
+}
+
+func (f *fakeTarget) Secret() string { return "window only" }
+
+// startServer starts a server for target on a socket in a temporary directory
+func startServer(t *testing.T, target any, hub *Hub) (string, *Client) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "custos.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go NewServer(target, hub, "Secret").Serve(l)
+	return path, NewClient(path)
+}
+
+func TestCall(t *testing.T) {
+	target := &fakeTarget{}
+	_, c := startServer(t, target, NewHub())
+
+	if err := c.Call("SetEnabled", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	var enabled bool
+	if err := c.Call("Enabled", &enabled); err != nil || !enabled {
+		t.Errorf("Enabled = %v, %v; want true", enabled, err)
+	}
+
+	var p point
+	if err := c.Call("Move", &p, point{1, 2}, 3); err != nil || p != (point{4, 2}) {
+		t.Errorf("Move = %v, %v; want {4 2}", p, err)
+	}
+	// Errors returned by the method come back as they are
+	if err := c.Call("Move", &p, point{}, -1); err == nil || err.Error() != "can't move back" {
+		t.Errorf("Move error = %v", err)
+	}
+
+	if err := c.Call("Move", &p, point{}); err == nil || !strings.Contains(err.Error(), "takes 2 arguments") {
+		t.Errorf("wrong argument count error = %v", err)
+	}
+	for _, name := range []string{"Secret", "Missing"} {
+		if err := c.Call(name, nil); err == nil {
+			t.Errorf("calling %s succeeded", name)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	hub := NewHub()
+	_, c := startServer(t, &fakeTarget{}, hub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The subscription starts with the request, wait for it
+	deadline := time.Now().Add(time.Second)
+	for {
+		hub.mu.Lock()
+		n := len(hub.subs)
+		hub.mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	hub.Publish("logs:dropped", 3)
+
+	select {
+	case ev := <-events:
+		if ev.Name != "logs:dropped" || len(ev.Data) != 1 || string(ev.Data[0]) != "3" {
+			t.Errorf("event = %s %s", ev.Name, ev.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+}
+
+func TestListen(t *testing.T) {
+	path, _ := startServer(t, &fakeTarget{}, NewHub())
+	if _, err := Listen(path); !errors.Is(err, ErrRunning) {
+		t.Errorf("second Listen = %v, want ErrRunning", err)
+	}
+
+	// A socket left by a process that died is replaced
+	stale := filepath.Join(t.TempDir(), "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = Listen(stale)
+	if err != nil {
+		t.Fatalf("Listen over a stale socket: %v", err)
+	}
+	defer l.Close()
+	// Created private rather than changed afterwards
+	if info, err := os.Stat(stale); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v, %v; want 0600", info.Mode(), err)
+	}
+}
+
+type Item struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Tags    []string  `json:"tags,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+type itemUpdate struct {
+	ID   string `path:"id" json:"-"`
+	Name string `json:"name"`
+}
+
+type itemQuery struct {
+	ID    string `path:"id"`
+	Limit int    `query:"limit"`
+}
+
+func itemRoutes() []Route {
+	return []Route{
+		Handle("GET", "/v1/items/{id}", "getItem", "Get an item", func(in itemQuery) (Item, error) {
+			if in.ID == "missing" {
+				return Item{}, NotFound("no item %s", in.ID)
+			}
+			return Item{ID: in.ID, Name: strconv.Itoa(in.Limit)}, nil
+		}),
+		Handle("PATCH", "/v1/items/{id}", "updateItem", "Rename an item", func(in itemUpdate) (struct{}, error) {
+			if in.Name == "" {
+				return struct{}{}, errors.New("name is required")
+			}
+			return struct{}{}, nil
+		}),
+	}
+}
+
+func do(t *testing.T, h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestHandle(t *testing.T) {
+	s := NewServer(&fakeTarget{}, NewHub())
+	s.Route(itemRoutes()...)
+
+	w := do(t, s, "GET", "/v1/items/a1?limit=5", "")
+	var item Item
+	if err := json.Unmarshal(w.Body.Bytes(), &item); w.Code != http.StatusOK || err != nil || item.ID != "a1" || item.Name != "5" {
+		t.Errorf("GET item = %d %s", w.Code, w.Body)
+	}
+	if w := do(t, s, "GET", "/v1/items/a1?limit=five", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bad query parameter = %d %s", w.Code, w.Body)
+	}
+	if w := do(t, s, "GET", "/v1/items/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing item = %d %s", w.Code, w.Body)
+	}
+
+	if w := do(t, s, "PATCH", "/v1/items/a1", `{"name":"b"}`); w.Code != http.StatusNoContent {
+		t.Errorf("PATCH = %d %s", w.Code, w.Body)
+	}
+	w = do(t, s, "PATCH", "/v1/items/a1", `{}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"error":"name is required"`) {
+		t.Errorf("failed PATCH = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	s := NewServer(&fakeTarget{}, NewHub())
+	s.Version = "1.2.3"
+	s.Route(itemRoutes()...)
+
+	// Round trip through JSON to compare with what clients see
+	data, err := json.Marshal(s.OpenAPI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Info  struct{ Version string }
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+			Parameters  []struct {
+				Name, In string
+			}
+			RequestBody *struct {
+				Content map[string]struct {
+					Schema struct {
+						Properties map[string]any
+					}
+				}
+			} `json:"requestBody"`
+			Responses map[string]any
+		}
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]any
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Info.Version != "1.2.3" {
+		t.Errorf("version = %q", doc.Info.Version)
+	}
+	get := doc.Paths["/v1/items/{id}"]["get"]
+	if get.OperationID != "getItem" || len(get.Parameters) != 2 ||
+		get.Parameters[0].In != "path" || get.Parameters[1].Name != "limit" || get.Parameters[1].In != "query" {
+		t.Errorf("GET operation = %+v", get)
+	}
+	if get.RequestBody != nil {
+		t.Error("GET has a request body")
+	}
+
+	patch := doc.Paths["/v1/items/{id}"]["patch"]
+	body := patch.RequestBody.Content["application/json"].Schema.Properties
+	if _, ok := body["name"]; !ok || len(body) != 1 {
+		t.Errorf("PATCH body = %v, want only name", body)
+	}
+	if _, ok := patch.Responses["204"]; !ok {
+		t.Errorf("PATCH responses = %v, want 204", patch.Responses)
+	}
+
+	item := doc.Components.Schemas["Item"].Properties
+	if item["created"]["format"] != "date-time" || item["tags"]["type"] != "array" {
+		t.Errorf("Item schema = %v", item)
+	}
+	for _, path := range []string{"/v1/ping", "/v1/call/{method}", "/v1/events", "/v1/openapi.json"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("built-in %s isn't described", path)
+		}
+	}
+}
+
+func TestServeTCP(t *testing.T) {
+	s := NewServer(&fakeTarget{}, NewHub())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.ServeTCP(l, "secret")
+
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "secret": http.StatusNoContent} {
+		req, _ := http.NewRequest("GET", "http://"+l.Addr().String()+"/v1/ping", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("token %q: status %d, want %d", token, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestLoadToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_token")
+	token, err := LoadToken(path)
+	if err != nil || len(token) != 64 {
+		t.Fatalf("LoadToken = %q, %v", token, err)
+	}
+	if again, _ := LoadToken(path); again != token {
+		t.Errorf("token changed from %q to %q", token, again)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("token file mode = %v", info.Mode())
+	}
+}
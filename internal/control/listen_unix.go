@@ -0,0 +1,23 @@
+//go:build !windows
+
+package control
+
+import (
+	"net"
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes the umask changes of concurrent Listen calls
+var umaskMu sync.Mutex
+
+// listenPrivate creates the socket with mode 0600 from the start, so no
+// other user can connect before its mode is changed. The umask is process
+// wide; files other goroutines create meanwhile only come out stricter.
+func listenPrivate(path string) (net.Listener, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,20 @@
+package control
+
+import (
+	"net"
+	"os"
+)
+
+// listenPrivate creates the socket; on Windows its access follows the ACL
+// of the user's profile directory it lives in
+func listenPrivate(path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
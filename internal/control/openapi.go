@@ -0,0 +1,234 @@
+package control
+
+import (
+	"encoding/json"
+	"go/token"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	rawJSONType  = reflect.TypeFor[json.RawMessage]()
+	pathParamsRe = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+// OpenAPI describes the routes of the server as an OpenAPI 3.1 document
+func (s *Server) OpenAPI() map[string]any {
+	g := &schemaGen{components: make(map[string]any), names: make(map[reflect.Type]string)}
+	paths := make(map[string]map[string]any)
+	for _, r := range s.routes {
+		if paths[r.Path] == nil {
+			paths[r.Path] = make(map[string]any)
+		}
+		paths[r.Path][strings.ToLower(r.Method)] = g.operation(r)
+	}
+	g.components["Error"] = g.schema(reflect.TypeFor[errorResponse]())
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Custos control API",
+			"version": s.Version,
+			"description": "Controls a running Custos. The Unix socket needs no credentials; " +
+				"the optional loopback TCP listener needs the API token as a bearer token.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"token": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []any{map[string]any{}, map[string]any{"token": []string{}}},
+	}
+}
+
+// schemaGen builds JSON schemas for Go types, named struct types become
+// shared components
+type schemaGen struct {
+	components map[string]any
+	names      map[reflect.Type]string
+}
+
+func (g *schemaGen) operation(r Route) map[string]any {
+	op := map[string]any{"operationId": r.Name, "summary": r.Summary}
+
+	var params []any
+	for _, m := range pathParamsRe.FindAllStringSubmatch(r.Path, -1) {
+		schema := map[string]any{"type": "string"}
+		if f, ok := paramField(r.in, "path", m[1]); ok {
+			schema = g.schema(f.Type)
+		}
+		params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": schema})
+	}
+	if r.in != nil && r.in.Kind() == reflect.Struct {
+		for i := range r.in.NumField() {
+			f := r.in.Field(i)
+			if name := f.Tag.Get("query"); name != "" {
+				params = append(params, map[string]any{"name": name, "in": "query", "schema": g.schema(f.Type)})
+			}
+		}
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if body := g.body(r.in); body != nil {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": body}},
+		}
+	}
+
+	responses := map[string]any{
+		"default": map[string]any{
+			"description": "Error",
+			"content": map[string]any{"application/json": map[string]any{
+				"schema": map[string]any{"$ref": "#/components/schemas/Error"},
+			}},
+		},
+	}
+	switch {
+	case r.stream:
+		responses["200"] = map[string]any{
+			"description": "One JSON object per line",
+			"content":     map[string]any{"application/x-ndjson": map[string]any{"schema": g.schema(r.out)}},
+		}
+	case r.out == noContent:
+		responses["204"] = map[string]any{"description": "Done"}
+	default:
+		responses["200"] = map[string]any{
+			"description": "OK",
+			"content":     map[string]any{"application/json": map[string]any{"schema": g.schema(r.out)}},
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// body returns the schema of the request body, nil if there is none
+func (g *schemaGen) body(in reflect.Type) map[string]any {
+	if in == nil || in == noContent {
+		return nil
+	}
+	if in.Kind() != reflect.Struct {
+		return g.schema(in)
+	}
+	if !hasBodyFields(in) {
+		return nil
+	}
+	// URL parameters are left out, so the request type itself isn't shared
+	props := make(map[string]any)
+	g.properties(in, props, func(f reflect.StructField) bool { return !isParam(f) })
+	return map[string]any{"type": "object", "properties": props}
+}
+
+func paramField(t reflect.Type, tag, name string) (reflect.StructField, bool) {
+	if t == nil || t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	for i := range t.NumField() {
+		if f := t.Field(i); f.Tag.Get(tag) == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawJSONType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if !token.IsExported(t.Name()) {
+			return g.object(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.componentName(t)
+			g.names[t] = name
+			// Registered first so recursive types refer to themselves
+			g.components[name] = map[string]any{}
+			g.components[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]any{}
+	}
+}
+
+// componentName is the type name, qualified by its package if another
+// package has a type of the same name
+func (g *schemaGen) componentName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := g.components[name]; taken {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+	return name
+}
+
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	g.properties(t, props, nil)
+	return map[string]any{"type": "object", "properties": props}
+}
+
+// properties adds the JSON fields of a struct, as encoding/json sees them
+func (g *schemaGen) properties(t reflect.Type, props map[string]any, keep func(reflect.StructField) bool) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if keep != nil && !keep(f) {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.properties(ft, props, keep)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+	}
+}
@@ -0,0 +1,95 @@
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Server answers the control API: the routes added with Route, calls to
+// the exported methods of a target, and the events of a hub
+type Server struct {
+	// Version is reported in the OpenAPI description
+	Version string
+
+	target  reflect.Value
+	exclude map[string]bool
+	hub     *Hub
+	mux     *http.ServeMux
+	routes  []Route
+}
+
+// NewServer serves the methods of target except those named in exclude,
+// e.g. ones that need a window
+func NewServer(target any, hub *Hub, exclude ...string) *Server {
+	s := &Server{
+		target:  reflect.ValueOf(target),
+		exclude: make(map[string]bool),
+		hub:     hub,
+		mux:     http.NewServeMux(),
+	}
+	for _, name := range exclude {
+		s.exclude[name] = true
+	}
+	s.Route(
+		Handle("GET", "/v1/ping", "ping", "Check that Custos is running",
+			func(struct{}) (struct{}, error) { return struct{}{}, nil }),
+		Route{
+			Method:  "POST",
+			Path:    "/v1/call/{method}",
+			Name:    "call",
+			Summary: "Call a binding of the window by name, with its arguments as a JSON array",
+			in:      rawArgsType,
+			out:     reflect.TypeFor[callResponse](),
+			handler: s.handleCall,
+		},
+		Stream("GET", "/v1/events", "events", "Stream the events sent to the window",
+			func(ctx context.Context, _ struct{}) (<-chan Event, error) { return s.hub.Subscribe(ctx), nil }),
+		Handle("GET", "/v1/openapi.json", "openapi", "Describe this API as OpenAPI 3.1",
+			func(struct{}) (map[string]any, error) { return s.OpenAPI(), nil }),
+	)
+	return s
+}
+
+// Route adds operations to the API
+func (s *Server) Route(routes ...Route) {
+	for _, r := range routes {
+		s.routes = append(s.routes, r)
+		s.mux.HandleFunc(r.Method+" "+r.Path, r.handler)
+	}
+}
+
+// Serve answers requests on the control socket until it's closed
+func (s *Server) Serve(l net.Listener) error {
+	return serve(l, s.mux)
+}
+
+// ServeTCP answers requests on a loopback TCP listener. Any local user can
+// connect to it, so every request must carry token as a bearer token.
+func (s *Server) ServeTCP(l net.Listener, token string) error {
+	return serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="custos"`)
+			writeJSON(w, http.StatusUnauthorized, errorResponse{"missing or invalid token"})
+			return
+		}
+		s.mux.ServeHTTP(w, r)
+	}))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func serve(l net.Listener, h http.Handler) error {
+	err := (&http.Server{Handler: h}).Serve(l)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -8,22 +8,24 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // BlocklistManager handles the loading and checking of blocked domains
 type BlocklistManager struct {
 	mu      sync.RWMutex
-	domains map[string]bool
+	domains atomic.Pointer[DomainSet] // Swapped as a whole on every Load
 	sources []string
 }
 
 // NewBlocklistManager creates a new manager
 func NewBlocklistManager() *BlocklistManager {
-	return &BlocklistManager{
-		domains: make(map[string]bool),
+	m := &BlocklistManager{
 		sources: []string{}, // Start empty, will be seeded/populated by App
 	}
+	m.domains.Store(emptyDomainSet)
+	return m
 }
 
 // SetSources updates the sources list
@@ -40,24 +42,25 @@ func (m *BlocklistManager) Load() error {
 	copy(sources, m.sources)
 	m.mu.RUnlock()
 
-	newDomains := make(map[string]bool)
+	// Build the new set off the hot path, lookups keep using the old one meanwhile
+	builder := NewDomainSetBuilder()
 
 	for _, source := range sources {
 		fmt.Println("Loading blocklist source:", source)
-		if err := m.loadSource(source, newDomains); err != nil {
+		if err := m.loadSource(source, builder); err != nil {
 			// Log error but continue
 			continue
 		}
 	}
 
-	m.mu.Lock()
-	m.domains = newDomains
-	m.mu.Unlock()
+	domains := builder.Build()
+	m.domains.Store(domains)
+	fmt.Printf("Blocklist ready: %d unique domains, ~%d KB\n", domains.Len(), domains.SizeBytes()/1024)
 
 	return nil
 }
 
-func (m *BlocklistManager) loadSource(source string, domains map[string]bool) error {
+func (m *BlocklistManager) loadSource(source string, domains *DomainSetBuilder) error {
 	if source == "" {
 		return nil
 	}
@@ -114,7 +117,7 @@ func (m *BlocklistManager) loadSource(source string, domains map[string]bool) er
 		if domain != "" {
 			// Simple validation
 			if strings.Contains(domain, ".") {
-				domains[domain] = true
+				domains.Add(domain)
 				count++
 			}
 		}
@@ -125,18 +128,13 @@ func (m *BlocklistManager) loadSource(source string, domains map[string]bool) er
 
 // IsBlocked checks if a domain is blocked
 func (m *BlocklistManager) IsBlocked(domain string) bool {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
 	// Remove trailing dot if present (DNS validity)
 	domain = strings.TrimSuffix(domain, ".")
 
-	return m.domains[domain]
+	return m.domains.Load().Contains(domain)
 }
 
 // Count returns the number of blocked domains
 func (m *BlocklistManager) Count() int {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return len(m.domains)
+	return m.domains.Load().Len()
 }
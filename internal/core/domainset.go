@@ -0,0 +1,146 @@
+package core
+
+import (
+	"bytes"
+	"hash/maphash"
+	"sort"
+)
+
+// DomainSet is an immutable, memory-compact set of domains.
+// All domains are stored sorted and back to back in a single byte slice and
+// looked up through an open-addressing table of uint32 positions, so a large
+// blocklist costs a few flat allocations plus ~10 bytes of index per entry
+// instead of a heap-allocated string and a map bucket slot per domain.
+type DomainSet struct {
+	data    []byte
+	offsets []uint32 // len = count+1, offsets[i]..offsets[i+1] is domain i
+	table   []uint32 // Power-of-two sized, holds domain index+1, 0 = empty slot
+	seed    maphash.Seed
+}
+
+// emptyDomainSet is used before the first blocklist load completes
+var emptyDomainSet = &DomainSet{offsets: []uint32{0}}
+
+// Contains reports whether the exact domain is in the set
+func (s *DomainSet) Contains(domain string) bool {
+	if len(s.table) == 0 {
+		return false
+	}
+	mask := uint64(len(s.table) - 1)
+	for slot := maphash.String(s.seed, domain) & mask; ; slot = (slot + 1) & mask {
+		idx := s.table[slot]
+		if idx == 0 {
+			return false
+		}
+		if string(s.at(int(idx-1))) == domain {
+			return true
+		}
+	}
+}
+
+// Len returns the number of domains in the set
+func (s *DomainSet) Len() int {
+	return len(s.offsets) - 1
+}
+
+// SizeBytes returns the approximate heap footprint of the set
+func (s *DomainSet) SizeBytes() int {
+	return cap(s.data) + 4*cap(s.offsets) + 4*cap(s.table)
+}
+
+func (s *DomainSet) at(i int) []byte {
+	return s.data[s.offsets[i]:s.offsets[i+1]]
+}
+
+// buildTable indexes every domain, keeping the load factor at or below 3/4
+func (s *DomainSet) buildTable() {
+	n := s.Len()
+	if n == 0 {
+		return
+	}
+	size := 1
+	for size*3 < n*4 {
+		size <<= 1
+	}
+	s.seed = maphash.MakeSeed()
+	s.table = make([]uint32, size)
+	mask := uint64(size - 1)
+	for i := 0; i < n; i++ {
+		slot := maphash.Bytes(s.seed, s.at(i)) & mask
+		for s.table[slot] != 0 {
+			slot = (slot + 1) & mask
+		}
+		s.table[slot] = uint32(i + 1)
+	}
+}
+
+// DomainSetBuilder accumulates domains for a DomainSet.
+// It is not safe for concurrent use.
+type DomainSetBuilder struct {
+	data    []byte
+	offsets []uint32
+}
+
+// NewDomainSetBuilder creates an empty builder
+func NewDomainSetBuilder() *DomainSetBuilder {
+	return &DomainSetBuilder{offsets: []uint32{0}}
+}
+
+// Add appends a domain; duplicates are removed by Build
+func (b *DomainSetBuilder) Add(domain string) {
+	if domain == "" {
+		return
+	}
+	b.data = append(b.data, domain...)
+	b.offsets = append(b.offsets, uint32(len(b.data)))
+}
+
+// Len returns the number of domains added so far, including duplicates
+func (b *DomainSetBuilder) Len() int {
+	return len(b.offsets) - 1
+}
+
+// Build sorts and deduplicates the added domains into an immutable set.
+// The builder must not be used afterwards.
+func (b *DomainSetBuilder) Build() *DomainSet {
+	n := b.Len()
+	at := func(i int) []byte {
+		return b.data[b.offsets[i]:b.offsets[i+1]]
+	}
+
+	order := make([]uint32, n)
+	for i := range order {
+		order[i] = uint32(i)
+	}
+	sort.Slice(order, func(x, y int) bool {
+		return bytes.Compare(at(int(order[x])), at(int(order[y]))) < 0
+	})
+
+	set := &DomainSet{
+		data:    make([]byte, 0, len(b.data)),
+		offsets: make([]uint32, 1, n+1),
+	}
+	var prev []byte
+	for k, idx := range order {
+		domain := at(int(idx))
+		if k > 0 && bytes.Equal(domain, prev) {
+			continue
+		}
+		set.data = append(set.data, domain...)
+		set.offsets = append(set.offsets, uint32(len(set.data)))
+		prev = domain
+	}
+
+	// Release the slack left by duplicates
+	if cap(set.data)-len(set.data) > len(set.data)/8 {
+		set.data = bytes.Clone(set.data)
+	}
+	if cap(set.offsets)-len(set.offsets) > len(set.offsets)/8 {
+		set.offsets = append([]uint32(nil), set.offsets...)
+	}
+
+	set.buildTable()
+
+	b.data, b.offsets = nil, nil
+	return set
+}
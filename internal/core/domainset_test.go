@@ -0,0 +1,127 @@
+package core
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func TestDomainSet(t *testing.T) {
+	b := NewDomainSetBuilder()
+	for _, d := range []string{"ads.example.com", "tracker.net", "ads.example.com", "a.b", "", "z.example.org"} {
+		b.Add(d)
+	}
+	set := b.Build()
+
+	if set.Len() != 4 {
+		t.Fatalf("Len() = %d; want 4", set.Len())
+	}
+
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"ads.example.com", true},
+		{"tracker.net", true},
+		{"a.b", true},
+		{"z.example.org", true},
+		{"example.com", false},
+		{"ads.example.co", false},
+		{"ads.example.comm", false},
+		{"", false},
+		{"zzz", false},
+	}
+	for _, tt := range tests {
+		if got := set.Contains(tt.domain); got != tt.want {
+			t.Errorf("Contains(%q) = %v; want %v", tt.domain, got, tt.want)
+		}
+	}
+
+	if emptyDomainSet.Contains("example.com") || emptyDomainSet.Len() != 0 {
+		t.Errorf("empty set should contain nothing")
+	}
+}
+
+// benchmarkDomains generates n distinct domains shaped like hosts-file entries
+func benchmarkDomains(n int) []string {
+	domains := make([]string, n)
+	for i := range domains {
+		domains[i] = fmt.Sprintf("ads%d.tracker-%d.example%d.com", i, i%977, i%31)
+	}
+	return domains
+}
+
+const benchmarkDomainCount = 300000
+
+func heapInUse() int64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.HeapAlloc)
+}
+
+func BenchmarkBlocklistMemory(b *testing.B) {
+	b.Run("map", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			domains := benchmarkDomains(benchmarkDomainCount)
+			before := heapInUse()
+			m := make(map[string]bool)
+			for _, d := range domains {
+				// Copy so the map owns its strings, as it does when scanning a file
+				m[string([]byte(d))] = true
+			}
+			after := heapInUse()
+			b.ReportMetric(float64(after-before)/benchmarkDomainCount, "B/domain")
+			runtime.KeepAlive(domains)
+			runtime.KeepAlive(m)
+		}
+	})
+
+	b.Run("domainset", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			domains := benchmarkDomains(benchmarkDomainCount)
+			before := heapInUse()
+			builder := NewDomainSetBuilder()
+			for _, d := range domains {
+				builder.Add(d)
+			}
+			set := builder.Build()
+			after := heapInUse()
+			b.ReportMetric(float64(after-before)/benchmarkDomainCount, "B/domain")
+			runtime.KeepAlive(domains)
+			runtime.KeepAlive(set)
+		}
+	})
+}
+
+func BenchmarkBlocklistLookup(b *testing.B) {
+	domains := benchmarkDomains(benchmarkDomainCount)
+	probes := make([]string, 1024)
+	for i := range probes {
+		if i%2 == 0 {
+			probes[i] = domains[(i*7919)%len(domains)]
+		} else {
+			probes[i] = fmt.Sprintf("www.site%d.org", i)
+		}
+	}
+
+	m := make(map[string]bool, len(domains))
+	builder := NewDomainSetBuilder()
+	for _, d := range domains {
+		m[d] = true
+		builder.Add(d)
+	}
+	set := builder.Build()
+
+	b.Run("map", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = m[probes[i%len(probes)]]
+		}
+	})
+
+	b.Run("domainset", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = set.Contains(probes[i%len(probes)])
+		}
+	})
+}
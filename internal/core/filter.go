@@ -0,0 +1,112 @@
+package core
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultFilterUpdateInterval is used when neither the user nor the list specify one
+	DefaultFilterUpdateInterval = 24 * time.Hour
+	// MinFilterUpdateInterval prevents lists from being hammered by aggressive Expires headers
+	MinFilterUpdateInterval = 1 * time.Hour
+	// filterRetryBase is the first backoff step after a failed download
+	filterRetryBase = 5 * time.Minute
+)
+
+// RefreshInterval returns the effective interval between two downloads of the list.
+// A user-configured interval wins over the list's own "! Expires:" header.
+func (f AdblockFilter) RefreshInterval() time.Duration {
+	interval := DefaultFilterUpdateInterval
+	if f.UpdateInterval > 0 {
+		interval = time.Duration(f.UpdateInterval) * time.Second
+	} else if f.Expires > 0 {
+		interval = time.Duration(f.Expires) * time.Second
+	}
+	if interval < MinFilterUpdateInterval {
+		interval = MinFilterUpdateInterval
+	}
+	return interval
+}
+
+// NextRefresh returns when the list should be downloaded again.
+// Failed attempts back off exponentially, capped at the regular interval.
+func (f AdblockFilter) NextRefresh() time.Time {
+	interval := f.RefreshInterval()
+	if f.FailureCount > 0 && !f.LastAttempt.IsZero() {
+		backoff := filterRetryBase
+		for i := 1; i < f.FailureCount && backoff < interval; i++ {
+			backoff *= 2
+		}
+		if backoff > interval {
+			backoff = interval
+		}
+		return f.LastAttempt.Add(backoff)
+	}
+	return f.LastUpdated.Add(interval)
+}
+
+// ParseFilterExpires reads the "! Expires: 4 days (update frequency)" header of an
+// adblock list and returns the advertised interval, or 0 if there is none.
+// Only the leading comment block is scanned.
+func ParseFilterExpires(content string) time.Duration {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "[") {
+			continue
+		}
+		if !strings.HasPrefix(line, "!") && !strings.HasPrefix(line, "#") {
+			// End of header
+			return 0
+		}
+
+		body := strings.TrimSpace(strings.TrimLeft(line, "!# "))
+		if len(body) < len("expires:") || !strings.EqualFold(body[:len("expires:")], "expires:") {
+			continue
+		}
+		return parseExpiresValue(strings.TrimSpace(body[len("expires:"):]))
+	}
+	return 0
+}
+
+// parseExpiresValue parses values like "4 days", "12 hours" or "1d"
+func parseExpiresValue(value string) time.Duration {
+	// Drop trailing comments such as "(update frequency)"
+	if idx := strings.Index(value, "("); idx >= 0 {
+		value = strings.TrimSpace(value[:idx])
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0
+	}
+
+	number, unit := fields[0], ""
+	if len(fields) > 1 {
+		unit = fields[1]
+	} else {
+		// Compact form, e.g. "12h"
+		i := 0
+		for i < len(number) && number[i] >= '0' && number[i] <= '9' {
+			i++
+		}
+		number, unit = number[:i], number[i:]
+	}
+
+	n, err := strconv.Atoi(number)
+	if err != nil || n <= 0 {
+		return 0
+	}
+
+	switch strings.ToLower(unit) {
+	case "h", "hour", "hours":
+		return time.Duration(n) * time.Hour
+	case "", "d", "day", "days":
+		return time.Duration(n) * 24 * time.Hour
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFilterExpires(t *testing.T) {
+	tests := []struct {
+		content string
+		want    time.Duration
+	}{
+		{"[Adblock Plus 2.0]\n! Title: EasyList\n! Expires: 4 days (update frequency)\n||ads.example.com^\n", 4 * 24 * time.Hour},
+		{"! Expires: 12 hours\n", 12 * time.Hour},
+		{"# Expires: 1d\n0.0.0.0 ads.example.com\n", 24 * time.Hour},
+		{"! Title: No expiry\n||ads.example.com^\n! Expires: 2 days\n", 0},
+		{"! Expires: soon\n", 0},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		if got := ParseFilterExpires(tt.content); got != tt.want {
+			t.Errorf("ParseFilterExpires(%q) = %v; want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestAdblockFilterNextRefresh(t *testing.T) {
+	now := time.Now()
+
+	f := AdblockFilter{LastUpdated: now, Expires: int64((4 * 24 * time.Hour) / time.Second)}
+	if got := f.NextRefresh(); !got.Equal(now.Add(4 * 24 * time.Hour)) {
+		t.Errorf("NextRefresh with Expires = %v; want %v", got, now.Add(4*24*time.Hour))
+	}
+
+	f.UpdateInterval = int64((6 * time.Hour) / time.Second)
+	if got := f.NextRefresh(); !got.Equal(now.Add(6 * time.Hour)) {
+		t.Errorf("NextRefresh with UpdateInterval = %v; want %v", got, now.Add(6*time.Hour))
+	}
+
+	f.LastAttempt = now
+	f.FailureCount = 3
+	if got := f.NextRefresh(); !got.Equal(now.Add(20 * time.Minute)) {
+		t.Errorf("NextRefresh after 3 failures = %v; want %v", got, now.Add(20*time.Minute))
+	}
+
+	f.FailureCount = 20
+	if got := f.NextRefresh(); !got.Equal(now.Add(6 * time.Hour)) {
+		t.Errorf("NextRefresh backoff cap = %v; want %v", got, now.Add(6*time.Hour))
+	}
+}
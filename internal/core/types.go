@@ -1,6 +1,11 @@
 package core
 
-import "time"
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
 
 const (
 	ProtocolTCP       string = "tcp"
@@ -42,6 +47,7 @@ type LogEntry struct {
 	Protocol    string    `json:"protocol"` // "tcp", "udp", "http", "https"
 	ProcessName string    `json:"process_name"`
 	ProcessID   int32     `json:"process_id"`
+	ProcessKey  string    `json:"process_key"` // Process.Key, "" if unidentified
 	BytesSent   int64     `json:"bytes_sent"`
 	BytesRecv   int64     `json:"bytes_recv"`
 	Status      string    `json:"status"`  // "allowed", "blocked", "error"
@@ -56,15 +62,79 @@ type Stats struct {
 	ActiveConns   int              `json:"active_connections"`
 	TopDomains    map[string]int64 `json:"top_domains"`
 	AdblockHits   int64            `json:"adblock_hits"`
-	Timestamp     time.Time        `json:"timestamp"` // Unix Milli
+	TopFilters    map[string]int64 `json:"top_filters"` // Adblock filter text -> hits
+	Timestamp     time.Time        `json:"timestamp"`   // Unix Milli
 }
 
 // TrafficDataPoint represents a point in the traffic chart
 type TrafficDataPoint struct {
-	Name      string    `json:"name"`      // Time label (e.g., "10:00")
-	Timestamp time.Time `json:"timestamp"` // Time of data point
-	Upload    int64     `json:"upload"`    // Bytes sent
-	Download  int64     `json:"download"`  // Bytes received
+	Name        string    `json:"name"`        // Time label (e.g., "10:00")
+	Timestamp   time.Time `json:"timestamp"`   // Time of data point
+	Upload      int64     `json:"upload"`      // Bytes sent
+	Download    int64     `json:"download"`    // Bytes received
+	Connections int64     `json:"connections"` // Connections opened
+	Blocked     int64     `json:"blocked"`     // Connections blocked
+}
+
+// TrafficQuery selects rolled up traffic over an arbitrary time range
+type TrafficQuery struct {
+	From    time.Time
+	To      time.Time
+	Bucket  time.Duration // Zero picks a size from the range
+	Domain  string        // Optional, restricts to one domain
+	Process string        // Optional, restricts to one process; ignored when Domain is set
+}
+
+const (
+	RollupKindDomain  string = "domain"
+	RollupKindProcess string = "process"
+)
+
+// TrafficRollup aggregates the traffic of one domain or process over a time bucket
+type TrafficRollup struct {
+	Resolution  int64  `gorm:"primaryKey;autoIncrement:false" json:"resolution"` // Bucket size in seconds
+	Bucket      int64  `gorm:"primaryKey;autoIncrement:false" json:"bucket"`     // Unix seconds of the bucket start
+	Kind        string `gorm:"primaryKey" json:"kind"`                           // "domain" or "process"
+	Key         string `gorm:"primaryKey" json:"key"`
+	Upload      int64  `json:"upload"`
+	Download    int64  `json:"download"`
+	Connections int64  `json:"connections"`
+	Blocked     int64  `json:"blocked"`
+}
+
+// Dimensions usage can be broken down by
+const (
+	UsageByDomain  string = "domain" // Domain, or destination IP when there is none
+	UsageByProcess string = "process"
+	UsageByPort    string = "port" // Destination port
+	UsageByIP      string = "ip"   // Destination IP
+)
+
+// Metrics top usage lists are ranked by
+const (
+	UsageMetricBytes       string = "bytes"
+	UsageMetricConnections string = "connections"
+	UsageMetricBlocked     string = "blocked"
+)
+
+// UsageQuery selects the top usage by one dimension within a time window
+type UsageQuery struct {
+	From    time.Time // Zero means 24 hours before To
+	To      time.Time // Zero means now
+	By      string
+	Metric  string // Defaults to bytes
+	Limit   int    // Zero returns every key
+	Domain  string // Optional, only count traffic to this domain
+	Process string // Optional, only count traffic of this process
+}
+
+// UsageStat is the traffic of one domain, process, port or IP
+type UsageStat struct {
+	Key         string `json:"key"`
+	Upload      int64  `json:"upload"`
+	Download    int64  `json:"download"`
+	Connections int64  `json:"connections"`
+	Blocked     int64  `json:"blocked"`
 }
 
 // RuleType defines blocking or allowing
@@ -88,6 +158,66 @@ type Rule struct {
 	Enabled  bool     `json:"enabled"`
 	Source   RuleType `json:"source"`    // "custom" or "default"
 	HitCount int64    `json:"hit_count"` // Number of times triggered
+
+	// Optional process matchers; a rule with any of them set only applies
+	// to connections from a matching process
+	ProcessPath   string `json:"process_path,omitempty"` // Executable path, or just its file name
+	ProcessSHA256 string `json:"process_sha256,omitempty"`
+	ProcessUser   string `json:"process_user,omitempty"`
+}
+
+// HasProcessMatcher reports whether the rule is limited to some processes
+func (r Rule) HasProcessMatcher() bool {
+	return r.ProcessPath != "" || r.ProcessSHA256 != "" || r.ProcessUser != ""
+}
+
+// MatchesProcess reports whether p passes the rule's process matchers. A
+// process that isn't known, or whose executable isn't hashed yet, only
+// passes rules without the matchers it lacks.
+func (r Rule) MatchesProcess(p *Process) bool {
+	return r.matchProcess(p, false)
+}
+
+// MayMatchProcess is MatchesProcess, except that an executable whose hash
+// couldn't be computed passes the SHA-256 matcher. Block rules use it to
+// fail closed.
+func (r Rule) MayMatchProcess(p *Process) bool {
+	return r.matchProcess(p, true)
+}
+
+func (r Rule) matchProcess(p *Process, unhashed bool) bool {
+	if !r.HasProcessMatcher() {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	if r.ProcessPath != "" && !matchExePath(r.ProcessPath, p.ExePath) {
+		return false
+	}
+	if r.ProcessSHA256 != "" && !strings.EqualFold(r.ProcessSHA256, p.SHA256) &&
+		!(unhashed && p.SHA256 == "" && p.ExePath != "") {
+		return false
+	}
+	if r.ProcessUser != "" && !strings.EqualFold(r.ProcessUser, p.User) {
+		return false
+	}
+	return true
+}
+
+// matchExePath matches a full executable path, or only the file name when
+// pattern has no directory. Windows paths are case-insensitive.
+func matchExePath(pattern, exe string) bool {
+	if exe == "" {
+		return false
+	}
+	if !strings.ContainsAny(pattern, `/\`) {
+		exe = exe[strings.LastIndexAny(exe, `/\`)+1:]
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(pattern, exe)
+	}
+	return pattern == exe
 }
 
 // TrafficStatsModel is the DB model for persistent stats
@@ -106,10 +236,11 @@ type PaginatedRulesResponse struct {
 
 // PaginatedLogs wraps logs and total count
 type PaginatedLogs struct {
-	Logs       []LogEntry `json:"logs"`
-	NextCursor string     `json:"next_cursor"`
-	HasMore    bool       `json:"has_more"`
-	Total      int64      `json:"total"`
+	Logs        []LogEntry `json:"logs"`
+	NextCursor  string     `json:"next_cursor"`
+	HasMore     bool       `json:"has_more"`
+	Total       int64      `json:"total"`
+	Approximate bool       `json:"approximate"` // Total is estimated for large result sets
 }
 
 // AppSetting represents a key-value setting
@@ -126,9 +257,45 @@ type AdblockFilter struct {
 	Enabled     bool      `json:"enabled"`
 	LastUpdated time.Time `json:"last_updated"`
 	Hits        int64     `json:"hits"`
+	// Refresh scheduling
+	UpdateInterval int64     `json:"update_interval"` // Seconds, 0 = use list Expires or default
+	Expires        int64     `json:"expires"`         // Seconds, from the list "! Expires:" header
+	LastAttempt    time.Time `json:"last_attempt"`
+	FailureCount   int       `json:"failure_count"`
+}
+
+// AdblockFilterHit counts how often a single adblock filter blocked a request
+type AdblockFilterHit struct {
+	Filter  string    `gorm:"primaryKey" json:"filter"`
+	ListID  string    `gorm:"index" json:"list_id"` // AdblockFilter.ID, empty for built-in rules
+	Hits    int64     `json:"hits"`
+	LastHit time.Time `json:"last_hit"`
 }
 
+// RetentionPolicy bounds the stored log history, a zero field disables that limit
+type RetentionPolicy struct {
+	AllowedMaxAge int64 `json:"allowed_max_age"` // Seconds, applies to allowed and error entries
+	BlockedMaxAge int64 `json:"blocked_max_age"` // Seconds
+	MaxRows       int64 `json:"max_rows"`
+	MaxDBSize     int64 `json:"max_db_size"` // Bytes
+}
+
+// Process identifies one run of a program. PIDs are reused, so the key
+// combines the PID with the start time.
 type Process struct {
-	PID  int32  `json:"pid"`
-	Name string `json:"name"`
+	Key       string    `gorm:"primaryKey" json:"key"`
+	PID       int32     `gorm:"column:pid" json:"pid"`
+	Name      string    `json:"name"`
+	ExePath   string    `json:"exe_path"`
+	SHA256    string    `json:"sha256"` // Of the executable, "" until hashed
+	Cmdline   string    `json:"cmdline"`
+	User      string    `json:"user"`
+	StartTime time.Time `json:"start_time"`
+	ParentPID int32     `gorm:"column:parent_pid" json:"parent_pid"`
+	ParentKey string    `gorm:"index" json:"parent_key"` // "" for the root of the chain
+}
+
+// ProcessKey identifies the process with a PID started at a given time
+func ProcessKey(pid int32, start time.Time) string {
+	return fmt.Sprintf("%d-%d", pid, start.UnixMilli())
 }
@@ -3,6 +3,7 @@ package dns
 import (
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/vkhangstack/Custos/internal/core"
@@ -18,17 +19,44 @@ type Server struct {
 	blocklist *core.BlocklistManager
 	server    *dns.Server
 	port      int
-	upstream  string
+
+	mu        sync.RWMutex
+	upstreams []string // Tried in order until one answers
 }
 
 // NewServer creates a new DNS server
-func NewServer(store store.Store, blocklist *core.BlocklistManager, port int) *Server {
+func NewServer(s store.Store, blocklist *core.BlocklistManager, port int) *Server {
 	return &Server{
-		store:     store,
+		store:     s,
 		blocklist: blocklist,
 		port:      port,
-		upstream:  "8.8.8.8:53",
+		upstreams: store.GetDNSUpstreams(s),
+	}
+}
+
+// SetUpstreams replaces the upstream resolvers
+func (s *Server) SetUpstreams(upstreams []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upstreams = upstreams
+}
+
+// exchange forwards a query to the first upstream that answers
+func (s *Server) exchange(r *dns.Msg) (*dns.Msg, error) {
+	s.mu.RLock()
+	upstreams := s.upstreams
+	s.mu.RUnlock()
+
+	c := new(dns.Client)
+	var lastErr error
+	for _, upstream := range upstreams {
+		resp, _, err := c.Exchange(r, upstream)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
 	}
+	return nil, lastErr
 }
 
 // Start starts the DNS server
@@ -85,8 +113,7 @@ func (s *Server) handleRequest(w dns.ResponseWriter, r *dns.Msg) {
 	}
 
 	// Forward to Upstream
-	c := new(dns.Client)
-	resp, _, err := c.Exchange(r, s.upstream)
+	resp, err := s.exchange(r)
 	if err != nil {
 		log.Printf("DNS upstream error: %v", err)
 		return
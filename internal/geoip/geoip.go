@@ -0,0 +1,155 @@
+// Package geoip maps IP addresses to countries with a range database in CSV
+// form, such as the free DB-IP "IP to Country Lite" or IP2Location LITE DB1
+// downloads. Rows are start, end, country code; further columns are ignored.
+package geoip
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vkhangstack/Custos/internal/core"
+)
+
+// Country codes for addresses without a public location
+const (
+	Local   = "LAN" // Private, loopback and link-local addresses
+	Unknown = "ZZ"  // Not in the database, or no database loaded
+)
+
+type ipRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// DB is an immutable, sorted list of IP ranges
+type DB struct {
+	ranges []ipRange
+}
+
+// Open loads a database file
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Load parses a CSV range database. IPv4 bounds may be written as dotted
+// quads or as decimal integers.
+func Load(r io.Reader) (*DB, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	db := &DB{}
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 3 || strings.HasPrefix(rec[0], "#") {
+			continue
+		}
+
+		start, err1 := parseAddr(rec[0])
+		end, err2 := parseAddr(rec[1])
+		if err1 != nil || err2 != nil || start.Is4() != end.Is4() || end.Less(start) {
+			return nil, fmt.Errorf("line %d: invalid range %q - %q", line, rec[0], rec[1])
+		}
+		country := strings.ToUpper(strings.TrimSpace(rec[2]))
+		if country == "" || country == "-" {
+			continue
+		}
+		db.ranges = append(db.ranges, ipRange{start: start, end: end, country: country})
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+	return db, nil
+}
+
+func parseAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		return netip.AddrFrom4(b), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	return addr.Unmap(), err
+}
+
+// Len returns the number of ranges in the database
+func (db *DB) Len() int {
+	if db == nil {
+		return 0
+	}
+	return len(db.ranges)
+}
+
+// Country returns the ISO country code of an IP, Local or Unknown.
+// A nil DB only tells local addresses apart.
+func (db *DB) Country(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Unknown
+	}
+	addr = addr.Unmap()
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		return Local
+	}
+	if db == nil {
+		return Unknown
+	}
+
+	// Last range starting at or before addr
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	}) - 1
+	if i < 0 {
+		return Unknown
+	}
+	r := db.ranges[i]
+	if r.start.Is4() != addr.Is4() || r.end.Less(addr) {
+		return Unknown
+	}
+	return r.country
+}
+
+// GroupByCountry sums usage by destination IP into usage by country
+func (db *DB) GroupByCountry(byIP []core.UsageStat) []core.UsageStat {
+	totals := make(map[string]*core.UsageStat)
+	var order []string
+	for _, u := range byIP {
+		country := db.Country(u.Key)
+		t, ok := totals[country]
+		if !ok {
+			t = &core.UsageStat{Key: country}
+			totals[country] = t
+			order = append(order, country)
+		}
+		t.Upload += u.Upload
+		t.Download += u.Download
+		t.Connections += u.Connections
+		t.Blocked += u.Blocked
+	}
+
+	stats := make([]core.UsageStat, 0, len(order))
+	for _, country := range order {
+		stats = append(stats, *totals[country])
+	}
+	return stats
+}
@@ -0,0 +1,72 @@
+package geoip
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vkhangstack/Custos/internal/core"
+)
+
+const testDB = `# start,end,country
+93.184.216.0,93.184.216.255,us
+"16777216","16777471","AU","Australia"
+1.0.1.0,1.0.3.255,CN
+2001:4860::,2001:4860:ffff:ffff:ffff:ffff:ffff:ffff,US
+8.8.8.0,8.8.8.255,-
+`
+
+func TestCountry(t *testing.T) {
+	db, err := Load(strings.NewReader(testDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 4 {
+		t.Errorf("Len = %d; want 4", db.Len())
+	}
+
+	tests := map[string]string{
+		"93.184.216.34":          "US",
+		"1.0.0.1":                "AU",
+		"1.0.2.200":              "CN",
+		"1.0.4.0":                Unknown,
+		"2001:4860:4860::8888":   "US",
+		"::ffff:93.184.216.34":   "US",
+		"8.8.8.8":                Unknown,
+		"0.0.0.1":                Unknown,
+		"192.168.1.10":           Local,
+		"127.0.0.1":              Local,
+		"fe80::1":                Local,
+		"not an ip":              Unknown,
+		"2a00:1450:4001:80b::ee": Unknown,
+	}
+	for ip, want := range tests {
+		if got := db.Country(ip); got != want {
+			t.Errorf("Country(%s) = %s; want %s", ip, got, want)
+		}
+	}
+
+	var none *DB
+	if none.Country("10.0.0.1") != Local || none.Country("93.184.216.34") != Unknown {
+		t.Errorf("a nil DB should only recognize local addresses")
+	}
+}
+
+func TestLoadRejectsInvalidRanges(t *testing.T) {
+	for _, row := range []string{"1.0.0.9,1.0.0.1,US", "1.0.0.1,::1,US", "x,y,US"} {
+		if _, err := Load(strings.NewReader(row)); err == nil {
+			t.Errorf("Load(%q) should fail", row)
+		}
+	}
+}
+
+func TestGroupByCountry(t *testing.T) {
+	db, _ := Load(strings.NewReader(testDB))
+	stats := db.GroupByCountry([]core.UsageStat{
+		{Key: "93.184.216.34", Upload: 10, Connections: 1},
+		{Key: "10.0.0.1", Download: 5, Connections: 2},
+		{Key: "93.184.216.35", Upload: 1, Connections: 1, Blocked: 1},
+	})
+	if len(stats) != 2 || stats[0].Key != "US" || stats[0].Upload != 11 || stats[0].Connections != 2 || stats[0].Blocked != 1 || stats[1].Key != Local {
+		t.Errorf("GroupByCountry = %+v", stats)
+	}
+}
@@ -54,14 +54,15 @@ func (c *CountingConn) tryUpdate() {
 	last := atomic.LoadInt64(&c.lastUpdate)
 
 	if now-last > updateIntervalMilli {
-		// Try to swap to prevent concurrent updates
+		// Try to swap to prevent concurrent updates. The store queues the
+		// write, so reporting inline is cheap.
 		if atomic.CompareAndSwapInt64(&c.lastUpdate, last, now) {
-			go c.report()
+			c.report()
 		}
 	}
 }
 
-// report performs the actual DB update calculating deltas
+// report queues the byte deltas and running totals with the store
 func (c *CountingConn) report() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -95,8 +96,8 @@ func (c *CountingConn) report() {
 
 // Close wraps Close to log final stats
 func (c *CountingConn) Close() error {
-	// Force a final report in a goroutine to not block the connection close
-	go c.report()
+	// Final report, queued by the store so closing isn't delayed
+	c.report()
 	fmt.Printf("[DEBUG] Closing conn %s. Last reported sent/recv: %d/%d\n", c.entry.ID, c.reportedSent, c.reportedRecv)
 	return c.Conn.Close()
 }
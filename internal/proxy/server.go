@@ -32,6 +32,12 @@ type Server struct {
 	adblockEnabled    bool
 	adblockEngine     *adblock.Engine
 	mu                sync.RWMutex
+
+	processMu    sync.Mutex
+	savedProcess map[string]string // Process.Key -> SHA256 it was saved with
+
+	transparentListeners []net.Listener
+	transparentPort      int // 0 while transparent mode is off
 }
 
 // NewServer creates a new proxy server
@@ -55,6 +61,64 @@ func NewServer(store store.Store, blocklist *core.BlocklistManager, systemTracke
 		port:              port,
 		adblockEngine:     engine,
 		protectionEnabled: true, // Default
+		savedProcess:      make(map[string]string),
+	}
+}
+
+// maxSavedProcesses bounds the set of processes known to be stored
+const maxSavedProcesses = 4096
+
+// identifyClient finds the process behind a proxy connection by its source
+// port and peer, and records it and its ancestors in the process table
+func (s *Server) identifyClient(c connRequest) *core.Process {
+	if s.systemTracker == nil || c.SrcPort == 0 {
+		return &core.Process{Name: "unknown"}
+	}
+	lineage := s.systemTracker.LineageFromPort(c.SrcPort, system.Peer{IP: c.PeerIP, Port: c.PeerPort})
+	if len(lineage) == 0 {
+		return &core.Process{Name: "unknown"}
+	}
+	s.saveProcesses(lineage)
+	return &lineage[0]
+}
+
+// hashForRules fills in the executable hash of proc when it's still being
+// computed in the background and a rule matches on it
+func (s *Server) hashForRules(proc *core.Process) {
+	if s.systemTracker == nil || proc.SHA256 != "" || proc.ExePath == "" {
+		return
+	}
+	for _, rule := range s.store.GetRules() {
+		if !rule.Enabled || rule.ProcessSHA256 == "" {
+			continue
+		}
+		sum, err := s.systemTracker.ExecutableHash(proc.ExePath)
+		if err != nil {
+			log.Printf("Failed to hash %s for rule %s: %v", proc.ExePath, rule.ID, err)
+			return
+		}
+		proc.SHA256 = sum
+		return
+	}
+}
+
+// saveProcesses stores processes not saved yet, and again once the hash of
+// their executable is known
+func (s *Server) saveProcesses(lineage []core.Process) {
+	s.processMu.Lock()
+	defer s.processMu.Unlock()
+	if len(s.savedProcess) >= maxSavedProcesses {
+		clear(s.savedProcess)
+	}
+	for _, p := range lineage {
+		if sum, ok := s.savedProcess[p.Key]; ok && sum == p.SHA256 {
+			continue
+		}
+		if err := s.store.SaveProcess(p); err != nil {
+			log.Printf("Failed to save process %d (%s): %v", p.PID, p.Name, err)
+			continue
+		}
+		s.savedProcess[p.Key] = p.SHA256
 	}
 }
 
@@ -74,16 +138,18 @@ func (s *Server) SetAdblockEnabled(enabled bool) {
 	log.Printf("Adblock engine enabled: %v", enabled)
 }
 
-func (s *Server) ReloadAdblockEngine(rules string) {
-	newEngine := adblock.NewEngine(rules)
-	log.Printf("Adblock engine parsed with %d bytes of rules", len(rules))
+// SetAdblockEngine swaps in a new adblock engine and releases the old one
+func (s *Server) SetAdblockEngine(engine *adblock.Engine) {
+	if engine == nil {
+		return
+	}
 
 	s.mu.Lock()
 	oldEngine := s.adblockEngine
-	s.adblockEngine = newEngine
+	s.adblockEngine = engine
 	s.mu.Unlock()
 
-	if oldEngine != nil {
+	if oldEngine != nil && oldEngine != engine {
 		oldEngine.Close()
 	}
 	log.Printf("Adblock engine swapped successfully")
@@ -95,13 +161,13 @@ const logIDKey = "logID"
 func (s *Server) Start() error {
 	conf := &socks5.Config{
 		Logger: log.New(log.Writer(), "[SOCKS5] ", log.LstdFlags),
-		Rules:  &LoggingRuleSet{store: s.store, blocklist: s.blocklist, server: s},
+		Rules:  &LoggingRuleSet{server: s},
 		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
 			// Extract ID early to update status on failure
 			logID, hasLogID := ctx.Value(logIDKey).(string)
 
 			// Dial upstream
-			conn, err := net.Dial(network, addr)
+			conn, err := s.dial(ctx, network, addr)
 			if err != nil {
 				if hasLogID {
 					// Update log status to error
@@ -116,18 +182,10 @@ func (s *Server) Start() error {
 			// Wrap if we have a logID
 			if hasLogID {
 				fmt.Printf("[DEBUG] Dialing for logID: %s\n", logID)
-				return &CountingConn{
-					Conn:  conn,
-					logID: logID,
-					entry: core.LogEntry{
-						ID: logID,
-					},
-					store: s.store,
-				}, nil
 			} else {
 				fmt.Printf("[DEBUG] No logID in Dial context!\n")
 			}
-			return conn, nil
+			return s.countTraffic(conn, logID), nil
 		},
 	}
 	// ...
@@ -180,109 +238,202 @@ func (s *Server) Restart(port int) error {
 	return s.Start()
 }
 
+// connRequest is an outbound connection checked against the rules, from
+// the SOCKS5 proxy or the transparent proxy
+type connRequest struct {
+	Domain  string // Requested host name, "" if only the IP is known
+	DstIP   net.IP
+	DstPort int
+	SrcIP   net.IP
+	SrcPort int
+	// Remote end of the client's socket: the proxy's port for SOCKS5, the
+	// original destination for the transparent proxy
+	PeerIP   net.IP
+	PeerPort int
+}
+
+// host is what domain rules are matched against
+func (c connRequest) host() string {
+	if c.Domain == "" && c.DstIP != nil {
+		return c.DstIP.String()
+	}
+	return c.Domain
+}
+
 // LoggingRuleSet intercepts requests for logging
 type LoggingRuleSet struct {
-	store     store.Store
-	blocklist *core.BlocklistManager
-	server    *Server
+	server *Server
 }
 
 func (r *LoggingRuleSet) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
-	domain := req.DestAddr.FQDN
+	c := connRequest{Domain: req.DestAddr.FQDN, DstIP: req.DestAddr.IP, DstPort: req.DestAddr.Port}
+	// req.RemoteAddr is *socks5.AddrSpec in this library version
+	if req.RemoteAddr != nil {
+		c.SrcIP, c.SrcPort = req.RemoteAddr.IP, req.RemoteAddr.Port
+	}
+	c.PeerPort = r.server.GetPort()
 
-	// Whitelist Localhost/Loopback
-	// Always allow local traffic to bypass protection and blocks
-	if domain == core.ProtocolLocalhost || req.DestAddr.IP.IsLoopback() {
+	logID, allowed := r.server.check(c)
+	if !allowed {
+		return ctx, false
+	}
+	if logID == "" {
 		return ctx, true
 	}
+	// Inject logID into context for Dial to pick up
+	return context.WithValue(ctx, logIDKey, logID), true
+}
 
-	// Resolve Process Name
-	procName := "unknown"
-	procID := int32(0)
-	// req.RemoteAddr is *socks5.AddrSpec in this library version
-	if r.server.systemTracker != nil && req.RemoteAddr != nil {
-		procName, procID = r.server.systemTracker.GetProcessFromPort(req.RemoteAddr.Port)
+// Decision sources of a Verdict
+const (
+	VerdictLocal     = "local"
+	VerdictAdblock   = "adblock"
+	VerdictRule      = "rule"
+	VerdictBlocklist = "blocklist"
+	VerdictDefault   = "default"
+)
+
+// Verdict is what the proxy decides for a host, and why
+type Verdict struct {
+	Host      string `json:"host"`
+	Allowed   bool   `json:"allowed"`
+	Source    string `json:"source"`              // One of the Verdict constants
+	Filter    string `json:"filter,omitempty"`    // Adblock filter or rule pattern that decided
+	List      string `json:"list,omitempty"`      // ID of the filter list Filter came from
+	Exception string `json:"exception,omitempty"` // Adblock exception that overrode a filter
+	RuleID    string `json:"rule_id,omitempty"`
+}
+
+// Explain decides on a connection to host from proc like the proxy would,
+// without counting or logging it. A nil proc is a process that couldn't be
+// identified.
+func (s *Server) Explain(host string, proc *core.Process) Verdict {
+	return s.decide(connRequest{Domain: host, DstIP: net.ParseIP(host)}, proc)
+}
+
+// local reports whether the connection stays on this machine
+func (c connRequest) local() bool {
+	return c.host() == core.ProtocolLocalhost || c.DstIP.IsLoopback()
+}
+
+// decide runs a connection from proc through the adblock engine, the custom
+// rules and the blocklist, in that order
+func (s *Server) decide(c connRequest, proc *core.Process) Verdict {
+	domain := c.host()
+	v := Verdict{Host: domain, Allowed: true, Source: VerdictDefault}
+
+	// Whitelist Localhost/Loopback
+	// Always allow local traffic to bypass protection and blocks
+	if c.local() {
+		v.Source = VerdictLocal
+		return v
 	}
 
 	// Check Adblock Engine
-	sEnabled := false
-	var engine *adblock.Engine
-
-	r.server.mu.RLock()
-	sEnabled = r.server.adblockEnabled
-	engine = r.server.adblockEngine
-	r.server.mu.RUnlock()
+	s.mu.RLock()
+	sEnabled := s.adblockEnabled
+	engine := s.adblockEngine
+	s.mu.RUnlock()
 
 	if sEnabled && engine != nil {
 		testURL := "http://" + domain
-		log.Printf("[DEBUG] Checking adblock for: %s", testURL)
-		if engine.Check(testURL, "http://"+domain, "other") {
-			r.store.IncrementAdblockHit(domain)
-			r.logBlock(req, domain, string(core.RuleSourceAdsblock), &core.Process{
-				PID:  procID,
-				Name: procName,
-			})
-			log.Printf("Blocked by adblock engine: %s", domain)
-			return ctx, false
-		} else {
-			log.Printf("[DEBUG] Not blocked by adblock: %s", domain)
+		res := engine.Match(testURL, testURL, "other")
+		if res.Matched {
+			v.Allowed, v.Source, v.Filter, v.List = false, VerdictAdblock, res.Filter, res.List
+			return v
 		}
+		v.Exception = res.Exception
 	}
 
 	// Check Custom Rules
 	// Optimized: Could cache this or use a more efficient matcher
-	rules := r.store.GetRules()
-	for _, rule := range rules {
-		if !rule.Enabled {
+	// A matching rule for the process wins over one for any process
+	var match *core.Rule
+	rules := s.store.GetRules()
+	for i, rule := range rules {
+		if !rule.Enabled || (rule.Type != core.RuleAllow && rule.Type != core.RuleBlock) {
 			continue
 		}
-		// Simple wildcard matching or substring?
-		// User said "pattern". Let's assume glob or exact.
-		// For MVP, let's support exact match or domain suffix.
-		// If rule.Pattern == domain ...
-		// Go's filepath.Match is good for globs.
-		if matched, _ := matchDomain(rule.Pattern, domain); matched {
-			r.store.IncrementRuleHit(rule.ID, domain)
-
-			if rule.Type == core.RuleAllow {
-				r.logAllow(req, domain, &core.Process{
-					PID:  procID,
-					Name: procName,
-				}, utils.GenerateIDString())
-				return ctx, true
-			}
-
-			if rule.Type == core.RuleBlock {
-				r.store.IncrementAdblockHit(domain)
-				r.logBlock(req, domain, string(core.RuleSourceAdsblock), &core.Process{
-					PID:  procID,
-					Name: procName,
-				})
-				return ctx, false
-			}
+		// Exact match or domain suffix
+		if matched, _ := matchDomain(rule.Pattern, domain); !matched {
+			continue
+		}
+		// A block rule isn't escaped by an executable that couldn't be hashed
+		matchesProcess := rule.MatchesProcess
+		if rule.Type == core.RuleBlock {
+			matchesProcess = rule.MayMatchProcess
+		}
+		if !matchesProcess(proc) {
+			continue
+		}
+		if rule.HasProcessMatcher() {
+			match = &rules[i]
+			break
 		}
+		if match == nil {
+			match = &rules[i]
+		}
+	}
+	if match != nil {
+		v.Allowed, v.Source, v.Filter, v.RuleID = match.Type == core.RuleAllow, VerdictRule, match.Pattern, match.ID
+		return v
 	}
 
 	// Check Blocklist
-	if r.blocklist.IsBlocked(domain) {
-		r.store.IncrementAdblockHit(domain)
-		r.logBlock(req, domain, string(core.RuleSourceBlocklist), &core.Process{
-			PID:  procID,
-			Name: procName,
-		})
-		return ctx, false
+	if s.blocklist.IsBlocked(domain) {
+		v.Allowed, v.Source = false, VerdictBlocklist
 	}
+	return v
+}
 
-	// Log the connection attempt
-	logID := utils.GenerateIDString()
+// check decides on a connection, then counts and logs the decision. Allowed
+// connections that were logged return the log ID their traffic is counted
+// against.
+func (s *Server) check(c connRequest) (logID string, allowed bool) {
+	if c.local() {
+		return "", true
+	}
 
-	r.logAllow(req, domain, &core.Process{
-		PID:  procID,
-		Name: procName,
-	}, logID)
+	// Identify the client process, rules may depend on it
+	proc := s.identifyClient(c)
+	s.hashForRules(proc)
+	v := s.decide(c, proc)
+	domain := v.Host
+
+	switch v.Source {
+	case VerdictAdblock:
+		s.store.IncrementAdblockHit(domain)
+		s.store.IncrementAdblockFilterHit(v.List, v.Filter)
+
+		// Record the exact filter so the log explains the block
+		reason := v.Filter
+		if reason == "" {
+			reason = string(core.RuleSourceAdsblock)
+		}
+		s.logBlock(c, reason, proc)
+		log.Printf("Blocked by adblock engine: %s (filter %q, list %q)", domain, v.Filter, v.List)
+		return "", false
+
+	case VerdictRule:
+		s.store.IncrementRuleHit(v.RuleID, domain)
+		if v.Allowed {
+			return s.logAllow(c, proc), true
+		}
+		s.store.IncrementAdblockHit(domain)
+		s.logBlock(c, string(core.RuleSourceAdsblock), proc)
+		return "", false
+
+	case VerdictBlocklist:
+		s.store.IncrementAdblockHit(domain)
+		s.logBlock(c, string(core.RuleSourceBlocklist), proc)
+		return "", false
+	}
 
-	// Inject logID into context for Dial to pick up
-	return context.WithValue(ctx, logIDKey, logID), true
+	if v.Exception != "" {
+		log.Printf("[DEBUG] Adblock exception %q applied for: %s", v.Exception, domain)
+	}
+	// Log the connection attempt
+	return s.logAllow(c, proc), true
 }
 
 // matchDomain checks if domain matches pattern
@@ -299,42 +450,38 @@ func matchDomain(pattern, domain string) (bool, error) {
 	return pattern == domain, nil
 }
 
-func (r *LoggingRuleSet) logBlock(req *socks5.Request, domain string, reason string, process *core.Process) {
+// logEntry builds the log of a checked connection
+func (c connRequest) logEntry(id, status string, process *core.Process) core.LogEntry {
 	entry := core.LogEntry{
-		ID:          utils.GenerateIDString(),
+		ID:          id,
 		Timestamp:   time.Now(),
 		Type:        core.LogSourceProxy,
-		DstIP:       req.DestAddr.IP.String(),
-		DstPort:     req.DestAddr.Port,
-		SrcIP:       req.RemoteAddr.IP.String(),
-		Domain:      domain,
+		DstPort:     c.DstPort,
+		Domain:      c.Domain,
 		Protocol:    core.ProtocolTCP,
-		Status:      core.LogStatusBlocked,
-		BytesSent:   0,
-		BytesRecv:   0,
+		Status:      status,
 		ProcessName: process.Name,
 		ProcessID:   process.PID,
-		Reason:      &reason,
+		ProcessKey:  process.Key,
+	}
+	if c.DstIP != nil {
+		entry.DstIP = c.DstIP.String()
 	}
-	r.store.AddLog(entry)
+	if c.SrcIP != nil {
+		entry.SrcIP = c.SrcIP.String()
+	}
+	return entry
 }
 
-func (r *LoggingRuleSet) logAllow(req *socks5.Request, domain string, process *core.Process, id string) {
-	entry := core.LogEntry{
-		ID:          id,
-		Timestamp:   time.Now(),
-		Type:        core.LogSourceProxy,
-		DstIP:       req.DestAddr.IP.String(),
-		DstPort:     req.DestAddr.Port,
-		SrcIP:       req.RemoteAddr.IP.String(),
-		Domain:      domain,
-		Protocol:    core.ProtocolTCP,
-		Status:      core.LogStatusAllowed,
-		BytesSent:   0,
-		BytesRecv:   0,
-		ProcessName: process.Name,
-		ProcessID:   process.PID,
-	}
+func (s *Server) logBlock(c connRequest, reason string, process *core.Process) {
+	entry := c.logEntry(utils.GenerateIDString(), core.LogStatusBlocked, process)
+	entry.Reason = &reason
+	s.store.AddLog(entry)
+}
 
-	r.store.AddLog(entry)
+// logAllow logs an allowed connection and returns the log ID
+func (s *Server) logAllow(c connRequest, process *core.Process) string {
+	entry := c.logEntry(utils.GenerateIDString(), core.LogStatusAllowed, process)
+	s.store.AddLog(entry)
+	return entry.ID
 }
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/store"
+	"github.com/vkhangstack/Custos/internal/system"
+)
+
+func TestExplain(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(list, []byte("0.0.0.0 tracker.example.net\n0.0.0.0 allowed.example.org\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	blocklist := core.NewBlocklistManager()
+	blocklist.SetSources([]string{list})
+	blocklist.Load()
+
+	st := store.NewMemoryStore()
+	st.AddRule(core.Rule{ID: "r1", Type: core.RuleAllow, Pattern: "*.example.org", Enabled: true})
+	st.AddRule(core.Rule{ID: "r2", Type: core.RuleBlock, Pattern: "off.example.com", Enabled: false})
+
+	s := NewServer(st, blocklist, nil, 0)
+	s.SetAdblockEnabled(true)
+
+	tests := []struct {
+		host    string
+		allowed bool
+		source  string
+	}{
+		{"localhost", true, VerdictLocal},
+		{"127.0.0.1", true, VerdictLocal},
+		{"ads.google.com", false, VerdictAdblock},
+		{"allowed.example.org", true, VerdictRule}, // Rules come before the blocklist
+		{"tracker.example.net", false, VerdictBlocklist},
+		{"off.example.com", true, VerdictDefault},
+	}
+	for _, tt := range tests {
+		v := s.Explain(tt.host, nil)
+		if v.Allowed != tt.allowed || v.Source != tt.source {
+			t.Errorf("Explain(%q) = %+v, want allowed %v by %s", tt.host, v, tt.allowed, tt.source)
+		}
+	}
+
+	if v := s.Explain("allowed.example.org", nil); v.RuleID != "r1" || v.Filter != "*.example.org" {
+		t.Errorf("rule verdict = %+v", v)
+	}
+	// Explaining doesn't count hits
+	for _, r := range st.GetRules() {
+		if r.HitCount != 0 {
+			t.Errorf("rule %s has %d hits", r.ID, r.HitCount)
+		}
+	}
+}
+
+func TestExplainProcessRules(t *testing.T) {
+	st := store.NewMemoryStore()
+	st.AddRule(core.Rule{ID: "any", Type: core.RuleAllow, Pattern: "*.example.com", Enabled: true})
+	st.AddRule(core.Rule{ID: "curl", Type: core.RuleBlock, Pattern: "*.example.com", Enabled: true, ProcessPath: "curl"})
+	st.AddRule(core.Rule{ID: "alice", Type: core.RuleBlock, Pattern: "api.example.com", Enabled: true,
+		ProcessPath: "/usr/bin/python3", ProcessSHA256: "ABC123", ProcessUser: "alice"})
+	st.AddRule(core.Rule{ID: "trusted", Type: core.RuleAllow, Pattern: "*.example.org", Enabled: true, ProcessSHA256: "abc123"})
+	st.AddRule(core.Rule{ID: "others", Type: core.RuleBlock, Pattern: "*.example.org", Enabled: true})
+	s := NewServer(st, core.NewBlocklistManager(), nil, 0)
+
+	curl := &core.Process{ExePath: "/usr/bin/curl", User: "bob"}
+	python := &core.Process{ExePath: "/usr/bin/python3", SHA256: "abc123", User: "alice"}
+	unhashed := &core.Process{ExePath: "/usr/bin/python3", User: "alice"}
+	tests := []struct {
+		name string
+		host string
+		proc *core.Process
+		rule string
+	}{
+		{"unknown process", "www.example.com", nil, "any"},
+		{"file name matcher wins over any process", "www.example.com", curl, "curl"},
+		{"every matcher has to match", "api.example.com", python, "alice"},
+		{"block rule matches an executable not hashed yet", "api.example.com", unhashed, "alice"},
+		{"allow rule needs the hash", "www.example.org", unhashed, "others"},
+		{"allow rule with the hash", "www.example.org", python, "trusted"},
+		{"other user", "api.example.com", &core.Process{ExePath: "/usr/bin/python3", SHA256: "abc123", User: "bob"}, "any"},
+	}
+	for _, tt := range tests {
+		if v := s.Explain(tt.host, tt.proc); v.RuleID != tt.rule {
+			t.Errorf("%s: Explain(%q) decided by rule %q, want %q", tt.name, tt.host, v.RuleID, tt.rule)
+		}
+	}
+}
+
+func TestHashForRules(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "app")
+	if err := os.WriteFile(exe, []byte("hello"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const sum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	st := store.NewMemoryStore()
+	s := NewServer(st, core.NewBlocklistManager(), system.NewTracker(), 0)
+	proc := &core.Process{ExePath: exe}
+	s.hashForRules(proc)
+	if proc.SHA256 != "" {
+		t.Errorf("executable hashed without a rule needing it")
+	}
+
+	st.AddRule(core.Rule{ID: "app", Type: core.RuleBlock, Pattern: "example.com", Enabled: true, ProcessSHA256: sum})
+	s.hashForRules(proc)
+	if proc.SHA256 != sum {
+		t.Errorf("SHA256 = %q; want %q", proc.SHA256, sum)
+	}
+	if v := s.Explain("example.com", proc); v.RuleID != "app" {
+		t.Errorf("decided by rule %q; want %q", v.RuleID, "app")
+	}
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// sniffTimeout bounds how long a transparent connection waits for the
+	// client to speak first; server-first protocols go on without a name
+	sniffTimeout = 250 * time.Millisecond
+	// sniffLimit is the most a connection is read ahead, a TLS record
+	sniffLimit = 16*1024 + 5
+)
+
+// sniffHost reads the start of a connection for the requested host name,
+// from the TLS SNI or the HTTP Host header. It returns the name, "" if
+// there is none, and the bytes read, which must be sent upstream first.
+func sniffHost(conn net.Conn) (string, []byte) {
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, sniffLimit)
+	n := 0
+	for n < len(buf) {
+		read, err := conn.Read(buf[n:])
+		n += read
+		host, done := parseHost(buf[:n])
+		if done || err != nil {
+			return host, buf[:n]
+		}
+	}
+	host, _ := parseHost(buf)
+	return host, buf
+}
+
+// parseHost finds the host name in the first bytes of a connection. done
+// is false while more data could still reveal it.
+func parseHost(data []byte) (host string, done bool) {
+	if len(data) == 0 {
+		return "", false
+	}
+	if data[0] == 0x16 {
+		return parseSNI(data)
+	}
+	return parseHTTPHost(data)
+}
+
+// parseSNI reads the server name from a TLS ClientHello
+func parseSNI(data []byte) (string, bool) {
+	if len(data) < 5 {
+		return "", false
+	}
+	recordLen := int(binary.BigEndian.Uint16(data[3:5]))
+	if len(data) < 5+recordLen {
+		return "", false
+	}
+	r := tlsReader(data[5 : 5+recordLen])
+
+	// Handshake type, length, version and random
+	if t, ok := r.byte(); !ok || t != 1 {
+		return "", true
+	}
+	if !r.skip(3 + 2 + 32) {
+		return "", true
+	}
+	// Session ID, cipher suites and compression methods
+	if !r.skipVector(1) || !r.skipVector(2) || !r.skipVector(1) {
+		return "", true
+	}
+	exts, ok := r.vector(2)
+	if !ok {
+		return "", true
+	}
+	for len(exts) > 0 {
+		typ, ok1 := exts.uint16()
+		body, ok2 := exts.vector(2)
+		if !ok1 || !ok2 {
+			break
+		}
+		if typ != 0 { // server_name
+			continue
+		}
+		names, ok := body.vector(2)
+		for ok && len(names) > 0 {
+			nameType, ok1 := names.byte()
+			name, ok2 := names.vector(2)
+			if !ok1 || !ok2 {
+				break
+			}
+			if nameType == 0 { // host_name
+				return string(name), true
+			}
+		}
+		break
+	}
+	return "", true
+}
+
+// tlsReader consumes TLS wire format fields
+type tlsReader []byte
+
+func (r *tlsReader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+func (r *tlsReader) byte() (byte, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	b := (*r)[0]
+	*r = (*r)[1:]
+	return b, true
+}
+
+func (r *tlsReader) uint16() (uint16, bool) {
+	if len(*r) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*r)
+	*r = (*r)[2:]
+	return v, true
+}
+
+// vector reads a field prefixed with its length in lenBytes bytes
+func (r *tlsReader) vector(lenBytes int) (tlsReader, bool) {
+	if len(*r) < lenBytes {
+		return nil, false
+	}
+	n := 0
+	for _, b := range (*r)[:lenBytes] {
+		n = n<<8 | int(b)
+	}
+	*r = (*r)[lenBytes:]
+	if len(*r) < n {
+		return nil, false
+	}
+	v := (*r)[:n]
+	*r = (*r)[n:]
+	return v, true
+}
+
+func (r *tlsReader) skipVector(lenBytes int) bool {
+	_, ok := r.vector(lenBytes)
+	return ok
+}
+
+// httpMethods start the request line of plain HTTP
+var httpMethods = []string{"GET ", "POST ", "PUT ", "HEAD ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+// parseHTTPHost reads the Host header of an HTTP request
+func parseHTTPHost(data []byte) (string, bool) {
+	prefix := string(data[:min(len(data), 8)])
+	isHTTP := false
+	for _, m := range httpMethods {
+		if strings.HasPrefix(m, prefix) && len(prefix) < len(m) {
+			// Too short to tell yet
+			return "", false
+		}
+		if strings.HasPrefix(prefix, m) {
+			isHTTP = true
+			break
+		}
+	}
+	if !isHTTP {
+		return "", true
+	}
+
+	headerEnd := bytes.Index(data, []byte("\r\n\r\n"))
+	headers := data
+	if headerEnd >= 0 {
+		headers = data[:headerEnd]
+	}
+	lines := strings.Split(string(headers), "\r\n")
+	if headerEnd < 0 {
+		// The last line may be cut short
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		if i == 0 {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(name, "Host") {
+			continue
+		}
+		host := strings.TrimSpace(value)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return host, true
+	}
+	return "", headerEnd >= 0
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// clientHello returns the first bytes a TLS client sends for serverName
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		client.Close()
+	}()
+
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatal(err)
+	}
+	return append(header, body...)
+}
+
+func TestParseSNI(t *testing.T) {
+	hello := clientHello(t, "ads.example.com")
+
+	if host, done := parseHost(hello); host != "ads.example.com" || !done {
+		t.Errorf("parseHost = %q, %v; want ads.example.com, true", host, done)
+	}
+	// A ClientHello split over several reads needs the rest
+	if _, done := parseHost(hello[:len(hello)/2]); done {
+		t.Error("parseHost gave up on a partial ClientHello")
+	}
+	// Connections by IP have no server name
+	if host, done := parseHost(clientHello(t, "")); host != "" || !done {
+		t.Errorf("parseHost without SNI = %q, %v; want \"\", true", host, done)
+	}
+}
+
+func TestParseHTTPHost(t *testing.T) {
+	tests := []struct {
+		data string
+		host string
+		done bool
+	}{
+		{"GET / HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\n\r\n", "example.com", true},
+		{"POST /x HTTP/1.1\r\nhost: example.com:8080\r\n\r\n", "example.com", true},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\n", "example.com", true},
+		// The Host line may still be arriving
+		{"GET / HTTP/1.1\r\nHost: exa", "", false},
+		{"GE", "", false},
+		{"GET / HTTP/1.0\r\n\r\n", "", true},
+		{"SSH-2.0-OpenSSH_9.6\r\n", "", true},
+	}
+	for _, tt := range tests {
+		host, done := parseHost([]byte(tt.data))
+		if host != tt.host || done != tt.done {
+			t.Errorf("parseHost(%q) = %q, %v; want %q, %v", tt.data, host, done, tt.host, tt.done)
+		}
+	}
+}
+
+func TestSniffHostReplaysPrefix(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	request := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	go func() {
+		client.Write([]byte(request))
+	}()
+
+	host, peeked := sniffHost(server)
+	if host != "example.com" || string(peeked) != request {
+		t.Errorf("sniffHost = %q, %q; want example.com and the request", host, peeked)
+	}
+
+	// Server-first protocols go on after the timeout with nothing read
+	quiet, other := net.Pipe()
+	defer quiet.Close()
+	defer other.Close()
+	if host, peeked := sniffHost(quiet); host != "" || len(peeked) != 0 {
+		t.Errorf("sniffHost on a silent client = %q, %q", host, peeked)
+	}
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/system"
+)
+
+// StartTransparent accepts connections the firewall redirected to port and
+// relays them to their original destination through the same checks as the
+// SOCKS5 proxy. From then on upstream connections are marked with
+// system.RedirectMark so they aren't redirected again; start it before
+// installing the redirect rules.
+func (s *Server) StartTransparent(port int) error {
+	if !transparentSupported {
+		return system.ErrRedirectUnsupported
+	}
+	s.StopTransparent()
+
+	// Locally generated connections are redirected to the loopback address
+	var listeners []net.Listener
+	for _, addr := range []string{"127.0.0.1", "::1"} {
+		l, err := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(port)))
+		if err != nil {
+			if addr == "::1" {
+				log.Printf("Transparent proxy isn't listening on IPv6: %v", err)
+				continue
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	s.mu.Lock()
+	s.transparentListeners = listeners
+	s.transparentPort = port
+	s.mu.Unlock()
+
+	for _, l := range listeners {
+		go s.serveTransparent(l)
+	}
+	log.Printf("Transparent proxy started on port %d", port)
+	return nil
+}
+
+// StopTransparent closes the transparent listeners, remove the redirect
+// rules first
+func (s *Server) StopTransparent() {
+	s.mu.Lock()
+	listeners := s.transparentListeners
+	s.transparentListeners = nil
+	s.transparentPort = 0
+	s.mu.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// TransparentPort returns the port of the transparent proxy, 0 if it's off
+func (s *Server) TransparentPort() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.transparentPort
+}
+
+func (s *Server) serveTransparent(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Transparent proxy error: %v", err)
+			}
+			return
+		}
+		go s.handleTransparent(conn.(*net.TCPConn))
+	}
+}
+
+func (s *Server) handleTransparent(conn *net.TCPConn) {
+	defer conn.Close()
+
+	dst, err := originalDst(conn)
+	if err != nil {
+		log.Printf("Transparent proxy: no original destination: %v", err)
+		return
+	}
+	host, peeked := sniffHost(conn)
+
+	c := connRequest{Domain: host, DstIP: dst.IP, DstPort: dst.Port, PeerIP: dst.IP, PeerPort: dst.Port}
+	if src, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		c.SrcIP, c.SrcPort = src.IP, src.Port
+	}
+	logID, allowed := s.check(c)
+	if !allowed {
+		return
+	}
+
+	upstream, err := s.dial(context.Background(), "tcp", dst.String())
+	if err != nil {
+		if logID != "" {
+			s.store.UpdateLog(core.LogEntry{ID: logID, Status: "connection_failed"})
+		}
+		return
+	}
+	upstream = s.countTraffic(upstream, logID)
+	defer upstream.Close()
+
+	if len(peeked) > 0 {
+		if _, err := upstream.Write(peeked); err != nil {
+			return
+		}
+	}
+	relay(conn, upstream)
+}
+
+// dial connects upstream. While transparent mode is on the connection is
+// marked so the redirect rules let it out.
+func (s *Server) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	if s.TransparentPort() != 0 {
+		d.Control = markSocket
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// countTraffic counts the bytes of conn against a log entry, if any
+func (s *Server) countTraffic(conn net.Conn, logID string) net.Conn {
+	if logID == "" {
+		return conn
+	}
+	return &CountingConn{
+		Conn:  conn,
+		logID: logID,
+		entry: core.LogEntry{ID: logID},
+		store: s.store,
+	}
+}
+
+// relay copies both ways until both sides are done
+func relay(client, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		closeWrite(dst)
+		done <- struct{}{}
+	}
+	go pipe(upstream, client)
+	go pipe(client, upstream)
+	<-done
+	<-done
+}
+
+// closeWrite passes on the end of one direction and keeps the other open
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(*CountingConn); ok {
+		conn = c.Conn
+	}
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	}
+}
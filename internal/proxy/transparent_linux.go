@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/vkhangstack/Custos/internal/system"
+
+	"golang.org/x/sys/unix"
+)
+
+const transparentSupported = true
+
+// originalDst returns where a redirected connection was headed, as kept
+// by conntrack. The IPv6 option has the same number as the IPv4 one.
+func originalDst(conn *net.TCPConn) (*net.TCPAddr, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	local, _ := conn.LocalAddr().(*net.TCPAddr)
+
+	var addr *net.TCPAddr
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if local != nil && local.IP.To4() != nil {
+			// The sockaddr_in fits the 16 bytes of an IPv6Mreq
+			mreq, err := unix.GetsockoptIPv6Mreq(int(fd), unix.IPPROTO_IP, unix.SO_ORIGINAL_DST)
+			if err != nil {
+				sockErr = err
+				return
+			}
+			b := mreq.Multiaddr
+			addr = &net.TCPAddr{IP: net.IPv4(b[4], b[5], b[6], b[7]), Port: int(b[2])<<8 | int(b[3])}
+			return
+		}
+		// And the sockaddr_in6 fits an IPv6MTUInfo
+		info, err := unix.GetsockoptIPv6MTUInfo(int(fd), unix.IPPROTO_IPV6, unix.SO_ORIGINAL_DST)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, info.Addr.Addr[:])
+		addr = &net.TCPAddr{IP: ip, Port: int(port[0])<<8 | int(port[1])}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return addr, sockErr
+}
+
+// markSocket sets system.RedirectMark on an outbound socket so the
+// redirect rules let it through
+func markSocket(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, system.RedirectMark)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/vkhangstack/Custos/internal/system"
+)
+
+const transparentSupported = false
+
+func originalDst(conn *net.TCPConn) (*net.TCPAddr, error) {
+	return nil, system.ErrRedirectUnsupported
+}
+
+func markSocket(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,115 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/store"
+)
+
+// Export formats for logs
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// exportPageSize is how many logs are read from the store at a time
+const exportPageSize = 1000
+
+// EachLog calls fn for every log matching q, newest first, reading the store a page at a time
+func EachLog(s store.Store, q store.LogQuery, fn func(core.LogEntry) error) error {
+	cursor := ""
+	for {
+		logs, err := s.ScanLogs(cursor, exportPageSize, q)
+		if err != nil {
+			return err
+		}
+		for _, l := range logs {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+		if len(logs) < exportPageSize {
+			return nil
+		}
+		cursor = logs[len(logs)-1].ID
+	}
+}
+
+// ExportLogs writes the logs matching q in the given format and returns how many were written
+func ExportLogs(w io.Writer, s store.Store, q store.LogQuery, format string) (int64, error) {
+	switch format {
+	case FormatCSV:
+		return ExportCSV(w, s, q)
+	case FormatJSONL:
+		return ExportJSONL(w, s, q)
+	default:
+		return 0, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+var csvHeader = []string{
+	"id", "timestamp", "type", "status", "domain", "src_ip", "dst_ip", "dst_port",
+	"protocol", "process_name", "process_id", "bytes_sent", "bytes_recv", "latency_ms", "reason",
+}
+
+// ExportCSV writes the logs matching q as CSV with a header row
+func ExportCSV(w io.Writer, s store.Store, q store.LogQuery) (int64, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	err := EachLog(s, q, func(l core.LogEntry) error {
+		reason := ""
+		if l.Reason != nil {
+			reason = *l.Reason
+		}
+		if err := cw.Write([]string{
+			l.ID,
+			l.Timestamp.Format(time.RFC3339Nano),
+			l.Type,
+			l.Status,
+			l.Domain,
+			l.SrcIP,
+			l.DstIP,
+			strconv.Itoa(l.DstPort),
+			l.Protocol,
+			l.ProcessName,
+			strconv.FormatInt(int64(l.ProcessID), 10),
+			strconv.FormatInt(l.BytesSent, 10),
+			strconv.FormatInt(l.BytesRecv, 10),
+			strconv.FormatInt(l.Latency, 10),
+			reason,
+		}); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// ExportJSONL writes the logs matching q as one JSON object per line
+func ExportJSONL(w io.Writer, s store.Store, q store.LogQuery) (int64, error) {
+	enc := json.NewEncoder(w)
+	var n int64
+	err := EachLog(s, q, func(l core.LogEntry) error {
+		if err := enc.Encode(l); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,181 @@
+package report
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/store"
+)
+
+// Report formats
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "md"
+)
+
+// DefaultTopN is how many domains and processes a report lists
+const DefaultTopN = 10
+
+// Report summarizes traffic over a date range
+type Report struct {
+	From         time.Time               `json:"from"`
+	To           time.Time               `json:"to"`
+	GeneratedAt  time.Time               `json:"generated_at"`
+	Totals       core.UsageStat          `json:"totals"`
+	TopDomains   []core.UsageStat        `json:"top_domains"`   // By bytes
+	TopProcesses []core.UsageStat        `json:"top_processes"` // By bytes
+	TopBlocked   []core.UsageStat        `json:"top_blocked"`   // Domains by blocked connections
+	Daily        []core.TrafficDataPoint `json:"daily"`
+}
+
+// Build summarizes the traffic in [from, to)
+func Build(s store.Store, from, to time.Time, topN int) (*Report, error) {
+	if topN <= 0 {
+		topN = DefaultTopN
+	}
+	r := &Report{From: from, To: to, GeneratedAt: time.Now()}
+
+	usage := func(by, metric string, limit int) ([]core.UsageStat, error) {
+		return s.GetTopUsage(core.UsageQuery{From: from, To: to, By: by, Metric: metric, Limit: limit})
+	}
+
+	domains, err := usage(core.UsageByDomain, core.UsageMetricBytes, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range domains {
+		r.Totals.Upload += u.Upload
+		r.Totals.Download += u.Download
+		r.Totals.Connections += u.Connections
+		r.Totals.Blocked += u.Blocked
+	}
+	r.TopDomains = domains[:min(topN, len(domains))]
+
+	if r.TopProcesses, err = usage(core.UsageByProcess, core.UsageMetricBytes, topN); err != nil {
+		return nil, err
+	}
+	if r.TopBlocked, err = usage(core.UsageByDomain, core.UsageMetricBlocked, topN); err != nil {
+		return nil, err
+	}
+
+	r.Daily = s.GetTrafficRange(core.TrafficQuery{From: from, To: to, Bucket: 24 * time.Hour})
+	return r, nil
+}
+
+// Write renders the report as HTML or Markdown
+func Write(w io.Writer, r *Report, format string) error {
+	switch format {
+	case FormatHTML:
+		return htmlReport.Execute(w, r)
+	case FormatMarkdown:
+		return markdownReport.Execute(w, r)
+	default:
+		return fmt.Errorf("unsupported report format %q", format)
+	}
+}
+
+// FormatBytes formats a byte count with binary units, e.g. "1.5 MB"
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// escapeMarkdown keeps values from breaking out of a table cell
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "*", `\*`, "_", `\_`, "`", "\\`", "\n", " ").Replace(s)
+}
+
+var templateFuncs = map[string]interface{}{
+	"bytes": FormatBytes,
+	"date":  func(t time.Time) string { return t.Format("2006-01-02") },
+	"time":  func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+	"md":    escapeMarkdown,
+}
+
+var markdownReport = template.Must(template.New("report.md").Funcs(templateFuncs).Parse(`# Custos traffic report
+
+{{time .From}} to {{time .To}}, generated {{time .GeneratedAt}}
+
+| Connections | Blocked | Upload | Download |
+|---:|---:|---:|---:|
+| {{.Totals.Connections}} | {{.Totals.Blocked}} | {{bytes .Totals.Upload}} | {{bytes .Totals.Download}} |
+{{define "usage"}}
+| Name | Connections | Blocked | Upload | Download |
+|---|---:|---:|---:|---:|
+{{range .}}| {{md .Key}} | {{.Connections}} | {{.Blocked}} | {{bytes .Upload}} | {{bytes .Download}} |
+{{end}}{{end}}
+## Top domains
+{{if .TopDomains}}{{template "usage" .TopDomains}}{{else}}
+No traffic.
+{{end}}
+## Top processes
+{{if .TopProcesses}}{{template "usage" .TopProcesses}}{{else}}
+No traffic.
+{{end}}
+## Most blocked domains
+{{if .TopBlocked}}{{template "usage" .TopBlocked}}{{else}}
+Nothing was blocked.
+{{end}}
+## Bandwidth per day
+{{if .Daily}}
+| Day | Connections | Blocked | Upload | Download |
+|---|---:|---:|---:|---:|
+{{range .Daily}}| {{date .Timestamp}} | {{.Connections}} | {{.Blocked}} | {{bytes .Upload}} | {{bytes .Download}} |
+{{end}}{{else}}
+No traffic.
+{{end}}`))
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("report.html").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Custos traffic report</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem auto; max-width: 60rem; color: #1f2937; }
+h1 { margin-bottom: 0; }
+.range { color: #6b7280; }
+table { border-collapse: collapse; width: 100%; margin: 1rem 0 2rem; }
+th, td { padding: .4rem .6rem; border-bottom: 1px solid #e5e7eb; text-align: right; }
+th:first-child, td:first-child { text-align: left; word-break: break-all; }
+th { background: #f3f4f6; }
+</style>
+</head>
+<body>
+<h1>Custos traffic report</h1>
+<p class="range">{{time .From}} to {{time .To}}, generated {{time .GeneratedAt}}</p>
+<table>
+<tr><th>Connections</th><th>Blocked</th><th>Upload</th><th>Download</th></tr>
+<tr><td>{{.Totals.Connections}}</td><td>{{.Totals.Blocked}}</td><td>{{bytes .Totals.Upload}}</td><td>{{bytes .Totals.Download}}</td></tr>
+</table>
+{{define "usage"}}<table>
+<tr><th>Name</th><th>Connections</th><th>Blocked</th><th>Upload</th><th>Download</th></tr>
+{{range .}}<tr><td>{{.Key}}</td><td>{{.Connections}}</td><td>{{.Blocked}}</td><td>{{bytes .Upload}}</td><td>{{bytes .Download}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Top domains</h2>
+{{if .TopDomains}}{{template "usage" .TopDomains}}{{else}}<p>No traffic.</p>{{end}}
+<h2>Top processes</h2>
+{{if .TopProcesses}}{{template "usage" .TopProcesses}}{{else}}<p>No traffic.</p>{{end}}
+<h2>Most blocked domains</h2>
+{{if .TopBlocked}}{{template "usage" .TopBlocked}}{{else}}<p>Nothing was blocked.</p>{{end}}
+<h2>Bandwidth per day</h2>
+{{if .Daily}}<table>
+<tr><th>Day</th><th>Connections</th><th>Blocked</th><th>Upload</th><th>Download</th></tr>
+{{range .Daily}}<tr><td>{{date .Timestamp}}</td><td>{{.Connections}}</td><td>{{.Blocked}}</td><td>{{bytes .Upload}}</td><td>{{bytes .Download}}</td></tr>
+{{end}}</table>{{else}}<p>No traffic.</p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,179 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/store"
+	"github.com/vkhangstack/Custos/internal/utils"
+)
+
+func testStore(t *testing.T) (store.Store, time.Time) {
+	t.Helper()
+	s := store.NewMemoryStore()
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	reason := "matched rule ads.*"
+	entries := []core.LogEntry{
+		{Domain: "www.example.com", ProcessName: "firefox", Status: core.LogStatusAllowed, BytesSent: 100, BytesRecv: 5000},
+		{Domain: "ads.example.com", ProcessName: "firefox", Status: core.LogStatusBlocked, Reason: &reason},
+		{Domain: "ads.example.com", ProcessName: "chrome", Status: core.LogStatusBlocked},
+		{DstIP: "10.0.0.1", DstPort: 22, ProcessName: "ssh", Status: core.LogStatusAllowed, BytesSent: 2000, BytesRecv: 2000},
+		{Domain: `evil|"domain",com`, ProcessName: "curl", Status: core.LogStatusAllowed, BytesSent: 1},
+	}
+	for i, e := range entries {
+		e.ID = utils.GenerateIDString()
+		e.Type = core.LogSourceProxy
+		e.Timestamp = start.Add(time.Duration(i) * time.Minute)
+		s.AddLog(e)
+	}
+	return s, start
+}
+
+func TestExportCSV(t *testing.T) {
+	s, _ := testStore(t)
+
+	var buf bytes.Buffer
+	n, err := ExportLogs(&buf, s, store.LogQuery{Statuses: []string{core.LogStatusBlocked}}, FormatCSV)
+	if err != nil || n != 2 {
+		t.Fatalf("ExportLogs = %d, %v; want 2 logs", n, err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(csvHeader, ",") {
+		t.Fatalf("rows = %v; want a header and 2 logs", rows)
+	}
+	if rows[1][4] != "ads.example.com" || rows[1][9] != "chrome" || rows[2][14] != "matched rule ads.*" {
+		t.Errorf("rows = %v; want the blocked logs newest first", rows[1:])
+	}
+
+	// Values with separators and quotes survive a round trip
+	buf.Reset()
+	ExportCSV(&buf, s, store.LogQuery{Processes: []string{"curl"}})
+	rows, _ = csv.NewReader(&buf).ReadAll()
+	if len(rows) != 2 || rows[1][4] != `evil|"domain",com` {
+		t.Errorf("rows = %v; want the domain unchanged", rows)
+	}
+}
+
+func TestExportJSONL(t *testing.T) {
+	s, _ := testStore(t)
+
+	var buf bytes.Buffer
+	n, err := ExportLogs(&buf, s, store.LogQuery{}, FormatJSONL)
+	if err != nil || n != 5 {
+		t.Fatalf("ExportLogs = %d, %v; want 5 logs", n, err)
+	}
+	var lines int
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var l core.LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatalf("line %d: %v", lines+1, err)
+		}
+		lines++
+	}
+	if lines != 5 {
+		t.Errorf("got %d lines; want 5", lines)
+	}
+
+	if _, err := ExportLogs(&buf, s, store.LogQuery{}, "xml"); err == nil {
+		t.Errorf("unknown formats should be rejected")
+	}
+}
+
+func TestEachLogPages(t *testing.T) {
+	s := store.NewMemoryStore()
+	for i := 0; i < exportPageSize+5; i++ {
+		s.AddLog(core.LogEntry{ID: utils.GenerateIDString(), Timestamp: time.Now(), Domain: "example.com"})
+	}
+	var n int
+	seen := make(map[string]bool)
+	EachLog(s, store.LogQuery{}, func(l core.LogEntry) error {
+		n++
+		seen[l.ID] = true
+		return nil
+	})
+	if n != exportPageSize+5 || len(seen) != n {
+		t.Errorf("visited %d logs (%d unique); want %d", n, len(seen), exportPageSize+5)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	s, start := testStore(t)
+
+	r, err := Build(s, start, start.Add(time.Hour), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Totals.Connections != 5 || r.Totals.Blocked != 2 || r.Totals.Upload != 2101 || r.Totals.Download != 7000 {
+		t.Errorf("totals = %+v", r.Totals)
+	}
+	if len(r.TopDomains) != 2 || r.TopDomains[0].Key != "www.example.com" || r.TopDomains[1].Key != "10.0.0.1" {
+		t.Errorf("top domains = %+v; want www.example.com then 10.0.0.1", r.TopDomains)
+	}
+	if len(r.TopProcesses) != 2 || r.TopProcesses[0].Key != "firefox" || r.TopProcesses[0].Connections != 2 {
+		t.Errorf("top processes = %+v; want firefox first", r.TopProcesses)
+	}
+	if len(r.TopBlocked) != 1 || r.TopBlocked[0].Key != "ads.example.com" || r.TopBlocked[0].Blocked != 2 {
+		t.Errorf("top blocked = %+v; want only ads.example.com", r.TopBlocked)
+	}
+	var daily core.TrafficDataPoint
+	for _, d := range r.Daily {
+		daily.Connections += d.Connections
+		daily.Download += d.Download
+	}
+	if daily.Connections != 5 || daily.Download != 7000 {
+		t.Errorf("daily = %+v; want all traffic", r.Daily)
+	}
+
+	// Logs outside the range are left out
+	r, _ = Build(s, start.Add(2*time.Minute), start.Add(4*time.Minute), 0)
+	if r.Totals.Connections != 2 {
+		t.Errorf("connections in range = %d; want 2", r.Totals.Connections)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	s, start := testStore(t)
+	r, _ := Build(s, start, start.Add(time.Hour), 0)
+
+	var html bytes.Buffer
+	if err := Write(&html, r, FormatHTML); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), "<td>www.example.com</td>") || !strings.Contains(html.String(), "4.9 KB") {
+		t.Errorf("HTML report is missing the top domain:\n%s", html.String())
+	}
+	if strings.Contains(html.String(), `evil|"domain"`) {
+		t.Errorf("HTML report should escape values")
+	}
+
+	var md bytes.Buffer
+	if err := Write(&md, r, FormatMarkdown); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(md.String(), "| ads.example.com | 2 | 2 | 0 B | 0 B |") || !strings.Contains(md.String(), `evil\|"domain",com`) {
+		t.Errorf("Markdown report is missing rows:\n%s", md.String())
+	}
+
+	if err := Write(&md, r, "pdf"); err == nil {
+		t.Errorf("unknown formats should be rejected")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{0: "0 B", 1023: "1023 B", 1024: "1.0 KB", 1536: "1.5 KB", 5 << 30: "5.0 GB"}
+	for n, want := range tests {
+		if got := FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%d) = %q; want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package store
+
+import "github.com/vkhangstack/Custos/internal/core"
+
+// mergeLogEntry copies the non-zero fields of a partial update into dst,
+// matching how GORM's Updates treats a struct
+func mergeLogEntry(dst *core.LogEntry, src core.LogEntry) {
+	if !src.Timestamp.IsZero() {
+		dst.Timestamp = src.Timestamp
+	}
+	if src.Type != "" {
+		dst.Type = src.Type
+	}
+	if src.Domain != "" {
+		dst.Domain = src.Domain
+	}
+	if src.SrcIP != "" {
+		dst.SrcIP = src.SrcIP
+	}
+	if src.DstIP != "" {
+		dst.DstIP = src.DstIP
+	}
+	if src.DstPort != 0 {
+		dst.DstPort = src.DstPort
+	}
+	if src.Protocol != "" {
+		dst.Protocol = src.Protocol
+	}
+	if src.ProcessName != "" {
+		dst.ProcessName = src.ProcessName
+	}
+	if src.ProcessID != 0 {
+		dst.ProcessID = src.ProcessID
+	}
+	if src.ProcessKey != "" {
+		dst.ProcessKey = src.ProcessKey
+	}
+	if src.BytesSent != 0 {
+		dst.BytesSent = src.BytesSent
+	}
+	if src.BytesRecv != 0 {
+		dst.BytesRecv = src.BytesRecv
+	}
+	if src.Status != "" {
+		dst.Status = src.Status
+	}
+	if src.Latency != 0 {
+		dst.Latency = src.Latency
+	}
+	if src.Reason != nil {
+		dst.Reason = src.Reason
+	}
+}
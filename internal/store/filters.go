@@ -0,0 +1,71 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+
+	"github.com/vkhangstack/Custos/internal/core"
+)
+
+// seededFiltersKey remembers the default list URLs already offered, so a
+// list the user deleted isn't added back on the next start
+const seededFiltersKey = "adblock_filters_seeded"
+
+// DefaultAdblockFilters are the lists a new installation subscribes to
+var DefaultAdblockFilters = []core.AdblockFilter{
+	{Name: "AdGuard DNS", URL: "https://justdomains.github.io/blocklists/lists/adguarddns-justdomains.txt"},
+	{Name: "Easy List", URL: "https://justdomains.github.io/blocklists/lists/easylist-justdomains.txt"},
+	{Name: "Easy Privacy", URL: "https://justdomains.github.io/blocklists/lists/easyprivacy-justdomains.txt"},
+	{Name: "NoCoin", URL: "https://justdomains.github.io/blocklists/lists/nocoin-justdomains.txt"},
+	{Name: "Pi-hole", URL: "https://raw.githubusercontent.com/xxcriticxx/.pl-host-file/master/hosts.txt"},
+	{Name: "Ramnit", URL: "https://1275.ru/DGA/ramnit.txt"},
+	{Name: "SharkBot", URL: "https://1275.ru/DGA/sharkbot.txt"},
+	{Name: "QSnatch", URL: "https://1275.ru/DGA/qsnatch.txt"},
+	{Name: "CryptoLocker", URL: "https://1275.ru/DGA/cryptolocker.txt"},
+	{Name: "1024 Hosts", URL: "https://raw.githubusercontent.com/Goooler/1024_hosts/master/hosts"},
+}
+
+// DefaultFilterID derives a stable ID from a default list's URL, so its
+// cache file and statistics stay attached to it across installs
+func DefaultFilterID(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return "default-" + hex.EncodeToString(sum[:8])
+}
+
+// SeedAdblockFilters subscribes to the default lists that were never
+// offered before and returns how many were added. Existing lists keep
+// their IDs, settings, backoff state and hits.
+func SeedAdblockFilters(s Store, defaults []core.AdblockFilter) int {
+	var offered []string
+	if val, err := s.GetSetting(seededFiltersKey); err == nil && val != "" {
+		json.Unmarshal([]byte(val), &offered)
+	}
+	skip := make(map[string]bool)
+	for _, u := range offered {
+		skip[u] = true
+	}
+	for _, f := range s.GetAdblockFilters() {
+		skip[f.URL] = true
+	}
+
+	added := 0
+	for _, d := range defaults {
+		if !slices.Contains(offered, d.URL) {
+			offered = append(offered, d.URL)
+		}
+		if skip[d.URL] {
+			continue
+		}
+		filter := core.AdblockFilter{ID: DefaultFilterID(d.URL), Name: d.Name, URL: d.URL, Enabled: true}
+		if err := s.AddAdblockFilter(filter); err == nil {
+			added++
+		}
+	}
+
+	if data, err := json.Marshal(offered); err == nil {
+		s.SetSetting(seededFiltersKey, string(data))
+	}
+	return added
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"time"
 
 	"github.com/vkhangstack/Custos/internal/core"
@@ -12,10 +13,15 @@ type Store interface {
 	UpdateLog(entry core.LogEntry)
 	AddTraffic(upload, download int64)
 	GetTrafficHistory(duration time.Duration) []core.TrafficDataPoint
+	GetTrafficRange(q core.TrafficQuery) []core.TrafficDataPoint
+	GetTopUsage(q core.UsageQuery) ([]core.UsageStat, error)
 	GetRecentLogs(limit int) []core.LogEntry
 	GetLogsPaginated(cursor string, limit int, search, status, logType string) ([]core.LogEntry, string, bool, int64, error)
+	SearchLogs(cursor string, limit int, q LogQuery) (core.PaginatedLogs, error)
+	// ScanLogs is SearchLogs without the total, for reading every match
+	ScanLogs(cursor string, limit int, q LogQuery) ([]core.LogEntry, error)
 	GetStats() core.Stats
-	Subscribe(callback func(core.LogEntry))
+	SubscribeLogs(ctx context.Context, opts SubscribeOptions) *Subscription
 	ResetData()
 	// Rule Management
 	AddRule(rule core.Rule) error
@@ -25,12 +31,16 @@ type Store interface {
 	UpdateRule(rule core.Rule) error
 	IncrementRuleHit(id string, domain string) error
 	IncrementAdblockHit(domain string) error
+	IncrementAdblockFilterHit(listID, filter string) error
 	// Adblock Filters
 	AddAdblockFilter(filter core.AdblockFilter) error
 	GetAdblockFilters() []core.AdblockFilter
 	DeleteAdblockFilter(id string) error
 	UpdateAdblockFilter(filter core.AdblockFilter) error
 	ClearAdblockFilters() error
+	// Processes
+	SaveProcess(p core.Process) error
+	GetProcess(key string) (core.Process, error)
 	// Settings
 	GetSetting(key string) (string, error)
 	SetSetting(key, value string) error
@@ -1,7 +1,10 @@
 package store
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,23 +12,38 @@ import (
 	"github.com/vkhangstack/Custos/internal/utils"
 )
 
-// MemoryStore holds logs and stats in memory
+// ErrNotFound is returned by MemoryStore lookups of missing records
+var ErrNotFound = fmt.Errorf("record not found")
+
+// MemoryStore keeps everything in memory. It is used when the SQLite
+// database can't be opened, so it honours the full Store contract; only
+// the log history is bounded.
 type MemoryStore struct {
-	mu          sync.RWMutex
-	logs        []core.LogEntry
-	maxLogs     int
-	stats       core.Stats
-	subscribers []func(core.LogEntry)
+	mu        sync.RWMutex
+	logs      []core.LogEntry // Oldest first
+	maxLogs   int
+	stats     core.Stats
+	logBroker broker
+
+	rules       []core.Rule
+	cachedRules []core.Rule // Snapshot handed out by GetRules, rebuilt on change
+	hitCache    sync.Map    // Map of [ruleID:domain]time.Time for de-duplication
+
+	settings    map[string]string
+	filters     []core.AdblockFilter
+	filterHits  map[string]*core.AdblockFilterHit
+	adblockHits int64
+	processes   map[string]core.Process
 }
 
 // NewMemoryStore creates a new store
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
-		logs:    make([]core.LogEntry, 0, 1000),
-		maxLogs: 1000,
-		stats: core.Stats{
-			TopDomains: make(map[string]int64),
-		},
+		logs:       make([]core.LogEntry, 0, 1000),
+		maxLogs:    10000,
+		settings:   make(map[string]string),
+		filterHits: make(map[string]*core.AdblockFilterHit),
+		processes:  make(map[string]core.Process),
 	}
 }
 
@@ -39,84 +57,56 @@ func (s *MemoryStore) AddLog(entry core.LogEntry) {
 		entry.ID = utils.GenerateIDString()
 	}
 
-	// Add to logs (circular buffer logic simplified)
+	// Drop the oldest entry once full
 	if len(s.logs) >= s.maxLogs {
-		// Remove oldest
-		s.logs = s.logs[1:]
+		copy(s.logs, s.logs[1:])
+		s.logs = s.logs[:len(s.logs)-1]
 	}
 	s.logs = append(s.logs, entry)
 
 	// Update stats
 	s.stats.TotalUpload += entry.BytesSent
 	s.stats.TotalDownload += entry.BytesRecv
-	if entry.Domain != "" {
-		s.stats.TopDomains[entry.Domain] += (entry.BytesSent + entry.BytesRecv)
-	}
 
-	// Notify subscribers (simple broadcast)
-	for _, sub := range s.subscribers {
-		// Run in goroutine to avoid blocking
-		go sub(entry)
-	}
+	s.notify(entry)
 }
 
-// UpdateLog updates an existing log entry (naive implementation: linear search)
-// For MemoryStore, this might be slow, but it's for dev/fallback.
+// UpdateLog merges the non-zero fields of entry into the stored log
 func (s *MemoryStore) UpdateLog(entry core.LogEntry) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for i, log := range s.logs {
-		if log.ID == entry.ID {
-			// Merge fields from entry into s.logs[i]
-			if !entry.Timestamp.IsZero() {
-				s.logs[i].Timestamp = entry.Timestamp
-			}
-			if entry.Type != "" {
-				s.logs[i].Type = entry.Type
-			}
-			if entry.Domain != "" {
-				s.logs[i].Domain = entry.Domain
-			}
-			if entry.SrcIP != "" {
-				s.logs[i].SrcIP = entry.SrcIP
-			}
-			if entry.DstIP != "" {
-				s.logs[i].DstIP = entry.DstIP
-			}
-			if entry.DstPort != 0 {
-				s.logs[i].DstPort = entry.DstPort
-			}
-			if entry.Protocol != "" {
-				s.logs[i].Protocol = entry.Protocol
-			}
-			if entry.ProcessName != "" {
-				s.logs[i].ProcessName = entry.ProcessName
-			}
-			if entry.ProcessID != 0 {
-				s.logs[i].ProcessID = entry.ProcessID
-			}
-			if entry.BytesSent != 0 {
-				s.logs[i].BytesSent = entry.BytesSent
-			}
-			if entry.BytesRecv != 0 {
-				s.logs[i].BytesRecv = entry.BytesRecv
-			}
-			if entry.Status != "" {
-				s.logs[i].Status = entry.Status
-			}
-			if entry.Latency != 0 {
-				s.logs[i].Latency = entry.Latency
-			}
-
-			// Notify subscribers of update with full entry
-			updatedEntry := s.logs[i]
-			for _, sub := range s.subscribers {
-				go sub(updatedEntry)
-			}
-			return
+	i := s.findLog(entry.ID)
+	if i < 0 {
+		return
+	}
+
+	l := &s.logs[i]
+	mergeLogEntry(l, entry)
+
+	// Notify subscribers of update with full entry
+	s.notify(*l)
+}
+
+// findLog returns the index of the log with the given ID, or -1.
+// Updates target recent connections, so search from the newest end.
+func (s *MemoryStore) findLog(id string) int {
+	for i := len(s.logs) - 1; i >= 0; i-- {
+		if s.logs[i].ID == id {
+			return i
 		}
 	}
+	return -1
+}
+
+// compareIDs orders snowflake IDs the same way SQLite orders the text column
+func compareIDs(a, b string) int {
+	return strings.Compare(a, b)
+}
+
+// notify broadcasts an entry to subscriptions
+func (s *MemoryStore) notify(entry core.LogEntry) {
+	s.logBroker.publish(entry)
 }
 
 // AddTraffic increments traffic stats
@@ -127,94 +117,479 @@ func (s *MemoryStore) AddTraffic(upload, download int64) {
 	s.stats.TotalDownload += download
 }
 
-// GetRecentLogs returns the last N logs
+// GetRecentLogs returns the last N logs, newest first
 func (s *MemoryStore) GetRecentLogs(limit int) []core.LogEntry {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if limit > len(s.logs) {
-		limit = len(s.logs)
+	sorted := s.sortedLogs()
+	if limit > len(sorted) {
+		limit = len(sorted)
 	}
+	return sorted[:limit]
+}
 
-	// Return a copy to be safe
-	result := make([]core.LogEntry, limit)
-	copy(result, s.logs[len(s.logs)-limit:])
-
-	// Reverse to show newest first? Frontend usually handles sorting, but let's just return raw
+// sortedLogs returns a copy of the logs ordered by ID descending, like the SQLite store
+func (s *MemoryStore) sortedLogs() []core.LogEntry {
+	result := make([]core.LogEntry, len(s.logs))
+	copy(result, s.logs)
+	sort.SliceStable(result, func(i, j int) bool {
+		return compareIDs(result[i].ID, result[j].ID) > 0
+	})
 	return result
 }
 
+// GetLogsPaginated returns logs older than cursor matching the filters, newest first
+func (s *MemoryStore) GetLogsPaginated(cursor string, limit int, search, status, logType string) ([]core.LogEntry, string, bool, int64, error) {
+	q, err := ParseLogQuery(search)
+	if err != nil {
+		return nil, "", false, 0, err
+	}
+	page, err := s.SearchLogs(cursor, limit, q.WithFilters(status, logType))
+	return page.Logs, page.NextCursor, page.HasMore, page.Total, err
+}
+
+// SearchLogs returns a page of matching logs, newest first, with an exact total
+func (s *MemoryStore) SearchLogs(cursor string, limit int, q LogQuery) (core.PaginatedLogs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page := core.PaginatedLogs{Logs: []core.LogEntry{}}
+	for _, l := range s.sortedLogs() {
+		if !q.Match(l) {
+			continue
+		}
+		page.Total++
+		if cursor != "" && compareIDs(l.ID, cursor) >= 0 {
+			continue
+		}
+		if len(page.Logs) == limit {
+			page.HasMore = true
+			continue
+		}
+		page.Logs = append(page.Logs, l)
+	}
+
+	if len(page.Logs) > 0 {
+		page.NextCursor = page.Logs[len(page.Logs)-1].ID
+	}
+	return page, nil
+}
+
+// ScanLogs returns up to limit logs older than cursor matching q, newest first
+func (s *MemoryStore) ScanLogs(cursor string, limit int, q LogQuery) ([]core.LogEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	logs := []core.LogEntry{}
+	for _, l := range s.sortedLogs() {
+		if len(logs) == limit {
+			break
+		}
+		if (cursor == "" || compareIDs(l.ID, cursor) < 0) && q.Match(l) {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
 // GetStats returns current stats
 func (s *MemoryStore) GetStats() core.Stats {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+
 	stats := s.stats
+	stats.AdblockHits = s.adblockHits
+
+	// Top Domains, falling back to DstIP if Domain is empty
+	usage := make(map[string]int64)
+	for _, l := range s.logs {
+		target := l.Domain
+		if target == "" {
+			target = l.DstIP
+		}
+		if target != "" {
+			usage[target] += l.BytesSent + l.BytesRecv
+		}
+	}
+	stats.TopDomains = topN(usage, 5)
+
+	filterHits := make(map[string]int64, len(s.filterHits))
+	for filter, hit := range s.filterHits {
+		filterHits[filter] = hit.Hits
+	}
+	stats.TopFilters = topN(filterHits, 5)
+
 	stats.Timestamp = time.Now()
 	return stats
 }
 
-// GetTrafficHistory returns (empty/dummy) traffic history for memory store
+// topN keeps the n largest values of m
+func topN(m map[string]int64, n int) map[string]int64 {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	result := make(map[string]int64)
+	for i := 0; i < len(keys) && i < n; i++ {
+		result[keys[i]] = m[keys[i]]
+	}
+	return result
+}
+
+// GetTrafficHistory returns traffic of the last duration, per minute or per hour beyond 4 hours
 func (s *MemoryStore) GetTrafficHistory(duration time.Duration) []core.TrafficDataPoint {
-	// Simple implementation: Aggregate recent logs manually?
-	// For now, return empty or mock.
-	return []core.TrafficDataPoint{}
+	return s.GetTrafficRange(historyQuery(duration))
+}
+
+// GetTrafficRange aggregates the retained logs within the query range.
+// Bytes are accounted to the time a connection was opened.
+func (s *MemoryStore) GetTrafficRange(q core.TrafficQuery) []core.TrafficDataPoint {
+	q, _ = normalizeTrafficQuery(q)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	buckets := newTrafficBuckets(q)
+	for _, l := range s.logs {
+		if l.Timestamp.Before(q.From) || !l.Timestamp.Before(q.To) || !matchesTrafficQuery(l, q) {
+			continue
+		}
+		blocked := int64(0)
+		if l.Status == core.LogStatusBlocked {
+			blocked = 1
+		}
+		buckets.add(l.Timestamp, l.BytesSent, l.BytesRecv, 1, blocked)
+	}
+	return buckets.result()
+}
+
+// GetTopUsage returns the top keys of a dimension within a time window
+func (s *MemoryStore) GetTopUsage(q core.UsageQuery) ([]core.UsageStat, error) {
+	q, err := normalizeUsageQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	totals := make(usageTotals)
+	for _, l := range s.logs {
+		key := usageKey(l, q.By)
+		if key == "" || !matchesUsageQuery(l, q) {
+			continue
+		}
+		blocked := int64(0)
+		if l.Status == core.LogStatusBlocked {
+			blocked = 1
+		}
+		totals.add(key, l.BytesSent, l.BytesRecv, 1, blocked)
+	}
+	return RankUsage(totals.list(), q.Metric, q.Limit), nil
+}
+
+// SubscribeLogs streams new and updated logs until ctx is done
+func (s *MemoryStore) SubscribeLogs(ctx context.Context, opts SubscribeOptions) *Subscription {
+	return s.logBroker.subscribe(ctx, opts)
 }
 
-// Subscribe adds a listener for new logs
-func (s *MemoryStore) Subscribe(callback func(core.LogEntry)) {
+// ResetData clears logs, stats and hit counters. Rules, filter lists and settings are kept.
+func (s *MemoryStore) ResetData() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.subscribers = append(s.subscribers, callback)
+
+	s.logs = s.logs[:0]
+	s.stats = core.Stats{}
+	for i := range s.filters {
+		s.filters[i].Hits = 0
+	}
+	s.filterHits = make(map[string]*core.AdblockFilterHit)
+	s.adblockHits = 0
+	s.processes = make(map[string]core.Process)
+	for i := range s.rules {
+		s.rules[i].HitCount = 0
+	}
+	s.cachedRules = nil
 }
 
-// ResetData resets data
-func (s *MemoryStore) ResetData() {
+// Rule Management Implementation
+
+func (s *MemoryStore) AddRule(rule core.Rule) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.stats = core.Stats{
-		TopDomains: make(map[string]int64),
+
+	if s.findRule(rule.ID) >= 0 {
+		return fmt.Errorf("rule %s already exists", rule.ID)
 	}
+	s.rules = append(s.rules, rule)
+	s.cachedRules = nil
+	return nil
 }
 
-func (s *MemoryStore) AddRule(rule core.Rule) error { return nil }
-func (s *MemoryStore) GetRules() []core.Rule        { return nil }
-func (s *MemoryStore) GetRulesPaginated(page, pageSize int, search string) ([]core.Rule, int64, error) {
-	return []core.Rule{}, 0, nil
+func (s *MemoryStore) GetRules() []core.Rule {
+	s.mu.RLock()
+	if s.cachedRules != nil || len(s.rules) == 0 {
+		defer s.mu.RUnlock()
+		return s.cachedRules
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cachedRules == nil {
+		s.cachedRules = make([]core.Rule, len(s.rules))
+		copy(s.cachedRules, s.rules)
+	}
+	return s.cachedRules
 }
 
-func (s *MemoryStore) GetLogsPaginated(cursor string, limit int, search, status, logType string) ([]core.LogEntry, string, bool, int64, error) {
+func (s *MemoryStore) GetRulesPaginated(page, pageSize int, search string) ([]core.Rule, int64, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	// Use GetRecentLogs as a base or just return empty for now as MemoryStore is for dev/fallback
-	return []core.LogEntry{}, "", false, 0, nil
+	search = strings.ToLower(search)
+	var rules []core.Rule
+	for _, r := range s.rules {
+		if search == "" || strings.Contains(strings.ToLower(r.Pattern), search) {
+			rules = append(rules, r)
+		}
+	}
+
+	// Sort by Source ASC (Custom first), then ID DESC (Newest first)
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Source != rules[j].Source {
+			return rules[i].Source < rules[j].Source
+		}
+		return compareIDs(rules[i].ID, rules[j].ID) > 0
+	})
+
+	total := int64(len(rules))
+	offset := (page - 1) * pageSize
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(rules) {
+		offset = len(rules)
+	}
+	end := offset + pageSize
+	if end > len(rules) {
+		end = len(rules)
+	}
+
+	result := make([]core.Rule, end-offset)
+	copy(result, rules[offset:end])
+	return result, total, nil
 }
 
-func (s *MemoryStore) GetSetting(key string) (string, error) {
-	return "", fmt.Errorf("not implemented")
+func (s *MemoryStore) DeleteRule(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := s.findRule(id); i >= 0 {
+		s.rules = append(s.rules[:i], s.rules[i+1:]...)
+		s.cachedRules = nil
+	}
+	return nil
 }
 
-func (s *MemoryStore) SetSetting(key, value string) error {
+// UpdateRule always applies Enabled, other fields only when set
+func (s *MemoryStore) UpdateRule(rule core.Rule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.findRule(rule.ID)
+	if i < 0 {
+		return nil
+	}
+
+	r := &s.rules[i]
+	r.Enabled = rule.Enabled
+	if rule.Pattern != "" {
+		r.Pattern = rule.Pattern
+	}
+	if rule.Type != "" {
+		r.Type = rule.Type
+	}
+	if rule.Source != "" {
+		r.Source = rule.Source
+	}
+	s.cachedRules = nil
 	return nil
 }
-func (s *MemoryStore) DeleteRule(id string) error      { return nil }
-func (s *MemoryStore) UpdateRule(rule core.Rule) error { return nil }
 
-func (s *MemoryStore) AddAdblockFilter(filter core.AdblockFilter) error    { return nil }
-func (s *MemoryStore) GetAdblockFilters() []core.AdblockFilter             { return nil }
-func (s *MemoryStore) DeleteAdblockFilter(id string) error                 { return nil }
-func (s *MemoryStore) UpdateAdblockFilter(filter core.AdblockFilter) error { return nil }
-func (s *MemoryStore) ClearAdblockFilters() error                          { return nil }
+func (s *MemoryStore) findRule(id string) int {
+	for i := range s.rules {
+		if s.rules[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
 
 func (s *MemoryStore) IncrementRuleHit(id string, domain string) error {
-	// Not fully implemented for rules in MemoryStore yet as MemoryStore
-	// doesn't actually store/manage rules in the current implementation.
+	// De-duplicate hits within a 5ms window per rule/domain
+	cacheKey := id + ":" + domain
+	now := time.Now()
+	if lastHit, ok := s.hitCache.Load(cacheKey); ok {
+		if now.Sub(lastHit.(time.Time)) < 5*time.Millisecond {
+			return nil
+		}
+	}
+	s.hitCache.Store(cacheKey, now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.findRule(id); i >= 0 {
+		s.rules[i].HitCount++
+		s.cachedRules = nil
+	}
 	return nil
 }
 
 func (s *MemoryStore) IncrementAdblockHit(domain string) error {
+	// De-duplicate hits within a 50ms window per domain
+	cacheKey := "adblock:" + domain
+	now := time.Now()
+	if lastHit, ok := s.hitCache.Load(cacheKey); ok {
+		if now.Sub(lastHit.(time.Time)) < 50*time.Millisecond {
+			return nil
+		}
+	}
+	s.hitCache.Store(cacheKey, now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.adblockHits++
+	return nil
+}
+
+func (s *MemoryStore) IncrementAdblockFilterHit(listID, filter string) error {
+	if filter == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hit, ok := s.filterHits[filter]
+	if !ok {
+		hit = &core.AdblockFilterHit{Filter: filter}
+		s.filterHits[filter] = hit
+	}
+	hit.ListID = listID
+	hit.Hits++
+	hit.LastHit = time.Now()
+
+	if i := s.findFilter(listID); listID != "" && i >= 0 {
+		s.filters[i].Hits++
+	}
+	return nil
+}
+
+// Settings
+
+func (s *MemoryStore) GetSetting(key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.settings[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *MemoryStore) SetSetting(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[key] = value
+	return nil
+}
+
+// Adblock Filters
+
+func (s *MemoryStore) AddAdblockFilter(filter core.AdblockFilter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findFilter(filter.ID) >= 0 {
+		return fmt.Errorf("adblock filter %s already exists", filter.ID)
+	}
+	s.filters = append(s.filters, filter)
+	return nil
+}
+
+func (s *MemoryStore) GetAdblockFilters() []core.AdblockFilter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	filters := make([]core.AdblockFilter, len(s.filters))
+	copy(filters, s.filters)
+	return filters
+}
+
+func (s *MemoryStore) DeleteAdblockFilter(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := s.findFilter(id); i >= 0 {
+		s.filters = append(s.filters[:i], s.filters[i+1:]...)
+	}
+	return nil
+}
+
+// UpdateAdblockFilter replaces the filter, inserting it if missing
+func (s *MemoryStore) UpdateAdblockFilter(filter core.AdblockFilter) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.stats.AdblockHits++
+
+	if i := s.findFilter(filter.ID); i >= 0 {
+		s.filters[i] = filter
+	} else {
+		s.filters = append(s.filters, filter)
+	}
 	return nil
 }
+
+func (s *MemoryStore) ClearAdblockFilters() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters = nil
+	return nil
+}
+
+func (s *MemoryStore) findFilter(id string) int {
+	for i := range s.filters {
+		if s.filters[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Process Implementation
+
+func (s *MemoryStore) SaveProcess(p core.Process) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processes[p.Key] = p
+	return nil
+}
+
+func (s *MemoryStore) GetProcess(key string) (core.Process, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.processes[key]
+	if !ok {
+		return core.Process{}, ErrNotFound
+	}
+	return p, nil
+}
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"gorm.io/gorm"
+)
+
+// schemaMigration upgrades the database schema by one version.
+// Migrations must tolerate databases that already have part of their
+// changes, since databases from before versioning are upgraded from 1.
+type schemaMigration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+}
+
+// migrations are applied in order. Append new ones; never edit a released one.
+var migrations = []schemaMigration{
+	{1, "baseline schema", migrateBaseline},
+	{2, "adblock filter refresh scheduling", migrateFilterScheduling},
+	{3, "adblock filter hits", migrateFilterHits},
+	{4, "log retention index", migrateRetentionIndex},
+	{5, "traffic rollups", migrateTrafficRollups},
+	{6, "log search index", migrateLogSearch},
+	{7, "process identity", migrateProcesses},
+	{8, "rule process matchers", migrateRuleProcesses},
+}
+
+// ErrSchemaTooNew is returned when the database was written by a newer build
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of Custos supports")
+
+// SchemaVersion records an applied migration
+type SchemaVersion struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// TableName keeps the table name singular, it is not a model collection
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// currentSchemaVersion returns the highest applied migration, 0 for none
+func currentSchemaVersion(db *gorm.DB) (int, error) {
+	var version int
+	err := db.Raw("SELECT ifnull(max(version), 0) FROM schema_version").Scan(&version).Error
+	return version, err
+}
+
+// migrate brings the database up to the latest schema version, backing up
+// existing databases first. Each migration runs in its own transaction.
+func migrate(db *gorm.DB, dbPath string) error {
+	if err := db.Exec("CREATE TABLE IF NOT EXISTS `schema_version` (`version` integer,`name` text,`applied_at` datetime,PRIMARY KEY (`version`))").Error; err != nil {
+		return err
+	}
+
+	current, err := currentSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := latestSchemaVersion()
+	if current > latest {
+		return fmt.Errorf("%w (v%d > v%d)", ErrSchemaTooNew, current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	// Databases from before versioning have tables but no version
+	if current > 0 || db.Migrator().HasTable("log_entries") {
+		backup, err := backupDatabase(db, dbPath, current)
+		if err != nil {
+			return fmt.Errorf("backup before migration: %w", err)
+		}
+		log.Printf("Backed up database to %s before migrating from schema v%d", backup, current)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		log.Printf("Applied schema migration %d: %s", m.version, m.name)
+	}
+	return nil
+}
+
+// backupDatabase writes a consistent copy of the database next to it,
+// named after the schema version it was taken at
+func backupDatabase(db *gorm.DB, dbPath string, version int) (string, error) {
+	if dbPath == "" || dbPath == ":memory:" {
+		return "", nil
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", dbPath, version)
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return backup, db.Exec("VACUUM INTO ?", backup).Error
+}
+
+// addColumns adds the columns a table is missing; defs are name, type pairs
+func addColumns(tx *gorm.DB, table string, defs ...[2]string) error {
+	for _, def := range defs {
+		if tx.Migrator().HasColumn(table, def[0]) {
+			continue
+		}
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD `%s` %s", table, def[0], def[1])).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateBaseline creates the schema as it was before versioning, and the
+// global traffic totals row, seeded from the logs of older databases
+func migrateBaseline(tx *gorm.DB) error {
+	tables := []string{
+		"CREATE TABLE IF NOT EXISTS `log_entries` (`id` text,`timestamp` datetime,`type` text,`domain` text,`src_ip` text,`dst_ip` text,`dst_port` integer,`protocol` text,`process_name` text,`process_id` integer,`bytes_sent` integer,`bytes_recv` integer,`status` text,`latency` integer,`reason` text,PRIMARY KEY (`id`))",
+		"CREATE TABLE IF NOT EXISTS `traffic_stats_models` (`id` text,`total_upload` integer,`total_download` integer,`timestamp` datetime,PRIMARY KEY (`id`))",
+		"CREATE TABLE IF NOT EXISTS `rules` (`id` text,`type` text,`pattern` text,`enabled` numeric,`source` text,`hit_count` integer,PRIMARY KEY (`id`))",
+		"CREATE TABLE IF NOT EXISTS `app_settings` (`key` text,`value` text,PRIMARY KEY (`key`))",
+		"CREATE TABLE IF NOT EXISTS `adblock_filters` (`id` text,`name` text,`url` text,`enabled` numeric,`last_updated` datetime,`hits` integer,PRIMARY KEY (`id`))",
+	}
+	for _, sql := range tables {
+		if err := tx.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Exec(`INSERT OR IGNORE INTO traffic_stats_models (id, total_upload, total_download, timestamp)
+		SELECT 'global', ifnull(sum(bytes_sent), 0), ifnull(sum(bytes_recv), 0), ? FROM log_entries`, time.Now()).Error
+}
+
+func migrateFilterScheduling(tx *gorm.DB) error {
+	return addColumns(tx, "adblock_filters",
+		[2]string{"update_interval", "integer"},
+		[2]string{"expires", "integer"},
+		[2]string{"last_attempt", "datetime"},
+		[2]string{"failure_count", "integer"},
+	)
+}
+
+func migrateFilterHits(tx *gorm.DB) error {
+	if err := tx.Exec("CREATE TABLE IF NOT EXISTS `adblock_filter_hits` (`filter` text,`list_id` text,`hits` integer,`last_hit` datetime,PRIMARY KEY (`filter`))").Error; err != nil {
+		return err
+	}
+	return tx.Exec("CREATE INDEX IF NOT EXISTS `idx_adblock_filter_hits_list_id` ON `adblock_filter_hits`(`list_id`)").Error
+}
+
+// migrateRetentionIndex speeds up pruning by status and age
+func migrateRetentionIndex(tx *gorm.DB) error {
+	return tx.Exec("CREATE INDEX IF NOT EXISTS idx_log_entries_status_timestamp ON log_entries(status, timestamp)").Error
+}
+
+// migrateTrafficRollups creates the rollup table and builds rollups from
+// the existing logs. Bytes are accounted to each log's start time.
+func migrateTrafficRollups(tx *gorm.DB) error {
+	if err := tx.Exec("CREATE TABLE IF NOT EXISTS `traffic_rollups` (`resolution` integer,`bucket` integer,`kind` text,`key` text,`upload` integer,`download` integer,`connections` integer,`blocked` integer,PRIMARY KEY (`resolution`,`bucket`,`kind`,`key`))").Error; err != nil {
+		return err
+	}
+
+	// Rebuild from scratch in case a previous build already rolled up some logs
+	if err := tx.Exec("DELETE FROM traffic_rollups").Error; err != nil {
+		return err
+	}
+
+	totals := make(rollupBatch)
+	var batch []core.LogEntry
+	err := tx.FindInBatches(&batch, 1000, func(_ *gorm.DB, _ int) error {
+		for _, l := range batch {
+			blocked := int64(0)
+			if l.Status == core.LogStatusBlocked {
+				blocked = 1
+			}
+			totals.add(rollupsFor(l, l.Timestamp, l.BytesSent, l.BytesRecv, 1, blocked))
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+	return upsertRollups(tx, totals.list())
+}
+
+// migrateLogSearch adds a trigram full-text index over the searchable log
+// columns, kept in sync by triggers, and indexes for the common filters
+func migrateLogSearch(tx *gorm.DB) error {
+	stmts := []string{
+		"CREATE INDEX IF NOT EXISTS idx_log_entries_timestamp ON log_entries(timestamp)",
+		"CREATE INDEX IF NOT EXISTS idx_log_entries_type ON log_entries(type)",
+		"CREATE VIRTUAL TABLE IF NOT EXISTS log_search USING fts5(domain, process_name, dst_ip, content='log_entries', tokenize='trigram')",
+		`CREATE TRIGGER IF NOT EXISTS log_search_insert AFTER INSERT ON log_entries BEGIN
+			INSERT INTO log_search(rowid, domain, process_name, dst_ip) VALUES (new.rowid, new.domain, new.process_name, new.dst_ip);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS log_search_delete AFTER DELETE ON log_entries BEGIN
+			INSERT INTO log_search(log_search, rowid, domain, process_name, dst_ip) VALUES ('delete', old.rowid, old.domain, old.process_name, old.dst_ip);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS log_search_update AFTER UPDATE OF domain, process_name, dst_ip ON log_entries
+		WHEN old.domain IS NOT new.domain OR old.process_name IS NOT new.process_name OR old.dst_ip IS NOT new.dst_ip BEGIN
+			INSERT INTO log_search(log_search, rowid, domain, process_name, dst_ip) VALUES ('delete', old.rowid, old.domain, old.process_name, old.dst_ip);
+			INSERT INTO log_search(rowid, domain, process_name, dst_ip) VALUES (new.rowid, new.domain, new.process_name, new.dst_ip);
+		END`,
+		"INSERT INTO log_search(log_search) VALUES ('rebuild')",
+	}
+	for _, sql := range stmts {
+		if err := tx.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateProcesses adds the process table and links logs to it
+func migrateProcesses(tx *gorm.DB) error {
+	if err := tx.Exec("CREATE TABLE IF NOT EXISTS `processes` (`key` text,`pid` integer,`name` text,`exe_path` text,`sha256` text,`cmdline` text,`user` text,`start_time` datetime,`parent_pid` integer,`parent_key` text,PRIMARY KEY (`key`))").Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("CREATE INDEX IF NOT EXISTS `idx_processes_parent_key` ON `processes`(`parent_key`)").Error; err != nil {
+		return err
+	}
+	if err := addColumns(tx, "log_entries", [2]string{"process_key", "text"}); err != nil {
+		return err
+	}
+	return tx.Exec("CREATE INDEX IF NOT EXISTS idx_log_entries_process_key ON log_entries(process_key)").Error
+}
+
+// migrateRuleProcesses lets rules match the process behind a connection
+func migrateRuleProcesses(tx *gorm.DB) error {
+	return addColumns(tx, "rules",
+		[2]string{"process_path", "text"},
+		[2]string{"process_sha256", "text"},
+		[2]string{"process_user", "text"},
+	)
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/vkhangstack/Custos/internal/core"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openRawDB opens a database without running migrations
+func openRawDB(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	return db
+}
+
+func closeRawDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+// loadFixture creates a database from a SQL script in testdata
+func loadFixture(t *testing.T, name string) string {
+	t.Helper()
+	script, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "custos.db")
+	db := openRawDB(t, path)
+	defer closeRawDB(db)
+	for _, stmt := range strings.Split(string(script), ";\n") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("fixture %s: %v", name, err)
+		}
+	}
+	return path
+}
+
+// assertSchemaMatchesModels fails if a model field has no column, so the
+// migrations can't drift from the structs the store reads and writes
+func assertSchemaMatchesModels(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	models := []interface{}{
+		&core.LogEntry{}, &core.TrafficStatsModel{}, &core.Rule{}, &core.AppSetting{},
+		&core.AdblockFilter{}, &core.AdblockFilterHit{}, &core.TrafficRollup{}, &core.Process{}, &SchemaVersion{},
+	}
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		if !db.Migrator().HasTable(model) {
+			t.Errorf("table %s is missing", stmt.Schema.Table)
+			continue
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("column %s.%s is missing", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custos.db")
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	if v, _ := currentSchemaVersion(s.db); v != latestSchemaVersion() {
+		t.Errorf("schema version = %d; want %d", v, latestSchemaVersion())
+	}
+	assertSchemaMatchesModels(t, s.db)
+
+	if matches, _ := filepath.Glob(path + ".v*.bak"); len(matches) != 0 {
+		t.Errorf("a new database should not be backed up, found %v", matches)
+	}
+	if stats := s.GetStats(); stats.TotalUpload != 0 {
+		t.Errorf("TotalUpload = %d; want 0", stats.TotalUpload)
+	}
+}
+
+func TestMigrateBaselineFixture(t *testing.T) {
+	path := loadFixture(t, "baseline.sql")
+
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	if v, _ := currentSchemaVersion(s.db); v != latestSchemaVersion() {
+		t.Errorf("schema version = %d; want %d", v, latestSchemaVersion())
+	}
+	assertSchemaMatchesModels(t, s.db)
+
+	// The untouched database was backed up first
+	backup := openRawDB(t, path+".v0.bak")
+	defer closeRawDB(backup)
+	if backup.Migrator().HasTable("traffic_rollups") || backup.Migrator().HasColumn("adblock_filters", "update_interval") {
+		t.Errorf("backup should hold the baseline schema")
+	}
+	var backupLogs int64
+	backup.Table("log_entries").Count(&backupLogs)
+	if backupLogs != 3 {
+		t.Errorf("backup has %d logs; want 3", backupLogs)
+	}
+
+	// Existing data survives
+	logs := s.GetRecentLogs(10)
+	if len(logs) != 3 || logs[0].ID != "1000003" || logs[2].Domain != "www.example.com" || logs[2].BytesRecv != 4800 {
+		t.Errorf("logs = %+v; want the 3 fixture logs", logs)
+	}
+	stats := s.GetStats()
+	if stats.TotalUpload != 5000 || stats.TotalDownload != 90000 || stats.AdblockHits != 3 {
+		t.Errorf("stats = %+v; want the fixture totals", stats)
+	}
+	if port, _ := s.GetSetting("proxy_port"); port != "1081" {
+		t.Errorf("proxy_port = %q; want 1081", port)
+	}
+	rules, _, _ := s.GetRulesPaginated(1, 10, "")
+	if len(rules) != 1 || rules[0].HitCount != 7 || !rules[0].Enabled {
+		t.Errorf("rules = %+v; want the fixture rule", rules)
+	}
+	filters := s.GetAdblockFilters()
+	if len(filters) != 1 || filters[0].Hits != 3 || filters[0].UpdateInterval != 0 || filters[0].RefreshInterval() != core.DefaultFilterUpdateInterval {
+		t.Errorf("filters = %+v; want the fixture filter with default scheduling", filters)
+	}
+
+	// Rollups are built from the existing logs
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	var total core.TrafficDataPoint
+	for _, p := range s.GetTrafficRange(core.TrafficQuery{From: from, To: from.Add(48 * time.Hour), Bucket: 24 * time.Hour}) {
+		total.Upload += p.Upload
+		total.Download += p.Download
+		total.Connections += p.Connections
+		total.Blocked += p.Blocked
+	}
+	if total.Upload != 420 || total.Download != 5700 || total.Connections != 3 || total.Blocked != 1 {
+		t.Errorf("rolled up totals = %+v; want 420/5700 bytes, 3 connections, 1 blocked", total)
+	}
+
+	// Reopening an up to date database is a no-op
+	s.Close()
+	os.Remove(path + ".v0.bak")
+	s, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	if matches, _ := filepath.Glob(path + ".v*.bak"); len(matches) != 0 {
+		t.Errorf("an up to date database should not be backed up, found %v", matches)
+	}
+}
+
+func TestMigrateUnversionedCurrentSchema(t *testing.T) {
+	// Databases created by AutoMigrate before versioning already have newer tables
+	path := filepath.Join(t.TempDir(), "custos.db")
+	db := openRawDB(t, path)
+	if err := db.AutoMigrate(&core.LogEntry{}, &core.TrafficStatsModel{}, &core.Rule{}, &core.AppSetting{}, &core.AdblockFilter{}, &core.AdblockFilterHit{}, &core.TrafficRollup{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&core.LogEntry{ID: "1", Timestamp: time.Now(), Domain: "example.com", BytesSent: 10})
+	closeRawDB(db)
+
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	if v, _ := currentSchemaVersion(s.db); v != latestSchemaVersion() {
+		t.Errorf("schema version = %d; want %d", v, latestSchemaVersion())
+	}
+	if stats := s.GetStats(); stats.TotalUpload != 10 {
+		t.Errorf("TotalUpload = %d; want the global row seeded from logs", stats.TotalUpload)
+	}
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custos.db")
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	s.db.Create(&SchemaVersion{Version: latestSchemaVersion() + 1, Name: "from the future"})
+	s.Close()
+
+	if _, err := NewSQLiteStore(path); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("NewSQLiteStore error = %v; want ErrSchemaTooNew", err)
+	}
+}
+
+func TestIncrementalVacuumConversion(t *testing.T) {
+	s, err := NewSQLiteStore(loadFixture(t, "baseline.sql"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	// Opening doesn't wait for a VACUUM, the pruner converts the database later
+	var mode int
+	s.db.Raw("PRAGMA auto_vacuum").Scan(&mode)
+	if mode != 0 {
+		t.Errorf("auto_vacuum after open = %d; want the conversion left to the pruner", mode)
+	}
+	if err := s.enableIncrementalVacuum(); err != nil {
+		t.Fatalf("enableIncrementalVacuum: %v", err)
+	}
+	s.db.Raw("PRAGMA auto_vacuum").Scan(&mode)
+	if mode != 2 {
+		t.Errorf("auto_vacuum after conversion = %d; want incremental (2)", mode)
+	}
+}
@@ -0,0 +1,21 @@
+package store
+
+import "github.com/vkhangstack/Custos/internal/core"
+
+// maxProcessDepth stops walking a parent chain that loops
+const maxProcessDepth = 64
+
+// ProcessLineage returns a process followed by its recorded ancestors,
+// nearest first. It is empty if the process isn't known.
+func ProcessLineage(s Store, key string) []core.Process {
+	var lineage []core.Process
+	for key != "" && len(lineage) < maxProcessDepth {
+		p, err := s.GetProcess(key)
+		if err != nil {
+			break
+		}
+		lineage = append(lineage, p)
+		key = p.ParentKey
+	}
+	return lineage
+}
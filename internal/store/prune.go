@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+)
+
+const (
+	pruneInterval     = 10 * time.Minute
+	pruneStartupDelay = 30 * time.Second
+	pruneBatchSize    = 2000
+	vacuumBatchPages  = 1000
+)
+
+// enableIncrementalVacuum switches the database to incremental auto-vacuum so
+// pruned pages can be returned to the OS without a blocking full VACUUM.
+// New databases are created that way; existing ones are converted here once,
+// which takes a VACUUM, so it runs in the pruner rather than on open.
+func (s *SQLiteStore) enableIncrementalVacuum() error {
+	s.pruneMu.Lock()
+	defer s.pruneMu.Unlock()
+
+	var mode int
+	if err := s.db.Raw("PRAGMA auto_vacuum").Scan(&mode).Error; err != nil {
+		return err
+	}
+	if mode == 2 {
+		return nil
+	}
+
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	// The mode change only sticks if VACUUM runs on the same connection
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Queued writes wait for the VACUUM instead of timing out on the lock
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := time.Now()
+	if _, err := conn.ExecContext(context.Background(), "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(context.Background(), "VACUUM"); err != nil {
+		return err
+	}
+	log.Printf("Converted the database to incremental vacuum in %v", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// runPruner enforces the retention policy periodically until the store is closed
+func (s *SQLiteStore) runPruner() {
+	timer := time.NewTimer(pruneStartupDelay)
+	defer timer.Stop()
+	converted := false
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-timer.C:
+			if _, err := s.Prune(); err != nil {
+				log.Printf("Failed to prune logs: %v", err)
+			}
+			// After pruning, so there is less to copy
+			if !converted {
+				if err := s.enableIncrementalVacuum(); err != nil {
+					log.Printf("Failed to enable incremental vacuum: %v", err)
+				}
+				converted = true
+			}
+			timer.Reset(pruneInterval)
+		}
+	}
+}
+
+// Prune deletes logs outside the retention policy in small batches, so
+// writers are never blocked for long, then releases the freed pages.
+func (s *SQLiteStore) Prune() (int64, error) {
+	s.pruneMu.Lock()
+	defer s.pruneMu.Unlock()
+
+	// Queued logs may already be past their retention
+	s.sync()
+
+	policy := GetRetentionPolicy(s)
+	now := time.Now()
+	var deleted int64
+
+	if policy.BlockedMaxAge > 0 {
+		cutoff := now.Add(-time.Duration(policy.BlockedMaxAge) * time.Second)
+		n, err := s.pruneWhere("status = ? AND timestamp < ?", core.LogStatusBlocked, cutoff)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	if policy.AllowedMaxAge > 0 {
+		cutoff := now.Add(-time.Duration(policy.AllowedMaxAge) * time.Second)
+		n, err := s.pruneWhere("status <> ? AND timestamp < ?", core.LogStatusBlocked, cutoff)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	if policy.MaxRows > 0 {
+		var count int64
+		if err := s.db.Model(&core.LogEntry{}).Count(&count).Error; err != nil {
+			return deleted, err
+		}
+		for count > policy.MaxRows {
+			n, err := s.pruneOldest(min(count-policy.MaxRows, pruneBatchSize))
+			deleted += n
+			if err != nil || n == 0 {
+				return deleted, err
+			}
+			count -= n
+		}
+	}
+
+	if policy.MaxDBSize > 0 {
+		for {
+			size, err := s.usedSize()
+			if err != nil {
+				return deleted, err
+			}
+			if size <= policy.MaxDBSize {
+				break
+			}
+			n, err := s.pruneOldest(pruneBatchSize)
+			deleted += n
+			if err != nil || n == 0 {
+				return deleted, err
+			}
+		}
+	}
+
+	if err := s.pruneRollups(); err != nil {
+		return deleted, err
+	}
+	if err := s.pruneProcesses(); err != nil {
+		return deleted, err
+	}
+
+	if deleted > 0 {
+		log.Printf("Pruned %d log entries", deleted)
+	}
+	return deleted, s.vacuumFreePages()
+}
+
+// pruneWhere deletes matching logs batch by batch
+func (s *SQLiteStore) pruneWhere(cond string, args ...interface{}) (int64, error) {
+	var deleted int64
+	for {
+		s.mu.Lock()
+		res := s.db.Exec("DELETE FROM log_entries WHERE id IN (SELECT id FROM log_entries WHERE "+cond+" LIMIT ?)",
+			append(args, pruneBatchSize)...)
+		s.mu.Unlock()
+
+		if res.Error != nil {
+			return deleted, res.Error
+		}
+		deleted += res.RowsAffected
+		if res.RowsAffected < pruneBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// pruneOldest deletes up to n of the oldest logs
+func (s *SQLiteStore) pruneOldest(n int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := s.db.Exec("DELETE FROM log_entries WHERE id IN (SELECT id FROM log_entries ORDER BY id ASC LIMIT ?)", n)
+	return res.RowsAffected, res.Error
+}
+
+// pruneProcesses deletes processes no log refers to, directly or as the
+// parent of a process one does
+func (s *SQLiteStore) pruneProcesses() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Exec(`WITH RECURSIVE used(key) AS (
+			SELECT DISTINCT process_key FROM log_entries WHERE process_key <> ''
+			UNION SELECT p.parent_key FROM processes p JOIN used ON p.key = used.key WHERE p.parent_key <> ''
+		)
+		DELETE FROM processes WHERE key NOT IN (SELECT key FROM used)`).Error
+}
+
+// usedSize returns the database size excluding free pages
+func (s *SQLiteStore) usedSize() (int64, error) {
+	var pageCount, freePages, pageSize int64
+	if err := s.db.Raw("PRAGMA page_count").Scan(&pageCount).Error; err != nil {
+		return 0, err
+	}
+	if err := s.db.Raw("PRAGMA freelist_count").Scan(&freePages).Error; err != nil {
+		return 0, err
+	}
+	if err := s.db.Raw("PRAGMA page_size").Scan(&pageSize).Error; err != nil {
+		return 0, err
+	}
+	return (pageCount - freePages) * pageSize, nil
+}
+
+// vacuumFreePages truncates free pages off the database file a chunk at a time
+func (s *SQLiteStore) vacuumFreePages() error {
+	for {
+		var freePages int64
+		if err := s.db.Raw("PRAGMA freelist_count").Scan(&freePages).Error; err != nil {
+			return err
+		}
+		if freePages == 0 {
+			return nil
+		}
+
+		s.mu.Lock()
+		err := s.db.Exec(fmt.Sprintf("PRAGMA incremental_vacuum(%d)", min(freePages, vacuumBatchPages))).Error
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		var remaining int64
+		if err := s.db.Raw("PRAGMA freelist_count").Scan(&remaining).Error; err != nil {
+			return err
+		}
+		// Not in incremental mode, nothing more to reclaim
+		if remaining >= freePages {
+			return nil
+		}
+	}
+}
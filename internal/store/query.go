@@ -0,0 +1,405 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"gorm.io/gorm"
+)
+
+// LogQuery is a parsed log search. The syntax is a list of whitespace
+// separated terms, all of which must match:
+//
+//	chrome                 free text, matched against domain, process and IP
+//	domain:*.google.com    domain glob; "*.x" also matches x itself
+//	process:chrome         process name glob
+//	status:blocked         log status
+//	type:dns               log source
+//	port:443               destination port
+//	ip:10.0.*              source or destination IP glob
+//	since:2h  until:1d     relative durations, or dates like 2025-06-01
+//	bytes>1MB  sent<=10KB  recv:0   total, sent or received byte comparisons
+//
+// Repeating a filter key matches any of its values. Values containing spaces
+// can be quoted: process:"Google Chrome".
+type LogQuery struct {
+	Terms     []string
+	Domains   []string
+	Processes []string
+	Statuses  []string
+	Types     []string
+	Ports     []int
+	IPs       []string
+	Since     time.Time
+	Until     time.Time
+	Bytes     []ByteFilter
+}
+
+// ByteFilter compares a byte count of a log against a value
+type ByteFilter struct {
+	Field string // "bytes" (sent + received), "sent" or "recv"
+	Op    string // ">", ">=", "<", "<=" or "="
+	Value int64
+}
+
+var queryFilterPattern = regexp.MustCompile(`^([a-zA-Z]+)(>=|<=|>|<|:)(.*)$`)
+
+// ParseLogQuery parses the log search syntax. Words that look like filters
+// with an unknown key (e.g. "https://...") are searched as free text.
+func ParseLogQuery(input string) (LogQuery, error) {
+	var q LogQuery
+	now := time.Now()
+
+	for _, token := range tokenizeQuery(input) {
+		m := queryFilterPattern.FindStringSubmatch(token)
+		if m == nil {
+			q.Terms = append(q.Terms, unquote(token))
+			continue
+		}
+
+		key, op, value := strings.ToLower(m[1]), m[2], unquote(m[3])
+		if op != ":" && key != "bytes" && key != "sent" && key != "recv" {
+			return q, fmt.Errorf("%s does not support %s", key, op)
+		}
+		if value == "" {
+			return q, fmt.Errorf("missing value for %s", key)
+		}
+
+		switch key {
+		case "domain":
+			q.Domains = append(q.Domains, value)
+		case "process":
+			q.Processes = append(q.Processes, value)
+		case "status":
+			q.Statuses = append(q.Statuses, strings.ToLower(value))
+		case "type":
+			q.Types = append(q.Types, strings.ToLower(value))
+		case "ip":
+			q.IPs = append(q.IPs, value)
+		case "port":
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 0 || port > 65535 {
+				return q, fmt.Errorf("invalid port %q", value)
+			}
+			q.Ports = append(q.Ports, port)
+		case "since", "until":
+			t, err := parseQueryTime(value, now)
+			if err != nil {
+				return q, err
+			}
+			if key == "since" {
+				q.Since = t
+			} else {
+				q.Until = t
+			}
+		case "bytes", "sent", "recv":
+			n, err := parseByteSize(value)
+			if err != nil {
+				return q, err
+			}
+			if op == ":" {
+				op = "="
+			}
+			q.Bytes = append(q.Bytes, ByteFilter{Field: key, Op: op, Value: n})
+		default:
+			q.Terms = append(q.Terms, token)
+		}
+	}
+	return q, nil
+}
+
+// WithFilters narrows a query to a status and log type, "all" or empty match anything
+func (q LogQuery) WithFilters(status, logType string) LogQuery {
+	if status != "" && status != "all" {
+		q.Statuses = append(q.Statuses[:len(q.Statuses):len(q.Statuses)], status)
+	}
+	if logType != "" && logType != "all" {
+		q.Types = append(q.Types[:len(q.Types):len(q.Types)], logType)
+	}
+	return q
+}
+
+// IsEmpty reports whether the query matches every log
+func (q LogQuery) IsEmpty() bool {
+	return len(q.Terms) == 0 && len(q.Domains) == 0 && len(q.Processes) == 0 &&
+		len(q.Statuses) == 0 && len(q.Types) == 0 && len(q.Ports) == 0 && len(q.IPs) == 0 &&
+		q.Since.IsZero() && q.Until.IsZero() && len(q.Bytes) == 0
+}
+
+// tokenizeQuery splits on whitespace, keeping double-quoted runs together
+func tokenizeQuery(input string) []string {
+	var tokens []string
+	var cur strings.Builder
+	quoted := false
+	for _, r := range input {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			cur.WriteRune(r)
+		case (r == ' ' || r == '\t' || r == '\n') && !quoted:
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens
+}
+
+func unquote(s string) string {
+	return strings.ReplaceAll(s, `"`, "")
+}
+
+// parseQueryTime accepts durations before now ("90m", "2h", "3d", "1w") and dates
+func parseQueryTime(value string, now time.Time) (time.Time, error) {
+	if n, ok := strings.CutSuffix(value, "d"); ok {
+		if days, err := strconv.Atoi(n); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if n, ok := strings.CutSuffix(value, "w"); ok {
+		if weeks, err := strconv.Atoi(n); err == nil {
+			return now.AddDate(0, 0, -7*weeks), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// parseByteSize parses sizes like 512, 10KB, 1.5MB or 2G, in binary units
+func parseByteSize(value string) (int64, error) {
+	upper := strings.ToUpper(value)
+	i := strings.IndexFunc(upper, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	num, unit := upper, ""
+	if i >= 0 {
+		num, unit = upper[:i], upper[i:]
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	var mult float64
+	switch strings.TrimSuffix(strings.TrimSuffix(unit, "IB"), "B") {
+	case "":
+		mult = 1
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	case "T":
+		mult = 1 << 40
+	default:
+		return 0, fmt.Errorf("invalid size unit %q", unit)
+	}
+	return int64(n * mult), nil
+}
+
+// Match reports whether a log matches the query
+func (q LogQuery) Match(l core.LogEntry) bool {
+	for _, term := range q.Terms {
+		term = strings.ToLower(term)
+		if !strings.Contains(strings.ToLower(l.Domain), term) &&
+			!strings.Contains(strings.ToLower(l.ProcessName), term) &&
+			!strings.Contains(strings.ToLower(l.DstIP), term) {
+			return false
+		}
+	}
+	if len(q.Domains) > 0 && !matchAny(q.Domains, func(p string) bool { return matchDomainGlob(p, l.Domain) }) {
+		return false
+	}
+	if len(q.Processes) > 0 && !matchAny(q.Processes, func(p string) bool { return matchGlob(p, l.ProcessName) }) {
+		return false
+	}
+	if len(q.IPs) > 0 && !matchAny(q.IPs, func(p string) bool { return matchGlob(p, l.DstIP) || matchGlob(p, l.SrcIP) }) {
+		return false
+	}
+	if len(q.Statuses) > 0 && !matchAny(q.Statuses, func(s string) bool { return strings.EqualFold(s, l.Status) }) {
+		return false
+	}
+	if len(q.Types) > 0 && !matchAny(q.Types, func(t string) bool { return strings.EqualFold(t, l.Type) }) {
+		return false
+	}
+	if len(q.Ports) > 0 {
+		found := false
+		for _, p := range q.Ports {
+			found = found || p == l.DstPort
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && l.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !l.Timestamp.Before(q.Until) {
+		return false
+	}
+	for _, b := range q.Bytes {
+		if !b.match(l) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b ByteFilter) match(l core.LogEntry) bool {
+	v := l.BytesSent + l.BytesRecv
+	switch b.Field {
+	case "sent":
+		v = l.BytesSent
+	case "recv":
+		v = l.BytesRecv
+	}
+	switch b.Op {
+	case ">":
+		return v > b.Value
+	case ">=":
+		return v >= b.Value
+	case "<":
+		return v < b.Value
+	case "<=":
+		return v <= b.Value
+	default:
+		return v == b.Value
+	}
+}
+
+func matchAny(values []string, match func(string) bool) bool {
+	for _, v := range values {
+		if match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches case-insensitively, "*" matches any run of characters
+func matchGlob(pattern, s string) bool {
+	pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// matchDomainGlob is matchGlob where "*.example.com" also matches example.com
+func matchDomainGlob(pattern, domain string) bool {
+	if base, ok := strings.CutPrefix(pattern, "*."); ok && strings.EqualFold(base, domain) {
+		return true
+	}
+	return matchGlob(pattern, domain)
+}
+
+// globToLike converts a glob to a LIKE pattern escaped with '\'
+func globToLike(pattern string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `*`, `%`)
+	return r.Replace(pattern)
+}
+
+// apply adds the query conditions to a log_entries query
+func (q LogQuery) apply(db *gorm.DB) *gorm.DB {
+	var ftsTerms []string
+	for _, term := range q.Terms {
+		// The trigram index can't look up terms shorter than 3 characters
+		if utf8.RuneCountInString(term) < 3 {
+			like := "%" + globToLike(term) + "%"
+			db = db.Where(`(domain LIKE ? ESCAPE '\' OR process_name LIKE ? ESCAPE '\' OR dst_ip LIKE ? ESCAPE '\')`, like, like, like)
+			continue
+		}
+		ftsTerms = append(ftsTerms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	if len(ftsTerms) > 0 {
+		db = db.Where("log_entries.rowid IN (SELECT rowid FROM log_search WHERE log_search MATCH ?)", strings.Join(ftsTerms, " AND "))
+	}
+
+	db = applyLikeAny(db, q.Domains, func(p string) (string, []interface{}) {
+		if base, ok := strings.CutPrefix(p, "*."); ok {
+			return `(domain LIKE ? ESCAPE '\' OR domain LIKE ? ESCAPE '\')`, []interface{}{globToLike(p), globToLike(base)}
+		}
+		return `domain LIKE ? ESCAPE '\'`, []interface{}{globToLike(p)}
+	})
+	db = applyLikeAny(db, q.Processes, func(p string) (string, []interface{}) {
+		return `process_name LIKE ? ESCAPE '\'`, []interface{}{globToLike(p)}
+	})
+	db = applyLikeAny(db, q.IPs, func(p string) (string, []interface{}) {
+		return `(dst_ip LIKE ? ESCAPE '\' OR src_ip LIKE ? ESCAPE '\')`, []interface{}{globToLike(p), globToLike(p)}
+	})
+
+	if len(q.Statuses) > 0 {
+		db = db.Where("status IN ?", q.Statuses)
+	}
+	if len(q.Types) > 0 {
+		db = db.Where("type IN ?", q.Types)
+	}
+	if len(q.Ports) > 0 {
+		db = db.Where("dst_port IN ?", q.Ports)
+	}
+	if !q.Since.IsZero() {
+		db = db.Where("timestamp >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		db = db.Where("timestamp < ?", q.Until)
+	}
+
+	for _, b := range q.Bytes {
+		column := "bytes_sent + bytes_recv"
+		switch b.Field {
+		case "sent":
+			column = "bytes_sent"
+		case "recv":
+			column = "bytes_recv"
+		}
+		db = db.Where(fmt.Sprintf("(%s) %s ?", column, b.Op), b.Value)
+	}
+	return db
+}
+
+// applyLikeAny ORs together one condition per value
+func applyLikeAny(db *gorm.DB, values []string, cond func(string) (string, []interface{})) *gorm.DB {
+	if len(values) == 0 {
+		return db
+	}
+	var sqls []string
+	var args []interface{}
+	for _, v := range values {
+		sql, a := cond(v)
+		sqls = append(sqls, sql)
+		args = append(args, a...)
+	}
+	return db.Where("("+strings.Join(sqls, " OR ")+")", args...)
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+)
+
+func TestParseLogQuery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  LogQuery
+	}{
+		{"", LogQuery{}},
+		{"  chrome   github ", LogQuery{Terms: []string{"chrome", "github"}}},
+		{`"google chrome"`, LogQuery{Terms: []string{"google chrome"}}},
+		{"domain:*.google.com Domain:x.org", LogQuery{Domains: []string{"*.google.com", "x.org"}}},
+		{`process:"Google Chrome"`, LogQuery{Processes: []string{"Google Chrome"}}},
+		{"status:BLOCKED type:dns", LogQuery{Statuses: []string{"blocked"}, Types: []string{"dns"}}},
+		{"port:443 port:80 ip:10.*", LogQuery{Ports: []int{443, 80}, IPs: []string{"10.*"}}},
+		{"bytes>1MB sent<=10kb recv:0", LogQuery{Bytes: []ByteFilter{
+			{Field: "bytes", Op: ">", Value: 1 << 20},
+			{Field: "sent", Op: "<=", Value: 10 << 10},
+			{Field: "recv", Op: "=", Value: 0},
+		}}},
+		{"https://example.com", LogQuery{Terms: []string{"https://example.com"}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseLogQuery(tt.input)
+		if err != nil {
+			t.Errorf("ParseLogQuery(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLogQuery(%q) = %+v; want %+v", tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{"port:http", "port:70000", "status>1", "domain:", "since:yesterday", "bytes>1XB", "sent:-1"} {
+		if _, err := ParseLogQuery(input); err == nil {
+			t.Errorf("ParseLogQuery(%q) should fail", input)
+		}
+	}
+}
+
+func TestParseQueryTime(t *testing.T) {
+	now := time.Date(2025, 6, 10, 12, 0, 0, 0, time.Local)
+	tests := map[string]time.Time{
+		"90m":        now.Add(-90 * time.Minute),
+		"2h":         now.Add(-2 * time.Hour),
+		"3d":         now.AddDate(0, 0, -3),
+		"1w":         now.AddDate(0, 0, -7),
+		"2025-06-01": time.Date(2025, 6, 1, 0, 0, 0, 0, time.Local),
+	}
+	for input, want := range tests {
+		got, err := parseQueryTime(input, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseQueryTime(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{"512": 512, "1k": 1024, "1.5MB": 3 << 19, "2GiB": 2 << 30, "1T": 1 << 40}
+	for input, want := range tests {
+		if got, err := parseByteSize(input); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"chrome", "Chrome", true},
+		{"chrome", "chromedriver", false},
+		{"chrome*", "chromedriver", true},
+		{"*drive*", "chromedriver", true},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "acb", false},
+		{"10.0.*", "10.0.0.1", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v; want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+
+	if !matchDomainGlob("*.example.com", "example.com") || !matchDomainGlob("*.example.com", "a.b.example.com") || matchDomainGlob("*.example.com", "badexample.com") {
+		t.Errorf("matchDomainGlob should match the domain and its subdomains only")
+	}
+}
+
+func TestLogQueryWithFilters(t *testing.T) {
+	q := LogQuery{Statuses: []string{core.LogStatusBlocked}}
+	narrowed := q.WithFilters("all", core.LogSourceDNS)
+	if len(q.Types) != 0 || !reflect.DeepEqual(narrowed.Types, []string{core.LogSourceDNS}) || len(narrowed.Statuses) != 1 {
+		t.Errorf("WithFilters = %+v; want the type added without changing the original", narrowed)
+	}
+	if !(LogQuery{}).IsEmpty() || narrowed.IsEmpty() {
+		t.Errorf("IsEmpty is wrong")
+	}
+}
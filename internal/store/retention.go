@@ -0,0 +1,59 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vkhangstack/Custos/internal/core"
+)
+
+const retentionPolicyKey = "retention_policy"
+
+// DefaultRetentionPolicy is used until the user configures one
+var DefaultRetentionPolicy = core.RetentionPolicy{
+	AllowedMaxAge: 7 * 24 * 60 * 60,
+	BlockedMaxAge: 30 * 24 * 60 * 60,
+	MaxRows:       1000000,
+	MaxDBSize:     512 << 20,
+}
+
+// Pruner is implemented by stores that enforce the retention policy themselves
+type Pruner interface {
+	// Prune deletes logs outside the retention policy and returns how many were removed
+	Prune() (int64, error)
+}
+
+// GetRetentionPolicy returns the stored retention policy, or the default
+func GetRetentionPolicy(s Store) core.RetentionPolicy {
+	val, err := s.GetSetting(retentionPolicyKey)
+	if err != nil || val == "" {
+		return DefaultRetentionPolicy
+	}
+
+	policy := DefaultRetentionPolicy
+	if err := json.Unmarshal([]byte(val), &policy); err != nil {
+		return DefaultRetentionPolicy
+	}
+	return policy
+}
+
+// ValidateRetentionPolicy rejects negative limits
+func ValidateRetentionPolicy(policy core.RetentionPolicy) error {
+	if policy.AllowedMaxAge < 0 || policy.BlockedMaxAge < 0 || policy.MaxRows < 0 || policy.MaxDBSize < 0 {
+		return fmt.Errorf("retention limits must not be negative")
+	}
+	return nil
+}
+
+// SetRetentionPolicy validates and stores the retention policy
+func SetRetentionPolicy(s Store, policy core.RetentionPolicy) error {
+	if err := ValidateRetentionPolicy(policy); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return s.SetSetting(retentionPolicyKey, string(data))
+}
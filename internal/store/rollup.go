@@ -0,0 +1,289 @@
+package store
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const day = 24 * time.Hour
+
+// Resolutions traffic is rolled up at, finest first
+var rollupResolutions = []time.Duration{time.Minute, time.Hour, day}
+
+// Finer rollups are dropped sooner, day rollups are kept forever
+const (
+	minuteRollupRetention = 7 * day
+	hourRollupRetention   = 400 * day
+)
+
+// normalizeTrafficQuery fills in defaults and picks the rollup resolution to read.
+// The returned query has a bucket that is a multiple of the resolution.
+func normalizeTrafficQuery(q core.TrafficQuery) (core.TrafficQuery, time.Duration) {
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	if q.From.IsZero() || !q.From.Before(q.To) {
+		q.From = q.To.Add(-time.Hour)
+	}
+
+	if q.Bucket <= 0 {
+		span := q.To.Sub(q.From)
+		switch {
+		case span <= 4*time.Hour:
+			q.Bucket = time.Minute
+		case span <= 14*day:
+			q.Bucket = time.Hour
+		default:
+			q.Bucket = day
+		}
+	}
+	q.Bucket = max(q.Bucket.Truncate(time.Minute), time.Minute)
+
+	resolution := time.Minute
+	if q.Bucket%day == 0 {
+		resolution = day
+	} else if q.Bucket%time.Hour == 0 {
+		resolution = time.Hour
+	}
+
+	// Older ranges are only available at a coarser resolution
+	age := time.Since(q.From)
+	if age > minuteRollupRetention && resolution < time.Hour {
+		resolution = time.Hour
+	}
+	if age > hourRollupRetention && resolution < day {
+		resolution = day
+	}
+	if q.Bucket < resolution || q.Bucket%resolution != 0 {
+		q.Bucket = (q.Bucket/resolution + 1) * resolution
+	}
+
+	return q, resolution
+}
+
+// historyQuery is the query behind GetTrafficHistory
+func historyQuery(duration time.Duration) core.TrafficQuery {
+	now := time.Now()
+	q := core.TrafficQuery{From: now.Add(-duration), To: now, Bucket: time.Minute}
+	if duration > 4*time.Hour {
+		q.Bucket = time.Hour
+	}
+	return q
+}
+
+// alignBucket returns the start of the bucket holding t. Buckets are aligned
+// to local wall-clock time, so day buckets start at local midnight.
+func alignBucket(t time.Time, size time.Duration) time.Time {
+	secs := int64(size / time.Second)
+	_, offset := t.Local().Zone()
+	local := t.Unix() + int64(offset)
+
+	start := local / secs * secs
+	if local < 0 && local%secs != 0 {
+		start -= secs
+	}
+	return time.Unix(start-int64(offset), 0).Local()
+}
+
+// bucketLabel formats a bucket start for chart axes
+func bucketLabel(t time.Time, q core.TrafficQuery) string {
+	switch {
+	case q.Bucket >= day:
+		return t.Format("Jan 2")
+	case q.To.Sub(q.From) > day:
+		return t.Format("Jan 2 15:04")
+	default:
+		return t.Format("15:04")
+	}
+}
+
+// trafficBuckets accumulates traffic into the buckets of a query
+type trafficBuckets struct {
+	q      core.TrafficQuery
+	points map[int64]*core.TrafficDataPoint
+}
+
+func newTrafficBuckets(q core.TrafficQuery) *trafficBuckets {
+	return &trafficBuckets{q: q, points: make(map[int64]*core.TrafficDataPoint)}
+}
+
+func (b *trafficBuckets) add(at time.Time, upload, download, connections, blocked int64) {
+	start := alignBucket(at, b.q.Bucket)
+	p, ok := b.points[start.Unix()]
+	if !ok {
+		p = &core.TrafficDataPoint{Name: bucketLabel(start, b.q), Timestamp: start}
+		b.points[start.Unix()] = p
+	}
+	p.Upload += upload
+	p.Download += download
+	p.Connections += connections
+	p.Blocked += blocked
+}
+
+// result returns the non-empty buckets in time order
+func (b *trafficBuckets) result() []core.TrafficDataPoint {
+	points := make([]core.TrafficDataPoint, 0, len(b.points))
+	for _, p := range b.points {
+		points = append(points, *p)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Timestamp.Before(points[j].Timestamp)
+	})
+	return points
+}
+
+// trafficKey is the domain a log is accounted to, falling back to the IP
+func trafficKey(entry core.LogEntry) string {
+	if entry.Domain != "" {
+		return entry.Domain
+	}
+	return entry.DstIP
+}
+
+// matchesTrafficQuery reports whether a log belongs to the domain or process of a query
+func matchesTrafficQuery(entry core.LogEntry, q core.TrafficQuery) bool {
+	if q.Domain != "" {
+		return trafficKey(entry) == q.Domain
+	}
+	if q.Process != "" {
+		return entry.ProcessName == q.Process
+	}
+	return true
+}
+
+// rollupsFor returns the rollup increments of traffic from a log at a point in time
+func rollupsFor(entry core.LogEntry, at time.Time, upload, download, connections, blocked int64) []core.TrafficRollup {
+	keys := [][2]string{{core.RollupKindDomain, trafficKey(entry)}}
+	if entry.ProcessName != "" {
+		keys = append(keys, [2]string{core.RollupKindProcess, entry.ProcessName})
+	}
+
+	rollups := make([]core.TrafficRollup, 0, len(rollupResolutions)*len(keys))
+	for _, res := range rollupResolutions {
+		bucket := alignBucket(at, res).Unix()
+		for _, k := range keys {
+			rollups = append(rollups, core.TrafficRollup{
+				Resolution:  int64(res / time.Second),
+				Bucket:      bucket,
+				Kind:        k[0],
+				Key:         k[1],
+				Upload:      upload,
+				Download:    download,
+				Connections: connections,
+				Blocked:     blocked,
+			})
+		}
+	}
+	return rollups
+}
+
+type rollupKey struct {
+	resolution int64
+	bucket     int64
+	kind       string
+	key        string
+}
+
+// rollupBatch sums rollup increments before they are written
+type rollupBatch map[rollupKey]*core.TrafficRollup
+
+func (b rollupBatch) add(rollups []core.TrafficRollup) {
+	for _, r := range rollups {
+		k := rollupKey{r.Resolution, r.Bucket, r.Kind, r.Key}
+		if t, ok := b[k]; ok {
+			t.Upload += r.Upload
+			t.Download += r.Download
+			t.Connections += r.Connections
+			t.Blocked += r.Blocked
+		} else {
+			b[k] = &r
+		}
+	}
+}
+
+func (b rollupBatch) list() []core.TrafficRollup {
+	rollups := make([]core.TrafficRollup, 0, len(b))
+	for _, r := range b {
+		rollups = append(rollups, *r)
+	}
+	return rollups
+}
+
+// upsertRollups adds rollup increments to the stored totals
+func upsertRollups(tx *gorm.DB, rollups []core.TrafficRollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "resolution"}, {Name: "bucket"}, {Name: "kind"}, {Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"upload":      gorm.Expr("upload + excluded.upload"),
+			"download":    gorm.Expr("download + excluded.download"),
+			"connections": gorm.Expr("connections + excluded.connections"),
+			"blocked":     gorm.Expr("blocked + excluded.blocked"),
+		}),
+	}).CreateInBatches(rollups, 500).Error
+}
+
+// GetTrafficRange returns rolled up traffic over an arbitrary range and bucket size
+func (s *SQLiteStore) GetTrafficRange(q core.TrafficQuery) []core.TrafficDataPoint {
+	q, resolution := normalizeTrafficQuery(q)
+	s.sync()
+
+	kind, key := core.RollupKindDomain, ""
+	if q.Domain != "" {
+		key = q.Domain
+	} else if q.Process != "" {
+		kind, key = core.RollupKindProcess, q.Process
+	}
+
+	// Buckets starting before To, including a partial second
+	to := q.To.Unix()
+	if q.To.Nanosecond() > 0 {
+		to++
+	}
+
+	query := s.db.Model(&core.TrafficRollup{}).
+		Select("bucket, sum(upload) as upload, sum(download) as download, sum(connections) as connections, sum(blocked) as blocked").
+		Where("resolution = ? AND kind = ? AND bucket >= ? AND bucket < ?",
+			int64(resolution/time.Second), kind, alignBucket(q.From, resolution).Unix(), to)
+	if key != "" {
+		query = query.Where("key = ?", key)
+	}
+
+	var rows []core.TrafficRollup
+	if err := query.Group("bucket").Order("bucket asc").Find(&rows).Error; err != nil {
+		log.Printf("Failed to get traffic range: %v", err)
+		return []core.TrafficDataPoint{}
+	}
+
+	buckets := newTrafficBuckets(q)
+	for _, r := range rows {
+		buckets.add(time.Unix(r.Bucket, 0), r.Upload, r.Download, r.Connections, r.Blocked)
+	}
+	return buckets.result()
+}
+
+// pruneRollups drops minute and hour rollups past their retention
+func (s *SQLiteStore) pruneRollups() error {
+	now := time.Now()
+	limits := map[time.Duration]time.Duration{
+		time.Minute: minuteRollupRetention,
+		time.Hour:   hourRollupRetention,
+	}
+	for res, retention := range limits {
+		s.mu.Lock()
+		err := s.db.Exec("DELETE FROM traffic_rollups WHERE resolution = ? AND bucket < ?",
+			int64(res/time.Second), now.Add(-retention).Unix()).Error
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
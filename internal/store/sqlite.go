@@ -2,7 +2,7 @@ package store
 
 import (
 	"bufio"
-	"fmt"
+	"context"
 	"log"
 	"net/http"
 	"strconv"
@@ -17,206 +17,218 @@ import (
 	"gorm.io/gorm/logger"
 )
 
+// defaultRulesURL is the hosts list seeded as default rules on open, empty disables seeding
+var defaultRulesURL = "https://adaway.org/hosts.txt"
+
 // SQLiteStore persists logs to a database
 type SQLiteStore struct {
-	db          *gorm.DB
-	subscribers []func(core.LogEntry)
-	mu          sync.RWMutex
-	hitCache    sync.Map // Map of [ruleID:domain]time.Time for de-duplication
+	db        *gorm.DB
+	logBroker broker
+	mu        sync.RWMutex
+	hitCache  sync.Map // Map of [ruleID:domain]time.Time for de-duplication
 
 	// Rule Cache
 	cachedRules []core.Rule
 	rulesLoaded bool
 	cacheMu     sync.RWMutex
+
+	// Retention
+	pruneMu   sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Batched writes
+	writer *writer
 }
 
 // NewSQLiteStore creates a new persistent store
 func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+	return NewSQLiteStoreWithOptions(dbPath, DefaultWriterOptions)
+}
+
+// NewSQLiteStoreWithOptions creates a new persistent store with a tuned write pipeline
+func NewSQLiteStoreWithOptions(dbPath string, opts WriterOptions) (*SQLiteStore, error) {
+	// WAL lets readers run alongside the batched writer; NORMAL sync is safe with WAL.
+	// auto_vacuum only takes effect on a new database, before WAL writes its header.
+	dsn := dbPath + "?_pragma=auto_vacuum(2)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Auto-migrate schema
-	if err := db.AutoMigrate(&core.LogEntry{}, &core.TrafficStatsModel{}, &core.Rule{}, &core.AppSetting{}, &core.AdblockFilter{}); err != nil {
-		return nil, err
-	}
-
-	// Initialize Stats if not present or resync
-	var stats core.TrafficStatsModel
-	if err := db.First(&stats, "id = ?", "global").Error; err != nil {
-		// Not found, create it with sum from logs (Migration logic)
-		var result struct {
-			Upload   int64
-			Download int64
-		}
-		db.Model(&core.LogEntry{}).Select("ifnull(sum(bytes_sent),0) as upload, ifnull(sum(bytes_recv),0) as download").Scan(&result)
-
-		stats = core.TrafficStatsModel{
-			ID:            "global",
-			TotalUpload:   result.Upload,
-			TotalDownload: result.Download,
+	if err := migrate(db, dbPath); err != nil {
+		if sqlDB, dbErr := db.DB(); dbErr == nil {
+			sqlDB.Close()
 		}
-		db.Create(&stats)
-		log.Printf("Initialized global stats from logs: Up=%d, Down=%d", stats.TotalUpload, stats.TotalDownload)
+		return nil, err
 	}
-
 	// Seed Default Rules if empty
 	s := &SQLiteStore{
-		db: db,
+		db:     db,
+		done:   make(chan struct{}),
+		writer: newWriter(opts),
 	}
+	go s.runWriter()
 
 	go func() {
 		s.seedDefaultRules()
 	}()
 
+	go s.runPruner()
+
 	return s, nil
 }
 
-// Subscribe adds a listener
-func (s *SQLiteStore) Subscribe(callback func(core.LogEntry)) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.subscribers = append(s.subscribers, callback)
+// Close stops background work and closes the database
+func (s *SQLiteStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.closeWriter()
+		s.logBroker.close()
+	})
+
+	// Wait for a running prune to finish
+	s.pruneMu.Lock()
+	defer s.pruneMu.Unlock()
+
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
 }
 
-// AddLog adds a log entry
-func (s *SQLiteStore) AddLog(entry core.LogEntry) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// SubscribeLogs streams logs as they are committed, until ctx is done or the store closes
+func (s *SQLiteStore) SubscribeLogs(ctx context.Context, opts SubscribeOptions) *Subscription {
+	return s.logBroker.subscribe(ctx, opts)
+}
 
+// AddLog queues a log entry for the next batch
+func (s *SQLiteStore) AddLog(entry core.LogEntry) {
 	// Ensure ID is set
 	if entry.ID == "" {
 		entry.ID = utils.GenerateIDString() // Fallback ID
 	}
-
-	// Use Transaction to ensure Log and Stats are in sync
-	err := s.db.Transaction(func(tx *gorm.DB) error {
-		// 1. Persist Log
-		if err := tx.Create(&entry).Error; err != nil {
-			return err
-		}
-
-		// 2. Update Stats Atomic
-		if entry.BytesSent > 0 || entry.BytesRecv > 0 {
-			if err := tx.Model(&core.TrafficStatsModel{}).Where("id = ?", "global").
-				Updates(map[string]interface{}{
-					"total_upload":   gorm.Expr("total_upload + ?", entry.BytesSent),
-					"total_download": gorm.Expr("total_download + ?", entry.BytesRecv),
-					"timestamp":      time.Now(),
-				}).Error; err != nil {
-				return err
-			}
-		}
-		return nil
-	})
-
-	if err != nil {
-		log.Printf("Failed to save log and stats: %v", err)
-	}
-
-	// Notify subscribers
-	for _, sub := range s.subscribers {
-		go sub(entry)
-	}
+	s.enqueue(writeOp{kind: opAddLog, entry: entry}, s.writer.opts.LogPolicy)
 }
 
-// UpdateLog updates an existing log entry
+// UpdateLog queues a partial update of an existing log entry. Only non-zero
+// fields are applied; subscribers receive the merged entry.
 func (s *SQLiteStore) UpdateLog(entry core.LogEntry) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Update only the non-zero fields (GORM Updates behavior) to avoid overwriting existing data with empty values
-	if err := s.db.Model(&core.LogEntry{}).Where("id = ?", entry.ID).Updates(entry).Error; err != nil {
-		log.Printf("Failed to update log: %v", err)
-	}
-
-	// Notify subscribers
-	// Note: entry here might be partial. Subscribers might need full entry.
-	// For now, we broadcast the partial entry. Frontend might merge or replace.
-	// If frontend replaces, we lose data in UI until refresh.
-	// Ideally we should fetch updated entry or merge before broadcast.
-	// But let's stick to simple for now.
-	for _, sub := range s.subscribers {
-		go sub(entry)
-	}
+	s.enqueue(writeOp{kind: opUpdateLog, entry: entry}, s.writer.opts.LogPolicy)
 }
 
 // AddTraffic increments persistent traffic stats explicitly
 func (s *SQLiteStore) AddTraffic(upload, download int64) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	if upload == 0 && download == 0 {
 		return
 	}
-
-	if err := s.db.Model(&core.TrafficStatsModel{}).Where("id = ?", "global").
-		Updates(map[string]interface{}{
-			"total_upload":   gorm.Expr("total_upload + ?", upload),
-			"total_download": gorm.Expr("total_download + ?", download),
-			"timestamp":      time.Now(),
-		}).Error; err != nil {
-		log.Printf("Failed to update traffic stats: %v", err)
-	}
+	s.enqueue(writeOp{kind: opTraffic, upload: upload, download: download}, s.writer.opts.LogPolicy)
 }
 
 // GetRecentLogs returns the last N logs
 func (s *SQLiteStore) GetRecentLogs(limit int) []core.LogEntry {
+	s.sync()
 	var logs []core.LogEntry
 	s.db.Order("id desc").Limit(limit).Find(&logs)
 	return logs
 }
 
+// GetLogsPaginated searches logs with the query syntax of ParseLogQuery, narrowed by status and type
 func (s *SQLiteStore) GetLogsPaginated(cursor string, limit int, search, status, logType string) ([]core.LogEntry, string, bool, int64, error) {
-	var logs []core.LogEntry
-	var total int64
+	q, err := ParseLogQuery(search)
+	if err != nil {
+		return nil, "", false, 0, err
+	}
+	page, err := s.SearchLogs(cursor, limit, q.WithFilters(status, logType))
+	return page.Logs, page.NextCursor, page.HasMore, page.Total, err
+}
 
-	query := s.db.Model(&core.LogEntry{})
+// Counting every match of a large result set is slow, beyond this the total is estimated
+var (
+	exactCountLimit int64 = 10000
+	countSampleSize int64 = 20000
+)
 
-	if search != "" {
-		likePattern := "%" + search + "%"
-		query = query.Where("(domain LIKE ? OR process_name LIKE ? OR dst_ip LIKE ?)", likePattern, likePattern, likePattern)
-	}
+// SearchLogs returns a page of matching logs, newest first
+func (s *SQLiteStore) SearchLogs(cursor string, limit int, q LogQuery) (core.PaginatedLogs, error) {
+	s.sync()
 
-	if status != "" && status != "all" {
-		query = query.Where("status = ?", status)
+	page := core.PaginatedLogs{Logs: []core.LogEntry{}}
+	total, approximate, err := s.countLogs(q)
+	if err != nil {
+		return page, err
 	}
+	page.Total, page.Approximate = total, approximate
 
-	if logType != "" && logType != "all" {
-		query = query.Where("type = ?", logType)
+	if page.Logs, err = s.findLogs(cursor, limit+1, q); err != nil {
+		return page, err
 	}
-
-	if err := query.Count(&total).Error; err != nil {
-		return nil, "", false, 0, err
+	if len(page.Logs) > limit {
+		page.HasMore = true
+		page.Logs = page.Logs[:limit]
 	}
+	if len(page.Logs) > 0 {
+		page.NextCursor = page.Logs[len(page.Logs)-1].ID
+	}
+	return page, nil
+}
 
+// ScanLogs returns up to limit logs older than cursor matching q, newest
+// first. Exports page through every match with it, skipping the count.
+func (s *SQLiteStore) ScanLogs(cursor string, limit int, q LogQuery) ([]core.LogEntry, error) {
+	s.sync()
+	return s.findLogs(cursor, limit, q)
+}
+
+func (s *SQLiteStore) findLogs(cursor string, limit int, q LogQuery) ([]core.LogEntry, error) {
+	logs := []core.LogEntry{}
+	query := q.apply(s.db.Model(&core.LogEntry{}))
 	if cursor != "" {
 		query = query.Where("id < ?", cursor)
 	}
+	err := query.Order("id desc").Limit(limit).Find(&logs).Error
+	return logs, err
+}
 
-	if err := query.Order("id desc").Limit(limit + 1).Find(&logs).Error; err != nil {
-		return nil, "", false, 0, err
+// countLogs counts matches exactly up to exactCountLimit. Larger totals are
+// estimated from the rowid span and the match rate among the newest logs.
+func (s *SQLiteStore) countLogs(q LogQuery) (int64, bool, error) {
+	var count int64
+	capped := q.apply(s.db.Model(&core.LogEntry{})).Select("1").Limit(int(exactCountLimit) + 1)
+	if err := s.db.Raw("SELECT count(*) FROM (?)", capped).Scan(&count).Error; err != nil {
+		return 0, false, err
 	}
-
-	hasMore := false
-	nextCursor := ""
-	if len(logs) > limit {
-		hasMore = true
-		logs = logs[:limit]
+	if count <= exactCountLimit {
+		return count, false, nil
 	}
 
-	if len(logs) > 0 {
-		nextCursor = logs[len(logs)-1].ID
+	var bounds struct {
+		Low  int64
+		High int64
+	}
+	if err := s.db.Raw("SELECT ifnull(min(rowid), 0) AS low, ifnull(max(rowid), 0) AS high FROM log_entries").Scan(&bounds).Error; err != nil {
+		return 0, false, err
+	}
+	span := bounds.High - bounds.Low + 1
+	if q.IsEmpty() {
+		return max(span, count), true, nil
 	}
 
-	return logs, nextCursor, hasMore, total, nil
+	var sampled int64
+	sample := q.apply(s.db.Model(&core.LogEntry{})).Where("log_entries.rowid > ?", bounds.High-countSampleSize)
+	if err := sample.Count(&sampled).Error; err != nil {
+		return 0, false, err
+	}
+	estimate := sampled * span / min(span, countSampleSize)
+	return max(estimate, count), true, nil
 }
 
 // GetStats calculates stats from DB
 func (s *SQLiteStore) GetStats() core.Stats {
+	s.sync()
 	var stats core.Stats
 
 	// Get Totals from TrafficStatsModel (Fast)
@@ -261,95 +273,41 @@ func (s *SQLiteStore) GetStats() core.Stats {
 		stats.AdblockHits = hits
 	}
 
+	// Top Adblock Filters
+	var topFilters []core.AdblockFilterHit
+	s.db.Order("hits desc").Limit(5).Find(&topFilters)
+	stats.TopFilters = make(map[string]int64)
+	for _, f := range topFilters {
+		stats.TopFilters[f.Filter] = f.Hits
+	}
+
 	stats.Timestamp = time.Now()
 	return stats
 }
 
-// GetTrafficHistory returns traffic stats grouped by time
+// GetTrafficHistory returns traffic of the last duration, per minute or per hour beyond 4 hours
 func (s *SQLiteStore) GetTrafficHistory(duration time.Duration) []core.TrafficDataPoint {
-	var points []core.TrafficDataPoint
-
-	// SQLite specific query to group by minute (or rough interval)
-	threshold := time.Now().Add(-duration)
-
-	// Determined Interval based on duration
-	// For < 3h, group by minute. For > 3h (e.g. 24h), group by hour maybe?
-	// For simplicity, let's keep grouping by minute for < 24h, or 5-min for 24h.
-	// Actually, for 24h charts, 1440 points is fine for Recharts, but maybe heavy.
-	// Let's stick to minute-grouping for < 4h, and hour-grouping for > 4h.
-	var format string
-	if duration.Hours() > 4 {
-		format = "%Y-%m-%d %H:00" // Group by Hour
-	} else {
-		format = "%Y-%m-%d %H:%M" // Group by Minute
-	}
-
-	// Group by using Local Time string for grouping, but we need parsed time for sorting/struct
-	// We select max(timestamp) or min(timestamp) in group to represent the bucket.
-	// strftime('%Y-%m-%d %H:%M', timestamp, 'localtime') -> 2023-12-18 10:05
-	query := fmt.Sprintf(`
-		SELECT 
-			strftime('%s', timestamp, 'localtime') as bucket_str, 
-			sum(bytes_sent) as upload, 
-			sum(bytes_recv) as download
-		FROM log_entries 
-		WHERE timestamp > ? 
-		GROUP BY bucket_str 
-		ORDER BY bucket_str ASC
-	`, format)
-
-	rows, err := s.db.Raw(query, threshold).Rows()
-
-	if err != nil {
-		log.Printf("Failed to get traffic history: %v", err)
-		return points
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var p core.TrafficDataPoint
-		var bucketStr string
-		rows.Scan(&bucketStr, &p.Upload, &p.Download)
-
-		// Parse back the time
-		// If using format, we get string.
-		// Actually, let's just use the formatted string for both name and parsing?
-		// Since we use localtime in query, we need to be careful with time.Parse assuming UTC or Local.
-		// NOTE: strftime('%Y-%m-%d %H:%M'...) returns string.
-		// Let's assume bucketStr is "YYYY-MM-DD HH:MM"
-
-		// Simplified approach: just parse it.
-		// For chart display name, we'll format in frontend or here.
-		// Let's send full timestamp object.
-
-		t, err := time.ParseInLocation("2006-01-02 15:04", bucketStr, time.Local)
-		if err == nil {
-			p.Timestamp = t
-			p.Name = t.Format("15:04") // Default label, frontend can override
-		} else {
-			// Fallback
-			p.Name = bucketStr
-		}
-
-		points = append(points, p)
-	}
-
-	return points
+	return s.GetTrafficRange(historyQuery(duration))
 }
 
-// Subscribe adds a listener
+// ResetData clears logs, stats and hit counters. Rules, filter lists and settings are kept.
 func (s *SQLiteStore) ResetData() {
+	s.sync()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	// Use Exec for direct deletion to bypass GORM's global delete protection if enabled
 	// and to ensure efficient clearing.
-	s.db.Exec("DELETE FROM traffic_stats_models WHERE id = ?", "global")
 	s.db.Exec("DELETE FROM log_entries")
-	s.db.Exec("DELETE FROM traffic_stats_models")
-	s.db.Exec("DELETE FROM stats")
-	s.db.Exec("DELETE FROM settings")
-	s.db.Exec("DELETE FROM adblock_filters")
+	s.db.Exec("UPDATE traffic_stats_models SET total_upload = 0, total_download = 0, timestamp = ? WHERE id = ?", time.Now(), "global")
+	// The lists stay, the seeding won't offer the defaults again
+	s.db.Exec("UPDATE adblock_filters SET hits = 0")
+	s.db.Exec("DELETE FROM adblock_filter_hits")
+	s.db.Exec("DELETE FROM traffic_rollups")
+	s.db.Exec("DELETE FROM processes")
 	s.db.Exec("UPDATE rules SET hit_count = 0")
 
-	s.SetSetting("adblock_hits", "0")
+	s.db.Save(&core.AppSetting{Key: "adblock_hits", Value: "0"})
 }
 
 // Rule Management Implementation
@@ -386,6 +344,8 @@ func (s *SQLiteStore) GetRules() []core.Rule {
 }
 
 func (s *SQLiteStore) GetRulesPaginated(page, pageSize int, search string) ([]core.Rule, int64, error) {
+	s.sync()
+
 	var rules []core.Rule
 	var total int64
 
@@ -467,7 +427,10 @@ func (s *SQLiteStore) SetSetting(key, value string) error {
 
 // seedDefaultRules fetches and applies a default blocklist
 func (s *SQLiteStore) seedDefaultRules() {
-	url := "https://adaway.org/hosts.txt"
+	url := defaultRulesURL
+	if url == "" {
+		return
+	}
 	log.Println("Seeding default rules from", url)
 
 	// Create a client that explicitly bypasses system proxy
@@ -568,7 +531,8 @@ func (s *SQLiteStore) IncrementRuleHit(id string, domain string) error {
 	}
 	s.hitCache.Store(cacheKey, now)
 
-	return s.db.Model(&core.Rule{}).Where("id = ?", id).Update("hit_count", gorm.Expr("hit_count + 1")).Error
+	s.enqueue(writeOp{kind: opRuleHit, id: id}, s.writer.opts.CounterPolicy)
+	return nil
 }
 
 func (s *SQLiteStore) IncrementAdblockHit(domain string) error {
@@ -582,16 +546,18 @@ func (s *SQLiteStore) IncrementAdblockHit(domain string) error {
 	}
 	s.hitCache.Store(cacheKey, now)
 
-	// Fetch current hits
-	currentHits := int64(0)
-	val, err := s.GetSetting("adblock_hits")
-	if err == nil {
-		currentHits, _ = strconv.ParseInt(val, 10, 64)
+	s.enqueue(writeOp{kind: opAdblockHit}, s.writer.opts.CounterPolicy)
+	return nil
+}
+
+// IncrementAdblockFilterHit attributes a block to the filter that matched and its list
+func (s *SQLiteStore) IncrementAdblockFilterHit(listID, filter string) error {
+	if filter == "" {
+		return nil
 	}
 
-	// Increment and save
-	currentHits++
-	return s.SetSetting("adblock_hits", strconv.FormatInt(currentHits, 10))
+	s.enqueue(writeOp{kind: opFilterHit, id: listID, filter: filter}, s.writer.opts.CounterPolicy)
+	return nil
 }
 
 func (s *SQLiteStore) AddAdblockFilter(filter core.AdblockFilter) error {
@@ -599,6 +565,7 @@ func (s *SQLiteStore) AddAdblockFilter(filter core.AdblockFilter) error {
 }
 
 func (s *SQLiteStore) GetAdblockFilters() []core.AdblockFilter {
+	s.sync()
 	var filters []core.AdblockFilter
 	s.db.Find(&filters)
 	return filters
@@ -615,3 +582,19 @@ func (s *SQLiteStore) UpdateAdblockFilter(filter core.AdblockFilter) error {
 func (s *SQLiteStore) ClearAdblockFilters() error {
 	return s.db.Exec("DELETE FROM adblock_filters").Error
 }
+
+// Process Implementation
+
+// SaveProcess queues the process for the writer, it's called for every
+// new connection
+func (s *SQLiteStore) SaveProcess(p core.Process) error {
+	s.enqueue(writeOp{kind: opProcess, process: p}, s.writer.opts.LogPolicy)
+	return nil
+}
+
+func (s *SQLiteStore) GetProcess(key string) (core.Process, error) {
+	s.sync()
+	var p core.Process
+	err := s.db.First(&p, "key = ?", key).Error
+	return p, err
+}
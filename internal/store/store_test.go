@@ -0,0 +1,1063 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/utils"
+	"gorm.io/gorm"
+)
+
+func init() {
+	// Never hit the network from tests
+	defaultRulesURL = ""
+}
+
+// storeFactories returns a constructor for every Store implementation,
+// so each conformance test runs against all of them
+func storeFactories() map[string]func(t *testing.T) Store {
+	return map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store {
+			return NewMemoryStore()
+		},
+		"sqlite": func(t *testing.T) Store {
+			s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "custos.db"))
+			if err != nil {
+				t.Fatalf("NewSQLiteStore: %v", err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+}
+
+func runConformance(t *testing.T, test func(t *testing.T, s Store)) {
+	for name, newStore := range storeFactories() {
+		t.Run(name, func(t *testing.T) {
+			test(t, newStore(t))
+		})
+	}
+}
+
+func TestStoreSettings(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		if _, err := s.GetSetting("missing"); err == nil {
+			t.Errorf("GetSetting of a missing key should fail")
+		}
+
+		s.SetSetting("proxy_port", "1080")
+		s.SetSetting("proxy_port", "1081")
+		if val, err := s.GetSetting("proxy_port"); err != nil || val != "1081" {
+			t.Errorf("GetSetting = %q, %v; want 1081", val, err)
+		}
+	})
+}
+
+func TestStoreRules(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		custom := core.Rule{ID: utils.GenerateIDString(), Type: core.RuleBlock, Pattern: "*.ads.com", Enabled: true, Source: core.RuleSourceCustom,
+			ProcessPath: "/usr/bin/curl", ProcessUser: "alice"}
+		def1 := core.Rule{ID: utils.GenerateIDString(), Type: core.RuleBlock, Pattern: "tracker.net", Enabled: true, Source: core.RuleSourceDefault}
+		def2 := core.Rule{ID: utils.GenerateIDString(), Type: core.RuleAllow, Pattern: "cdn.ads.com", Enabled: true, Source: core.RuleSourceDefault}
+		for _, r := range []core.Rule{def1, custom, def2} {
+			if err := s.AddRule(r); err != nil {
+				t.Fatalf("AddRule: %v", err)
+			}
+		}
+
+		if got := len(s.GetRules()); got != 3 {
+			t.Fatalf("GetRules returned %d rules; want 3", got)
+		}
+		for _, r := range s.GetRules() {
+			if r.ID == custom.ID && (r.ProcessPath != custom.ProcessPath || r.ProcessUser != custom.ProcessUser) {
+				t.Errorf("process matchers = %q, %q; want %q, %q", r.ProcessPath, r.ProcessUser, custom.ProcessPath, custom.ProcessUser)
+			}
+		}
+
+		// Custom first, then newest first
+		rules, total, err := s.GetRulesPaginated(1, 2, "")
+		if err != nil || total != 3 || len(rules) != 2 {
+			t.Fatalf("GetRulesPaginated = %d rules, total %d, %v; want 2, 3", len(rules), total, err)
+		}
+		if rules[0].ID != custom.ID || rules[1].ID != def2.ID {
+			t.Errorf("GetRulesPaginated order = %s, %s; want %s, %s", rules[0].Pattern, rules[1].Pattern, custom.Pattern, def2.Pattern)
+		}
+		rules, _, _ = s.GetRulesPaginated(2, 2, "")
+		if len(rules) != 1 || rules[0].ID != def1.ID {
+			t.Errorf("GetRulesPaginated page 2 = %v; want [%s]", rules, def1.Pattern)
+		}
+		if _, total, _ := s.GetRulesPaginated(1, 10, "ads"); total != 2 {
+			t.Errorf("GetRulesPaginated search total = %d; want 2", total)
+		}
+
+		// Toggling keeps the other fields
+		if err := s.UpdateRule(core.Rule{ID: custom.ID, Enabled: false}); err != nil {
+			t.Fatalf("UpdateRule: %v", err)
+		}
+		s.IncrementRuleHit(custom.ID, "x.ads.com")
+		rules, _, _ = s.GetRulesPaginated(1, 1, "")
+		if rules[0].Enabled || rules[0].Pattern != custom.Pattern || rules[0].HitCount != 1 {
+			t.Errorf("updated rule = %+v; want disabled %s with 1 hit", rules[0], custom.Pattern)
+		}
+
+		s.DeleteRule(def1.ID)
+		if got := len(s.GetRules()); got != 2 {
+			t.Errorf("GetRules after delete returned %d rules; want 2", got)
+		}
+	})
+}
+
+func TestStoreAdblockFilters(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		easy := core.AdblockFilter{ID: utils.GenerateIDString(), Name: "EasyList", URL: "https://example.com/easylist.txt", Enabled: true}
+		privacy := core.AdblockFilter{ID: utils.GenerateIDString(), Name: "EasyPrivacy", URL: "https://example.com/easyprivacy.txt", Enabled: true}
+		s.AddAdblockFilter(easy)
+		s.AddAdblockFilter(privacy)
+		if err := s.AddAdblockFilter(easy); err == nil {
+			t.Errorf("AddAdblockFilter with a duplicate ID should fail")
+		}
+
+		easy.Enabled = false
+		easy.UpdateInterval = 3600
+		s.UpdateAdblockFilter(easy)
+
+		s.IncrementAdblockFilterHit(privacy.ID, "||tracker.com^")
+		s.IncrementAdblockFilterHit(privacy.ID, "||tracker.com^")
+
+		filters := make(map[string]core.AdblockFilter)
+		for _, f := range s.GetAdblockFilters() {
+			filters[f.ID] = f
+		}
+		if len(filters) != 2 {
+			t.Fatalf("GetAdblockFilters returned %d filters; want 2", len(filters))
+		}
+		if f := filters[easy.ID]; f.Enabled || f.UpdateInterval != 3600 {
+			t.Errorf("updated filter = %+v; want disabled with interval 3600", f)
+		}
+		if f := filters[privacy.ID]; f.Hits != 2 {
+			t.Errorf("filter hits = %d; want 2", f.Hits)
+		}
+		if hits := s.GetStats().TopFilters["||tracker.com^"]; hits != 2 {
+			t.Errorf("TopFilters hits = %d; want 2", hits)
+		}
+
+		s.DeleteAdblockFilter(easy.ID)
+		if got := len(s.GetAdblockFilters()); got != 1 {
+			t.Errorf("GetAdblockFilters after delete returned %d filters; want 1", got)
+		}
+		s.ClearAdblockFilters()
+		if got := len(s.GetAdblockFilters()); got != 0 {
+			t.Errorf("GetAdblockFilters after clear returned %d filters; want 0", got)
+		}
+	})
+}
+
+// addTestLogs adds logs oldest first and returns them in insertion order
+func addTestLogs(s Store) []core.LogEntry {
+	now := time.Now()
+	entries := []core.LogEntry{
+		{Type: core.LogSourceProxy, Domain: "www.example.com", ProcessName: "firefox", Status: core.LogStatusAllowed, BytesSent: 100, BytesRecv: 1000},
+		{Type: core.LogSourceProxy, Domain: "ads.example.com", ProcessName: "firefox", Status: core.LogStatusBlocked},
+		{Type: core.LogSourceDNS, Domain: "api.github.com", Status: core.LogStatusAllowed},
+		{Type: core.LogSourceProxy, DstIP: "10.0.0.1", ProcessName: "curl", Status: core.LogStatusAllowed, BytesSent: 10, BytesRecv: 20},
+		{Type: core.LogSourceProxy, Domain: "tracker.net", ProcessName: "chrome", Status: core.LogStatusBlocked},
+	}
+	for i := range entries {
+		entries[i].ID = utils.GenerateIDString()
+		entries[i].Timestamp = now.Add(time.Duration(i-len(entries)) * time.Second)
+		s.AddLog(entries[i])
+	}
+	return entries
+}
+
+func TestStoreLogs(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		entries := addTestLogs(s)
+
+		recent := s.GetRecentLogs(2)
+		if len(recent) != 2 || recent[0].ID != entries[4].ID || recent[1].ID != entries[3].ID {
+			t.Errorf("GetRecentLogs should return the newest logs first")
+		}
+
+		// Walk all pages
+		var seen []string
+		cursor := ""
+		for page := 0; page < 5; page++ {
+			logs, next, hasMore, total, err := s.GetLogsPaginated(cursor, 2, "", "all", "all")
+			if err != nil || total != 5 {
+				t.Fatalf("GetLogsPaginated total = %d, %v; want 5", total, err)
+			}
+			for _, l := range logs {
+				seen = append(seen, l.ID)
+			}
+			if !hasMore {
+				break
+			}
+			cursor = next
+		}
+		if len(seen) != 5 || seen[0] != entries[4].ID || seen[4] != entries[0].ID {
+			t.Errorf("paginated IDs = %v; want all 5 logs newest first", seen)
+		}
+
+		filters := []struct {
+			search, status, logType string
+			want                    int64
+		}{
+			{"EXAMPLE", "", "", 2},
+			{"firefox", "", "", 2},
+			{"10.0.0", "", "", 1},
+			{"", core.LogStatusBlocked, "all", 2},
+			{"", "all", core.LogSourceDNS, 1},
+			{"example", core.LogStatusBlocked, core.LogSourceProxy, 1},
+		}
+		for _, f := range filters {
+			logs, _, _, total, _ := s.GetLogsPaginated("", 10, f.search, f.status, f.logType)
+			if total != f.want || int64(len(logs)) != f.want {
+				t.Errorf("GetLogsPaginated(%q, %q, %q) = %d logs, total %d; want %d", f.search, f.status, f.logType, len(logs), total, f.want)
+			}
+		}
+
+		// Partial updates are merged
+		s.UpdateLog(core.LogEntry{ID: entries[2].ID, BytesSent: 5, BytesRecv: 7})
+		logs, _, _, _, _ := s.GetLogsPaginated("", 1, "api.github.com", "", "")
+		if len(logs) != 1 || logs[0].BytesSent != 5 || logs[0].BytesRecv != 7 || logs[0].Domain != "api.github.com" || logs[0].Status != core.LogStatusAllowed {
+			t.Errorf("UpdateLog result = %+v; want merged entry", logs)
+		}
+	})
+}
+
+func TestStoreSearchLogs(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		entries := addTestLogs(s)
+		extra := core.LogEntry{ID: utils.GenerateIDString(), Timestamp: time.Now().Add(-48 * time.Hour), Type: core.LogSourceProxy,
+			Domain: "example.com", DstIP: "93.184.216.34", DstPort: 8443, ProcessName: "Google Chrome", Status: core.LogStatusAllowed, BytesRecv: 3 << 20}
+		s.AddLog(extra)
+		entries = append(entries, extra)
+
+		tests := []struct {
+			query string
+			want  []int // Indexes into entries, by descending ID
+		}{
+			{"", []int{5, 4, 3, 2, 1, 0}},
+			{"example", []int{5, 1, 0}},
+			{"EXAMPLE.COM firefox", []int{1, 0}},
+			{"10.0", []int{3}},
+			{"domain:*.example.com", []int{5, 1, 0}},
+			{"domain:www.*", []int{0}},
+			{"process:fire*", []int{1, 0}},
+			{`process:"google chrome"`, []int{5}},
+			{"process:curl process:chrome", []int{4, 3}},
+			{"status:blocked", []int{4, 1}},
+			{"type:dns", []int{2}},
+			{"port:8443", []int{5}},
+			{"ip:93.184.*", []int{5}},
+			{"since:1d", []int{4, 3, 2, 1, 0}},
+			{"until:1d", []int{5}},
+			{"bytes>1KB", []int{5, 0}},
+			{"recv>=1MB", []int{5}},
+			{"sent:10", []int{3}},
+			{"bytes<1 status:allowed", []int{2}},
+			{"nothing-matches", nil},
+		}
+		for _, tt := range tests {
+			q, err := ParseLogQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseLogQuery(%q): %v", tt.query, err)
+			}
+			page, err := s.SearchLogs("", 10, q)
+			if err != nil {
+				t.Fatalf("SearchLogs(%q): %v", tt.query, err)
+			}
+			var got, want []string
+			for _, l := range page.Logs {
+				got = append(got, l.ID)
+			}
+			for _, i := range tt.want {
+				want = append(want, entries[i].ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) || page.Total != int64(len(want)) || page.Approximate {
+				t.Errorf("SearchLogs(%q) = %v (total %d); want %v", tt.query, got, page.Total, want)
+			}
+
+			// Scanning two at a time finds the same logs
+			var scanned []string
+			for cursor := ""; ; {
+				logs, err := s.ScanLogs(cursor, 2, q)
+				if err != nil {
+					t.Fatalf("ScanLogs(%q): %v", tt.query, err)
+				}
+				for _, l := range logs {
+					scanned = append(scanned, l.ID)
+				}
+				if len(logs) < 2 {
+					break
+				}
+				cursor = logs[len(logs)-1].ID
+			}
+			if fmt.Sprint(scanned) != fmt.Sprint(want) {
+				t.Errorf("ScanLogs(%q) = %v; want %v", tt.query, scanned, want)
+			}
+		}
+
+		// The legacy filters combine with the query
+		logs, _, _, total, err := s.GetLogsPaginated("", 10, "firefox", core.LogStatusBlocked, "all")
+		if err != nil || total != 1 || len(logs) != 1 || logs[0].ID != entries[1].ID {
+			t.Errorf("GetLogsPaginated(firefox, blocked) = %d logs (total %d, err %v); want the blocked firefox log", len(logs), total, err)
+		}
+	})
+}
+
+func TestSQLiteStoreSearchIndex(t *testing.T) {
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	entries := addTestLogs(s)
+	search := func(query string) int64 {
+		t.Helper()
+		q, _ := ParseLogQuery(query)
+		page, err := s.SearchLogs("", 10, q)
+		if err != nil {
+			t.Fatalf("SearchLogs(%q): %v", query, err)
+		}
+		return page.Total
+	}
+
+	// Updates and deletes keep the full-text index in sync
+	s.UpdateLog(core.LogEntry{ID: entries[3].ID, Domain: "renamed.org"})
+	if n := search("renamed"); n != 1 {
+		t.Errorf("search after update = %d; want 1", n)
+	}
+	s.ResetData()
+	if n := search("example"); n != 0 {
+		t.Errorf("search after reset = %d; want 0", n)
+	}
+}
+
+func TestSQLiteStoreApproximateCount(t *testing.T) {
+	limit, sample := exactCountLimit, countSampleSize
+	exactCountLimit, countSampleSize = 10, 20
+	defer func() { exactCountLimit, countSampleSize = limit, sample }()
+
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 40; i++ {
+		status := core.LogStatusAllowed
+		if i%4 == 0 {
+			status = core.LogStatusBlocked
+		}
+		s.AddLog(core.LogEntry{ID: utils.GenerateIDString(), Timestamp: time.Now(), Domain: fmt.Sprintf("host%d.example.com", i), Status: status})
+	}
+
+	page, err := s.SearchLogs("", 5, LogQuery{})
+	if err != nil || !page.Approximate || page.Total != 40 {
+		t.Errorf("unfiltered total = %d (approximate %v, err %v); want an estimate of 40", page.Total, page.Approximate, err)
+	}
+	page, _ = s.SearchLogs("", 5, LogQuery{Statuses: []string{core.LogStatusAllowed}})
+	if !page.Approximate || page.Total < 11 || page.Total > 40 {
+		t.Errorf("filtered total = %d (approximate %v); want an estimate of about 30", page.Total, page.Approximate)
+	}
+	page, _ = s.SearchLogs("", 5, LogQuery{Statuses: []string{core.LogStatusBlocked}})
+	if page.Approximate || page.Total != 10 {
+		t.Errorf("small total = %d (approximate %v); want exactly 10", page.Total, page.Approximate)
+	}
+	if len(page.Logs) != 5 || !page.HasMore {
+		t.Errorf("page has %d logs (has more %v); want 5 and more", len(page.Logs), page.HasMore)
+	}
+}
+
+func TestStoreStats(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		addTestLogs(s)
+		s.AddTraffic(1, 2)
+		s.IncrementAdblockHit("ads.example.com")
+		s.IncrementAdblockHit("tracker.net")
+
+		stats := s.GetStats()
+		if stats.TotalUpload != 111 || stats.TotalDownload != 1022 {
+			t.Errorf("totals = %d/%d; want 111/1022", stats.TotalUpload, stats.TotalDownload)
+		}
+		if stats.TopDomains["www.example.com"] != 1100 || stats.TopDomains["10.0.0.1"] != 30 {
+			t.Errorf("TopDomains = %v; want www.example.com=1100 and 10.0.0.1=30", stats.TopDomains)
+		}
+		if stats.AdblockHits != 2 {
+			t.Errorf("AdblockHits = %d; want 2", stats.AdblockHits)
+		}
+
+		var up, down int64
+		for _, p := range s.GetTrafficHistory(time.Hour) {
+			up += p.Upload
+			down += p.Download
+			if p.Timestamp.IsZero() || p.Name == "" {
+				t.Errorf("traffic point %+v should carry its bucket time", p)
+			}
+		}
+		if up != 110 || down != 1020 {
+			t.Errorf("traffic history = %d/%d; want 110/1020", up, down)
+		}
+	})
+}
+
+func TestStoreSubscribe(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		all := s.SubscribeLogs(ctx, SubscribeOptions{})
+		blocked := s.SubscribeLogs(ctx, SubscribeOptions{Query: LogQuery{Statuses: []string{core.LogStatusBlocked}}})
+
+		receive := func(sub *Subscription) core.LogEntry {
+			t.Helper()
+			select {
+			case entry := <-sub.C:
+				return entry
+			case <-time.After(2 * time.Second):
+				t.Fatalf("subscriber was not notified")
+			}
+			return core.LogEntry{}
+		}
+
+		id := utils.GenerateIDString()
+		s.AddLog(core.LogEntry{ID: id, Timestamp: time.Now(), Domain: "example.com", ProcessName: "firefox", Status: core.LogStatusAllowed})
+		if entry := receive(all); entry.ID != id {
+			t.Errorf("subscriber got %s; want %s", entry.ID, id)
+		}
+
+		// Updates deliver the whole merged entry
+		s.UpdateLog(core.LogEntry{ID: id, BytesRecv: 500})
+		if entry := receive(all); entry.ID != id || entry.Domain != "example.com" || entry.ProcessName != "firefox" || entry.BytesRecv != 500 {
+			t.Errorf("update delivered %+v; want the merged entry", entry)
+		}
+
+		// Filtered subscriptions only see matching entries
+		blockedID := utils.GenerateIDString()
+		s.AddLog(core.LogEntry{ID: blockedID, Timestamp: time.Now(), Domain: "ads.com", Status: core.LogStatusBlocked})
+		if entry := receive(blocked); entry.ID != blockedID {
+			t.Errorf("filtered subscriber got %s; want the blocked log", entry.ID)
+		}
+		receive(all)
+
+		// Cancelling closes the channel
+		cancel()
+		for _, sub := range []*Subscription{all, blocked} {
+			select {
+			case _, ok := <-sub.C:
+				if ok {
+					t.Errorf("cancelled subscription received an entry")
+				}
+			case <-time.After(2 * time.Second):
+				t.Errorf("cancelled subscription was not closed")
+			}
+		}
+		s.AddLog(core.LogEntry{ID: utils.GenerateIDString(), Timestamp: time.Now(), Domain: "example.com"})
+	})
+}
+
+func TestStoreSubscribeOverflow(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		sub := s.SubscribeLogs(context.Background(), SubscribeOptions{Buffer: 2})
+		for i := 0; i < 5; i++ {
+			s.AddLog(core.LogEntry{ID: utils.GenerateIDString(), Timestamp: time.Now(), Domain: "example.com"})
+		}
+		// Reads wait for queued writes to be committed
+		s.GetRecentLogs(1)
+
+		deadline := time.After(2 * time.Second)
+		for sub.Dropped() != 3 {
+			select {
+			case <-deadline:
+				t.Fatalf("dropped = %d; want 3", sub.Dropped())
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		if len(sub.C) != 2 {
+			t.Errorf("buffered = %d; want 2", len(sub.C))
+		}
+	})
+}
+
+func TestSQLiteStoreCloseEndsSubscriptions(t *testing.T) {
+	s, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := s.SubscribeLogs(context.Background(), SubscribeOptions{})
+	s.Close()
+	if _, ok := <-sub.C; ok {
+		t.Errorf("subscription should be closed with the store")
+	}
+	if _, ok := <-s.SubscribeLogs(context.Background(), SubscribeOptions{}).C; ok {
+		t.Errorf("subscribing to a closed store should return a closed channel")
+	}
+}
+
+func TestStoreResetData(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		addTestLogs(s)
+		rule := core.Rule{ID: utils.GenerateIDString(), Type: core.RuleBlock, Pattern: "ads.com", Enabled: true, Source: core.RuleSourceCustom}
+		s.AddRule(rule)
+		s.IncrementRuleHit(rule.ID, "ads.com")
+		s.IncrementAdblockHit("ads.com")
+		filter := core.AdblockFilter{ID: utils.GenerateIDString(), Name: "EasyList"}
+		s.AddAdblockFilter(filter)
+		s.IncrementAdblockFilterHit(filter.ID, "||ads.com^")
+		s.SetSetting("proxy_port", "1081")
+
+		s.ResetData()
+
+		if logs := s.GetRecentLogs(10); len(logs) != 0 {
+			t.Errorf("logs after reset = %d; want 0", len(logs))
+		}
+		stats := s.GetStats()
+		if stats.TotalUpload != 0 || stats.TotalDownload != 0 || stats.AdblockHits != 0 || len(stats.TopDomains) != 0 {
+			t.Errorf("stats after reset = %+v; want zero", stats)
+		}
+		if filters := s.GetAdblockFilters(); len(filters) != 1 || filters[0].Hits != 0 {
+			t.Errorf("filters after reset = %+v; want the list kept without hits", filters)
+		}
+		rules, _, _ := s.GetRulesPaginated(1, 10, "")
+		if len(rules) != 1 || rules[0].HitCount != 0 {
+			t.Errorf("rules after reset = %+v; want 1 rule without hits", rules)
+		}
+		if val, _ := s.GetSetting("proxy_port"); val != "1081" {
+			t.Errorf("settings should survive a reset, got proxy_port=%q", val)
+		}
+	})
+}
+
+func TestSeedAfterResetData(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		defaults := []core.AdblockFilter{
+			{Name: "EasyList", URL: "https://example.com/easylist.txt"},
+			{Name: "EasyPrivacy", URL: "https://example.com/easyprivacy.txt"},
+		}
+		SeedAdblockFilters(s, defaults)
+		s.ResetData()
+		SeedAdblockFilters(s, defaults)
+
+		// A reset must not leave the next start without adblock lists
+		filters := s.GetAdblockFilters()
+		if len(filters) != len(defaults) {
+			t.Fatalf("got %d lists after a reset and the next start; want %d", len(filters), len(defaults))
+		}
+		for _, f := range filters {
+			if f.ID != DefaultFilterID(f.URL) || !f.Enabled {
+				t.Errorf("list after reset = %+v; want the enabled default", f)
+			}
+		}
+	})
+}
+
+func TestSQLiteStorePrune(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "custos.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	var mode int
+	s.db.Raw("PRAGMA auto_vacuum").Scan(&mode)
+	if mode != 2 {
+		t.Errorf("auto_vacuum = %d; want incremental (2)", mode)
+	}
+
+	now := time.Now()
+	add := func(status string, age time.Duration, n int) {
+		for i := 0; i < n; i++ {
+			s.AddLog(core.LogEntry{ID: utils.GenerateIDString(), Timestamp: now.Add(-age), Status: status, Domain: "example.com"})
+		}
+	}
+	add(core.LogStatusAllowed, 3*time.Hour, 5)
+	add(core.LogStatusBlocked, 3*time.Hour, 5)
+	add(core.LogStatusBlocked, 3*24*time.Hour, 5)
+	add(core.LogStatusError, 3*time.Hour, 2)
+	add(core.LogStatusAllowed, time.Minute, 3)
+
+	count := func(status string) int64 {
+		_, _, _, total, _ := s.GetLogsPaginated("", 1, "", status, "all")
+		return total
+	}
+
+	SetRetentionPolicy(s, core.RetentionPolicy{AllowedMaxAge: 60 * 60, BlockedMaxAge: 24 * 60 * 60})
+	deleted, err := s.Prune()
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if deleted != 12 {
+		t.Errorf("Prune deleted %d logs; want 12", deleted)
+	}
+	if got := count(core.LogStatusBlocked); got != 5 {
+		t.Errorf("blocked logs after prune = %d; want 5", got)
+	}
+	if got := count(core.LogStatusAllowed); got != 3 {
+		t.Errorf("allowed logs after prune = %d; want 3", got)
+	}
+
+	// Row limit drops the oldest first
+	SetRetentionPolicy(s, core.RetentionPolicy{MaxRows: 4})
+	if _, err := s.Prune(); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if got := count("all"); got != 4 {
+		t.Errorf("logs after row limit = %d; want 4", got)
+	}
+	var freePages int64
+	s.db.Raw("PRAGMA freelist_count").Scan(&freePages)
+	if freePages != 0 {
+		t.Errorf("freelist_count after prune = %d; want pages reclaimed", freePages)
+	}
+	if got := count(core.LogStatusAllowed); got != 3 {
+		t.Errorf("row limit should keep the newest logs, got %d allowed; want 3", got)
+	}
+
+	if err := SetRetentionPolicy(s, core.RetentionPolicy{MaxRows: -1}); err == nil {
+		t.Errorf("SetRetentionPolicy should reject negative limits")
+	}
+	if got := GetRetentionPolicy(s); got.MaxRows != 4 {
+		t.Errorf("GetRetentionPolicy = %+v; want the last valid policy", got)
+	}
+}
+
+// testLineage returns a shell started by init running curl
+func testLineage() []core.Process {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+	initProc := core.Process{PID: 1, Name: "systemd", ExePath: "/usr/lib/systemd/systemd", User: "root", StartTime: start}
+	initProc.Key = core.ProcessKey(initProc.PID, initProc.StartTime)
+	shell := core.Process{PID: 200, Name: "bash", ExePath: "/usr/bin/bash", User: "alice", StartTime: start.Add(time.Hour), ParentPID: 1, ParentKey: initProc.Key}
+	shell.Key = core.ProcessKey(shell.PID, shell.StartTime)
+	curl := core.Process{PID: 300, Name: "curl", ExePath: "/usr/bin/curl", SHA256: "ab12", Cmdline: "curl https://example.com", User: "alice", StartTime: start.Add(2 * time.Hour), ParentPID: 200, ParentKey: shell.Key}
+	curl.Key = core.ProcessKey(curl.PID, curl.StartTime)
+	return []core.Process{curl, shell, initProc}
+}
+
+func TestStoreProcesses(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		lineage := testLineage()
+		for _, p := range lineage {
+			if err := s.SaveProcess(p); err != nil {
+				t.Fatalf("SaveProcess: %v", err)
+			}
+		}
+		curl := lineage[0]
+		got, err := s.GetProcess(curl.Key)
+		if err != nil {
+			t.Fatalf("GetProcess: %v", err)
+		}
+		if got.Cmdline != curl.Cmdline || got.SHA256 != curl.SHA256 || !got.StartTime.Equal(curl.StartTime) {
+			t.Errorf("GetProcess = %+v; want %+v", got, curl)
+		}
+		if _, err := s.GetProcess("1-0"); err == nil {
+			t.Errorf("GetProcess of an unknown key should fail")
+		}
+
+		// Saving again updates the record
+		curl.SHA256 = "cd34"
+		s.SaveProcess(curl)
+		if got, _ := s.GetProcess(curl.Key); got.SHA256 != "cd34" {
+			t.Errorf("SHA256 after resave = %q; want cd34", got.SHA256)
+		}
+
+		var names []string
+		for _, p := range ProcessLineage(s, curl.Key) {
+			names = append(names, p.Name)
+		}
+		if strings.Join(names, ",") != "curl,bash,systemd" {
+			t.Errorf("ProcessLineage = %v; want curl, bash, systemd", names)
+		}
+
+		entry := core.LogEntry{ID: utils.GenerateIDString(), Timestamp: time.Now(), Type: core.LogSourceProxy, Domain: "example.com",
+			ProcessName: curl.Name, ProcessID: curl.PID, ProcessKey: curl.Key, Status: core.LogStatusAllowed}
+		s.AddLog(entry)
+		if logs := s.GetRecentLogs(1); len(logs) != 1 || logs[0].ProcessKey != curl.Key {
+			t.Errorf("logs should keep their process key, got %+v", logs)
+		}
+	})
+}
+
+func TestSQLiteStorePruneProcesses(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "custos.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	lineage := testLineage()
+	for _, p := range lineage {
+		s.SaveProcess(p)
+	}
+	orphan := core.Process{PID: 400, Name: "exited", StartTime: time.Now()}
+	orphan.Key = core.ProcessKey(orphan.PID, orphan.StartTime)
+	s.SaveProcess(orphan)
+	s.AddLog(core.LogEntry{ID: utils.GenerateIDString(), Timestamp: time.Now(), Status: core.LogStatusAllowed, ProcessKey: lineage[0].Key})
+
+	if _, err := s.Prune(); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if _, err := s.GetProcess(orphan.Key); err == nil {
+		t.Errorf("a process no log refers to should be pruned")
+	}
+	if n := len(ProcessLineage(s, lineage[0].Key)); n != 3 {
+		t.Errorf("lineage after prune has %d processes; want the logged process and its 2 ancestors", n)
+	}
+}
+
+func TestStoreTrafficRange(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		now := time.Now()
+		logs := []core.LogEntry{
+			{Timestamp: now.Add(-50 * time.Hour), Domain: "example.com", ProcessName: "firefox", Status: core.LogStatusAllowed, BytesSent: 1, BytesRecv: 10},
+			{Timestamp: now.Add(-26 * time.Hour), Domain: "example.com", ProcessName: "curl", Status: core.LogStatusAllowed, BytesSent: 2, BytesRecv: 20},
+			{Timestamp: now.Add(-26 * time.Hour), Domain: "ads.com", ProcessName: "firefox", Status: core.LogStatusBlocked},
+			{Timestamp: now.Add(-10 * time.Minute), DstIP: "10.0.0.1", ProcessName: "curl", Status: core.LogStatusAllowed, BytesSent: 4, BytesRecv: 40},
+		}
+		for _, l := range logs {
+			l.ID = utils.GenerateIDString()
+			s.AddLog(l)
+		}
+
+		sum := func(points []core.TrafficDataPoint) (total core.TrafficDataPoint) {
+			for _, p := range points {
+				total.Upload += p.Upload
+				total.Download += p.Download
+				total.Connections += p.Connections
+				total.Blocked += p.Blocked
+			}
+			return total
+		}
+
+		week := core.TrafficQuery{From: now.Add(-7 * 24 * time.Hour), To: now, Bucket: 24 * time.Hour}
+		points := s.GetTrafficRange(week)
+		if len(points) < 2 || len(points) > 4 {
+			t.Errorf("day buckets = %d; want 2 to 4", len(points))
+		}
+		for _, p := range points {
+			if p.Timestamp.Hour() != 0 || p.Timestamp.Minute() != 0 {
+				t.Errorf("day bucket starts at %v; want local midnight", p.Timestamp)
+			}
+		}
+		if got := sum(points); got.Upload != 7 || got.Download != 70 || got.Connections != 4 || got.Blocked != 1 {
+			t.Errorf("week totals = %+v; want 7/70 bytes, 4 connections, 1 blocked", got)
+		}
+
+		// Range bounds are honoured
+		if got := sum(s.GetTrafficRange(core.TrafficQuery{From: now.Add(-time.Hour), To: now})); got.Connections != 1 {
+			t.Errorf("last hour connections = %d; want 1", got.Connections)
+		}
+
+		week.Domain = "example.com"
+		if got := sum(s.GetTrafficRange(week)); got.Upload != 3 || got.Connections != 2 {
+			t.Errorf("example.com totals = %+v; want 3 bytes up, 2 connections", got)
+		}
+		week.Domain, week.Process = "", "firefox"
+		if got := sum(s.GetTrafficRange(week)); got.Connections != 2 || got.Blocked != 1 {
+			t.Errorf("firefox totals = %+v; want 2 connections, 1 blocked", got)
+		}
+		week.Process, week.Bucket = "", 90*time.Minute
+		if got := sum(s.GetTrafficRange(week)); got.Download != 70 {
+			t.Errorf("totals with 90m buckets = %+v; want 70 bytes down", got)
+		}
+	})
+}
+
+func TestStoreTopUsage(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		now := time.Now()
+		logs := []core.LogEntry{
+			{Timestamp: now.Add(-30 * time.Hour), Domain: "old.com", ProcessName: "firefox", DstPort: 443, BytesRecv: 1 << 20},
+			{Timestamp: now.Add(-3 * time.Hour), Domain: "example.com", DstIP: "93.184.216.34", ProcessName: "firefox", DstPort: 443, Status: core.LogStatusAllowed, BytesSent: 100, BytesRecv: 900},
+			{Timestamp: now.Add(-2 * time.Hour), Domain: "example.com", DstIP: "93.184.216.34", ProcessName: "curl", DstPort: 80, Status: core.LogStatusAllowed, BytesSent: 10, BytesRecv: 90},
+			{Timestamp: now.Add(-2 * time.Hour), Domain: "ads.com", ProcessName: "firefox", DstPort: 443, Status: core.LogStatusBlocked},
+			{Timestamp: now.Add(-time.Hour), Domain: "ads.com", ProcessName: "firefox", DstPort: 443, Status: core.LogStatusBlocked},
+			{Timestamp: now.Add(-time.Hour), Domain: "ads.com", ProcessName: "chrome", DstPort: 443, Status: core.LogStatusBlocked},
+			{Timestamp: now.Add(-10 * time.Minute), DstIP: "10.0.0.1", ProcessName: "ssh", DstPort: 22, Status: core.LogStatusAllowed, BytesSent: 400, BytesRecv: 400},
+			{Timestamp: now.Add(-5 * time.Minute), Type: core.LogSourceDNS, Domain: "dns.only", Status: core.LogStatusAllowed},
+		}
+		for _, l := range logs {
+			l.ID = utils.GenerateIDString()
+			s.AddLog(l)
+		}
+
+		keys := func(q core.UsageQuery) string {
+			t.Helper()
+			stats, err := s.GetTopUsage(q)
+			if err != nil {
+				t.Fatalf("GetTopUsage(%+v): %v", q, err)
+			}
+			var keys []string
+			for _, u := range stats {
+				keys = append(keys, u.Key)
+			}
+			return strings.Join(keys, ",")
+		}
+
+		tests := []struct {
+			q    core.UsageQuery
+			want string
+		}{
+			{core.UsageQuery{By: core.UsageByDomain}, "example.com,10.0.0.1,ads.com,dns.only"},
+			{core.UsageQuery{By: core.UsageByDomain, Limit: 2}, "example.com,10.0.0.1"},
+			{core.UsageQuery{By: core.UsageByDomain, Metric: core.UsageMetricConnections}, "ads.com,example.com,10.0.0.1,dns.only"},
+			{core.UsageQuery{By: core.UsageByDomain, Metric: core.UsageMetricBlocked}, "ads.com"},
+			{core.UsageQuery{By: core.UsageByProcess}, "firefox,ssh,curl,chrome"},
+			{core.UsageQuery{By: core.UsageByProcess, Metric: core.UsageMetricBlocked}, "firefox,chrome"},
+			{core.UsageQuery{By: core.UsageByPort, Metric: core.UsageMetricConnections}, "443,22,80"},
+			{core.UsageQuery{By: core.UsageByIP}, "93.184.216.34,10.0.0.1"},
+			{core.UsageQuery{By: core.UsageByDomain, From: now.Add(-48 * time.Hour), Limit: 1}, "old.com"},
+			{core.UsageQuery{By: core.UsageByDomain, From: now.Add(-90 * time.Minute), To: now.Add(-30 * time.Minute)}, "ads.com"},
+			// Drill down from a process to its domains, and from a domain to its processes
+			{core.UsageQuery{By: core.UsageByDomain, Process: "firefox"}, "example.com,ads.com"},
+			{core.UsageQuery{By: core.UsageByProcess, Domain: "ads.com", Metric: core.UsageMetricBlocked}, "firefox,chrome"},
+		}
+		for _, tt := range tests {
+			if got := keys(tt.q); got != tt.want {
+				t.Errorf("GetTopUsage(by %s, metric %q, limit %d, process %q, domain %q) = %s; want %s",
+					tt.q.By, tt.q.Metric, tt.q.Limit, tt.q.Process, tt.q.Domain, got, tt.want)
+			}
+		}
+
+		stats, _ := s.GetTopUsage(core.UsageQuery{By: core.UsageByDomain, Limit: 1})
+		if len(stats) != 1 || stats[0].Upload != 110 || stats[0].Download != 990 || stats[0].Connections != 2 || stats[0].Blocked != 0 {
+			t.Errorf("example.com usage = %+v; want 110/990 bytes over 2 connections", stats)
+		}
+
+		for _, q := range []core.UsageQuery{{By: "country"}, {By: core.UsageByDomain, Metric: "latency"}, {By: core.UsageByDomain, From: now, To: now.Add(-time.Hour)}} {
+			if _, err := s.GetTopUsage(q); err == nil {
+				t.Errorf("GetTopUsage(%+v) should fail", q)
+			}
+		}
+	})
+}
+
+func TestNormalizeTrafficQuery(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		from       time.Time
+		bucket     time.Duration
+		wantBucket time.Duration
+		wantRes    time.Duration
+	}{
+		{now.Add(-time.Hour), 0, time.Minute, time.Minute},
+		{now.Add(-24 * time.Hour), 0, time.Hour, time.Hour},
+		{now.Add(-60 * 24 * time.Hour), 0, 24 * time.Hour, 24 * time.Hour},
+		{now.Add(-time.Hour), 5 * time.Minute, 5 * time.Minute, time.Minute},
+		{now.Add(-time.Hour), 30 * time.Second, time.Minute, time.Minute},
+		{now.Add(-2 * time.Hour), 2 * time.Hour, 2 * time.Hour, time.Hour},
+		// Minute rollups are gone for older ranges
+		{now.Add(-30 * 24 * time.Hour), 90 * time.Minute, 2 * time.Hour, time.Hour},
+		{now.Add(-500 * 24 * time.Hour), time.Hour, 24 * time.Hour, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		q, res := normalizeTrafficQuery(core.TrafficQuery{From: tt.from, To: now, Bucket: tt.bucket})
+		if q.Bucket != tt.wantBucket || res != tt.wantRes {
+			t.Errorf("normalize(%v ago, %v) = %v, %v; want %v, %v", now.Sub(tt.from).Round(time.Hour), tt.bucket, q.Bucket, res, tt.wantBucket, tt.wantRes)
+		}
+	}
+}
+
+func TestSQLiteStoreRollups(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "custos.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	// Running byte totals are rolled up as deltas
+	id := utils.GenerateIDString()
+	s.AddLog(core.LogEntry{ID: id, Timestamp: time.Now(), Domain: "example.com", Status: core.LogStatusAllowed})
+	s.UpdateLog(core.LogEntry{ID: id, BytesSent: 100, BytesRecv: 1000})
+	s.UpdateLog(core.LogEntry{ID: id, BytesSent: 150, BytesRecv: 1500})
+
+	points := s.GetTrafficHistory(time.Hour)
+	if len(points) != 1 || points[0].Upload != 150 || points[0].Download != 1500 || points[0].Connections != 1 {
+		t.Errorf("traffic history = %+v; want one point with 150/1500 bytes", points)
+	}
+}
+
+func TestSQLiteStoreWriter(t *testing.T) {
+	opts := WriterOptions{QueueSize: 4, BatchSize: 1, FlushInterval: time.Hour, LogPolicy: OverflowBlock, CounterPolicy: OverflowDrop}
+	s, err := NewSQLiteStoreWithOptions(filepath.Join(t.TempDir(), "custos.db"), opts)
+	if err != nil {
+		t.Fatalf("NewSQLiteStoreWithOptions: %v", err)
+	}
+	defer s.Close()
+
+	var mode string
+	s.db.Raw("PRAGMA journal_mode").Scan(&mode)
+	if mode != "wal" {
+		t.Errorf("journal_mode = %q; want wal", mode)
+	}
+
+	// Hold the writer so the queue fills up
+	s.mu.Lock()
+	for i := 0; i < 20; i++ {
+		s.IncrementAdblockHit(fmt.Sprintf("ads%d.com", i))
+	}
+	s.mu.Unlock()
+
+	dropped := s.DroppedWrites()
+	if dropped < int64(20-opts.QueueSize-1) {
+		t.Errorf("DroppedWrites = %d; want counters dropped once the queue is full", dropped)
+	}
+	if hits := s.GetStats().AdblockHits; hits+dropped != 20 {
+		t.Errorf("AdblockHits = %d with %d dropped; want 20 in total", hits, dropped)
+	}
+
+}
+
+func TestSQLiteStoreWriterRetries(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "custos.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	// Fail the transaction of the next inserts
+	var failures atomic.Int32
+	s.db.Callback().Create().Before("gorm:create").Register("test:fail", func(db *gorm.DB) {
+		if failures.Add(-1) >= 0 {
+			db.AddError(errors.New("database is locked"))
+		}
+	})
+
+	failures.Store(2)
+	s.AddLog(core.LogEntry{ID: "retried", Timestamp: time.Now(), Domain: "example.com", Status: core.LogStatusAllowed, BytesSent: 100})
+	if logs := s.GetRecentLogs(10); len(logs) != 1 || logs[0].ID != "retried" {
+		t.Errorf("logs after transient failures = %+v; want the retried log", logs)
+	}
+	// The rolled back totals are only counted once
+	if up := s.GetStats().TotalUpload; up != 100 {
+		t.Errorf("TotalUpload = %d; want 100", up)
+	}
+	if dropped := s.DroppedWrites(); dropped != 0 {
+		t.Errorf("DroppedWrites = %d; want 0", dropped)
+	}
+
+	failures.Store(writeRetries + 1)
+	s.AddLog(core.LogEntry{ID: "dropped", Timestamp: time.Now(), Domain: "example.com", Status: core.LogStatusAllowed})
+	if logs := s.GetRecentLogs(10); len(logs) != 1 {
+		t.Errorf("logs after a persistent failure = %d; want the failed batch dropped", len(logs))
+	}
+	if dropped := s.DroppedWrites(); dropped != 1 {
+		t.Errorf("DroppedWrites = %d; want 1", dropped)
+	}
+}
+
+func TestSQLiteStoreWriterCoalesces(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "custos.db")
+	s, err := NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+
+	received := s.SubscribeLogs(context.Background(), SubscribeOptions{Buffer: 10}).C
+
+	id := utils.GenerateIDString()
+	s.AddLog(core.LogEntry{ID: id, Timestamp: time.Now(), Domain: "example.com", Status: core.LogStatusAllowed})
+	for i := 1; i <= 10; i++ {
+		s.UpdateLog(core.LogEntry{ID: id, BytesSent: int64(i * 10), BytesRecv: int64(i * 100)})
+		s.AddTraffic(10, 100)
+	}
+	rule := core.Rule{ID: utils.GenerateIDString(), Type: core.RuleBlock, Pattern: "ads.com", Enabled: true, Source: core.RuleSourceCustom}
+	s.AddRule(rule)
+	s.IncrementRuleHit(rule.ID, "a.ads.com")
+	s.IncrementRuleHit(rule.ID, "b.ads.com")
+	s.IncrementAdblockFilterHit("", "||ads.com^")
+	s.IncrementAdblockFilterHit("", "||ads.com^")
+
+	// Pending writes are committed on close
+	s.Close()
+
+	s, err = NewSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer s.Close()
+
+	logs := s.GetRecentLogs(10)
+	if len(logs) != 1 || logs[0].BytesSent != 100 || logs[0].BytesRecv != 1000 || logs[0].Domain != "example.com" {
+		t.Errorf("logs = %+v; want one merged entry with 100/1000 bytes", logs)
+	}
+	stats := s.GetStats()
+	if stats.TotalUpload != 100 || stats.TotalDownload != 1000 {
+		t.Errorf("totals = %d/%d; want 100/1000", stats.TotalUpload, stats.TotalDownload)
+	}
+	if stats.TopFilters["||ads.com^"] != 2 {
+		t.Errorf("TopFilters = %v; want ||ads.com^ with 2 hits", stats.TopFilters)
+	}
+	rules, _, _ := s.GetRulesPaginated(1, 10, "")
+	if len(rules) != 1 || rules[0].HitCount != 2 {
+		t.Errorf("rules = %+v; want 2 hits", rules)
+	}
+	var up int64
+	for _, p := range s.GetTrafficHistory(time.Hour) {
+		up += p.Upload
+	}
+	if up != 100 {
+		t.Errorf("rolled up upload = %d; want 100", up)
+	}
+
+	// Subscribers get the merged entry
+	select {
+	case entry := <-received:
+		if entry.ID != id || entry.Domain != "example.com" || entry.BytesSent == 0 {
+			t.Errorf("subscriber got %+v; want the merged entry", entry)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("subscriber was not notified")
+	}
+}
+
+func TestSeedAdblockFilters(t *testing.T) {
+	runConformance(t, func(t *testing.T, s Store) {
+		defaults := []core.AdblockFilter{
+			{Name: "EasyList", URL: "https://example.com/easylist.txt"},
+			{Name: "EasyPrivacy", URL: "https://example.com/easyprivacy.txt"},
+		}
+		if added := SeedAdblockFilters(s, defaults); added != 2 {
+			t.Fatalf("first seed added %d lists; want 2", added)
+		}
+		easy := DefaultFilterID(defaults[0].URL)
+		privacy := DefaultFilterID(defaults[1].URL)
+
+		// Settings and statistics of a list survive the next start
+		for _, f := range s.GetAdblockFilters() {
+			if f.ID == easy {
+				f.UpdateInterval = 3600
+				f.FailureCount = 2
+				s.UpdateAdblockFilter(f)
+			}
+		}
+		s.IncrementAdblockFilterHit(easy, "||ads.com^")
+		// A deleted default stays deleted
+		s.DeleteAdblockFilter(privacy)
+
+		if added := SeedAdblockFilters(s, defaults); added != 0 {
+			t.Errorf("second seed added %d lists; want 0", added)
+		}
+		filters := s.GetAdblockFilters()
+		if len(filters) != 1 {
+			t.Fatalf("got %d lists after reseeding; want 1", len(filters))
+		}
+		if f := filters[0]; f.ID != easy || f.UpdateInterval != 3600 || f.FailureCount != 2 || f.Hits != 1 {
+			t.Errorf("reseeded list = %+v; want its ID, settings and hits kept", f)
+		}
+
+		// A new default is offered once
+		defaults = append(defaults, core.AdblockFilter{Name: "NoCoin", URL: "https://example.com/nocoin.txt"})
+		if added := SeedAdblockFilters(s, defaults); added != 1 {
+			t.Errorf("seed with a new default added %d lists; want 1", added)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vkhangstack/Custos/internal/core"
+)
+
+// DefaultSubscriptionBuffer is the channel capacity of a subscription
+const DefaultSubscriptionBuffer = 256
+
+// SubscribeOptions configures a log subscription
+type SubscribeOptions struct {
+	Query  LogQuery // Only entries matching the query are delivered
+	Buffer int      // Channel capacity, 0 = DefaultSubscriptionBuffer
+}
+
+// Subscription delivers new and updated logs. Entries are always complete:
+// an update delivers the whole merged log, so consumers replace by ID.
+// A subscriber that falls behind loses entries rather than slowing the store.
+type Subscription struct {
+	// C is closed when the subscription's context is done or the store closes
+	C <-chan core.LogEntry
+
+	ch      chan core.LogEntry
+	query   LogQuery
+	dropped atomic.Int64
+	stop    func() bool // Stops watching the context
+}
+
+// Dropped returns how many entries were discarded because C was full
+func (sub *Subscription) Dropped() int64 {
+	return sub.dropped.Load()
+}
+
+// broker fans logs out to subscriptions
+type broker struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func (b *broker) subscribe(ctx context.Context, opts SubscribeOptions) *Subscription {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultSubscriptionBuffer
+	}
+	ch := make(chan core.LogEntry, opts.Buffer)
+	sub := &Subscription{C: ch, ch: ch, query: opts.Query}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return sub
+	}
+	if b.subs == nil {
+		b.subs = make(map[*Subscription]struct{})
+	}
+	b.subs[sub] = struct{}{}
+	sub.stop = context.AfterFunc(ctx, func() { b.unsubscribe(sub) })
+	return sub
+}
+
+func (b *broker) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// publish delivers an entry to every matching subscription without blocking
+func (b *broker) publish(entry core.LogEntry) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if !sub.query.Match(entry) {
+			continue
+		}
+		select {
+		case sub.ch <- entry:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// close ends every subscription and rejects new ones
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		sub.stop()
+		close(sub.ch)
+	}
+}
@@ -0,0 +1,14 @@
+-- Schema and sample data of a database created before versioned migrations
+CREATE TABLE `log_entries` (`id` text,`timestamp` datetime,`type` text,`domain` text,`src_ip` text,`dst_ip` text,`dst_port` integer,`protocol` text,`process_name` text,`process_id` integer,`bytes_sent` integer,`bytes_recv` integer,`status` text,`latency` integer,`reason` text,PRIMARY KEY (`id`));
+CREATE TABLE `traffic_stats_models` (`id` text,`total_upload` integer,`total_download` integer,`timestamp` datetime,PRIMARY KEY (`id`));
+CREATE TABLE `rules` (`id` text,`type` text,`pattern` text,`enabled` numeric,`source` text,`hit_count` integer,PRIMARY KEY (`id`));
+CREATE TABLE `app_settings` (`key` text,`value` text,PRIMARY KEY (`key`));
+CREATE TABLE `adblock_filters` (`id` text,`name` text,`url` text,`enabled` numeric,`last_updated` datetime,`hits` integer,PRIMARY KEY (`id`));
+INSERT INTO `log_entries` VALUES ('1000001','2025-06-01 10:00:05+00:00','proxy','www.example.com','127.0.0.1','93.184.216.34',443,'tcp','firefox',4242,120,4800,'allowed',12,NULL);
+INSERT INTO `log_entries` VALUES ('1000002','2025-06-01 10:00:30+00:00','proxy','ads.example.com','127.0.0.1','93.184.216.35',443,'tcp','firefox',4242,0,0,'blocked',0,'adsblock');
+INSERT INTO `log_entries` VALUES ('1000003','2025-06-01 11:30:00+00:00','proxy','','127.0.0.1','10.0.0.1',22,'tcp','ssh',777,300,900,'allowed',3,NULL);
+INSERT INTO `traffic_stats_models` VALUES ('global',5000,90000,'2025-06-01 11:30:00+00:00');
+INSERT INTO `rules` VALUES ('2000001','BLOCK','*.ads.com',1,'custom',7);
+INSERT INTO `app_settings` VALUES ('proxy_port','1081');
+INSERT INTO `app_settings` VALUES ('adblock_hits','3');
+INSERT INTO `adblock_filters` VALUES ('3000001','EasyList','https://easylist.to/easylist/easylist.txt',1,'2025-05-31 08:00:00+00:00',3);
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+const dnsUpstreamsKey = "dns_upstreams"
+
+// DefaultDNSUpstreams is used until the user configures upstream resolvers
+var DefaultDNSUpstreams = []string{"8.8.8.8:53"}
+
+// GetDNSUpstreams returns the upstream resolvers in the order they are tried
+func GetDNSUpstreams(s Store) []string {
+	val, err := s.GetSetting(dnsUpstreamsKey)
+	if err != nil || val == "" {
+		return DefaultDNSUpstreams
+	}
+
+	var upstreams []string
+	if err := json.Unmarshal([]byte(val), &upstreams); err != nil || len(upstreams) == 0 {
+		return DefaultDNSUpstreams
+	}
+	return upstreams
+}
+
+// ValidateDNSUpstream checks that an upstream is a host:port address
+func ValidateDNSUpstream(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid upstream %q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid upstream port in %q", addr)
+	}
+	if host == "" {
+		return fmt.Errorf("invalid upstream %q: missing host", addr)
+	}
+	return nil
+}
+
+// SetDNSUpstreams validates and stores the upstream resolvers
+func SetDNSUpstreams(s Store, upstreams []string) error {
+	if len(upstreams) == 0 {
+		return fmt.Errorf("at least one DNS upstream is required")
+	}
+	for _, u := range upstreams {
+		if err := ValidateDNSUpstream(u); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(upstreams)
+	if err != nil {
+		return err
+	}
+	return s.SetSetting(dnsUpstreamsKey, string(data))
+}
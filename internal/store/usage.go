@@ -0,0 +1,229 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+)
+
+// normalizeUsageQuery fills in defaults and validates the dimension and metric
+func normalizeUsageQuery(q core.UsageQuery) (core.UsageQuery, error) {
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-day)
+	}
+	if !q.From.Before(q.To) {
+		return q, fmt.Errorf("invalid usage range %s - %s", q.From, q.To)
+	}
+
+	switch q.By {
+	case core.UsageByDomain, core.UsageByProcess, core.UsageByPort, core.UsageByIP:
+	default:
+		return q, fmt.Errorf("unsupported usage dimension %q", q.By)
+	}
+
+	switch q.Metric {
+	case "":
+		q.Metric = core.UsageMetricBytes
+	case core.UsageMetricBytes, core.UsageMetricConnections, core.UsageMetricBlocked:
+	default:
+		return q, fmt.Errorf("unsupported usage metric %q", q.Metric)
+	}
+	return q, nil
+}
+
+// usageKey returns the key a log is counted under, "" if it has none
+func usageKey(entry core.LogEntry, by string) string {
+	switch by {
+	case core.UsageByDomain:
+		return trafficKey(entry)
+	case core.UsageByProcess:
+		return entry.ProcessName
+	case core.UsageByPort:
+		if entry.DstPort == 0 {
+			return ""
+		}
+		return strconv.Itoa(entry.DstPort)
+	case core.UsageByIP:
+		return entry.DstIP
+	}
+	return ""
+}
+
+// matchesUsageQuery reports whether a log is counted by a query
+func matchesUsageQuery(entry core.LogEntry, q core.UsageQuery) bool {
+	if entry.Timestamp.Before(q.From) || !entry.Timestamp.Before(q.To) {
+		return false
+	}
+	if q.Domain != "" && trafficKey(entry) != q.Domain {
+		return false
+	}
+	return q.Process == "" || entry.ProcessName == q.Process
+}
+
+func usageMetric(u core.UsageStat, metric string) int64 {
+	switch metric {
+	case core.UsageMetricConnections:
+		return u.Connections
+	case core.UsageMetricBlocked:
+		return u.Blocked
+	default:
+		return u.Upload + u.Download
+	}
+}
+
+// RankUsage sorts stats by a metric, largest first with ties broken by key,
+// and keeps the first limit of them; a limit of zero keeps all. Keys that
+// score zero on the blocked metric are dropped, they were never blocked.
+func RankUsage(stats []core.UsageStat, metric string, limit int) []core.UsageStat {
+	if metric == core.UsageMetricBlocked {
+		kept := stats[:0]
+		for _, u := range stats {
+			if u.Blocked > 0 {
+				kept = append(kept, u)
+			}
+		}
+		stats = kept
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := usageMetric(stats[i], metric), usageMetric(stats[j], metric)
+		if a != b {
+			return a > b
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	if limit > 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats
+}
+
+// usageTotals sums usage by key
+type usageTotals map[string]*core.UsageStat
+
+func (t usageTotals) add(key string, upload, download, connections, blocked int64) {
+	u, ok := t[key]
+	if !ok {
+		u = &core.UsageStat{Key: key}
+		t[key] = u
+	}
+	u.Upload += upload
+	u.Download += download
+	u.Connections += connections
+	u.Blocked += blocked
+}
+
+func (t usageTotals) list() []core.UsageStat {
+	stats := make([]core.UsageStat, 0, len(t))
+	for _, u := range t {
+		stats = append(stats, *u)
+	}
+	return stats
+}
+
+// GetTopUsage returns the top keys of a dimension within a time window.
+// Domains and processes are read from the traffic rollups, so they cover
+// ranges whose logs were already pruned; everything else is read from logs.
+func (s *SQLiteStore) GetTopUsage(q core.UsageQuery) ([]core.UsageStat, error) {
+	q, err := normalizeUsageQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	s.sync()
+
+	var stats []core.UsageStat
+	if (q.By == core.UsageByDomain || q.By == core.UsageByProcess) && q.Domain == "" && q.Process == "" {
+		stats, err = s.usageFromRollups(q)
+	} else {
+		stats, err = s.usageFromLogs(q)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return RankUsage(stats, q.Metric, q.Limit), nil
+}
+
+// usageOrder ranks rows by a metric in SQL, matching RankUsage
+func usageOrder(metric string) string {
+	switch metric {
+	case core.UsageMetricConnections:
+		return "connections desc, key asc"
+	case core.UsageMetricBlocked:
+		return "blocked desc, key asc"
+	default:
+		return "upload + download desc, key asc"
+	}
+}
+
+// usageFromRollups sums the finest rollups still kept for the start of the window
+func (s *SQLiteStore) usageFromRollups(q core.UsageQuery) ([]core.UsageStat, error) {
+	resolution := time.Minute
+	if age := time.Since(q.From); age > hourRollupRetention {
+		resolution = day
+	} else if age > minuteRollupRetention {
+		resolution = time.Hour
+	}
+	to := q.To.Unix()
+	if q.To.Nanosecond() > 0 {
+		to++
+	}
+
+	kind := core.RollupKindDomain
+	if q.By == core.UsageByProcess {
+		kind = core.RollupKindProcess
+	}
+
+	query := s.db.Model(&core.TrafficRollup{}).
+		Select("key, sum(upload) as upload, sum(download) as download, sum(connections) as connections, sum(blocked) as blocked").
+		Where("resolution = ? AND kind = ? AND bucket >= ? AND bucket < ? AND key != ''",
+			int64(resolution/time.Second), kind, alignBucket(q.From, resolution).Unix(), to).
+		Group("key").Order(usageOrder(q.Metric))
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+
+	var stats []core.UsageStat
+	err := query.Find(&stats).Error
+	return stats, err
+}
+
+// usageFromLogs groups the logs of the window by a dimension
+func (s *SQLiteStore) usageFromLogs(q core.UsageQuery) ([]core.UsageStat, error) {
+	domainKey := "CASE WHEN domain != '' THEN domain ELSE dst_ip END"
+	var key string
+	switch q.By {
+	case core.UsageByDomain:
+		key = domainKey
+	case core.UsageByProcess:
+		key = "process_name"
+	case core.UsageByPort:
+		key = "CASE WHEN dst_port > 0 THEN CAST(dst_port AS TEXT) ELSE '' END"
+	case core.UsageByIP:
+		key = "dst_ip"
+	}
+
+	query := s.db.Model(&core.LogEntry{}).
+		Select(fmt.Sprintf("%s as key, sum(bytes_sent) as upload, sum(bytes_recv) as download, count(*) as connections, sum(status = ?) as blocked", key), core.LogStatusBlocked).
+		Where("timestamp >= ? AND timestamp < ?", q.From, q.To).
+		Where(fmt.Sprintf("ifnull(%s, '') != ''", key))
+	if q.Domain != "" {
+		query = query.Where(domainKey+" = ?", q.Domain)
+	}
+	if q.Process != "" {
+		query = query.Where("process_name = ?", q.Process)
+	}
+	query = query.Group("key").Order(usageOrder(q.Metric))
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+
+	var stats []core.UsageStat
+	err := query.Find(&stats).Error
+	return stats, err
+}
@@ -0,0 +1,435 @@
+package store
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OverflowPolicy decides what happens to a write when the queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue, slowing the caller down
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop discards the write
+	OverflowDrop
+)
+
+// WriterOptions tunes the batched write pipeline of SQLiteStore
+type WriterOptions struct {
+	QueueSize     int
+	BatchSize     int            // Flush once this many writes are pending
+	FlushInterval time.Duration  // Flush at least this often
+	LogPolicy     OverflowPolicy // Log inserts, updates, traffic totals and processes
+	CounterPolicy OverflowPolicy // Rule, adblock and filter hit counters
+}
+
+// DefaultWriterOptions keeps traffic accounting exact and sheds hit counters under load
+var DefaultWriterOptions = WriterOptions{
+	QueueSize:     8192,
+	BatchSize:     1000,
+	FlushInterval: 250 * time.Millisecond,
+	LogPolicy:     OverflowBlock,
+	CounterPolicy: OverflowDrop,
+}
+
+// A batch whose transaction fails is retried this many times, doubling the
+// delay each time, before its writes are dropped
+const (
+	writeRetries    = 4
+	writeRetryDelay = 50 * time.Millisecond
+)
+
+type writeKind int
+
+const (
+	opAddLog writeKind = iota
+	opUpdateLog
+	opTraffic
+	opRuleHit
+	opAdblockHit
+	opFilterHit
+	opProcess
+	opFlush
+)
+
+// writeOp is a single queued write
+type writeOp struct {
+	kind     writeKind
+	at       time.Time
+	entry    core.LogEntry
+	process  core.Process
+	upload   int64
+	download int64
+	id       string        // Rule ID or filter list ID
+	filter   string        // Adblock filter text
+	done     chan struct{} // Closed once an opFlush has been applied
+}
+
+// writer queues writes for the store's writer goroutine
+type writer struct {
+	opts    WriterOptions
+	queue   chan writeOp
+	mu      sync.RWMutex // Guards closing the queue against senders
+	closed  bool
+	pending atomic.Int64 // Writes queued but not yet committed
+	dropped atomic.Int64
+	stopped chan struct{}
+}
+
+func newWriter(opts WriterOptions) *writer {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultWriterOptions.QueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultWriterOptions.BatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultWriterOptions.FlushInterval
+	}
+	return &writer{
+		opts:    opts,
+		queue:   make(chan writeOp, opts.QueueSize),
+		stopped: make(chan struct{}),
+	}
+}
+
+// enqueue hands a write to the writer goroutine, applying the overflow policy
+func (s *SQLiteStore) enqueue(op writeOp, policy OverflowPolicy) {
+	w := s.writer
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return
+	}
+
+	if op.at.IsZero() {
+		op.at = time.Now()
+	}
+	w.pending.Add(1)
+	if policy == OverflowDrop {
+		select {
+		case w.queue <- op:
+		default:
+			w.pending.Add(-1)
+			w.dropped.Add(1)
+		}
+		return
+	}
+	w.queue <- op
+}
+
+// sync waits until every write queued so far is committed, so reads see them
+func (s *SQLiteStore) sync() {
+	w := s.writer
+	if w.pending.Load() == 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return
+	}
+	w.queue <- writeOp{kind: opFlush, done: done}
+	w.mu.RUnlock()
+	<-done
+}
+
+// DroppedWrites returns how many writes were discarded because the queue was
+// full or the database kept failing to commit them
+func (s *SQLiteStore) DroppedWrites() int64 {
+	return s.writer.dropped.Load()
+}
+
+// closeWriter stops accepting writes and waits for the queue to be flushed
+func (s *SQLiteStore) closeWriter() {
+	w := s.writer
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.stopped
+}
+
+// runWriter commits queued writes in batches until the queue is closed
+func (s *SQLiteStore) runWriter() {
+	w := s.writer
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []writeOp
+	var waiters []chan struct{}
+	var reportedDrops int64
+
+	flush := func() {
+		if len(batch) > 0 {
+			s.commitWrites(batch)
+			w.pending.Add(-int64(len(batch)))
+			batch = batch[:0]
+		}
+		for _, done := range waiters {
+			close(done)
+		}
+		waiters = waiters[:0]
+
+		if dropped := w.dropped.Load(); dropped != reportedDrops {
+			log.Printf("Dropped %d queued writes so far", dropped)
+			reportedDrops = dropped
+		}
+	}
+
+	for {
+		select {
+		case op, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if op.kind == opFlush {
+				waiters = append(waiters, op.done)
+				flush()
+				continue
+			}
+			batch = append(batch, op)
+			if len(batch) >= w.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// commitWrites applies a batch, retrying with backoff while the database is
+// busy or failing, e.g. locked by a backup or out of disk space
+func (s *SQLiteStore) commitWrites(ops []writeOp) {
+	delay := writeRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := s.applyWrites(ops)
+		if err == nil {
+			return
+		}
+		if attempt == writeRetries {
+			s.writer.dropped.Add(int64(len(ops)))
+			log.Printf("Failed to write %d queued changes, dropped them: %v", len(ops), err)
+			return
+		}
+		log.Printf("Failed to write %d queued changes, retrying in %v: %v", len(ops), delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// applyWrites coalesces a batch into a single transaction: logs are inserted
+// together, repeated updates of a log collapse into one, and counters are summed.
+// A failed transaction leaves nothing behind, so the batch can be applied again.
+func (s *SQLiteStore) applyWrites(ops []writeOp) error {
+	// Latest state of every log touched by the batch
+	known := make(map[string]*core.LogEntry)
+	var inserts, updates, touched []string
+	isInsert := make(map[string]bool)
+	isUpdate := make(map[string]bool)
+	isTouched := make(map[string]bool)
+
+	// Updates of logs inserted by earlier batches need their stored state
+	var missing []string
+	for _, op := range ops {
+		switch op.kind {
+		case opAddLog:
+			isInsert[op.entry.ID] = true
+		case opUpdateLog:
+			if !isInsert[op.entry.ID] && !isUpdate[op.entry.ID] {
+				isUpdate[op.entry.ID] = true
+				missing = append(missing, op.entry.ID)
+			}
+		}
+	}
+	clear(isInsert)
+	clear(isUpdate)
+	for i := 0; i < len(missing); i += 500 {
+		var rows []core.LogEntry
+		s.db.Where("id IN ?", missing[i:min(i+500, len(missing))]).Find(&rows)
+		for j := range rows {
+			known[rows[j].ID] = &rows[j]
+		}
+	}
+
+	var upload, download, adblockHits int64
+	ruleHits := make(map[string]int64)
+	listHits := make(map[string]int64)
+	filterHits := make(map[string]*core.AdblockFilterHit)
+	rollups := make(rollupBatch)
+	processes := make(map[string]core.Process)
+	var processKeys []string
+
+	touch := func(id string) {
+		if !isTouched[id] {
+			isTouched[id] = true
+			touched = append(touched, id)
+		}
+	}
+
+	for _, op := range ops {
+		switch op.kind {
+		case opAddLog:
+			entry := op.entry
+			if entry.Timestamp.IsZero() {
+				entry.Timestamp = op.at
+			}
+			known[entry.ID] = &entry
+			if !isInsert[entry.ID] {
+				isInsert[entry.ID] = true
+				inserts = append(inserts, entry.ID)
+			}
+			touch(entry.ID)
+
+			upload += entry.BytesSent
+			download += entry.BytesRecv
+			blocked := int64(0)
+			if entry.Status == core.LogStatusBlocked {
+				blocked = 1
+			}
+			rollups.add(rollupsFor(entry, entry.Timestamp, entry.BytesSent, entry.BytesRecv, 1, blocked))
+
+		case opUpdateLog:
+			l, ok := known[op.entry.ID]
+			if !ok {
+				continue
+			}
+			// Byte counts are running totals, roll up what was transferred since the last update
+			sent := max(op.entry.BytesSent-l.BytesSent, 0)
+			recv := max(op.entry.BytesRecv-l.BytesRecv, 0)
+			mergeLogEntry(l, op.entry)
+			if !isInsert[l.ID] && !isUpdate[l.ID] {
+				isUpdate[l.ID] = true
+				updates = append(updates, l.ID)
+			}
+			touch(l.ID)
+			if sent > 0 || recv > 0 {
+				rollups.add(rollupsFor(*l, op.at, sent, recv, 0, 0))
+			}
+
+		case opTraffic:
+			upload += op.upload
+			download += op.download
+
+		case opRuleHit:
+			ruleHits[op.id]++
+
+		case opAdblockHit:
+			adblockHits++
+
+		case opFilterHit:
+			if h, ok := filterHits[op.filter]; ok {
+				h.Hits++
+				h.ListID = op.id
+				h.LastHit = op.at
+			} else {
+				filterHits[op.filter] = &core.AdblockFilterHit{Filter: op.filter, ListID: op.id, Hits: 1, LastHit: op.at}
+			}
+			if op.id != "" {
+				listHits[op.id]++
+			}
+
+		case opProcess:
+			// Later saves of a process carry the hash of its executable
+			if _, ok := processes[op.process.Key]; !ok {
+				processKeys = append(processKeys, op.process.Key)
+			}
+			processes[op.process.Key] = op.process
+		}
+	}
+
+	s.mu.Lock()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, key := range processKeys {
+			p := processes[key]
+			if err := tx.Save(&p).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(inserts) > 0 {
+			entries := make([]core.LogEntry, len(inserts))
+			for i, id := range inserts {
+				entries[i] = *known[id]
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(entries, 200).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, id := range updates {
+			if err := tx.Model(&core.LogEntry{}).Where("id = ?", id).Updates(*known[id]).Error; err != nil {
+				return err
+			}
+		}
+
+		if upload > 0 || download > 0 {
+			if err := tx.Model(&core.TrafficStatsModel{}).Where("id = ?", "global").
+				Updates(map[string]interface{}{
+					"total_upload":   gorm.Expr("total_upload + ?", upload),
+					"total_download": gorm.Expr("total_download + ?", download),
+					"timestamp":      time.Now(),
+				}).Error; err != nil {
+				return err
+			}
+		}
+
+		for id, n := range ruleHits {
+			if err := tx.Model(&core.Rule{}).Where("id = ?", id).Update("hit_count", gorm.Expr("hit_count + ?", n)).Error; err != nil {
+				return err
+			}
+		}
+
+		if adblockHits > 0 {
+			if err := tx.Exec("INSERT INTO app_settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = CAST(value AS INTEGER) + ?",
+				"adblock_hits", adblockHits, adblockHits).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, hit := range filterHits {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "filter"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"hits":     gorm.Expr("hits + ?", hit.Hits),
+					"list_id":  hit.ListID,
+					"last_hit": hit.LastHit,
+				}),
+			}).Create(hit).Error; err != nil {
+				return err
+			}
+		}
+		for id, n := range listHits {
+			if err := tx.Model(&core.AdblockFilter{}).Where("id = ?", id).Update("hits", gorm.Expr("hits + ?", n)).Error; err != nil {
+				return err
+			}
+		}
+
+		return upsertRollups(tx, rollups.list())
+	})
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Notify subscribers with the full entries
+	for _, id := range touched {
+		s.logBroker.publish(*known[id])
+	}
+	return nil
+}
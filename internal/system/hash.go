@@ -0,0 +1,113 @@
+package system
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxConcurrentHashes limits how many executables are read at once
+const maxConcurrentHashes = 2
+
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	sum     string // "" while hashing or if the file can't be read
+}
+
+// hashCache computes SHA-256 sums of executables in the background, so a
+// connection never waits for a large binary to be read. Sums are kept until
+// the file changes size or modification time.
+type hashCache struct {
+	mu    sync.Mutex
+	files map[string]fileHash
+	sem   chan struct{}
+}
+
+// sum returns the hash of a file if it is known, and otherwise starts
+// computing it and returns ""
+func (c *hashCache) sum(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.init()
+	if h, ok := c.files[path]; ok && h.size == info.Size() && h.modTime.Equal(info.ModTime()) {
+		return h.sum
+	}
+
+	c.files[path] = fileHash{size: info.Size(), modTime: info.ModTime()}
+	go c.compute(path, info)
+	return ""
+}
+
+// sumNow returns the hash of a file, reading it right away if it isn't
+// known yet, for callers that can't decide without it
+func (c *hashCache) sumNow(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.init()
+	h, ok := c.files[path]
+	c.mu.Unlock()
+	if ok && h.sum != "" && h.size == info.Size() && h.modTime.Equal(info.ModTime()) {
+		return h.sum, nil
+	}
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.files[path] = fileHash{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	c.mu.Unlock()
+	return sum, nil
+}
+
+func (c *hashCache) init() {
+	if c.files == nil {
+		c.files = make(map[string]fileHash)
+		c.sem = make(chan struct{}, maxConcurrentHashes)
+	}
+}
+
+func (c *hashCache) compute(path string, info os.FileInfo) {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Keep the entry pending if the file changed while it was read
+	if h := c.files[path]; h.size == info.Size() && h.modTime.Equal(info.ModTime()) {
+		h.sum = sum
+		c.files[path] = h
+	}
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
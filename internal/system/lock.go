@@ -0,0 +1,40 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrLocked is returned by Lock when another process holds the lock
+var ErrLocked = errors.New("locked by another process")
+
+// FileLock is an advisory lock on a file. The OS releases it when the
+// process exits, so a crash never leaves a stale lock behind.
+type FileLock struct {
+	f *os.File
+}
+
+// Lock takes the lock on the file at path, creating the file if needed,
+// without waiting for another holder
+func Lock(path string) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// For people wondering who holds it
+	f.Truncate(0)
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	return &FileLock{f: f}, nil
+}
+
+// Unlock releases the lock. The file stays: removing it would let a
+// process that opened it just before lock a file nobody else sees.
+func (l *FileLock) Unlock() error {
+	unlockFile(l.f)
+	return l.f.Close()
+}
@@ -0,0 +1,29 @@
+package system
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.lock")
+	l, err := Lock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each Lock opens the file anew, so a second one conflicts even in
+	// the same process
+	if _, err := Lock(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Lock = %v, want ErrLocked", err)
+	}
+
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = Lock(path)
+	if err != nil {
+		t.Fatalf("Lock after Unlock = %v", err)
+	}
+	l.Unlock()
+}
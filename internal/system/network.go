@@ -1,7 +1,260 @@
 package system
 
-// SetSystemProxy configures the system SOCKS5 proxy
-// Implementation is platform-specific in network_*.go files
-func SetSystemProxy(enabled bool, port int) error {
-	return setProxy(enabled, port)
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProxySettings is a copy of the system proxy configuration, taken before
+// Custos changes it so it can be put back exactly
+type ProxySettings struct {
+	Platform  string            `json:"platform"`
+	Values    map[string]string `json:"values"`           // Setting -> raw value
+	Absent    []string          `json:"absent,omitempty"` // Settings that were not set at all
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// ErrNoProxyBackend is returned when no way to configure the system proxy was found
+var ErrNoProxyBackend = errors.New("no supported system proxy configuration found")
+
+// ProxyBackendStatus reports the last change to one place the system proxy
+// is configured, like GNOME settings or the Windows registry
+type ProxyBackendStatus struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"` // Present on this system
+	Applied   bool   `json:"applied"`
+	Error     string `json:"error,omitempty"`
+}
+
+// appliedStatus reports the outcome of a change to an available backend
+func appliedStatus(name string, err error) ProxyBackendStatus {
+	st := ProxyBackendStatus{Name: name, Available: true, Applied: err == nil}
+	if err != nil {
+		st.Error = err.Error()
+	}
+	return st
+}
+
+// ProxyStatus reports the state of the system proxy
+type ProxyStatus struct {
+	Enabled   bool                 `json:"enabled"`
+	Port      int                  `json:"port"`
+	Saved     bool                 `json:"saved"` // Previous settings are waiting to be restored
+	Backends  []ProxyBackendStatus `json:"backends"`
+	UpdatedAt time.Time            `json:"updated_at"`
+}
+
+// proxyPlatform reads and writes the proxy configuration of one OS
+type proxyPlatform interface {
+	// read returns the current settings Custos would change
+	read() (*ProxySettings, error)
+	// restore writes back settings returned by read
+	restore(prev *ProxySettings) []ProxyBackendStatus
+	// set points the system proxy at a local SOCKS5 port, or turns it off
+	set(enabled bool, port int) []ProxyBackendStatus
+	// ownPort returns the port of settings written by set, ok is false for
+	// settings of anything else
+	ownPort(s *ProxySettings) (port int, ok bool)
+}
+
+// ProxyController switches the system proxy to Custos and back. The
+// settings found before the first switch are saved to a file, so they
+// survive restarts and crashes until they are restored.
+type ProxyController struct {
+	mu       sync.Mutex
+	path     string
+	platform proxyPlatform
+	status   ProxyStatus
+}
+
+// NewProxyController creates a controller saving the previous settings to snapshotPath
+func NewProxyController(snapshotPath string) *ProxyController {
+	return &ProxyController{path: snapshotPath, platform: newProxyPlatform()}
+}
+
+// SetSystemProxy points the system proxy at the SOCKS5 port, or restores
+// the configuration from before Custos enabled it
+func (c *ProxyController) SetSystemProxy(enabled bool, port int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if enabled {
+		return c.enable(port)
+	}
+	return c.disable()
+}
+
+func (c *ProxyController) enable(port int) error {
+	// An existing snapshot is older than the current settings, which are
+	// then Custos's own, e.g. after a crash or a port change
+	if _, err := c.load(); os.IsNotExist(err) {
+		prev, err := c.platform.read()
+		if err != nil {
+			return fmt.Errorf("failed to read proxy settings: %w", err)
+		}
+		if _, own := c.platform.ownPort(prev); !own {
+			if err := c.save(prev); err != nil {
+				return fmt.Errorf("failed to save proxy settings: %w", err)
+			}
+		}
+	} else if err != nil {
+		log.Printf("Ignoring unreadable proxy snapshot %s: %v", c.path, err)
+	}
+	return c.record(true, port, c.platform.set(true, port))
+}
+
+func (c *ProxyController) disable() error {
+	prev, err := c.load()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Ignoring unreadable proxy snapshot %s: %v", c.path, err)
+		}
+		// Nothing to go back to: only turn off settings that are Custos's
+		// own, anything else is the user's, like a corporate PAC
+		cur, err := c.platform.read()
+		if err != nil {
+			return fmt.Errorf("failed to read proxy settings: %w", err)
+		}
+		if _, own := c.platform.ownPort(cur); !own {
+			c.status = ProxyStatus{UpdatedAt: time.Now()}
+			return nil
+		}
+		return c.record(false, 0, c.platform.set(false, 0))
+	}
+	// The snapshot is kept until every backend is restored
+	if err := c.record(false, 0, c.platform.restore(prev)); err != nil {
+		return fmt.Errorf("failed to restore proxy settings: %w", err)
+	}
+	log.Printf("Restored system proxy settings from %s", prev.CreatedAt.Format(time.RFC3339))
+	return os.Remove(c.path)
+}
+
+// RecoverStale repairs the system proxy after Custos died without
+// restoring it: if the proxy points at Custos but no SOCKS5 server answers
+// on that port, the saved settings are restored. A snapshot is dropped if
+// the user has configured something else since. It reports whether the
+// system proxy was changed.
+func (c *ProxyController) RecoverStale() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cur, err := c.platform.read()
+	if err != nil {
+		return false, fmt.Errorf("failed to read proxy settings: %w", err)
+	}
+	port, own := c.platform.ownPort(cur)
+	if !own {
+		if _, err := os.Stat(c.path); err == nil {
+			log.Printf("System proxy was changed outside Custos, dropping snapshot %s", c.path)
+			return false, os.Remove(c.path)
+		}
+		return false, nil
+	}
+	if socksListening(port) {
+		return false, nil
+	}
+
+	log.Printf("System proxy points at 127.0.0.1:%d but nothing is listening, restoring it", port)
+	return true, c.disable()
+}
+
+// socksListening reports whether a SOCKS5 server without authentication
+// answers on a local port
+func socksListening(port int) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), time.Second)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	// Version 5, one method: no authentication
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		return false
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return false
+	}
+	return reply[0] == 5
+}
+
+// record keeps the outcome of a change for Status. The change failed if
+// any available backend failed, or if no backend was available.
+func (c *ProxyController) record(enabled bool, port int, backends []ProxyBackendStatus) error {
+	c.status = ProxyStatus{Enabled: enabled, Port: port, Backends: backends, UpdatedAt: time.Now()}
+
+	var errs []error
+	available := false
+	for _, b := range backends {
+		available = available || b.Available
+		if b.Error != "" {
+			errs = append(errs, fmt.Errorf("%s: %s", b.Name, b.Error))
+		}
+	}
+	if !available {
+		return ErrNoProxyBackend
+	}
+	return errors.Join(errs...)
+}
+
+// Status reports the outcome of the last change to the system proxy
+func (c *ProxyController) Status() ProxyStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := c.status
+	status.Backends = append([]ProxyBackendStatus(nil), c.status.Backends...)
+	_, err := os.Stat(c.path)
+	status.Saved = err == nil
+	return status
+}
+
+// Snapshot returns the saved settings, or nil if Custos hasn't changed them
+func (c *ProxyController) Snapshot() *ProxySettings {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev, err := c.load()
+	if err != nil {
+		return nil
+	}
+	return prev
+}
+
+func (c *ProxyController) load() (*ProxySettings, error) {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+	var prev ProxySettings
+	if err := json.Unmarshal(data, &prev); err != nil {
+		return nil, err
+	}
+	return &prev, nil
+}
+
+// save writes the snapshot atomically, a torn file would lose the settings
+func (c *ProxyController) save(prev *ProxySettings) error {
+	if prev.CreatedAt.IsZero() {
+		prev.CreatedAt = time.Now()
+	}
+	data, err := json.MarshalIndent(prev, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
 }
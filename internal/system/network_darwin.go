@@ -4,7 +4,26 @@ import (
 	"log"
 )
 
-func setProxy(enabled bool, port int) error {
+// darwinProxy is a placeholder until networksetup support is added
+type darwinProxy struct{}
+
+func newProxyPlatform() proxyPlatform {
+	return darwinProxy{}
+}
+
+func (darwinProxy) read() (*ProxySettings, error) {
+	return &ProxySettings{Platform: "darwin", Values: make(map[string]string)}, nil
+}
+
+func (darwinProxy) restore(prev *ProxySettings) []ProxyBackendStatus {
+	return []ProxyBackendStatus{{Name: "networksetup"}}
+}
+
+func (darwinProxy) ownPort(s *ProxySettings) (int, bool) {
+	return 0, false
+}
+
+func (darwinProxy) set(enabled bool, port int) []ProxyBackendStatus {
 	log.Println("System proxy configuration not yet implemented for darwin")
-	return nil
+	return []ProxyBackendStatus{{Name: "networksetup"}}
 }
@@ -0,0 +1,149 @@
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	envProxyFile    = "90-custos-proxy.conf"
+	profileBegin    = "# >>> custos proxy >>>"
+	profileEnd      = "# <<< custos proxy <<<"
+	envProxyURLBase = "socks5h://127.0.0.1:"
+)
+
+// envProxyVars are set for programs that only read the environment, like
+// curl, git and most command line tools
+var envProxyVars = []string{"ALL_PROXY", "all_proxy", "http_proxy", "https_proxy", "HTTPS_PROXY"}
+
+// envProxy sets the proxy variables for new sessions through
+// environment.d, and for login shells through a marked block in
+// ~/.profile. Both are Custos's own and are removed when turned off;
+// nothing else in ~/.profile is touched.
+type envProxy struct {
+	home      string
+	configDir string // "" = home/.config
+}
+
+func (e *envProxy) name() string {
+	return "environment"
+}
+
+func (e *envProxy) available() bool {
+	return e.home != ""
+}
+
+func (e *envProxy) envFile() string {
+	dir := e.configDir
+	if dir == "" {
+		dir = filepath.Join(e.home, ".config")
+	}
+	return filepath.Join(dir, "environment.d", envProxyFile)
+}
+
+func (e *envProxy) profile() string {
+	return filepath.Join(e.home, ".profile")
+}
+
+func (e *envProxy) read(prev *ProxySettings) error {
+	f, err := os.Open(e.envFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if key, val, ok := strings.Cut(sc.Text(), "="); ok && key == "ALL_PROXY" {
+			prev.Values["env ALL_PROXY"] = val
+		}
+	}
+	return sc.Err()
+}
+
+// restore removes the variables, they are only ever set by Custos
+func (e *envProxy) restore(prev *ProxySettings) error {
+	return e.set(false, 0)
+}
+
+func (e *envProxy) ownPort(s *ProxySettings) (int, bool) {
+	rest, ok := strings.CutPrefix(s.Values["env ALL_PROXY"], envProxyURLBase)
+	if !ok {
+		return 0, false
+	}
+	port, err := strconv.Atoi(rest)
+	return port, err == nil
+}
+
+func (e *envProxy) set(enabled bool, port int) error {
+	if !enabled {
+		if err := os.Remove(e.envFile()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return e.writeProfileBlock(nil)
+	}
+
+	url := envProxyURLBase + strconv.Itoa(port)
+	noProxy := strings.Join(noProxyHosts, ",")
+	var env, exports []string
+	for _, name := range envProxyVars {
+		env = append(env, name+"="+url)
+		exports = append(exports, fmt.Sprintf("export %s=%q", name, url))
+	}
+	for _, name := range []string{"NO_PROXY", "no_proxy"} {
+		env = append(env, name+"="+noProxy)
+		exports = append(exports, fmt.Sprintf("export %s=%q", name, noProxy))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(e.envFile()), 0755); err != nil {
+		return err
+	}
+	content := "# Written by Custos, removed when the system proxy is turned off\n" + strings.Join(env, "\n") + "\n"
+	if err := os.WriteFile(e.envFile(), []byte(content), 0644); err != nil {
+		return err
+	}
+	return e.writeProfileBlock(exports)
+}
+
+// writeProfileBlock replaces the Custos block in ~/.profile with lines,
+// or removes it if lines is empty
+func (e *envProxy) writeProfileBlock(lines []string) error {
+	data, err := os.ReadFile(e.profile())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if os.IsNotExist(err) && len(lines) == 0 {
+		return nil
+	}
+
+	var kept []string
+	inBlock := false
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		switch {
+		case strings.TrimSpace(line) == profileBegin:
+			inBlock = true
+		case strings.TrimSpace(line) == profileEnd:
+			inBlock = false
+		case !inBlock && line != "":
+			kept = append(kept, line)
+		}
+	}
+	out := strings.Join(kept, "")
+	if len(lines) > 0 {
+		if out != "" && !strings.HasSuffix(out, "\n") {
+			out += "\n"
+		}
+		out += profileBegin + "\n" + strings.Join(lines, "\n") + "\n" + profileEnd + "\n"
+	}
+	if out == string(data) {
+		return nil
+	}
+	return os.WriteFile(e.profile(), []byte(out), 0644)
+}
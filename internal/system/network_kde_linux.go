@@ -0,0 +1,123 @@
+package system
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	kdeProxyFile  = "kioslaverc"
+	kdeProxyGroup = "Proxy Settings"
+	kdeManual     = "1" // ProxyType of a manually configured proxy
+)
+
+// kdeProxyKeys are the kioslaverc settings Custos changes, ProxyType last
+// for the same reason as the GNOME mode
+var kdeProxyKeys = []string{"socksProxy", "NoProxyFor", "ProxyType"}
+
+// kdeProxy configures KDE Plasma through kioslaverc
+type kdeProxy struct{}
+
+func (kdeProxy) name() string {
+	return "kde"
+}
+
+// kdeTool finds the Plasma 6 or Plasma 5 version of a config tool
+func kdeTool(base string) (string, bool) {
+	for _, v := range []string{"6", "5"} {
+		if path, err := exec.LookPath(base + v); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func (kdeProxy) available() bool {
+	_, okRead := kdeTool("kreadconfig")
+	_, okWrite := kdeTool("kwriteconfig")
+	return okRead && okWrite
+}
+
+func (kdeProxy) read(prev *ProxySettings) error {
+	tool, _ := kdeTool("kreadconfig")
+	for _, key := range kdeProxyKeys {
+		out, err := exec.Command(tool, "--file", kdeProxyFile, "--group", kdeProxyGroup, "--key", key).Output()
+		if err != nil {
+			return err
+		}
+		// Missing keys read as empty
+		if val := strings.TrimRight(string(out), "\n"); val != "" {
+			prev.Values["kde "+key] = val
+		} else {
+			prev.Absent = append(prev.Absent, "kde "+key)
+		}
+	}
+	return nil
+}
+
+func (kdeProxy) restore(prev *ProxySettings) error {
+	tool, _ := kdeTool("kwriteconfig")
+	absent := make(map[string]bool)
+	for _, k := range prev.Absent {
+		absent[k] = true
+	}
+	for _, key := range kdeProxyKeys {
+		args := []string{"--file", kdeProxyFile, "--group", kdeProxyGroup, "--key", key}
+		if val, ok := prev.Values["kde "+key]; ok {
+			args = append(args, val)
+		} else if absent["kde "+key] {
+			args = append(args, "--delete")
+		} else {
+			continue
+		}
+		if err := exec.Command(tool, args...).Run(); err != nil {
+			return err
+		}
+	}
+	notifyKDE()
+	return nil
+}
+
+func (kdeProxy) ownPort(s *ProxySettings) (int, bool) {
+	if s.Values["kde ProxyType"] != kdeManual || s.Values["kde NoProxyFor"] != strings.Join(noProxyHosts, ",") {
+		return 0, false
+	}
+	// Written as "socks://127.0.0.1 1080", newer Plasma versions use a colon
+	rest, ok := strings.CutPrefix(s.Values["kde socksProxy"], "socks://127.0.0.1")
+	if !ok || rest == "" {
+		return 0, false
+	}
+	port, err := strconv.Atoi(rest[1:])
+	return port, err == nil
+}
+
+func (kdeProxy) set(enabled bool, port int) error {
+	tool, _ := kdeTool("kwriteconfig")
+	write := func(key, val string) error {
+		return exec.Command(tool, "--file", kdeProxyFile, "--group", kdeProxyGroup, "--key", key, val).Run()
+	}
+
+	if enabled {
+		if err := write("socksProxy", "socks://127.0.0.1 "+strconv.Itoa(port)); err != nil {
+			return err
+		}
+		if err := write("NoProxyFor", strings.Join(noProxyHosts, ",")); err != nil {
+			return err
+		}
+		if err := write("ProxyType", kdeManual); err != nil {
+			return err
+		}
+	} else if err := write("ProxyType", "0"); err != nil {
+		return err
+	}
+	notifyKDE()
+	return nil
+}
+
+// notifyKDE makes running KDE applications reload their proxy settings
+func notifyKDE() {
+	// Best effort, new applications read the file anyway
+	exec.Command("dbus-send", "--type=signal", "/KIO/Scheduler",
+		"org.kde.KIO.Scheduler.reparseSlaveConfiguration", "string:").Run()
+}
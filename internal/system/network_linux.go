@@ -1,17 +1,154 @@
 package system
 
 import (
-	"log"
+	"fmt"
+	"os"
 	"os/exec"
 	"strconv"
+	"strings"
 )
 
-func setProxy(enabled bool, port int) error {
-	// Check for gsettings
+// noProxyHosts keeps loopback traffic away from the proxy
+var noProxyHosts = []string{"localhost", "127.0.0.0/8", "::1"}
+
+// linuxBackend is one of the places Linux desktops and programs read
+// proxy settings from. Each keeps its values in the snapshot under keys
+// of its own.
+type linuxBackend interface {
+	name() string
+	available() bool
+	// read adds the current settings to a snapshot
+	read(prev *ProxySettings) error
+	restore(prev *ProxySettings) error
+	set(enabled bool, port int) error
+	ownPort(s *ProxySettings) (int, bool)
+}
+
+// linuxProxy applies the proxy to every backend present on the system
+type linuxProxy struct {
+	backends []linuxBackend
+}
+
+func newProxyPlatform() proxyPlatform {
+	home, _ := os.UserHomeDir()
+	configDir, _ := os.UserConfigDir()
+	return &linuxProxy{backends: []linuxBackend{
+		gnomeProxy{},
+		kdeProxy{},
+		&envProxy{home: home, configDir: configDir},
+	}}
+}
+
+func (l *linuxProxy) read() (*ProxySettings, error) {
+	prev := &ProxySettings{Platform: "linux", Values: make(map[string]string)}
+	for _, b := range l.backends {
+		if !b.available() {
+			continue
+		}
+		// A backend that can't be read couldn't be restored either
+		if err := b.read(prev); err != nil {
+			return nil, fmt.Errorf("%s: %w", b.name(), err)
+		}
+	}
+	return prev, nil
+}
+
+func (l *linuxProxy) restore(prev *ProxySettings) []ProxyBackendStatus {
+	return l.each(func(b linuxBackend) error { return b.restore(prev) })
+}
+
+func (l *linuxProxy) set(enabled bool, port int) []ProxyBackendStatus {
+	return l.each(func(b linuxBackend) error { return b.set(enabled, port) })
+}
+
+// each applies a change to every available backend, one failing doesn't
+// stop the others
+func (l *linuxProxy) each(apply func(b linuxBackend) error) []ProxyBackendStatus {
+	statuses := make([]ProxyBackendStatus, 0, len(l.backends))
+	for _, b := range l.backends {
+		if !b.available() {
+			statuses = append(statuses, ProxyBackendStatus{Name: b.name()})
+			continue
+		}
+		statuses = append(statuses, appliedStatus(b.name(), apply(b)))
+	}
+	return statuses
+}
+
+// ownPort reports the settings as Custos's own if any backend points at
+// it; after a crash some backends may already have been restored
+func (l *linuxProxy) ownPort(s *ProxySettings) (int, bool) {
+	for _, b := range l.backends {
+		if port, ok := b.ownPort(s); ok {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// gnomeProxyKeys are the settings Custos changes, as schema and key. The
+// mode comes last so a restored manual proxy is complete when it turns on.
+var gnomeProxyKeys = [][2]string{
+	{"org.gnome.system.proxy.socks", "host"},
+	{"org.gnome.system.proxy.socks", "port"},
+	{"org.gnome.system.proxy", "ignore-hosts"},
+	{"org.gnome.system.proxy", "mode"},
+}
+
+// gnomeIgnoreHosts is noProxyHosts as a GVariant string array
+var gnomeIgnoreHosts = "['" + strings.Join(noProxyHosts, "', '") + "']"
+
+// gnomeProxy configures GNOME and other GTK desktops through gsettings
+type gnomeProxy struct{}
+
+func (gnomeProxy) name() string {
+	return "gnome"
+}
+
+func (gnomeProxy) available() bool {
 	if _, err := exec.LookPath("gsettings"); err != nil {
-		log.Println("gsettings not found, skipping system proxy configuration")
-		return nil
+		return false
 	}
+	// gsettings is also installed where the proxy schema is not
+	return exec.Command("gsettings", "list-keys", "org.gnome.system.proxy").Run() == nil
+}
+
+func (gnomeProxy) read(prev *ProxySettings) error {
+	for _, k := range gnomeProxyKeys {
+		// Values are printed as GVariant text, which set accepts as is
+		out, err := exec.Command("gsettings", "get", k[0], k[1]).Output()
+		if err != nil {
+			return err
+		}
+		prev.Values[k[0]+" "+k[1]] = strings.TrimSpace(string(out))
+	}
+	return nil
+}
+
+func (gnomeProxy) restore(prev *ProxySettings) error {
+	for _, k := range gnomeProxyKeys {
+		val, ok := prev.Values[k[0]+" "+k[1]]
+		if !ok {
+			continue
+		}
+		if err := exec.Command("gsettings", "set", k[0], k[1], val).Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gnomeProxy) ownPort(s *ProxySettings) (int, bool) {
+	if s.Values["org.gnome.system.proxy mode"] != "'manual'" ||
+		s.Values["org.gnome.system.proxy.socks host"] != "'127.0.0.1'" ||
+		s.Values["org.gnome.system.proxy ignore-hosts"] != gnomeIgnoreHosts {
+		return 0, false
+	}
+	port, err := strconv.Atoi(s.Values["org.gnome.system.proxy.socks port"])
+	return port, err == nil
+}
+
+func (gnomeProxy) set(enabled bool, port int) error {
 	if enabled {
 		return enableGnomeProxy(port)
 	}
@@ -29,7 +166,7 @@ func enableGnomeProxy(port int) error {
 	}
 	// Set ignore hosts
 	// Prevent loopback traffic from going through proxy
-	if err := exec.Command("gsettings", "set", "org.gnome.system.proxy", "ignore-hosts", "['localhost', '127.0.0.0/8', '::1']").Run(); err != nil {
+	if err := exec.Command("gsettings", "set", "org.gnome.system.proxy", "ignore-hosts", gnomeIgnoreHosts).Run(); err != nil {
 		return err
 	}
 	// Set mode to manual
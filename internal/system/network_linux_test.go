@@ -0,0 +1,117 @@
+package system
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnvProxy(t *testing.T) {
+	home := t.TempDir()
+	profile := filepath.Join(home, ".profile")
+	userProfile := "export PATH=$HOME/bin:$PATH\n"
+	os.WriteFile(profile, []byte(userProfile), 0644)
+	e := &envProxy{home: home}
+
+	if err := e.set(true, 1080); err != nil {
+		t.Fatal(err)
+	}
+	// Enabling twice, e.g. on a port change, replaces the block
+	if err := e.set(true, 1081); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(profile)
+	if !strings.HasPrefix(string(data), userProfile) || strings.Count(string(data), profileBegin) != 1 ||
+		!strings.Contains(string(data), `export ALL_PROXY="socks5h://127.0.0.1:1081"`) {
+		t.Errorf(".profile after enable:\n%s", data)
+	}
+
+	prev := &ProxySettings{Values: make(map[string]string)}
+	if err := e.read(prev); err != nil {
+		t.Fatal(err)
+	}
+	if port, ok := e.ownPort(prev); !ok || port != 1081 {
+		t.Errorf("ownPort = %d, %v; want 1081", port, ok)
+	}
+
+	if err := e.restore(prev); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(profile); string(data) != userProfile {
+		t.Errorf(".profile after disable = %q; want the user's content only", data)
+	}
+	if _, err := os.Stat(e.envFile()); !os.IsNotExist(err) {
+		t.Errorf("the environment.d file should be removed")
+	}
+}
+
+// fakeBackend records the changes made to it
+type fakeBackend struct {
+	id      string
+	present bool
+	err     error
+	enabled bool
+}
+
+func (f *fakeBackend) name() string                      { return f.id }
+func (f *fakeBackend) available() bool                   { return f.present }
+func (f *fakeBackend) read(prev *ProxySettings) error    { return f.err }
+func (f *fakeBackend) restore(prev *ProxySettings) error { return f.set(false, 0) }
+func (f *fakeBackend) ownPort(s *ProxySettings) (int, bool) {
+	return 0, false
+}
+func (f *fakeBackend) set(enabled bool, port int) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.enabled = enabled
+	return nil
+}
+
+func TestLinuxProxyBackends(t *testing.T) {
+	missing := &fakeBackend{id: "gnome"}
+	broken := &fakeBackend{id: "kde", present: true, err: errors.New("kwriteconfig failed")}
+	working := &fakeBackend{id: "environment", present: true}
+	l := &linuxProxy{backends: []linuxBackend{missing, broken, working}}
+
+	statuses := l.set(true, 1080)
+	if len(statuses) != 3 {
+		t.Fatalf("statuses = %+v; want one per backend", statuses)
+	}
+	if st := statuses[0]; st.Available || st.Applied {
+		t.Errorf("missing backend status = %+v", st)
+	}
+	if st := statuses[1]; !st.Available || st.Applied || st.Error != "kwriteconfig failed" {
+		t.Errorf("broken backend status = %+v", st)
+	}
+	if st := statuses[2]; !st.Applied || !working.enabled {
+		t.Errorf("a failing backend should not stop the others, got %+v", st)
+	}
+	if missing.enabled {
+		t.Errorf("unavailable backends should not be changed")
+	}
+
+	if _, err := l.read(); err == nil || !strings.Contains(err.Error(), "kde") {
+		t.Errorf("read = %v; want the kde error", err)
+	}
+}
+
+func TestKDEOwnPort(t *testing.T) {
+	for socks, want := range map[string]int{
+		"socks://127.0.0.1 1080": 1080,
+		"socks://127.0.0.1:1081": 1081,
+		"socks://10.0.0.1 1080":  0,
+		"socks://127.0.0.1":      0,
+	} {
+		s := &ProxySettings{Values: map[string]string{
+			"kde ProxyType":  kdeManual,
+			"kde NoProxyFor": strings.Join(noProxyHosts, ","),
+			"kde socksProxy": socks,
+		}}
+		if port, _ := (kdeProxy{}).ownPort(s); port != want {
+			t.Errorf("ownPort(%q) = %d; want %d", socks, port, want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package system
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeProxy stands in for the OS settings
+type fakeProxy struct {
+	values map[string]string
+}
+
+func (f *fakeProxy) read() (*ProxySettings, error) {
+	prev := &ProxySettings{Platform: "fake", Values: make(map[string]string)}
+	for k, v := range f.values {
+		prev.Values[k] = v
+	}
+	return prev, nil
+}
+
+func (f *fakeProxy) restore(prev *ProxySettings) []ProxyBackendStatus {
+	f.values = make(map[string]string)
+	for k, v := range prev.Values {
+		f.values[k] = v
+	}
+	return []ProxyBackendStatus{appliedStatus("fake", nil)}
+}
+
+func (f *fakeProxy) set(enabled bool, port int) []ProxyBackendStatus {
+	if enabled {
+		f.values = map[string]string{"mode": "manual", "socks": "127.0.0.1:" + strconv.Itoa(port)}
+	} else {
+		f.values["mode"] = "none"
+	}
+	return []ProxyBackendStatus{appliedStatus("fake", nil)}
+}
+
+func (f *fakeProxy) ownPort(s *ProxySettings) (int, bool) {
+	rest, ok := strings.CutPrefix(s.Values["socks"], "127.0.0.1:")
+	if !ok || s.Values["mode"] != "manual" {
+		return 0, false
+	}
+	port, err := strconv.Atoi(rest)
+	return port, err == nil
+}
+
+func TestProxyControllerRestoresPrevious(t *testing.T) {
+	corporate := map[string]string{"mode": "auto", "pac": "http://proxy.corp/wpad.dat"}
+	fake := &fakeProxy{values: corporate}
+	path := filepath.Join(t.TempDir(), "proxy_snapshot.json")
+	c := &ProxyController{path: path, platform: fake}
+
+	if err := c.SetSystemProxy(true, 1080); err != nil {
+		t.Fatal(err)
+	}
+	if fake.values["mode"] != "manual" {
+		t.Fatalf("enable left %v", fake.values)
+	}
+	if snap := c.Snapshot(); snap == nil || snap.Values["pac"] != corporate["pac"] {
+		t.Fatalf("Snapshot = %+v; want the corporate settings", snap)
+	}
+
+	// Enabling again, e.g. on a port change or after a crash, keeps the
+	// original snapshot instead of saving Custos's own settings
+	if err := c.SetSystemProxy(true, 1081); err != nil {
+		t.Fatal(err)
+	}
+	restarted := &ProxyController{path: path, platform: fake}
+	if err := restarted.SetSystemProxy(false, 1081); err != nil {
+		t.Fatal(err)
+	}
+	if fake.values["mode"] != "auto" || fake.values["pac"] != corporate["pac"] || len(fake.values) != 2 {
+		t.Errorf("disable restored %v; want %v", fake.values, corporate)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the snapshot should be removed once restored")
+	}
+
+	// Without a snapshot, settings that aren't Custos's own survive, e.g.
+	// a launch with protection off
+	if err := restarted.SetSystemProxy(false, 1081); err != nil {
+		t.Fatal(err)
+	}
+	if fake.values["mode"] != "auto" || fake.values["pac"] != corporate["pac"] {
+		t.Errorf("disable without snapshot changed the foreign settings to %v", fake.values)
+	}
+}
+
+func TestProxyControllerStatus(t *testing.T) {
+	fake := &fakeProxy{values: map[string]string{"mode": "none"}}
+	c := &ProxyController{path: filepath.Join(t.TempDir(), "proxy_snapshot.json"), platform: fake}
+
+	c.SetSystemProxy(true, 1080)
+	st := c.Status()
+	if !st.Enabled || st.Port != 1080 || !st.Saved || len(st.Backends) != 1 || !st.Backends[0].Applied {
+		t.Errorf("Status after enable = %+v", st)
+	}
+	c.SetSystemProxy(false, 1080)
+	if st := c.Status(); st.Enabled || st.Saved {
+		t.Errorf("Status after disable = %+v", st)
+	}
+
+	failed := []ProxyBackendStatus{
+		{Name: "missing"},
+		appliedStatus("broken", errors.New("permission denied")),
+		appliedStatus("working", nil),
+	}
+	if err := c.record(true, 1080, failed); err == nil || !strings.Contains(err.Error(), "broken: permission denied") {
+		t.Errorf("record with a failed backend = %v; want its error", err)
+	}
+	if err := c.record(true, 1080, failed[:1]); !errors.Is(err, ErrNoProxyBackend) {
+		t.Errorf("record without backends = %v; want ErrNoProxyBackend", err)
+	}
+}
+
+func TestProxyControllerSkipsOwnSettings(t *testing.T) {
+	// Settings left behind by a crash before snapshots existed
+	fake := &fakeProxy{values: map[string]string{"mode": "manual", "socks": "127.0.0.1:1080"}}
+	c := &ProxyController{path: filepath.Join(t.TempDir(), "proxy_snapshot.json"), platform: fake}
+
+	if err := c.SetSystemProxy(true, 1080); err != nil {
+		t.Fatal(err)
+	}
+	if c.Snapshot() != nil {
+		t.Errorf("Custos's own settings should not be saved as the previous ones")
+	}
+	c.SetSystemProxy(false, 1080)
+	if fake.values["mode"] != "none" {
+		t.Errorf("disable left %v; want the proxy off", fake.values)
+	}
+}
+
+func TestProxyControllerRecoverStale(t *testing.T) {
+	corporate := map[string]string{"mode": "auto"}
+	fake := &fakeProxy{values: corporate}
+	path := filepath.Join(t.TempDir(), "proxy_snapshot.json")
+	c := &ProxyController{path: path, platform: fake}
+
+	// A SOCKS5 server is listening, the proxy is alive
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 3)
+			conn.Read(buf)
+			conn.Write([]byte{5, 0})
+			conn.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	c.SetSystemProxy(true, port)
+
+	if recovered, err := c.RecoverStale(); err != nil || recovered {
+		t.Fatalf("RecoverStale with a live proxy = %v, %v; want no change", recovered, err)
+	}
+	if fake.values["mode"] != "manual" {
+		t.Fatalf("a live proxy should be left alone, got %v", fake.values)
+	}
+
+	// Custos died
+	ln.Close()
+	if recovered, err := c.RecoverStale(); err != nil || !recovered {
+		t.Fatalf("RecoverStale with a dead proxy = %v, %v; want recovered", recovered, err)
+	}
+	if fake.values["mode"] != "auto" {
+		t.Errorf("RecoverStale restored %v; want %v", fake.values, corporate)
+	}
+	if c.Snapshot() != nil {
+		t.Errorf("the snapshot should be consumed")
+	}
+
+	// A snapshot is stale once the user configured a different proxy
+	c.SetSystemProxy(true, port)
+	fake.values = map[string]string{"mode": "manual", "socks": "10.0.0.1:3128"}
+	if recovered, _ := c.RecoverStale(); recovered || c.Snapshot() != nil {
+		t.Errorf("RecoverStale should drop the stale snapshot and keep the user's proxy")
+	}
+	if fake.values["socks"] != "10.0.0.1:3128" {
+		t.Errorf("the user's proxy was changed to %v", fake.values)
+	}
+}
@@ -1,8 +1,11 @@
 package system
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 
 	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/registry"
@@ -18,19 +21,112 @@ const (
 	INTERNET_OPTION_REFRESH          = 37
 )
 
-func setProxy(enabled bool, port int) error {
-	k, err := registry.OpenKey(registry.CURRENT_USER, `Software\Microsoft\Windows\CurrentVersion\Internet Settings`, registry.SET_VALUE)
+const (
+	internetSettingsKey = `Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+	proxyOverride       = "localhost;127.*;<local>"
+	ownProxyPrefix      = "socks=socks5://127.0.0.1:"
+)
+
+// windowsProxy configures the WinINet proxy in the registry
+type windowsProxy struct{}
+
+func newProxyPlatform() proxyPlatform {
+	return windowsProxy{}
+}
+
+func (windowsProxy) read() (*ProxySettings, error) {
+	k, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.QUERY_VALUE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open registry key: %w", err)
+	}
+	defer k.Close()
+
+	prev := &ProxySettings{Platform: "windows", Values: make(map[string]string)}
+	if v, _, err := k.GetIntegerValue("ProxyEnable"); err == nil {
+		prev.Values["ProxyEnable"] = strconv.FormatUint(v, 10)
+	} else if errors.Is(err, registry.ErrNotExist) {
+		prev.Absent = append(prev.Absent, "ProxyEnable")
+	} else {
+		return nil, err
+	}
+	for _, name := range []string{"ProxyServer", "ProxyOverride"} {
+		if v, _, err := k.GetStringValue(name); err == nil {
+			prev.Values[name] = v
+		} else if errors.Is(err, registry.ErrNotExist) {
+			prev.Absent = append(prev.Absent, name)
+		} else {
+			return nil, err
+		}
+	}
+	return prev, nil
+}
+
+// registryBackend names the only Windows backend in status reports
+const registryBackend = "registry"
+
+func (windowsProxy) restore(prev *ProxySettings) []ProxyBackendStatus {
+	return []ProxyBackendStatus{appliedStatus(registryBackend, restoreRegistry(prev))}
+}
+
+func restoreRegistry(prev *ProxySettings) error {
+	k, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to open registry key: %w", err)
+	}
+	defer k.Close()
+
+	for _, name := range []string{"ProxyServer", "ProxyOverride"} {
+		if v, ok := prev.Values[name]; ok {
+			if err := k.SetStringValue(name, v); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", name, err)
+			}
+		}
+	}
+	if v, ok := prev.Values["ProxyEnable"]; ok {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid ProxyEnable %q", v)
+		}
+		if err := k.SetDWordValue("ProxyEnable", uint32(n)); err != nil {
+			return fmt.Errorf("failed to restore ProxyEnable: %w", err)
+		}
+	}
+	for _, name := range prev.Absent {
+		if err := k.DeleteValue(name); err != nil && !errors.Is(err, registry.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+
+	notifyProxyChange()
+	return nil
+}
+
+func (windowsProxy) ownPort(s *ProxySettings) (int, bool) {
+	rest, ok := strings.CutPrefix(s.Values["ProxyServer"], ownProxyPrefix)
+	if !ok || s.Values["ProxyEnable"] != "1" || s.Values["ProxyOverride"] != proxyOverride {
+		return 0, false
+	}
+	port, err := strconv.Atoi(rest)
+	return port, err == nil
+}
+
+func (windowsProxy) set(enabled bool, port int) []ProxyBackendStatus {
+	return []ProxyBackendStatus{appliedStatus(registryBackend, setRegistry(enabled, port))}
+}
+
+func setRegistry(enabled bool, port int) error {
+	k, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.SET_VALUE)
 	if err != nil {
 		return fmt.Errorf("failed to open registry key: %w", err)
 	}
 	defer k.Close()
 
 	if enabled {
-		proxyServer := fmt.Sprintf("socks=socks5://127.0.0.1:%d", port)
+		proxyServer := fmt.Sprintf("%s%d", ownProxyPrefix, port)
 		if err = k.SetStringValue("ProxyServer", proxyServer); err != nil {
 			return fmt.Errorf("failed to set ProxyServer: %w", err)
 		}
-		if err = k.SetStringValue("ProxyOverride", "localhost;127.*;<local>"); err != nil {
+		if err = k.SetStringValue("ProxyOverride", proxyOverride); err != nil {
 			return fmt.Errorf("failed to set ProxyOverride: %w", err)
 		}
 		if err = k.SetDWordValue("ProxyEnable", 1); err != nil {
@@ -44,7 +140,12 @@ func setProxy(enabled bool, port int) error {
 		log.Println("Disabled system proxy")
 	}
 
-	// Notify system of settings change
+	notifyProxyChange()
+	return nil
+}
+
+// notifyProxyChange makes running applications pick up the new settings
+func notifyProxyChange() {
 	// Call InternetSetOption to refresh settings immediately
 	// 0 means NULL handle
 	ret, _, err := procInternetSetOption.Call(0, uintptr(INTERNET_OPTION_SETTINGS_CHANGED), 0, 0)
@@ -56,6 +157,4 @@ func setProxy(enabled bool, port int) error {
 	if ret == 0 {
 		log.Printf("InternetSetOption(REFRESH) failed: %v", err)
 	}
-
-	return nil
 }
@@ -0,0 +1,252 @@
+//go:build linux
+
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxIndexedSockets bounds the inode index, it is rebuilt from scratch past this
+	maxIndexedSockets = 16384
+	// maxRecentPIDs is how many connection owners are scanned before the rest
+	maxRecentPIDs = 32
+	// tcpListen is the LISTEN state in /proc/net/tcp
+	tcpListen = "0A"
+)
+
+// procResolver maps a local port and peer to its socket inode through /proc/net/tcp
+// and the inode to a PID through the fd links in /proc/<pid>/fd. Every socket
+// seen while scanning is indexed, and processes that recently opened
+// connections are scanned first since they usually open the next one too.
+type procResolver struct {
+	root     string // "/proc", replaced in tests
+	fallback portResolver
+
+	mu     sync.Mutex
+	inodes map[uint64]int32 // Socket inode -> PID
+	recent []int32          // Most recent owner first
+}
+
+func newPortResolver() portResolver {
+	return &procResolver{
+		root:     "/proc",
+		fallback: gopsutilResolver{},
+		inodes:   make(map[uint64]int32),
+	}
+}
+
+func (r *procResolver) lookup(port int, peer Peer) (int32, error) {
+	inode, err := r.socketInode(port, peer)
+	if os.IsNotExist(err) && r.fallback != nil {
+		// No procfs, e.g. in a restricted container
+		return r.fallback.lookup(port, peer)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if pid, ok := r.inodes[inode]; ok && r.ownsSocket(pid, inode) {
+		r.touch(pid)
+		return pid, nil
+	}
+	if pid, ok := r.scan(inode); ok {
+		r.touch(pid)
+		return pid, nil
+	}
+	return 0, errPortNotFound
+}
+
+// socketInode finds the inode of the socket bound to a local port and
+// connected to peer
+func (r *procResolver) socketInode(port int, peer Peer) (uint64, error) {
+	var lastErr error
+	for _, name := range []string{"tcp", "tcp6"} {
+		inode, err := findSocketInode(filepath.Join(r.root, "net", name), port, peer)
+		if err == nil {
+			return inode, nil
+		}
+		lastErr = err
+		if err != errPortNotFound && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+	return 0, lastErr
+}
+
+// findSocketInode reads a /proc/net/tcp style table:
+//
+//	sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+//	 0: 0100007F:D2A4 0100007F:0438 01 00000000:00000000 00:00000000 00000000  1000        0 73420
+//
+// A local port can be shared by connections to different peers, so the
+// remote address has to match too.
+func findSocketInode(path string, port int, peer Peer) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// Cheap substring test before splitting, the port is followed by a space
+	// in both the local and the remote address
+	needle := []byte(fmt.Sprintf(":%04X ", port))
+	sc := bufio.NewScanner(f)
+	sc.Scan() // Header
+	for sc.Scan() {
+		line := sc.Bytes()
+		if !bytes.Contains(line, needle) {
+			continue
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) < 10 || fields[3] == tcpListen {
+			continue
+		}
+		if _, localPort, err := parseSocketAddr(fields[1]); err != nil || localPort != port {
+			continue
+		}
+		if ip, remotePort, err := parseSocketAddr(fields[2]); err != nil || !peer.matches(ip, remotePort) {
+			continue
+		}
+		// Sockets in TIME_WAIT have no owner and inode 0
+		if inode, err := strconv.ParseUint(fields[9], 10, 64); err == nil && inode != 0 {
+			return inode, nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errPortNotFound
+}
+
+// parseSocketAddr parses an address of /proc/net/tcp, the IP is printed as
+// 32-bit words in host byte order: "0100007F:0438" is 127.0.0.1:1080
+func parseSocketAddr(s string) (net.IP, int, error) {
+	hexIP, hexPort, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid socket address %q", s)
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	raw, err := hex.DecodeString(hexIP)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid socket address %q", s)
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.NativeEndian.PutUint32(ip[i:], binary.BigEndian.Uint32(raw[i:]))
+	}
+	return ip, int(port), nil
+}
+
+// scan walks the fd tables of recent owners and then every other process,
+// indexing the sockets it passes, until it finds the inode
+func (r *procResolver) scan(inode uint64) (int32, bool) {
+	if len(r.inodes) > maxIndexedSockets {
+		clear(r.inodes)
+	}
+
+	scanned := make(map[int32]bool, len(r.recent))
+	for _, pid := range r.recent {
+		scanned[pid] = true
+		if r.indexFDs(pid, inode) {
+			return pid, true
+		}
+	}
+
+	entries, err := os.ReadDir(r.root)
+	if err != nil {
+		return 0, false
+	}
+	for _, e := range entries {
+		n, err := strconv.ParseInt(e.Name(), 10, 32)
+		if err != nil || !e.IsDir() || scanned[int32(n)] {
+			continue
+		}
+		if r.indexFDs(int32(n), inode) {
+			return int32(n), true
+		}
+	}
+	return 0, false
+}
+
+// indexFDs records the sockets a process holds and reports whether one of
+// them is the wanted inode. Processes of other users are skipped silently.
+func (r *procResolver) indexFDs(pid int32, want uint64) bool {
+	dir := filepath.Join(r.root, strconv.Itoa(int(pid)), "fd")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	found := false
+	for _, e := range entries {
+		inode, ok := readSocketLink(filepath.Join(dir, e.Name()))
+		if !ok {
+			continue
+		}
+		r.inodes[inode] = pid
+		if inode == want {
+			// Keep indexing the rest of this process, it likely opens more
+			found = true
+		}
+	}
+	return found
+}
+
+// ownsSocket confirms an index hit, the process may have exited or closed
+// the socket since it was indexed
+func (r *procResolver) ownsSocket(pid int32, inode uint64) bool {
+	dir := filepath.Join(r.root, strconv.Itoa(int(pid)), "fd")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		delete(r.inodes, inode)
+		return false
+	}
+	for _, e := range entries {
+		if got, ok := readSocketLink(filepath.Join(dir, e.Name())); ok && got == inode {
+			return true
+		}
+	}
+	delete(r.inodes, inode)
+	return false
+}
+
+// readSocketLink parses an fd link of the form "socket:[73420]"
+func readSocketLink(path string) (uint64, bool) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return 0, false
+	}
+	s, ok := strings.CutPrefix(target, "socket:[")
+	if !ok {
+		return 0, false
+	}
+	inode, err := strconv.ParseUint(strings.TrimSuffix(s, "]"), 10, 64)
+	return inode, err == nil
+}
+
+// touch moves a PID to the front of the recent owners
+func (r *procResolver) touch(pid int32) {
+	if i := slices.Index(r.recent, pid); i >= 0 {
+		r.recent = slices.Delete(r.recent, i, i+1)
+	}
+	r.recent = slices.Insert(r.recent, 0, pid)
+	if len(r.recent) > maxRecentPIDs {
+		r.recent = r.recent[:maxRecentPIDs]
+	}
+}
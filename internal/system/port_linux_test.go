@@ -0,0 +1,217 @@
+//go:build linux
+
+package system
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+const tcpHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+// fakeProc builds a procfs tree with the given sockets per PID
+func fakeProc(t *testing.T, tcp string, fds map[int][]uint64) string {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "net", "tcp"), []byte(tcpHeader+tcp), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for pid, inodes := range fds {
+		dir := filepath.Join(root, strconv.Itoa(pid), "fd")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.Symlink("/dev/null", filepath.Join(dir, "0"))
+		for i, inode := range inodes {
+			os.Symlink("socket:["+strconv.FormatUint(inode, 10)+"]", filepath.Join(dir, strconv.Itoa(i+3)))
+		}
+	}
+	return root
+}
+
+func TestFindSocketInode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tcp")
+	table := tcpHeader +
+		"   0: 00000000:D2A4 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 100 1 0000000000000000 100 0 0 10 0\n" +
+		"   1: 0100007F:0438 0100007F:D2A4 01 00000000:00000000 00:00000000 00000000  1000        0 200 1 0000000000000000 20 4 30 10 -1\n" +
+		"   2: 0100007F:D2A4 0100007F:0438 06 00000000:00000000 03:00000F9F 00000000     0        0 0 3 0000000000000000\n" +
+		"   3: 0100007F:D2A4 0100007F:0438 01 00000000:00000000 00:00000000 00000000  1000        0 300 1 0000000000000000 20 4 30 10 -1\n"
+	if err := os.WriteFile(path, []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Skips the listener, the proxy side with the port as remote and TIME_WAIT
+	if inode, err := findSocketInode(path, 0xD2A4, Peer{}); err != nil || inode != 300 {
+		t.Errorf("findSocketInode = %d, %v; want 300", inode, err)
+	}
+	if _, err := findSocketInode(path, 80, Peer{}); !errors.Is(err, errPortNotFound) {
+		t.Errorf("missing port: err = %v; want errPortNotFound", err)
+	}
+}
+
+func TestFindSocketInodePeer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tcp")
+	// The same local port connected to the proxy and, through the transparent
+	// proxy, to 93.184.216.34:443 and [2001:db8::1]:443
+	table := tcpHeader +
+		"   0: 0100007F:D2A4 22D8B85D:01BB 01 00000000:00000000 00:00000000 00000000  1000        0 400 1 0000000000000000 20 4 30 10 -1\n" +
+		"   1: 0100007F:D2A4 0100007F:0438 01 00000000:00000000 00:00000000 00000000  1000        0 300 1 0000000000000000 20 4 30 10 -1\n"
+	if err := os.WriteFile(path, []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path6 := filepath.Join(t.TempDir(), "tcp6")
+	table6 := tcpHeader +
+		"   0: 00000000000000000000000001000000:D2A4 B80D0120000000000000000001000000:01BB 01 00000000:00000000 00:00000000 00000000  1000        0 600 1 0000000000000000 20 4 30 10 -1\n"
+	if err := os.WriteFile(path6, []byte(table6), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		peer Peer
+		want uint64
+	}{
+		{"proxy port", path, Peer{Port: 0x0438}, 300},
+		{"original destination", path, Peer{IP: net.ParseIP("93.184.216.34"), Port: 443}, 400},
+		{"IPv6 destination", path6, Peer{IP: net.ParseIP("2001:db8::1"), Port: 443}, 600},
+	}
+	for _, tt := range tests {
+		if inode, err := findSocketInode(tt.path, 0xD2A4, tt.peer); err != nil || inode != tt.want {
+			t.Errorf("%s: findSocketInode = %d, %v; want %d", tt.name, inode, err, tt.want)
+		}
+	}
+	if _, err := findSocketInode(path, 0xD2A4, Peer{IP: net.ParseIP("93.184.216.34"), Port: 80}); !errors.Is(err, errPortNotFound) {
+		t.Errorf("other peer: err = %v; want errPortNotFound", err)
+	}
+}
+
+func TestProcResolver(t *testing.T) {
+	tcp := "   0: 0100007F:D2A4 0100007F:0438 01 00000000:00000000 00:00000000 00000000  1000        0 300 1 0000000000000000 20 4 30 10 -1\n" +
+		"   1: 0100007F:D2A5 0100007F:0438 01 00000000:00000000 00:00000000 00000000  1000        0 301 1 0000000000000000 20 4 30 10 -1\n"
+	root := fakeProc(t, tcp, map[int][]uint64{
+		10: {7},
+		20: {300, 301},
+	})
+	r := &procResolver{root: root, inodes: make(map[uint64]int32)}
+
+	if pid, err := r.lookup(0xD2A4, Peer{Port: 0x0438}); err != nil || pid != 20 {
+		t.Fatalf("lookup = %d, %v; want 20", pid, err)
+	}
+	if r.inodes[301] != 20 {
+		t.Errorf("the other sockets of the owner should be indexed")
+	}
+	if len(r.recent) != 1 || r.recent[0] != 20 {
+		t.Errorf("recent = %v; want [20]", r.recent)
+	}
+
+	// An index hit for a process that closed the socket is not trusted
+	os.RemoveAll(filepath.Join(root, "20"))
+	if _, err := r.lookup(0xD2A5, Peer{Port: 0x0438}); !errors.Is(err, errPortNotFound) {
+		t.Errorf("stale index: err = %v; want errPortNotFound", err)
+	}
+	if _, ok := r.inodes[301]; ok {
+		t.Errorf("stale index entries should be dropped")
+	}
+}
+
+func TestProcResolverOwnConnection(t *testing.T) {
+	if _, err := os.Stat("/proc/net/tcp"); err != nil {
+		t.Skip("procfs not available")
+	}
+	conn := dialLoopback(t)
+
+	r := newPortResolver()
+	port := conn.LocalAddr().(*net.TCPAddr).Port
+	if pid, err := r.lookup(port, remotePeer(conn)); err != nil || int(pid) != os.Getpid() {
+		t.Errorf("lookup(%d) = %d, %v; want this process %d", port, pid, err, os.Getpid())
+	}
+}
+
+// dialLoopback opens a connection to a local listener, the client side
+// belongs to this process like a browser's connection to the proxy
+func dialLoopback(tb testing.TB) net.Conn {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			tb.Cleanup(func() { c.Close() })
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func remotePeer(conn net.Conn) Peer {
+	addr := conn.RemoteAddr().(*net.TCPAddr)
+	return Peer{IP: addr.IP, Port: addr.Port}
+}
+
+// The per-connection cost of attribution with each resolver, uncached
+
+func BenchmarkProcResolver(b *testing.B) {
+	conn := dialLoopback(b)
+	port := conn.LocalAddr().(*net.TCPAddr).Port
+	r := newPortResolver().(*procResolver)
+	for b.Loop() {
+		// A new connection is never in the index
+		clear(r.inodes)
+		if _, err := r.lookup(port, remotePeer(conn)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcResolverColdScan(b *testing.B) {
+	conn := dialLoopback(b)
+	port := conn.LocalAddr().(*net.TCPAddr).Port
+	r := newPortResolver().(*procResolver)
+	for b.Loop() {
+		clear(r.inodes)
+		r.recent = nil
+		if _, err := r.lookup(port, remotePeer(conn)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGopsutilResolver(b *testing.B) {
+	conn := dialLoopback(b)
+	port := conn.LocalAddr().(*net.TCPAddr).Port
+	var r gopsutilResolver
+	for b.Loop() {
+		if _, err := r.lookup(port, remotePeer(conn)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTrackerCached(b *testing.B) {
+	conn := dialLoopback(b)
+	port := conn.LocalAddr().(*net.TCPAddr).Port
+	t := NewTracker()
+	t.GetProcessFromPort(port, remotePeer(conn))
+	for b.Loop() {
+		t.GetProcessFromPort(port, remotePeer(conn))
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package system
+
+func newPortResolver() portResolver {
+	return gopsutilResolver{}
+}
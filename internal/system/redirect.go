@@ -0,0 +1,24 @@
+package system
+
+import "errors"
+
+// RedirectMark is set on Custos's own outbound connections so the redirect
+// rules let them through instead of looping them back
+const RedirectMark = 0xc057
+
+// ErrRedirectUnsupported is returned where transparent proxying isn't available
+var ErrRedirectUnsupported = errors.New("transparent proxying is only supported on Linux")
+
+// InstallRedirect redirects outbound TCP connections of this host to a
+// local port, except loopback traffic, connections marked with RedirectMark
+// and those from the cgroup of Custos if it has one to itself. Existing
+// Custos rules are replaced. It returns the name of the firewall used.
+// Changing the firewall and marking sockets need CAP_NET_ADMIN.
+func InstallRedirect(port int) (string, error) {
+	return installRedirect(port)
+}
+
+// RemoveRedirect deletes the redirect rules, it does nothing if there are none
+func RemoveRedirect() error {
+	return removeRedirect()
+}
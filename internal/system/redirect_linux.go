@@ -0,0 +1,234 @@
+package system
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	nftTable      = "custos"
+	iptablesChain = "CUSTOS"
+)
+
+func installRedirect(port int) (string, error) {
+	cgroup := ownCgroup()
+	if cgroup == "" {
+		log.Printf("Custos shares its cgroup with other programs, only its marked connections skip the redirect")
+	}
+
+	firewall, err := "", errors.New("neither nft nor iptables was found")
+	if _, lookErr := exec.LookPath("nft"); lookErr == nil {
+		firewall, err = "nftables", runNft(nftRuleset(port, cgroup))
+	} else if _, lookErr := exec.LookPath("iptables"); lookErr == nil {
+		firewall, err = "iptables", installIptables(port, cgroup)
+	}
+	if err != nil && !hasNetAdmin() {
+		return firewall, fmt.Errorf("%w; changing the firewall needs CAP_NET_ADMIN, see \"Transparent proxy\" in the README", err)
+	}
+	return firewall, err
+}
+
+func removeRedirect() error {
+	var errs []error
+	// Listing fails both when there are no rules and when we may not
+	// change them, either way there is nothing to do
+	if _, err := exec.LookPath("nft"); err == nil && firewallCommand("nft", "list", "table", "inet", nftTable).Run() == nil {
+		errs = append(errs, runNft(fmt.Sprintf("delete table inet %s\n", nftTable)))
+	}
+	for _, tool := range []string{"iptables", "ip6tables"} {
+		if _, err := exec.LookPath(tool); err == nil {
+			errs = append(errs, removeIptables(tool))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ownCgroup returns the cgroup v2 path of this process, relative to the
+// cgroup root, if nothing but Custos runs in it, e.g. under custos.service.
+// Matching it lets every connection of Custos out, also the filter
+// downloads, DNS upstreams and update checks that aren't marked.
+func ownCgroup() string {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	path, ok := cgroupV2Path(string(data))
+	if !ok || path == "" {
+		return ""
+	}
+
+	procs, err := os.ReadFile(filepath.Join("/sys/fs/cgroup", path, "cgroup.procs"))
+	if err != nil {
+		return ""
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	for _, pid := range strings.Fields(string(procs)) {
+		if exe, err := os.Readlink(filepath.Join("/proc", pid, "exe")); err != nil || exe != self {
+			return ""
+		}
+	}
+	return path
+}
+
+// cgroupV2Path finds the unified hierarchy entry in /proc/self/cgroup,
+// "0::/user.slice/custos.service", and returns it without the leading slash
+func cgroupV2Path(procCgroup string) (string, bool) {
+	for _, line := range strings.Split(procCgroup, "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return strings.Trim(path, "/"), true
+		}
+	}
+	return "", false
+}
+
+// nftRuleset replaces the Custos table in one transaction. Connections from
+// cgroup, if set, and marked ones are let through.
+func nftRuleset(port int, cgroup string) string {
+	var exempt string
+	if cgroup != "" {
+		exempt = fmt.Sprintf("\t\tsocket cgroupv2 level %d %q return\n", strings.Count(cgroup, "/")+1, cgroup)
+	}
+	return fmt.Sprintf(`table inet %[1]s
+delete table inet %[1]s
+table inet %[1]s {
+	chain output {
+		type nat hook output priority -100; policy accept;
+%[4]s		meta mark %#[2]x return
+		ip daddr 127.0.0.0/8 return
+		ip6 daddr ::1 return
+		meta l4proto tcp redirect to :%[3]d
+	}
+}
+`, nftTable, RedirectMark, port, exempt)
+}
+
+// hasNetAdmin reports whether this process may change the firewall, as
+// root or with the capability set on the executable
+func hasNetAdmin() bool {
+	caps, err := capabilities()
+	return err == nil && caps.Effective&(1<<unix.CAP_NET_ADMIN) != 0
+}
+
+// capabilities returns the first 32 capabilities of this process, the
+// network ones are among them
+func capabilities() (unix.CapUserData, error) {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	err := unix.Capget(&hdr, &data[0])
+	return data[0], err
+}
+
+// firewallCommand runs a firewall tool with the network capabilities of
+// this process. An unprivileged process that got them from
+// `setcap cap_net_admin,cap_net_raw+eip` has to pass them on as ambient
+// capabilities, exec drops them otherwise.
+func firewallCommand(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	if os.Geteuid() == 0 {
+		return cmd
+	}
+	caps, err := capabilities()
+	if err != nil {
+		return cmd
+	}
+	var ambient []uintptr
+	for _, c := range []uint32{unix.CAP_NET_ADMIN, unix.CAP_NET_RAW} {
+		// Raising an ambient capability needs it permitted and inheritable
+		if bit := uint32(1) << c; caps.Permitted&bit != 0 && caps.Inheritable&bit != 0 {
+			ambient = append(ambient, uintptr(c))
+		}
+	}
+	if len(ambient) > 0 {
+		cmd.SysProcAttr = &syscall.SysProcAttr{AmbientCaps: ambient}
+	}
+	return cmd
+}
+
+func runNft(ruleset string) error {
+	cmd := firewallCommand("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(ruleset)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("nft: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// iptablesRules returns the rules of the Custos chain for one address
+// family, exempting cgroup like nftRuleset
+func iptablesRules(port int, loopback, cgroup string) [][]string {
+	var rules [][]string
+	if cgroup != "" {
+		rules = append(rules, []string{"-m", "cgroup", "--path", cgroup, "-j", "RETURN"})
+	}
+	return append(rules,
+		[]string{"-m", "mark", "--mark", fmt.Sprintf("%#x", RedirectMark), "-j", "RETURN"},
+		[]string{"-d", loopback, "-j", "RETURN"},
+		[]string{"-p", "tcp", "-j", "REDIRECT", "--to-ports", strconv.Itoa(port)},
+	)
+}
+
+func installIptables(port int, cgroup string) error {
+	if err := removeIptables("iptables"); err != nil {
+		return err
+	}
+	if err := setupIptables("iptables", iptablesRules(port, "127.0.0.0/8", cgroup)); err != nil {
+		return err
+	}
+	// IPv6 NAT may be missing, IPv4 redirection is still worth having
+	if _, err := exec.LookPath("ip6tables"); err == nil {
+		removeIptables("ip6tables")
+		if err := setupIptables("ip6tables", iptablesRules(port, "::1/128", cgroup)); err != nil {
+			log.Printf("IPv6 connections won't be redirected: %v", err)
+		}
+	}
+	return nil
+}
+
+func setupIptables(tool string, rules [][]string) error {
+	if err := iptables(tool, "-N", iptablesChain); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := iptables(tool, append([]string{"-A", iptablesChain}, rule...)...); err != nil {
+			return err
+		}
+	}
+	return iptables(tool, "-A", "OUTPUT", "-p", "tcp", "-j", iptablesChain)
+}
+
+// removeIptables unhooks and deletes the Custos chain if it exists and
+// can be seen
+func removeIptables(tool string) error {
+	if iptables(tool, "-n", "-L", iptablesChain) != nil {
+		return nil
+	}
+	for iptables(tool, "-D", "OUTPUT", "-p", "tcp", "-j", iptablesChain) == nil {
+	}
+	if err := iptables(tool, "-F", iptablesChain); err != nil {
+		return err
+	}
+	return iptables(tool, "-X", iptablesChain)
+}
+
+func iptables(tool string, args ...string) error {
+	out, err := firewallCommand(tool, append([]string{"-t", "nat", "-w"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", tool, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package system
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNftRuleset(t *testing.T) {
+	rules := nftRuleset(1090, "")
+
+	// Custos's own and loopback traffic must be let through before the
+	// redirect, or connections would loop back into the proxy
+	mark := strings.Index(rules, "meta mark 0xc057 return")
+	loopback := strings.Index(rules, "ip daddr 127.0.0.0/8 return")
+	redirect := strings.Index(rules, "meta l4proto tcp redirect to :1090")
+	if mark < 0 || loopback < 0 || redirect < 0 || redirect < mark || redirect < loopback {
+		t.Errorf("unexpected ruleset:\n%s", rules)
+	}
+	// The table is replaced as a whole, installing twice doesn't add rules
+	if !strings.HasPrefix(rules, "table inet custos\ndelete table inet custos\n") {
+		t.Errorf("ruleset doesn't replace the table:\n%s", rules)
+	}
+	if strings.Contains(rules, "cgroupv2") {
+		t.Errorf("ruleset without a cgroup matches one:\n%s", rules)
+	}
+
+	// Everything Custos runs in its own cgroup is let through, unmarked too
+	rules = nftRuleset(1090, "user.slice/user-1000.slice/user@1000.service/app.slice/custos.service")
+	cgroup := strings.Index(rules, `socket cgroupv2 level 5 "user.slice/user-1000.slice/user@1000.service/app.slice/custos.service" return`)
+	if cgroup < 0 || cgroup > strings.Index(rules, "redirect to") {
+		t.Errorf("ruleset doesn't exempt the cgroup:\n%s", rules)
+	}
+}
+
+func TestCgroupV2Path(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"0::/user.slice/user-1000.slice/user@1000.service/app.slice/custos.service\n", "user.slice/user-1000.slice/user@1000.service/app.slice/custos.service", true},
+		{"12:pids:/user.slice\n1:name=systemd:/user.slice\n0::/\n", "", true},
+		{"4:memory:/user.slice\n", "", false}, // cgroup v1 only
+	}
+	for _, tt := range tests {
+		if got, ok := cgroupV2Path(tt.in); got != tt.want || ok != tt.ok {
+			t.Errorf("cgroupV2Path(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIptablesRules(t *testing.T) {
+	rules := iptablesRules(1090, "127.0.0.0/8", "")
+	if len(rules) != 3 {
+		t.Fatalf("got %d rules, want 3", len(rules))
+	}
+	if !slices.Equal(rules[0], []string{"-m", "mark", "--mark", "0xc057", "-j", "RETURN"}) {
+		t.Errorf("first rule = %v, want the mark exemption", rules[0])
+	}
+	if last := rules[len(rules)-1]; !slices.Equal(last[len(last)-2:], []string{"--to-ports", "1090"}) {
+		t.Errorf("last rule = %v, want the redirect", last)
+	}
+
+	rules = iptablesRules(1090, "127.0.0.0/8", "system.slice/custos.service")
+	if !slices.Equal(rules[0], []string{"-m", "cgroup", "--path", "system.slice/custos.service", "-j", "RETURN"}) {
+		t.Errorf("first rule = %v, want the cgroup exemption", rules[0])
+	}
+}
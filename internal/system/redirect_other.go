@@ -0,0 +1,11 @@
+//go:build !linux
+
+package system
+
+func installRedirect(port int) (string, error) {
+	return "", ErrRedirectUnsupported
+}
+
+func removeRedirect() error {
+	return nil
+}
@@ -1,5 +1,12 @@
 package system
 
+// DaemonArg starts Custos without a window
+const DaemonArg = "daemon"
+
+// ExitRunning is the exit status of a daemon that found another instance
+// running; the service unit isn't restarted for it
+const ExitRunning = 3
+
 // SetStartup configures the application to run on system startup
 func SetStartup(enabled bool) error {
 	return setStartup(enabled)
@@ -5,28 +5,73 @@ package system
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
+// UserServiceName is the systemd user unit that runs the Custos daemon
+const UserServiceName = "custos.service"
+
+// setStartup runs the daemon from a systemd user unit where the user has a
+// systemd instance, so it starts with any session, graphical or not.
+// Elsewhere the window is started from an XDG autostart entry.
 func setStartup(enabled bool) error {
 	configDir, err := os.UserConfigDir()
 	if err != nil {
 		return err
 	}
-	autostartDir := filepath.Join(configDir, "autostart")
-	desktopFile := filepath.Join(autostartDir, "custos.desktop")
+	desktopFile := filepath.Join(configDir, "autostart", "custos.desktop")
+	unitFile := filepath.Join(configDir, "systemd", "user", UserServiceName)
 
-	if enabled {
-		if err := os.MkdirAll(autostartDir, 0755); err != nil {
-			return err
+	if !enabled {
+		if hasUserSystemd() {
+			// The unit only starts at the next login, so don't stop a
+			// daemon the window may be attached to
+			exec.Command("systemctl", "--user", "disable", UserServiceName).Run()
+		}
+		for _, path := range []string{unitFile, desktopFile} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if hasUserSystemd() {
+			exec.Command("systemctl", "--user", "daemon-reload").Run()
 		}
+		return nil
+	}
 
-		exePath, err := os.Executable()
-		if err != nil {
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if hasUserSystemd() {
+		if err := os.MkdirAll(filepath.Dir(unitFile), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(unitFile, []byte(UserServiceUnit(exePath)), 0644); err != nil {
+			return err
+		}
+		if out, err := exec.Command("systemctl", "--user", "daemon-reload").CombinedOutput(); err != nil {
+			return fmt.Errorf("systemctl daemon-reload: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		if out, err := exec.Command("systemctl", "--user", "enable", UserServiceName).CombinedOutput(); err != nil {
+			return fmt.Errorf("systemctl enable: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		// Starting both would run two instances
+		if err := os.Remove(desktopFile); err != nil && !os.IsNotExist(err) {
 			return err
 		}
+		return nil
+	}
 
-		content := fmt.Sprintf(`[Desktop Entry]
+	autostartDir := filepath.Dir(desktopFile)
+	if err := os.MkdirAll(autostartDir, 0755); err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf(`[Desktop Entry]
 Type=Application
 Name=Custos
 Comment=Custos Network Monitor
@@ -36,14 +81,48 @@ NoDisplay=false
 X-GNOME-Autostart-enabled=true
 `, exePath)
 
-		return os.WriteFile(desktopFile, []byte(content), 0644)
+	return os.WriteFile(desktopFile, []byte(content), 0644)
+}
+
+// UserServiceUnit returns a systemd user unit running exePath as a daemon.
+// Stopping it only signals the daemon, which stops its proxy watchdog
+// itself once the watchdog has cleaned up.
+func UserServiceUnit(exePath string) string {
+	return fmt.Sprintf(`[Unit]
+Description=Custos network monitor
+Documentation=https://github.com/vkhangstack/Custos
+
+[Service]
+Type=simple
+ExecStart=%s %s
+Restart=on-failure
+RestartSec=5
+RestartPreventExitStatus=%d
+KillMode=mixed
+
+[Install]
+WantedBy=default.target
+`, systemdQuote(exePath), DaemonArg, ExitRunning)
+}
+
+// systemdQuote quotes a path for ExecStart if it needs it
+func systemdQuote(s string) string {
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
 	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
 
-	// Remove file
-	if err := os.Remove(desktopFile); err != nil && !os.IsNotExist(err) {
-		return err
+// hasUserSystemd reports whether the user has a running systemd instance
+func hasUserSystemd() bool {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return false
+	}
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return false
 	}
-	return nil
+	return exec.Command("systemctl", "--user", "show-environment").Run() == nil
 }
 
 func isStartupEnabled() (bool, error) {
@@ -51,8 +130,13 @@ func isStartupEnabled() (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	desktopFile := filepath.Join(configDir, "autostart", "custos.desktop")
 
+	unitFile := filepath.Join(configDir, "systemd", "user", UserServiceName)
+	if _, err := os.Stat(unitFile); err == nil && hasUserSystemd() {
+		return exec.Command("systemctl", "--user", "is-enabled", "--quiet", UserServiceName).Run() == nil, nil
+	}
+
+	desktopFile := filepath.Join(configDir, "autostart", "custos.desktop")
 	if _, err := os.Stat(desktopFile); err == nil {
 		return true, nil
 	}
@@ -0,0 +1,28 @@
+package system
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUserServiceUnit(t *testing.T) {
+	unit := UserServiceUnit("/usr/bin/custos")
+	for _, want := range []string{
+		"ExecStart=/usr/bin/custos daemon\n",
+		"Restart=on-failure\n",
+		// An instance that is already running isn't worth retrying every 5s
+		"RestartPreventExitStatus=3\n",
+		// The watchdog has to outlive the daemon to clean up after it
+		"KillMode=mixed\n",
+		"WantedBy=default.target\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("unit lacks %q:\n%s", want, unit)
+		}
+	}
+
+	unit = UserServiceUnit(`/home/me/My Apps/cus"tos`)
+	if !strings.Contains(unit, `ExecStart="/home/me/My Apps/cus\"tos" daemon`) {
+		t.Errorf("path isn't quoted:\n%s", unit)
+	}
+}
@@ -1,9 +1,15 @@
 package system
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	stdnet "net"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
 
 	"github.com/shirou/gopsutil/v4/net"
 	"github.com/shirou/gopsutil/v4/process"
@@ -19,16 +25,77 @@ type ConnectionInfo struct {
 	Protocol    string `json:"protocol"` // "tcp" or "udp"
 }
 
+const (
+	// maxCachedProcesses bounds the identity cache, it is rebuilt past this
+	maxCachedProcesses = 4096
+	// maxLineageDepth stops walking parents at an unreasonable depth
+	maxLineageDepth = 64
+)
+
+// portTTL is how long a resolved client port is trusted, ephemeral ports
+// are reused so entries must not outlive the connection by much
+const portTTL = time.Second
+
+// errPortNotFound is returned when no process owns a local port
+var errPortNotFound = errors.New("no process owns the port")
+
+// Peer is the remote end of a client's socket, the address it connected
+// to. The same ephemeral port may be in use towards several peers. A nil IP
+// or a zero port matches any.
+type Peer struct {
+	IP   stdnet.IP
+	Port int
+}
+
+func (p Peer) String() string {
+	ip := ""
+	if p.IP != nil {
+		ip = p.IP.String()
+	}
+	return stdnet.JoinHostPort(ip, strconv.Itoa(p.Port))
+}
+
+// matches reports whether a socket's remote end is the peer
+func (p Peer) matches(ip stdnet.IP, port int) bool {
+	if p.Port != 0 && p.Port != port {
+		return false
+	}
+	return p.IP == nil || p.IP.IsUnspecified() || p.IP.Equal(ip)
+}
+
+// portKey identifies a client socket in the port cache
+type portKey struct {
+	port int
+	peer string
+}
+
+// portResolver finds the PID owning a local TCP port connected to a peer
+type portResolver interface {
+	lookup(port int, peer Peer) (int32, error)
+}
+
+type portOwner struct {
+	pid     int32
+	expires time.Time
+}
+
 // Tracker manages system process and network monitoring
 type Tracker struct {
 	mu           sync.RWMutex
-	processCache map[int32]string
+	processCache map[int32]core.Process
+	hashes       hashCache
+
+	resolver portResolver
+	portMu   sync.Mutex
+	ports    map[portKey]portOwner
 }
 
 // NewTracker creates a new system tracker
 func NewTracker() *Tracker {
 	return &Tracker{
-		processCache: make(map[int32]string),
+		processCache: make(map[int32]core.Process),
+		resolver:     newPortResolver(),
+		ports:        make(map[portKey]portOwner),
 	}
 }
 
@@ -37,57 +104,170 @@ func (t *Tracker) GetProcessName(pid int32) string {
 	if pid == 0 {
 		return "kernel"
 	}
-
-	t.mu.RLock()
-	// Cache invalidation logic could be added here (e.g. check if PID is reused)
-	// For now, let's just rely on simple map
-	if name, ok := t.processCache[pid]; ok {
-		t.mu.RUnlock()
-		return name
+	p, ok := t.Identify(pid)
+	if !ok {
+		return "unknown"
 	}
-	t.mu.RUnlock()
+	return p.Name
+}
 
+// Identify returns the identity of a running process. Identities are cached
+// until the PID is reused, which is detected by a different start time.
+func (t *Tracker) Identify(pid int32) (core.Process, bool) {
+	if pid <= 0 {
+		return core.Process{}, false
+	}
 	proc, err := process.NewProcess(pid)
 	if err != nil {
-		return "unknown"
+		return core.Process{}, false
 	}
-	name, err := proc.Name()
+	created, err := proc.CreateTime()
 	if err != nil {
-		return "unknown"
+		return core.Process{}, false
+	}
+	start := time.UnixMilli(created)
+
+	t.mu.RLock()
+	p, ok := t.processCache[pid]
+	t.mu.RUnlock()
+	if ok && p.StartTime.Equal(start) {
+		if p.SHA256 == "" && p.ExePath != "" {
+			if p.SHA256 = t.hashes.sum(p.ExePath); p.SHA256 != "" {
+				t.mu.Lock()
+				t.processCache[pid] = p
+				t.mu.Unlock()
+			}
+		}
+		return p, true
+	}
+
+	p = core.Process{Key: core.ProcessKey(pid, start), PID: pid, StartTime: start}
+	if p.Name, err = proc.Name(); err != nil {
+		p.Name = "unknown"
+	}
+	p.ExePath, _ = proc.Exe()
+	p.Cmdline, _ = proc.Cmdline()
+	p.User, _ = proc.Username()
+	if p.ExePath != "" {
+		p.SHA256 = t.hashes.sum(p.ExePath)
+	}
+	if ppid, err := proc.Ppid(); err == nil && ppid > 0 && ppid != pid {
+		p.ParentPID = ppid
+		if parent, ok := t.Identify(ppid); ok {
+			p.ParentKey = parent.Key
+		}
 	}
 
 	t.mu.Lock()
-	t.processCache[pid] = name
+	if len(t.processCache) >= maxCachedProcesses {
+		clear(t.processCache)
+	}
+	t.processCache[pid] = p
 	t.mu.Unlock()
+	return p, true
+}
 
-	return name
+// ExecutableHash returns the SHA-256 of an executable, reading it now if
+// the background hashing hasn't finished. Identify fills in the same sum
+// from then on.
+func (t *Tracker) ExecutableHash(path string) (string, error) {
+	return t.hashes.sumNow(path)
+}
+
+// Lineage returns a running process followed by its ancestors, nearest first
+func (t *Tracker) Lineage(pid int32) []core.Process {
+	var lineage []core.Process
+	for len(lineage) < maxLineageDepth {
+		p, ok := t.Identify(pid)
+		if !ok {
+			break
+		}
+		lineage = append(lineage, p)
+		if p.ParentKey == "" {
+			break
+		}
+		pid = p.ParentPID
+	}
+	return lineage
 }
 
 // GetProcessFromPort attempts to identify the process owning a local port
-// This is used to identify the source of a connection to the proxy
-func (t *Tracker) GetProcessFromPort(port int) (string, int32) {
-	// 1. Get all TCP connections
-	conns, err := net.Connections("tcp")
-	if err != nil {
-		log.Printf("[Tracker] Error getting connections: %v", err)
+// connected to peer. This is used to identify the source of a connection to
+// the proxy
+func (t *Tracker) GetProcessFromPort(port int, peer Peer) (string, int32) {
+	pid, ok := t.pidFromPort(port, peer)
+	if !ok {
 		return "unknown", 0
 	}
+	return t.GetProcessName(pid), pid
+}
 
-	log.Printf("[Tracker] Searching for process on port %d. Found %d active TCP connections.", port, len(conns))
+// LineageFromPort returns the process owning a local port connected to peer
+// followed by its ancestors, or nil if the owner can't be found
+func (t *Tracker) LineageFromPort(port int, peer Peer) []core.Process {
+	pid, ok := t.pidFromPort(port, peer)
+	if !ok {
+		return nil
+	}
+	return t.Lineage(pid)
+}
 
-	// 2. Find the connection where Laddr.Port matches our target port
-	for _, conn := range conns {
-		if int(conn.Laddr.Port) == port {
-			// Found it!
-			pid := conn.Pid
-			name := t.GetProcessName(pid)
-			log.Printf("[Tracker] Found match! Port %d -> PID %d (%s)", port, pid, name)
-			return name, pid
+func (t *Tracker) pidFromPort(port int, peer Peer) (int32, bool) {
+	key := portKey{port: port, peer: peer.String()}
+	if pid, ok := t.cachedPort(key); ok {
+		return pid, true
+	}
+	pid, err := t.resolver.lookup(port, peer)
+	if err != nil {
+		if !errors.Is(err, errPortNotFound) {
+			log.Printf("[Tracker] Failed to resolve port %d: %v", port, err)
 		}
+		return 0, false
 	}
+	t.cachePort(key, pid)
+	return pid, true
+}
 
-	log.Printf("[Tracker] No process found for port %d", port)
-	return "unknown", 0
+func (t *Tracker) cachedPort(key portKey) (int32, bool) {
+	t.portMu.Lock()
+	defer t.portMu.Unlock()
+	owner, ok := t.ports[key]
+	if !ok || time.Now().After(owner.expires) {
+		return 0, false
+	}
+	return owner.pid, true
+}
+
+func (t *Tracker) cachePort(key portKey, pid int32) {
+	now := time.Now()
+	t.portMu.Lock()
+	defer t.portMu.Unlock()
+	// Drop expired entries once the cache grows past a burst of connections
+	if len(t.ports) >= 1024 {
+		for k, owner := range t.ports {
+			if now.After(owner.expires) {
+				delete(t.ports, k)
+			}
+		}
+	}
+	t.ports[key] = portOwner{pid: pid, expires: now.Add(portTTL)}
+}
+
+// gopsutilResolver enumerates every TCP connection on the system, it is
+// portable but slow
+type gopsutilResolver struct{}
+
+func (gopsutilResolver) lookup(port int, peer Peer) (int32, error) {
+	conns, err := net.Connections("tcp")
+	if err != nil {
+		return 0, err
+	}
+	for _, conn := range conns {
+		if int(conn.Laddr.Port) == port && conn.Pid != 0 && peer.matches(stdnet.ParseIP(conn.Raddr.IP), int(conn.Raddr.Port)) {
+			return conn.Pid, nil
+		}
+	}
+	return 0, errPortNotFound
 }
 
 // GetActiveConnections returns a list of current network connections
@@ -0,0 +1,91 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIdentify(t *testing.T) {
+	tr := NewTracker()
+	pid := int32(os.Getpid())
+
+	p, ok := tr.Identify(pid)
+	if !ok {
+		t.Fatal("Identify should find this process")
+	}
+	if p.Key == "" || p.Name == "" || p.StartTime.IsZero() {
+		t.Errorf("Identify = %+v; want key, name and start time", p)
+	}
+	if p.ParentPID != int32(os.Getppid()) {
+		t.Errorf("ParentPID = %d; want %d", p.ParentPID, os.Getppid())
+	}
+
+	lineage := tr.Lineage(pid)
+	if len(lineage) < 2 || lineage[0].Key != p.Key || lineage[1].Key != p.ParentKey {
+		t.Errorf("Lineage should start with this process and its parent, got %+v", lineage)
+	}
+
+	// A cached identity with another start time belongs to a reused PID
+	stale := p
+	stale.Name = "previous"
+	stale.StartTime = p.StartTime.Add(-time.Hour)
+	tr.mu.Lock()
+	tr.processCache[pid] = stale
+	tr.mu.Unlock()
+	if got := tr.GetProcessName(pid); got != p.Name {
+		t.Errorf("GetProcessName after PID reuse = %q; want %q", got, p.Name)
+	}
+
+	if _, ok := tr.Identify(0); ok {
+		t.Errorf("Identify(0) should fail")
+	}
+}
+
+func TestHashCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app")
+	if err := os.WriteFile(path, []byte("hello"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const want = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	var c hashCache
+	if sum := c.sum(path); sum != "" && sum != want {
+		t.Fatalf("first sum = %q; want it pending", sum)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for c.sum(path) == "" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sum := c.sum(path); sum != want {
+		t.Errorf("sum = %q; want %q", sum, want)
+	}
+
+	// A changed file is hashed again
+	os.WriteFile(path, []byte("hello, world"), 0755)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	if sum := c.sum(path); sum == want {
+		t.Errorf("sum of a changed file should not be the old one")
+	}
+}
+
+func TestHashCacheSumNow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app")
+	if err := os.WriteFile(path, []byte("hello"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const want = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	var c hashCache
+	if sum, err := c.sumNow(path); err != nil || sum != want {
+		t.Fatalf("sumNow = %q, %v; want %q", sum, err, want)
+	}
+	// The sum is cached for the asynchronous lookups too
+	if sum := c.sum(path); sum != want {
+		t.Errorf("sum after sumNow = %q; want %q", sum, want)
+	}
+	if _, err := c.sumNow(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("sumNow of a missing file should fail")
+	}
+}
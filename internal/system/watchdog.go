@@ -0,0 +1,74 @@
+package system
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// WatchdogArg is the command line flag that runs the binary as a proxy watchdog
+const WatchdogArg = "--proxy-watchdog"
+
+// Watchdog is a helper process that repairs the system proxy once the
+// process that started it exits, including when it crashes or is killed.
+// The watchdog reads a pipe whose only write end is held by this process,
+// and the OS closes it when this process dies, however it dies.
+type Watchdog struct {
+	cmd  *exec.Cmd
+	pipe *os.File
+}
+
+// StartWatchdog runs this executable again as a watchdog for the proxy
+// settings this controller saves
+func (c *ProxyController) StartWatchdog() (*Watchdog, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, WatchdogArg, c.path)
+	cmd.Stdin = r
+	cmd.Stderr = os.Stderr
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to start proxy watchdog: %w", err)
+	}
+	return &Watchdog{cmd: cmd, pipe: w}, nil
+}
+
+// Stop tells the watchdog this process is exiting and waits for its last check
+func (w *Watchdog) Stop() error {
+	w.pipe.Close()
+	return w.cmd.Wait()
+}
+
+// RunWatchdog is the main function of the watchdog process. It waits for
+// stdin to close and then restores the proxy if it points at a dead Custos,
+// and removes any transparent proxy redirect rules.
+func RunWatchdog(snapshotPath string) error {
+	// Outlive the parent when the terminal it ran in is interrupted or
+	// closed, or when a service manager stops everything it started
+	signal.Ignore(os.Interrupt, syscall.SIGHUP, syscall.SIGTERM)
+
+	io.Copy(io.Discard, os.Stdin)
+
+	if err := RemoveRedirect(); err != nil {
+		log.Printf("[Watchdog] Failed to remove redirect rules: %v", err)
+	}
+
+	recovered, err := NewProxyController(snapshotPath).RecoverStale()
+	if recovered {
+		log.Printf("[Watchdog] Restored the system proxy after Custos exited")
+	}
+	return err
+}
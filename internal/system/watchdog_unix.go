@@ -0,0 +1,14 @@
+//go:build !windows
+
+package system
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach moves the watchdog to its own process group, so signals sent to
+// the app's group, like Ctrl+C in a terminal, don't reach it
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
@@ -1,31 +1,86 @@
 use adblock::Engine;
 use adblock::lists::ParseOptions;
 use adblock::request::Request;
-use std::ffi::CStr;
+use std::collections::HashMap;
+use std::ffi::{CStr, CString};
 use std::os::raw::c_char;
 
 pub struct AdblockEngine {
     engine: Engine,
+    // Raw filter line -> ID of the first list that contained it
+    origins: HashMap<String, String>,
 }
 
-#[no_mangle]
-pub extern "C" fn adblock_engine_create(rules: *const c_char) -> *mut AdblockEngine {
-    if rules.is_null() {
-        return std::ptr::null_mut();
+fn build_engine(lists: Vec<(String, String)>) -> AdblockEngine {
+    let mut origins = HashMap::new();
+    let mut filter_lines: Vec<String> = Vec::new();
+
+    for (id, rules) in lists {
+        for line in rules.lines() {
+            let line = line.trim();
+            if line.is_empty() || line.starts_with('!') {
+                continue;
+            }
+            if !id.is_empty() {
+                origins.entry(line.to_string()).or_insert_with(|| id.clone());
+            }
+            filter_lines.push(line.to_string());
+        }
     }
 
-    let c_str = unsafe { CStr::from_ptr(rules) };
-    let rules_str = match c_str.to_str() {
-        Ok(s) => s,
-        Err(_) => return std::ptr::null_mut(),
+    // Debug mode keeps the raw filter text so matches can be reported
+    let engine = Engine::from_rules_debug(&filter_lines, ParseOptions::default());
+
+    AdblockEngine { engine, origins }
+}
+
+unsafe fn c_str<'a>(s: *const c_char) -> Option<&'a str> {
+    if s.is_null() {
+        return None;
+    }
+    CStr::from_ptr(s).to_str().ok()
+}
+
+fn into_c_string(s: Option<&str>) -> *mut c_char {
+    match s {
+        Some(s) => CString::new(s).map(|c| c.into_raw()).unwrap_or(std::ptr::null_mut()),
+        None => std::ptr::null_mut(),
+    }
+}
+
+#[no_mangle]
+pub extern "C" fn adblock_engine_create(rules: *const c_char) -> *mut AdblockEngine {
+    let rules_str = match unsafe { c_str(rules) } {
+        Some(s) => s,
+        None => return std::ptr::null_mut(),
     };
 
-    let filter_lines: Vec<String> = rules_str.lines().map(|s| s.to_string()).collect();
-    let filter_refs: Vec<&str> = filter_lines.iter().map(|s| s.as_str()).collect();
+    let engine = build_engine(vec![(String::new(), rules_str.to_string())]);
+
+    Box::into_raw(Box::new(engine))
+}
+
+#[no_mangle]
+pub extern "C" fn adblock_engine_create_lists(
+    ids: *const *const c_char,
+    rules: *const *const c_char,
+    count: usize,
+) -> *mut AdblockEngine {
+    if ids.is_null() || rules.is_null() {
+        return std::ptr::null_mut();
+    }
 
-    let engine = Engine::from_rules(&filter_refs, ParseOptions::default());
+    let mut lists = Vec::with_capacity(count);
+    for i in 0..count {
+        let id = unsafe { c_str(*ids.add(i)) }.unwrap_or("");
+        let list_rules = match unsafe { c_str(*rules.add(i)) } {
+            Some(s) => s,
+            None => continue,
+        };
+        lists.push((id.to_string(), list_rules.to_string()));
+    }
 
-    Box::into_raw(Box::new(AdblockEngine { engine }))
+    Box::into_raw(Box::new(build_engine(lists)))
 }
 
 #[no_mangle]
@@ -34,16 +89,40 @@ pub extern "C" fn adblock_engine_check(
     url: *const c_char,
     source_url: *const c_char,
     resource_type: *const c_char,
+) -> bool {
+    adblock_engine_match(
+        engine,
+        url,
+        source_url,
+        resource_type,
+        std::ptr::null_mut(),
+        std::ptr::null_mut(),
+        std::ptr::null_mut(),
+    )
+}
+
+/// Checks a request and reports the matched filter, the exception that
+/// overrode it and the originating list. Returned strings must be released
+/// with adblock_string_free.
+#[no_mangle]
+pub extern "C" fn adblock_engine_match(
+    engine: *mut AdblockEngine,
+    url: *const c_char,
+    source_url: *const c_char,
+    resource_type: *const c_char,
+    out_filter: *mut *mut c_char,
+    out_exception: *mut *mut c_char,
+    out_list: *mut *mut c_char,
 ) -> bool {
     if engine.is_null() || url.is_null() || source_url.is_null() || resource_type.is_null() {
         return false;
     }
 
     let engine = unsafe { &*engine };
-    
-    let url_str = unsafe { CStr::from_ptr(url) }.to_str().unwrap_or("");
-    let source_url_str = unsafe { CStr::from_ptr(source_url) }.to_str().unwrap_or("");
-    let resource_type_str = unsafe { CStr::from_ptr(resource_type) }.to_str().unwrap_or("");
+
+    let url_str = unsafe { c_str(url) }.unwrap_or("");
+    let source_url_str = unsafe { c_str(source_url) }.unwrap_or("");
+    let resource_type_str = unsafe { c_str(resource_type) }.unwrap_or("");
 
     let request = match Request::new(url_str, source_url_str, resource_type_str) {
         Ok(r) => r,
@@ -51,10 +130,111 @@ pub extern "C" fn adblock_engine_check(
     };
 
     let blocker_result = engine.engine.check_network_request(&request);
-    
+
+    let list = blocker_result
+        .filter
+        .as_ref()
+        .and_then(|f| engine.origins.get(f))
+        .map(|s| s.as_str());
+
+    unsafe {
+        if !out_filter.is_null() {
+            *out_filter = into_c_string(blocker_result.filter.as_deref());
+        }
+        if !out_exception.is_null() {
+            *out_exception = into_c_string(blocker_result.exception.as_deref());
+        }
+        if !out_list.is_null() {
+            *out_list = into_c_string(list);
+        }
+    }
+
     blocker_result.matched
 }
 
+#[no_mangle]
+pub extern "C" fn adblock_string_free(s: *mut c_char) {
+    if !s.is_null() {
+        unsafe {
+            drop(CString::from_raw(s));
+        }
+    }
+}
+
+/// Serializes the engine and its list origins. The returned buffer must be
+/// released with adblock_bytes_free.
+#[no_mangle]
+pub extern "C" fn adblock_engine_serialize(engine: *mut AdblockEngine, out_len: *mut usize) -> *mut u8 {
+    if engine.is_null() || out_len.is_null() {
+        return std::ptr::null_mut();
+    }
+
+    let engine = unsafe { &*engine };
+    let data = match engine.engine.serialize_raw() {
+        Ok(d) => d,
+        Err(_) => return std::ptr::null_mut(),
+    };
+
+    // [engine length: u64 LE][engine bytes][origins as "id\tfilter\n" lines]
+    let mut buf = Vec::with_capacity(8 + data.len());
+    buf.extend_from_slice(&(data.len() as u64).to_le_bytes());
+    buf.extend_from_slice(&data);
+    for (filter, id) in &engine.origins {
+        buf.extend_from_slice(id.as_bytes());
+        buf.push(b'\t');
+        buf.extend_from_slice(filter.as_bytes());
+        buf.push(b'\n');
+    }
+
+    let mut boxed = buf.into_boxed_slice();
+    unsafe {
+        *out_len = boxed.len();
+    }
+    let ptr = boxed.as_mut_ptr();
+    std::mem::forget(boxed);
+    ptr
+}
+
+#[no_mangle]
+pub extern "C" fn adblock_engine_deserialize(data: *const u8, len: usize) -> *mut AdblockEngine {
+    if data.is_null() || len < 8 {
+        return std::ptr::null_mut();
+    }
+
+    let bytes = unsafe { std::slice::from_raw_parts(data, len) };
+    let mut engine_len = [0u8; 8];
+    engine_len.copy_from_slice(&bytes[..8]);
+    let engine_len = u64::from_le_bytes(engine_len) as usize;
+    if engine_len > len - 8 {
+        return std::ptr::null_mut();
+    }
+
+    let mut engine = Engine::new(true);
+    if engine.deserialize(&bytes[8..8 + engine_len]).is_err() {
+        return std::ptr::null_mut();
+    }
+
+    let mut origins = HashMap::new();
+    if let Ok(text) = std::str::from_utf8(&bytes[8 + engine_len..]) {
+        for line in text.lines() {
+            if let Some((id, filter)) = line.split_once('\t') {
+                origins.insert(filter.to_string(), id.to_string());
+            }
+        }
+    }
+
+    Box::into_raw(Box::new(AdblockEngine { engine, origins }))
+}
+
+#[no_mangle]
+pub extern "C" fn adblock_bytes_free(data: *mut u8, len: usize) {
+    if !data.is_null() {
+        unsafe {
+            drop(Box::from_raw(std::ptr::slice_from_raw_parts_mut(data, len)));
+        }
+    }
+}
+
 #[no_mangle]
 pub extern "C" fn adblock_engine_destroy(engine: *mut AdblockEngine) {
     if !engine.is_null() {
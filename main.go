@@ -3,12 +3,16 @@ package main
 import (
 	"context"
 	"embed"
+	"errors"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 
 	"github.com/getlantern/systray"
+	"github.com/vkhangstack/Custos/internal/cli"
+	"github.com/vkhangstack/Custos/internal/control"
+	"github.com/vkhangstack/Custos/internal/system"
 	"github.com/vkhangstack/Custos/internal/utils"
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/logger"
@@ -31,6 +35,29 @@ var iconData []byte
 var iconIco []byte
 
 func main() {
+	// Helper process started by App.startup
+	if len(os.Args) == 3 && os.Args[1] == system.WatchdogArg {
+		if err := system.RunWatchdog(os.Args[2]); err != nil {
+			println("Proxy watchdog:", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) == 2 && os.Args[1] == system.DaemonArg {
+		if err := runDaemon(); err != nil {
+			println("Custos daemon:", err.Error())
+			if errors.Is(err, control.ErrRunning) {
+				os.Exit(system.ExitRunning)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+	// Command line client of the running instance
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
 	// Resolve log path
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -43,28 +70,37 @@ func main() {
 		return
 	}
 
-	// Single Instance Lock
-	lockFile := filepath.Join(homeDir, ".custos", "app.lock")
-	if _, err := os.Stat(lockFile); err == nil {
-		// On Windows, if the app is running, removing will fail or be locked.
-		// We try to remove it; if it fails, another instance is likely using it.
-		err = os.Remove(lockFile)
+	// Single instance: a second launch hands its arguments to the window
+	launchArgs := os.Args[1:]
+	windowLock, err := lockWindow(launchArgs)
+	if err != nil {
+		println("Custos:", err.Error())
+		return
+	}
+	if windowLock == nil {
+		return
+	}
+	defer windowLock.Unlock()
+
+	// Attach to a running daemon rather than starting a second proxy
+	var app *App
+	servicesLock, err := lock(servicesLockName)
+	switch {
+	case err == nil:
+		defer servicesLock.Unlock()
+		// Create an instance of the app structure
+		app = NewApp()
+	case errors.Is(err, system.ErrLocked):
+		client, err := runningInstance()
 		if err != nil {
-			println("Another instance of Custos is already running.")
+			println("Custos:", err.Error())
 			return
 		}
-	}
-	// Create the lock file
-	f, err := os.Create(lockFile)
-	if err != nil {
-		println("Error creating lock file:", err.Error())
+		app = newAttachedApp(client)
+	default:
+		println("Error locking the instance:", err.Error())
 		return
 	}
-	f.Close()
-	defer os.Remove(lockFile)
-
-	// Create an instance of the app structure
-	app := NewApp()
 
 	AppMenu := menu.NewMenu()
 	if runtime.GOOS == "darwin" {
@@ -90,7 +126,7 @@ func main() {
 		rt.EventsEmit(app.ctx, "navigate-to", "/opensource")
 	})
 	AboutMenu.AddText("Reset Data", keys.Combo("c", keys.CmdOrCtrlKey, keys.ShiftKey), func(_ *menu.CallbackData) {
-		app.store.ResetData()
+		app.ResetData()
 		rt.MessageDialog(app.ctx, rt.MessageDialogOptions{
 			Type:          rt.InfoDialog,
 			Title:         "Reset Data",
@@ -112,6 +148,11 @@ func main() {
 			Assets: assets,
 		},
 		BackgroundColour: &options.RGBA{R: 27, G: 38, B: 54, A: 1},
+		OnDomReady: func(ctx context.Context) {
+			// Follow a custos:// link this launch was opened with, once
+			app.openLink(launchArgs)
+			launchArgs = nil
+		},
 		OnStartup: func(ctx context.Context) {
 			app.startup(ctx)
 			if utils.GetOS() == utils.Windows {
@@ -161,8 +202,7 @@ func setupTray(app *App) {
 		for {
 			select {
 			case <-mShow.ClickedCh:
-				rt.WindowUnminimise(app.ctx)
-				rt.WindowShow(app.ctx)
+				app.showWindow(nil)
 			case <-mQuit.ClickedCh:
 				systray.Quit()
 				rt.Quit(app.ctx)
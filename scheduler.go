@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+)
+
+const (
+	// filterSchedulerTick is how often the scheduler looks for due filter lists
+	filterSchedulerTick = time.Minute
+	// maxFilterJitter caps the random delay added to each list's due time
+	maxFilterJitter = 30 * time.Minute
+)
+
+// runFilterScheduler refreshes adblock filter lists whose update interval has elapsed
+func (a *App) runFilterScheduler(ctx context.Context) {
+	ticker := time.NewTicker(filterSchedulerTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.refreshDueFilters()
+		}
+	}
+}
+
+// refreshDueFilters downloads every due list and rebuilds the engines once if anything changed
+func (a *App) refreshDueFilters() {
+	now := time.Now()
+	updated := 0
+	for _, f := range a.store.GetAdblockFilters() {
+		if !f.Enabled || f.URL == "" {
+			continue
+		}
+		if now.Before(a.filterDueTime(f)) {
+			continue
+		}
+
+		if _, err := a.downloadFilter(f); err != nil {
+			log.Printf("Scheduled refresh of adblock filter %s failed: %v", f.Name, err)
+			continue
+		}
+		a.clearFilterJitter(f.ID)
+		updated++
+	}
+
+	if updated > 0 {
+		log.Printf("Scheduled refresh updated %d adblock filter(s)", updated)
+		a.RefreshAdblockFilters()
+	}
+}
+
+// filterDueTime returns when a list should next be downloaded, including jitter
+// so that lists sharing an interval don't all hit the network at once
+func (a *App) filterDueTime(f core.AdblockFilter) time.Time {
+	if f.FailureCount > 0 {
+		// Backoff is already spread out by the failure timestamps
+		return f.NextRefresh()
+	}
+	return f.NextRefresh().Add(a.filterJitterFor(f))
+}
+
+// filterJitterFor returns a stable random delay for the list's current cycle
+func (a *App) filterJitterFor(f core.AdblockFilter) time.Duration {
+	a.schedulerMu.Lock()
+	defer a.schedulerMu.Unlock()
+
+	if jitter, ok := a.filterJitter[f.ID]; ok {
+		return jitter
+	}
+
+	// Up to 10% of the interval
+	limit := f.RefreshInterval() / 10
+	if limit > maxFilterJitter {
+		limit = maxFilterJitter
+	}
+	jitter := time.Duration(0)
+	if limit > 0 {
+		jitter = rand.N(limit)
+	}
+	a.filterJitter[f.ID] = jitter
+	return jitter
+}
+
+func (a *App) clearFilterJitter(id string) {
+	a.schedulerMu.Lock()
+	delete(a.filterJitter, id)
+	a.schedulerMu.Unlock()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/vkhangstack/Custos/internal/system"
+)
+
+// defaultTransparentPort is where the transparent proxy listens unless the
+// "transparent_port" setting says otherwise
+const defaultTransparentPort = 1090
+
+// TransparentStatus describes the transparent proxy
+type TransparentStatus struct {
+	Enabled  bool   `json:"enabled"`
+	Port     int    `json:"port"`
+	Firewall string `json:"firewall"` // "nftables" or "iptables"
+	Error    string `json:"error,omitempty"`
+}
+
+// transparentState is the result of the last change to transparent mode
+type transparentState struct {
+	mu       sync.Mutex
+	firewall string
+	err      error
+}
+
+// EnableTransparentProxy redirects all outbound TCP of this machine through
+// Custos, or stops doing so, and remembers the choice. It needs the
+// privileges to change the firewall and only works on Linux.
+func (a *App) EnableTransparentProxy(enabled bool) error {
+	if a.remote != nil {
+		return a.remote.Call("EnableTransparentProxy", nil, enabled)
+	}
+	if err := a.setTransparent(enabled); err != nil {
+		return err
+	}
+	a.store.SetSetting("transparent_enabled", strconv.FormatBool(enabled))
+	return nil
+}
+
+// GetTransparentStatus reports whether the transparent proxy is running
+func (a *App) GetTransparentStatus() TransparentStatus {
+	if a.remote != nil {
+		return call[TransparentStatus](a, "GetTransparentStatus")
+	}
+	a.transparent.mu.Lock()
+	defer a.transparent.mu.Unlock()
+
+	status := TransparentStatus{Port: a.proxyServer.TransparentPort()}
+	status.Enabled = status.Port != 0
+	if status.Enabled {
+		status.Firewall = a.transparent.firewall
+	}
+	if a.transparent.err != nil {
+		status.Error = a.transparent.err.Error()
+	}
+	return status
+}
+
+// setTransparent starts the listener before the redirect rules go in, and
+// removes the rules before the listener stops, so redirected connections
+// always have somewhere to go
+func (a *App) setTransparent(enabled bool) error {
+	a.transparent.mu.Lock()
+	defer a.transparent.mu.Unlock()
+
+	if !enabled {
+		err := system.RemoveRedirect()
+		a.proxyServer.StopTransparent()
+		a.transparent.firewall, a.transparent.err = "", err
+		return err
+	}
+
+	port := a.transparentPort()
+	err := a.proxyServer.StartTransparent(port)
+	if err == nil {
+		a.transparent.firewall, err = system.InstallRedirect(port)
+		if err != nil {
+			system.RemoveRedirect()
+			a.proxyServer.StopTransparent()
+		}
+	}
+	a.transparent.err = err
+	if err != nil {
+		log.Printf("Failed to start the transparent proxy: %v", err)
+		return err
+	}
+	log.Printf("Redirecting outbound TCP with %s to port %d", a.transparent.firewall, port)
+	return nil
+}
+
+func (a *App) transparentPort() int {
+	if val, err := a.store.GetSetting("transparent_port"); err == nil && val != "" {
+		if p, err := strconv.Atoi(val); err == nil {
+			return p
+		}
+	}
+	return defaultTransparentPort
+}
+
+// getTransparentEnabled returns whether transparent mode was left on
+func (a *App) getTransparentEnabled() bool {
+	val, err := a.store.GetSetting("transparent_enabled")
+	return err == nil && val == "true"
+}
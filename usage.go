@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/vkhangstack/Custos/internal/core"
+	"github.com/vkhangstack/Custos/internal/geoip"
+	"github.com/vkhangstack/Custos/internal/store"
+)
+
+// UsageByCountry breaks usage down by the country of the destination IP
+const UsageByCountry = "country"
+
+// UsageRequest selects the top usage within a time window
+type UsageRequest struct {
+	From    int64  `json:"from" query:"from"`       // Unix milliseconds, 0 = 24 hours before To
+	To      int64  `json:"to" query:"to"`           // Unix milliseconds, 0 = now
+	By      string `json:"by" query:"by"`           // "domain", "process", "port", "ip" or "country"
+	Metric  string `json:"metric" query:"metric"`   // "bytes", "connections" or "blocked"
+	Limit   int    `json:"limit" query:"limit"`     // 0 = 10
+	Domain  string `json:"domain" query:"domain"`   // Optional, only count traffic to this domain
+	Process string `json:"process" query:"process"` // Optional, only count traffic of this process
+}
+
+var (
+	geoOnce sync.Once
+	geoDB   *geoip.DB
+)
+
+// geoDatabase loads ~/.custos/data/geoip.csv once. Without it, countries
+// are only told apart from local addresses.
+func geoDatabase() *geoip.DB {
+	geoOnce.Do(func() {
+		homeDir, _ := os.UserHomeDir()
+		path := filepath.Join(homeDir, ".custos", "data", "geoip.csv")
+		db, err := geoip.Open(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Failed to load GeoIP database %s: %v", path, err)
+			}
+			return
+		}
+		log.Printf("Loaded %d GeoIP ranges", db.Len())
+		geoDB = db
+	})
+	return geoDB
+}
+
+// GetTopUsage returns the top domains, processes, ports, IPs or countries by bytes, connections or blocks
+func (a *App) GetTopUsage(req UsageRequest) ([]core.UsageStat, error) {
+	if a.remote != nil {
+		return callErr[[]core.UsageStat](a, "GetTopUsage", req)
+	}
+	q := core.UsageQuery{
+		By:      req.By,
+		Metric:  req.Metric,
+		Limit:   req.Limit,
+		Domain:  req.Domain,
+		Process: req.Process,
+	}
+	if q.Limit <= 0 {
+		q.Limit = 10
+	}
+	if req.From > 0 {
+		q.From = time.UnixMilli(req.From)
+	}
+	if req.To > 0 {
+		q.To = time.UnixMilli(req.To)
+	}
+
+	if q.By != UsageByCountry {
+		return a.store.GetTopUsage(q)
+	}
+
+	// Countries are summed from every destination IP
+	limit := q.Limit
+	q.By, q.Limit = core.UsageByIP, 0
+	byIP, err := a.store.GetTopUsage(q)
+	if err != nil {
+		return nil, err
+	}
+	return store.RankUsage(geoDatabase().GroupByCountry(byIP), req.Metric, limit), nil
+}
+
+// GetProcessDomains drills down from a process to the domains it talked to, by bytes
+func (a *App) GetProcessDomains(process string, from, to int64, limit int) ([]core.UsageStat, error) {
+	return a.GetTopUsage(UsageRequest{From: from, To: to, By: core.UsageByDomain, Limit: limit, Process: process})
+}